@@ -3,6 +3,7 @@ package alerting
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/skia-dev/glog"
@@ -26,6 +27,11 @@ To snooze or dismiss this alert, visit %s
 var (
 	emailAuth  *email.GMail       = nil
 	emailQueue chan *AlertMessage = nil
+
+	// actionQueues contains the pending calls of each Action of each Alert,
+	// keyed by actionKey. A key is present while its calls are being run.
+	actionQueues      = map[string][]func(){}
+	actionQueuesMutex sync.Mutex
 )
 
 // Actions are performed whenever an Alert is updated.
@@ -35,6 +41,40 @@ type Action interface {
 	String() string
 }
 
+// actionKey returns the key of the given Action of the given Alert in
+// actionQueues.
+func actionKey(alert *Alert, action Action) string {
+	return fmt.Sprintf("%d-%s", alert.Id, action.String())
+}
+
+// runAction calls fn in the background, after the calls queued before for the
+// same Action of the same Alert have returned. Actions retry for a long time,
+// and e.g. the followup for dismissing an Alert must not overtake firing it.
+func runAction(alert *Alert, action Action, fn func()) {
+	key := actionKey(alert, action)
+	actionQueuesMutex.Lock()
+	defer actionQueuesMutex.Unlock()
+	queue, running := actionQueues[key]
+	actionQueues[key] = append(queue, fn)
+	if running {
+		return
+	}
+	go func() {
+		for {
+			actionQueuesMutex.Lock()
+			queue := actionQueues[key]
+			if len(queue) == 0 {
+				delete(actionQueues, key)
+				actionQueuesMutex.Unlock()
+				return
+			}
+			actionQueues[key] = queue[1:]
+			actionQueuesMutex.Unlock()
+			queue[0]()
+		}
+	}()
+}
+
 type EmailAction struct {
 	to  []string
 	str string
@@ -214,6 +254,12 @@ func ParseAction(str string) (Action, error) {
 		return NewEmailAction(to, str), nil
 	} else if str == "Print" {
 		return NewPrintAction(), nil
+	} else if url, ok := parseArg(str, "Webhook"); ok && url != "" {
+		return NewWebhookAction(url, str), nil
+	} else if room, ok := parseArg(str, "Chat"); ok && room != "" {
+		return NewChatAction(room, str), nil
+	} else if component, ok := parseArg(str, "IssueTracker"); ok && component != "" {
+		return NewIssueTrackerAction(component, str), nil
 	} else {
 		return nil, fmt.Errorf("Unknown action: %q", str)
 	}
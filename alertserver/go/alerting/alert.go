@@ -5,24 +5,27 @@ import "encoding/json"
 const INFRA_ALERT = "infra"
 
 type alertFields struct {
-	Id           int64      `json:"id"`
-	Name         string     `json:"name"`
-	Category     string     `json:"category"`
-	Triggered    int64      `json:"triggered"`
-	SnoozedUntil int64      `json:"snoozedUntil"`
-	DismissedAt  int64      `json:"dismissedAt"`
-	Message      string     `json:"message"`
-	Nag          int64      `json:"nag"`
-	AutoDismiss  int64      `json:"autoDismiss"`
-	LastFired    int64      `json:"lastFired"`
-	Comments     []*Comment `json:"comments"`
-	Actions      []string   `json:"actions"`
+	Id           int64             `json:"id"`
+	Name         string            `json:"name"`
+	Rule         string            `json:"rule"`
+	Category     string            `json:"category"`
+	Triggered    int64             `json:"triggered"`
+	SnoozedUntil int64             `json:"snoozedUntil"`
+	DismissedAt  int64             `json:"dismissedAt"`
+	Message      string            `json:"message"`
+	Nag          int64             `json:"nag"`
+	AutoDismiss  int64             `json:"autoDismiss"`
+	LastFired    int64             `json:"lastFired"`
+	Comments     []*Comment        `json:"comments"`
+	Actions      []string          `json:"actions"`
+	Tags         map[string]string `json:"tags,omitempty"`
 }
 
 // Alert is an object which represents an active alert.
 type Alert struct {
 	Id           int64      `db:"id"           json:"id"`
 	Name         string     `db:"name"         json:"name"`
+	Rule         string     `db:"rule"         json:"rule"`
 	Category     string     `db:"category"     json:"category"`
 	Triggered    int64      `db:"triggered"    json:"triggered"`
 	SnoozedUntil int64      `db:"snoozedUntil" json:"snoozedUntil"`
@@ -33,6 +36,10 @@ type Alert struct {
 	LastFired    int64      `db:"lastFired"    json:"lastFired"`
 	Comments     []*Comment `db:"-"            json:"comments"`
 	Actions      []Action   `db:"-"            json:"-"`
+	// Tags are the tags of the query result which triggered the Alert. They
+	// are used to match Alerts in a Policy or Schedule, and are kept in memory
+	// only.
+	Tags map[string]string `db:"-" json:"tags,omitempty"`
 }
 
 func (a *Alert) MarshalJSON() ([]byte, error) {
@@ -43,6 +50,7 @@ func (a *Alert) MarshalJSON() ([]byte, error) {
 	fields := alertFields{
 		Id:           a.Id,
 		Name:         a.Name,
+		Rule:         a.Rule,
 		Category:     a.Category,
 		Triggered:    a.Triggered,
 		SnoozedUntil: a.SnoozedUntil,
@@ -53,6 +61,7 @@ func (a *Alert) MarshalJSON() ([]byte, error) {
 		LastFired:    a.LastFired,
 		Comments:     a.Comments,
 		Actions:      actions,
+		Tags:         a.Tags,
 	}
 	if fields.Comments == nil {
 		fields.Comments = []*Comment{}
@@ -67,6 +76,7 @@ func (a *Alert) UnmarshalJSON(b []byte) error {
 	}
 	a.Id = proxy.Id
 	a.Name = proxy.Name
+	a.Rule = proxy.Rule
 	a.Category = proxy.Category
 	a.Triggered = proxy.Triggered
 	a.SnoozedUntil = proxy.SnoozedUntil
@@ -76,6 +86,7 @@ func (a *Alert) UnmarshalJSON(b []byte) error {
 	a.AutoDismiss = proxy.AutoDismiss
 	a.LastFired = proxy.LastFired
 	a.Comments = proxy.Comments
+	a.Tags = proxy.Tags
 	actions := make([]Action, 0, len(proxy.Actions))
 	for _, s := range proxy.Actions {
 		action, err := ParseAction(s)
@@ -95,6 +106,21 @@ type Comment struct {
 	Message string `db:"message" json:"message"`
 }
 
+// Copy returns a copy of the Alert, which can be used while the AlertManager
+// modifies the original.
+func (a *Alert) Copy() *Alert {
+	c := *a
+	c.Comments = append([]*Comment(nil), a.Comments...)
+	c.Actions = append([]Action(nil), a.Actions...)
+	if a.Tags != nil {
+		c.Tags = make(map[string]string, len(a.Tags))
+		for k, v := range a.Tags {
+			c.Tags[k] = v
+		}
+	}
+	return &c
+}
+
 // Snoozed indicates whether the Alert has been Snoozed.
 func (a *Alert) Snoozed() bool {
 	return a.SnoozedUntil != 0
@@ -77,6 +77,24 @@ func TestAlertJsonSerialization(t *testing.T) {
 	}
 }
 
+// TestAlertCopy verifies that modifying an Alert doesn't modify its copies.
+func TestAlertCopy(t *testing.T) {
+	testutils.SmallTest(t)
+	a := makeAlert()
+	a.Tags = map[string]string{"host": "a"}
+	c := a.Copy()
+	testutils.AssertDeepEqual(t, a, c)
+
+	a.DismissedAt = 1000
+	a.Comments = append(a.Comments, &Comment{User: "me", Message: "Dismissed"})
+	a.Comments[0] = nil
+	a.Tags["host"] = "b"
+	assert.Equal(t, int64(0), c.DismissedAt)
+	assert.Equal(t, 2, len(c.Comments))
+	assert.Equal(t, "me", c.Comments[0].User)
+	assert.Equal(t, "a", c.Tags["host"])
+}
+
 // TestAlertDBSerialization verifies that we properly serialize and
 // deserialize Alerts into the DB.
 func TestAlertDBSerialization(t *testing.T) {
@@ -26,8 +26,17 @@ var (
 // AlertManager is the primary point of interaction with Alert objects.
 type AlertManager struct {
 	activeAlerts map[int64]*Alert
+	groups       map[string]*alertGroup
+	// inhibited contains the IDs of the active Alerts whose notifications
+	// are inhibited by other active Alerts.
+	inhibited map[int64]bool
+	// recovered indicates whether the notifications which were pending when
+	// the AlertManager was last stopped have been recovered.
+	recovered    bool
 	interrupt    chan bool
 	mutex        sync.RWMutex
+	policy       *Policy
+	schedule     *Schedule
 	tickInterval time.Duration
 }
 
@@ -56,7 +65,8 @@ func (am *AlertManager) AddAlert(a *Alert) error {
 	defer am.mutex.Unlock()
 	var alert *Alert
 	active := am.activeAlert(a.Name)
-	t := time.Now().UTC().Unix()
+	now := time.Now().UTC()
+	t := now.Unix()
 	if active != 0 {
 		// If the alert is already active, just update LastFired.
 		alert = am.activeAlerts[active]
@@ -66,6 +76,9 @@ func (am *AlertManager) AddAlert(a *Alert) error {
 		alert = a
 		// Force some initial values.
 		a.Id = 0
+		if a.Rule == "" {
+			a.Rule = a.Name
+		}
 		a.Triggered = t
 		a.SnoozedUntil = 0
 		a.DismissedAt = 0
@@ -83,21 +96,115 @@ func (am *AlertManager) AddAlert(a *Alert) error {
 		if !found {
 			a.Actions = append(a.Actions, NewPrintAction())
 		}
+
+		// Add the actions of whoever is on call.
+		am.route(a, now)
 	}
 	// Insert the alert.
 	if err := am.updateAlert(alert); err != nil {
 		return fmt.Errorf("Failed to add Alert: %v", err)
 	}
 
-	// Trigger the alert actions if we inserted a new alert.
-	if active == 0 {
-		for _, action := range alert.Actions {
-			go action.Fire(alert)
+	if active != 0 {
+		return nil
+	}
+
+	// Trigger the alert actions if we inserted a new alert, unless its
+	// notifications are inhibited or grouped with those of other alerts.
+	if am.policy != nil {
+		if source := am.policy.inhibitedBy(alert, am.activeAlerts); source != nil {
+			glog.Infof("Notifications for alert %q inhibited by %q", alert.Name, source.Name)
+			am.inhibited[alert.Id] = true
+			return am.noteNotification(alert, fmt.Sprintf(INHIBITED_MSG_TMPL, source.Name))
+		}
+	}
+	return am.notify(alert, now, false)
+}
+
+// notify fires the actions of the given Alert, unless its notification is
+// grouped with those of other Alerts. If released is true, the notification
+// was held back before, and that it has been sent is recorded. Assumes the
+// caller holds a write lock.
+func (am *AlertManager) notify(a *Alert, now time.Time, released bool) error {
+	if am.policy != nil {
+		if rule := am.policy.groupRule(a); rule != nil {
+			am.addToGroup(rule, a, now)
+			return am.noteNotification(a, fmt.Sprintf(GROUPED_MSG_TMPL, rule.Name))
+		}
+	}
+	fire(a)
+	if released {
+		return am.noteNotification(a, NOTIFIED_MSG)
+	}
+	return nil
+}
+
+// noteNotification records the state of the notification for the given Alert
+// in a comment, so that pending notifications can be recovered after a
+// restart. Assumes the caller holds a write lock.
+func (am *AlertManager) noteNotification(a *Alert, msg string) error {
+	// Comment directly, rather than using addComment, since the followup
+	// would be a notification.
+	a.Comments = append(a.Comments, &Comment{
+		Time:    time.Now().UTC().Unix(),
+		User:    USER_ALERTSERVER,
+		Message: msg,
+	})
+	return am.updateAlert(a)
+}
+
+// releaseInhibited sends the notifications for the inhibited Alerts which are
+// still active but no longer inhibited, eg. because the inhibiting Alert was
+// dismissed. Assumes the caller holds a write lock.
+func (am *AlertManager) releaseInhibited(now time.Time) error {
+	for id := range am.inhibited {
+		a, ok := am.activeAlerts[id]
+		if !ok || a.DismissedAt != 0 {
+			delete(am.inhibited, id)
+			continue
+		}
+		if am.policy != nil && am.policy.inhibitedBy(a, am.activeAlerts) != nil {
+			continue
+		}
+		glog.Infof("Notifications for alert %q are no longer inhibited", a.Name)
+		delete(am.inhibited, id)
+		if err := am.notify(a, now, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverNotifications finds the notifications which were pending when the
+// AlertManager was last stopped. Inhibited Alerts are tracked again and
+// grouped notifications, whose groups were lost, are sent immediately.
+// Assumes the caller holds a write lock.
+func (am *AlertManager) recoverNotifications() error {
+	for _, a := range am.activeAlerts {
+		switch notificationState(a) {
+		case NOTIFICATION_INHIBITED:
+			am.inhibited[a.Id] = true
+		case NOTIFICATION_GROUPED:
+			glog.Infof("Sending pending grouped notification for alert %q", a.Name)
+			fire(a)
+			if err := am.noteNotification(a, NOTIFIED_MSG); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// fire triggers the actions of the given Alert. The actions run in the
+// background and get a copy of the Alert, since it may be modified meanwhile.
+func fire(a *Alert) {
+	c := a.Copy()
+	for _, action := range c.Actions {
+		action := action
+		runAction(c, action, func() { action.Fire(c) })
+	}
+}
+
 // activeAlert returns the ID for the active alert with the given name, or
 // zero if no alert with the given name is active.
 func (am *AlertManager) activeAlert(name string) int64 {
@@ -123,18 +230,28 @@ func (am *AlertManager) updateAlert(a *Alert) error {
 	if err := a.retryReplaceIntoDB(); err != nil {
 		return err
 	}
-	return am.reloadAlerts()
+	if err := am.reloadAlerts(); err != nil {
+		return err
+	}
+	if active, ok := am.activeAlerts[a.Id]; ok && a.Tags != nil {
+		active.Tags = a.Tags
+	}
+	return nil
 }
 
-// reloadAlerts reloads the active alerts from the database. Assumes the caller
-// holds a write lock.
+// reloadAlerts reloads the active alerts from the database, keeping their
+// Tags, which are not stored. Assumes the caller holds a write lock.
 func (am *AlertManager) reloadAlerts() error {
 	activeAlerts, err := GetActiveAlerts()
 	if err != nil {
 		return err
 	}
+	old := am.activeAlerts
 	am.activeAlerts = map[int64]*Alert{}
 	for _, a := range activeAlerts {
+		if prev, ok := old[a.Id]; ok {
+			a.Tags = prev.Tags
+		}
 		am.activeAlerts[a.Id] = a
 	}
 	return nil
@@ -214,14 +331,20 @@ func (am *AlertManager) Dismiss(id int64, user, message string) error {
 	if !ok {
 		return fmt.Errorf("Unknown alert: %d", id)
 	}
-	return am.dismiss(a, user, message)
+	if err := am.dismiss(a, user, message); err != nil {
+		return err
+	}
+	return am.releaseInhibited(time.Now())
 }
 
 // Add a comment to the given alert. Assumes the caller holds a write lock.
 func (am *AlertManager) addComment(a *Alert, c *Comment) error {
 	a.Comments = append(a.Comments, c)
-	for _, action := range a.Actions {
-		go action.Followup(a, fmt.Sprintf("%s: %s", c.User, c.Message))
+	cp := a.Copy()
+	msg := fmt.Sprintf("%s: %s", c.User, c.Message)
+	for _, action := range cp.Actions {
+		action := action
+		runAction(cp, action, func() { action.Followup(cp, msg) })
 	}
 	return am.updateAlert(a)
 }
@@ -279,7 +402,36 @@ func (am *AlertManager) tick() error {
 		}
 	}
 
-	return am.reloadAlerts()
+	if err := am.reloadAlerts(); err != nil {
+		return err
+	}
+
+	if !am.recovered {
+		if err := am.recoverNotifications(); err != nil {
+			return err
+		}
+		am.recovered = true
+	}
+
+	// Send the notifications which are no longer inhibited, eg. because
+	// the inhibiting alert was auto-dismissed.
+	if err := am.releaseInhibited(time.Now()); err != nil {
+		return err
+	}
+
+	// Send the notifications for groups whose window has passed.
+	for _, g := range am.readyGroups(time.Now()) {
+		members := g.members(am.activeAlerts)
+		if a := g.notification(am.activeAlerts); a != nil {
+			fire(a)
+		}
+		for _, m := range members {
+			if err := am.noteNotification(m, NOTIFIED_MSG); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // loop runs the AlertManager's main loop.
@@ -311,6 +463,8 @@ func MakeAlertManager(tickInterval time.Duration, e *email.GMail) (*AlertManager
 	}
 	initEmail(e)
 	Manager = &AlertManager{
+		groups:       map[string]*alertGroup{},
+		inhibited:    map[int64]bool{},
 		interrupt:    make(chan bool),
 		tickInterval: tickInterval,
 	}
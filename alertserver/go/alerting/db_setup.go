@@ -95,6 +95,19 @@ var v2_down = []string{
 	`ALTER TABLE alerts DROP COLUMN lastFired;`,
 }
 
+var v3_up = []string{
+	`ALTER TABLE alerts ADD COLUMN rule VARCHAR(100) NOT NULL DEFAULT '';`,
+	`UPDATE alerts SET rule = name;`,
+	`CREATE INDEX idx_rule ON alerts (rule);`,
+	`CREATE INDEX idx_triggered ON alerts (triggered);`,
+}
+
+var v3_down = []string{
+	`DROP INDEX idx_triggered ON alerts;`,
+	`DROP INDEX idx_rule ON alerts;`,
+	`ALTER TABLE alerts DROP COLUMN rule;`,
+}
+
 // Define the migration steps.
 // Note: Only add to this list, once a step has landed in version control it
 // must not be changed.
@@ -109,6 +122,11 @@ var migrationSteps = []database.MigrationStep{
 		MySQLUp:   v2_up,
 		MySQLDown: v2_down,
 	},
+	// version 3. Record the rule which triggered each alert, for the history.
+	{
+		MySQLUp:   v3_up,
+		MySQLDown: v3_down,
+	},
 }
 
 // MigrationSteps returns the database migration steps.
@@ -0,0 +1,284 @@
+package alerting
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.skia.org/infra/go/util"
+)
+
+/*
+	History of past and present alerts, and statistics about how quickly they
+	were handled.
+*/
+
+// HISTORY_COMMENTS_CHUNK_SIZE is the maximum number of Alerts whose Comments
+// are retrieved with one query, to stay below the limit on the number of
+// placeholders in a statement.
+const HISTORY_COMMENTS_CHUNK_SIZE = 1000
+
+// HistoryQuery selects Alerts from the history. Empty fields match any Alert.
+type HistoryQuery struct {
+	Id       int64
+	Rule     string
+	Category string
+	// Alerts which triggered in [Begin, End) are selected.
+	Begin time.Time
+	End   time.Time
+}
+
+// GetAlertHistory retrieves the Alerts matching the query, active or not,
+// along with their Comments. Actions are not retrieved. The Comments are
+// retrieved in chunks of HISTORY_COMMENTS_CHUNK_SIZE Alerts.
+func GetAlertHistory(q *HistoryQuery) ([]*Alert, error) {
+	where := []string{}
+	args := []interface{}{}
+	if q.Id != 0 {
+		where = append(where, "id = ?")
+		args = append(args, q.Id)
+	}
+	if q.Rule != "" {
+		where = append(where, "rule = ?")
+		args = append(args, q.Rule)
+	}
+	if q.Category != "" {
+		where = append(where, "category = ?")
+		args = append(args, q.Category)
+	}
+	if !q.Begin.IsZero() {
+		where = append(where, "triggered >= ?")
+		args = append(args, q.Begin.Unix())
+	}
+	if !q.End.IsZero() {
+		where = append(where, "triggered < ?")
+		args = append(args, q.End.Unix())
+	}
+	stmt := fmt.Sprintf("SELECT id,name,rule,category,triggered,snoozedUntil,dismissedAt,message,nag,autoDismiss,lastFired FROM %s", TABLE_ALERTS)
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += " ORDER BY triggered;"
+	rv := []*Alert{}
+	if err := DB.Select(&rv, stmt, args...); err != nil {
+		return nil, fmt.Errorf("Could not retrieve alert history: %v", err)
+	}
+	if len(rv) == 0 {
+		return rv, nil
+	}
+
+	alertsById := make(map[int64]*Alert, len(rv))
+	for _, a := range rv {
+		a.Comments = []*Comment{}
+		alertsById[a.Id] = a
+	}
+	for start := 0; start < len(rv); start += HISTORY_COMMENTS_CHUNK_SIZE {
+		end := util.MinInt(start+HISTORY_COMMENTS_CHUNK_SIZE, len(rv))
+		interfaceIds := make([]interface{}, 0, end-start)
+		for _, a := range rv[start:end] {
+			interfaceIds = append(interfaceIds, a.Id)
+		}
+		inputTmpl := util.RepeatJoin("?", ",", len(interfaceIds))
+		comments := []*commentFromDB{}
+		if err := DB.Select(&comments, fmt.Sprintf("SELECT * FROM %s WHERE alertId IN (%s) ORDER BY time;", TABLE_COMMENTS, inputTmpl), interfaceIds...); err != nil {
+			return nil, fmt.Errorf("Could not retrieve comments for alert history: %v", err)
+		}
+		for _, c := range comments {
+			alertsById[c.AlertId].Comments = append(alertsById[c.AlertId].Comments, c.toComment())
+		}
+	}
+	return rv, nil
+}
+
+// AcknowledgedAt returns the time at which a user first commented on, snoozed
+// or dismissed the Alert, or zero if nobody has. Comments made by the alert
+// server itself, eg. nags and automatic dismissals, do not count.
+func (a *Alert) AcknowledgedAt() int64 {
+	for _, c := range a.Comments {
+		if c.User != USER_ALERTSERVER {
+			return c.Time
+		}
+	}
+	return 0
+}
+
+// RuleStats are statistics about the Alerts triggered by a rule.
+type RuleStats struct {
+	Rule     string `json:"rule"`
+	Category string `json:"category"`
+	// FireCount is the number of Alerts triggered.
+	FireCount int `json:"fireCount"`
+	// Acknowledged is the number of Alerts which a user commented on, snoozed
+	// or dismissed. The times to acknowledge are in seconds.
+	Acknowledged            int   `json:"acknowledged"`
+	MeanTimeToAcknowledge   int64 `json:"meanTimeToAcknowledge"`
+	MedianTimeToAcknowledge int64 `json:"medianTimeToAcknowledge"`
+	// Dismissed is the number of Alerts which were dismissed, by a user or
+	// automatically. The times to dismiss are in seconds; the mean is the
+	// mean time to repair (MTTR).
+	Dismissed           int   `json:"dismissed"`
+	MeanTimeToDismiss   int64 `json:"meanTimeToDismiss"`
+	MedianTimeToDismiss int64 `json:"medianTimeToDismiss"`
+}
+
+// meanAndMedian returns the mean and median of the given values, which are
+// sorted in place.
+func meanAndMedian(values []int64) (int64, int64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	sort.Sort(util.Int64Slice(values))
+	sum := int64(0)
+	for _, v := range values {
+		sum += v
+	}
+	median := values[len(values)/2]
+	if len(values)%2 == 0 {
+		median = (values[len(values)/2-1] + values[len(values)/2]) / 2
+	}
+	return sum / int64(len(values)), median
+}
+
+// ruleStatsSlice sorts RuleStats by decreasing FireCount, then by Rule.
+type ruleStatsSlice []*RuleStats
+
+func (s ruleStatsSlice) Len() int { return len(s) }
+func (s ruleStatsSlice) Less(i, j int) bool {
+	if s[i].FireCount != s[j].FireCount {
+		return s[i].FireCount > s[j].FireCount
+	}
+	return s[i].Rule < s[j].Rule
+}
+func (s ruleStatsSlice) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// ComputeStats returns the statistics for each rule which triggered any of
+// the given Alerts, the most frequently triggered first.
+func ComputeStats(alerts []*Alert) []*RuleStats {
+	stats := map[string]*RuleStats{}
+	ackTimes := map[string][]int64{}
+	dismissTimes := map[string][]int64{}
+	for _, a := range alerts {
+		rule := a.Rule
+		if rule == "" {
+			rule = a.Name
+		}
+		s, ok := stats[rule]
+		if !ok {
+			s = &RuleStats{
+				Rule:     rule,
+				Category: a.Category,
+			}
+			stats[rule] = s
+		}
+		s.FireCount++
+		if ack := a.AcknowledgedAt(); ack != 0 {
+			s.Acknowledged++
+			ackTimes[rule] = append(ackTimes[rule], ack-a.Triggered)
+		}
+		if a.DismissedAt != 0 {
+			s.Dismissed++
+			dismissTimes[rule] = append(dismissTimes[rule], a.DismissedAt-a.Triggered)
+		}
+	}
+	rv := make([]*RuleStats, 0, len(stats))
+	for rule, s := range stats {
+		s.MeanTimeToAcknowledge, s.MedianTimeToAcknowledge = meanAndMedian(ackTimes[rule])
+		s.MeanTimeToDismiss, s.MedianTimeToDismiss = meanAndMedian(dismissTimes[rule])
+		rv = append(rv, s)
+	}
+	sort.Sort(ruleStatsSlice(rv))
+	return rv
+}
+
+// HISTORY_CSV_HEADER is the header of the CSV written by WriteHistoryCSV.
+var HISTORY_CSV_HEADER = []string{"id", "rule", "name", "category", "triggered", "acknowledged", "dismissed", "time_to_acknowledge_s", "time_to_dismiss_s", "message", "comments"}
+
+// formatTime formats a time in seconds since the epoch, or returns an empty
+// string if it is zero.
+func formatTime(t int64) string {
+	if t == 0 {
+		return ""
+	}
+	return time.Unix(t, 0).UTC().Format(time.RFC3339)
+}
+
+// formatComment formats a Comment as a line of a timeline.
+func formatComment(c *Comment) string {
+	return fmt.Sprintf("%s %s: %s", formatTime(c.Time), c.User, c.Message)
+}
+
+// WriteHistoryCSV writes the given Alerts as CSV, one row per Alert, for use
+// in incident reviews.
+func WriteHistoryCSV(w io.Writer, alerts []*Alert) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(HISTORY_CSV_HEADER); err != nil {
+		return err
+	}
+	for _, a := range alerts {
+		ack := a.AcknowledgedAt()
+		timeToAck := ""
+		if ack != 0 {
+			timeToAck = strconv.FormatInt(ack-a.Triggered, 10)
+		}
+		timeToDismiss := ""
+		if a.DismissedAt != 0 {
+			timeToDismiss = strconv.FormatInt(a.DismissedAt-a.Triggered, 10)
+		}
+		comments := make([]string, 0, len(a.Comments))
+		for _, c := range a.Comments {
+			comments = append(comments, formatComment(c))
+		}
+		if err := writer.Write([]string{
+			strconv.FormatInt(a.Id, 10),
+			a.Rule,
+			a.Name,
+			a.Category,
+			formatTime(a.Triggered),
+			formatTime(ack),
+			formatTime(a.DismissedAt),
+			timeToAck,
+			timeToDismiss,
+			a.Message,
+			strings.Join(comments, "\n"),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteIncidentReport writes a plain text post-incident report for the Alert,
+// with its timeline.
+func WriteIncidentReport(w io.Writer, a *Alert) error {
+	lines := []string{
+		fmt.Sprintf("Incident report for alert %d: %s", a.Id, a.Name),
+		"",
+		fmt.Sprintf("Rule: %s", a.Rule),
+		fmt.Sprintf("Category: %s", a.Category),
+		fmt.Sprintf("Message: %s", a.Message),
+		"",
+		"Timeline:",
+		fmt.Sprintf("%s Triggered", formatTime(a.Triggered)),
+	}
+	for _, c := range a.Comments {
+		lines = append(lines, formatComment(c))
+	}
+	lines = append(lines, "")
+	if ack := a.AcknowledgedAt(); ack != 0 {
+		lines = append(lines, fmt.Sprintf("Time to acknowledge: %s", time.Duration(ack-a.Triggered)*time.Second))
+	} else {
+		lines = append(lines, "Not acknowledged.")
+	}
+	if a.DismissedAt != 0 {
+		lines = append(lines, fmt.Sprintf("Time to dismiss: %s", time.Duration(a.DismissedAt-a.Triggered)*time.Second))
+	} else {
+		lines = append(lines, "Still active.")
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
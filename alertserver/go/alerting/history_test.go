@@ -0,0 +1,166 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+)
+
+// makeHistory returns example Alerts for two rules.
+func makeHistory() []*Alert {
+	return []*Alert{
+		&Alert{
+			Id:          1,
+			Name:        "Build Failed",
+			Rule:        "Build Failed",
+			Category:    "infra",
+			Triggered:   1000,
+			DismissedAt: 1600,
+			Comments: []*Comment{
+				&Comment{User: USER_ALERTSERVER, Time: 1010, Message: "Nag"},
+				&Comment{User: "me", Time: 1100, Message: "Looking into it."},
+				&Comment{User: "me", Time: 1600, Message: "Dismissed."},
+			},
+		},
+		&Alert{
+			Id:          2,
+			Name:        "Build Failed",
+			Rule:        "Build Failed",
+			Category:    "infra",
+			Triggered:   2000,
+			DismissedAt: 2400,
+			Comments: []*Comment{
+				&Comment{User: "you", Time: 2300, Message: "Snoozed."},
+			},
+		},
+		&Alert{
+			Id:          3,
+			Name:        "Build Failed",
+			Rule:        "Build Failed",
+			Category:    "infra",
+			Triggered:   3000,
+			DismissedAt: 3200,
+			Comments: []*Comment{
+				&Comment{User: USER_ALERTSERVER, Time: 3200, Message: "Auto-dismissed."},
+			},
+		},
+		&Alert{
+			Id:        4,
+			Name:      "Disk Full",
+			Rule:      "Disk Full",
+			Category:  "bots",
+			Triggered: 4000,
+			Comments:  []*Comment{},
+		},
+	}
+}
+
+func TestAcknowledgedAt(t *testing.T) {
+	testutils.SmallTest(t)
+	h := makeHistory()
+	expect.Equal(t, int64(1100), h[0].AcknowledgedAt())
+	expect.Equal(t, int64(2300), h[1].AcknowledgedAt())
+	expect.Equal(t, int64(0), h[2].AcknowledgedAt())
+	expect.Equal(t, int64(0), h[3].AcknowledgedAt())
+}
+
+func TestComputeStats(t *testing.T) {
+	testutils.SmallTest(t)
+	expect.Equal(t, []*RuleStats{}, ComputeStats([]*Alert{}))
+	testutils.AssertDeepEqual(t, []*RuleStats{
+		&RuleStats{
+			Rule:                    "Build Failed",
+			Category:                "infra",
+			FireCount:               3,
+			Acknowledged:            2,
+			MeanTimeToAcknowledge:   200,
+			MedianTimeToAcknowledge: 200,
+			Dismissed:               3,
+			MeanTimeToDismiss:       400,
+			MedianTimeToDismiss:     400,
+		},
+		&RuleStats{
+			Rule:      "Disk Full",
+			Category:  "bots",
+			FireCount: 1,
+		},
+	}, ComputeStats(makeHistory()))
+
+	// Alerts from before rules were recorded fall back to their names.
+	stats := ComputeStats([]*Alert{&Alert{Name: "Old Alert"}})
+	assert.Equal(t, 1, len(stats))
+	expect.Equal(t, "Old Alert", stats[0].Rule)
+}
+
+func TestWriteHistoryCSV(t *testing.T) {
+	testutils.SmallTest(t)
+	var buf bytes.Buffer
+	assert.NoError(t, WriteHistoryCSV(&buf, makeHistory()))
+	records, err := csv.NewReader(&buf).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(records))
+	expect.Equal(t, HISTORY_CSV_HEADER, records[0])
+	expect.Equal(t, []string{"1", "Build Failed", "Build Failed", "infra", "1970-01-01T00:16:40Z", "1970-01-01T00:18:20Z", "1970-01-01T00:26:40Z", "100", "600"}, records[1][:9])
+	expect.Equal(t, 3, len(strings.Split(records[1][10], "\n")))
+	expect.Equal(t, []string{"4", "Disk Full", "Disk Full", "bots", "1970-01-01T01:06:40Z", "", "", "", ""}, records[4][:9])
+}
+
+func TestWriteIncidentReport(t *testing.T) {
+	testutils.SmallTest(t)
+	h := makeHistory()
+	var buf bytes.Buffer
+	assert.NoError(t, WriteIncidentReport(&buf, h[0]))
+	report := buf.String()
+	expect.Contains(t, report, "Incident report for alert 1: Build Failed")
+	expect.Contains(t, report, "1970-01-01T00:18:20Z me: Looking into it.")
+	expect.Contains(t, report, "Time to acknowledge: 1m40s")
+	expect.Contains(t, report, "Time to dismiss: 10m0s")
+
+	buf.Reset()
+	assert.NoError(t, WriteIncidentReport(&buf, h[3]))
+	report = buf.String()
+	expect.Contains(t, report, "Not acknowledged.")
+	expect.Contains(t, report, "Still active.")
+}
+
+// TestGetAlertHistory verifies that Alerts and their Comments are retrieved
+// from the DB by rule, category and date range.
+func TestGetAlertHistory(t *testing.T) {
+	testutils.MediumTest(t)
+	testutils.SkipIfShort(t)
+	d := clearDB(t)
+	defer d.Close(t)
+
+	for _, a := range makeHistory() {
+		assert.NoError(t, a.retryReplaceIntoDB())
+	}
+
+	check := func(q *HistoryQuery, ids ...int64) {
+		alerts, err := GetAlertHistory(q)
+		assert.NoError(t, err)
+		var got []int64
+		for _, a := range alerts {
+			got = append(got, a.Id)
+		}
+		expect.Equal(t, ids, got)
+	}
+	check(&HistoryQuery{}, 1, 2, 3, 4)
+	check(&HistoryQuery{Id: 2}, 2)
+	check(&HistoryQuery{Rule: "Disk Full"}, 4)
+	check(&HistoryQuery{Category: "infra"}, 1, 2, 3)
+	check(&HistoryQuery{Begin: time.Unix(2000, 0), End: time.Unix(4000, 0)}, 2, 3)
+	check(&HistoryQuery{Rule: "No Such Rule"})
+
+	alerts, err := GetAlertHistory(&HistoryQuery{Id: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, 3, len(alerts[0].Comments))
+	expect.Equal(t, "Looking into it.", alerts[0].Comments[1].Message)
+	expect.Equal(t, int64(1100), alerts[0].AcknowledgedAt())
+}
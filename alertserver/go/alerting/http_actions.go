@@ -0,0 +1,312 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/httputils"
+	"go.skia.org/infra/go/issues"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/go/webhook"
+)
+
+const (
+	// Values of the "event" field of webhook payloads.
+	WEBHOOK_EVENT_FIRE     = "fire"
+	WEBHOOK_EVENT_FOLLOWUP = "followup"
+
+	// Issues filed by IssueTrackerActions are labeled with ISSUE_LABEL_PREFIX
+	// followed by the Id of the Alert, so that they can be found again.
+	ISSUE_LABEL_PREFIX   = "AlertServer-"
+	ISSUE_STATUS_NEW     = "New"
+	ISSUE_STATUS_FIXED   = "Fixed"
+	ISSUE_SUMMARY_TMPL   = "Skia Alert: %s"
+	ISSUE_DESCRIPTION    = "%s\n\nThis issue was filed by the Skia alert server and will be closed when the alert is dismissed.\nTo snooze or dismiss this alert, visit %s"
+	CHAT_FIRE_TMPL       = "Alert %q triggered: %s\n%s"
+	CHAT_FOLLOWUP_TMPL   = "Alert %q: %s\n%s"
+	ACTION_RETRY_INITIAL = 5 * time.Second
+	ACTION_RETRY_MAX     = 5 * time.Minute
+	ACTION_RETRY_TIMEOUT = 30 * time.Minute
+)
+
+var (
+	// httpClient is used by the Actions which send HTTP requests.
+	httpClient = httputils.NewTimeoutClient()
+
+	// newBackOff returns the BackOff used to retry failed Actions. Replaced
+	// in tests.
+	newBackOff = func() backoff.BackOff {
+		b := backoff.NewExponentialBackOff()
+		b.InitialInterval = ACTION_RETRY_INITIAL
+		b.MaxInterval = ACTION_RETRY_MAX
+		b.MaxElapsedTime = ACTION_RETRY_TIMEOUT
+		return b
+	}
+
+	// chatRooms maps the names of chat rooms to their incoming webhook URLs.
+	chatRooms = map[string]string{}
+
+	// issueTracker is used by IssueTrackerActions.
+	issueTracker issues.IssueTracker = nil
+)
+
+// InitChatRooms reads the chat rooms available to ChatActions from the given
+// JSON file, which maps room names to their incoming webhook URLs.
+func InitChatRooms(filename string) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to read chat rooms file: %s", err)
+	}
+	rooms := map[string]string{}
+	if err := json.Unmarshal(b, &rooms); err != nil {
+		return fmt.Errorf("Failed to decode chat rooms file %s: %s", filename, err)
+	}
+	chatRooms = rooms
+	return nil
+}
+
+// InitIssueTracker sets the IssueTracker used by IssueTrackerActions.
+func InitIssueTracker(tracker issues.IssueTracker) {
+	issueTracker = tracker
+}
+
+// retry runs the given function until it succeeds or the BackOff returned by
+// newBackOff gives up, in which case the last error is returned.
+func retry(desc string, fn func() error) error {
+	return backoff.RetryNotify(fn, newBackOff(), func(err error, wait time.Duration) {
+		glog.Warningf("%s failed; retrying in %s: %s", desc, wait, err)
+	})
+}
+
+// postJSON sends the given JSON body to the given URL, along with any extra
+// headers. Responses with a non-2xx status are considered errors.
+func postJSON(url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Failed to create request for %s: %s", url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Request to %s failed: %s", url, err)
+	}
+	defer util.Close(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Request to %s failed with status %q", url, resp.Status)
+	}
+	return nil
+}
+
+// WebhookAction POSTs a JSON payload describing the Alert to a URL. Requests
+// are signed using the go/webhook scheme, so the salt must be initialized.
+type WebhookAction struct {
+	url string
+	str string
+}
+
+// webhookPayload is the body of the requests sent by WebhookActions.
+type webhookPayload struct {
+	Event   string `json:"event"`
+	Message string `json:"message"`
+	Link    string `json:"link"`
+	Alert   *Alert `json:"alert"`
+}
+
+func (a *WebhookAction) send(alert *Alert, event, msg string) {
+	body, err := json.Marshal(&webhookPayload{
+		Event:   event,
+		Message: msg,
+		Link:    getLinkToAlert(alert),
+		Alert:   alert,
+	})
+	if err != nil {
+		glog.Errorf("Failed to encode webhook payload for alert %q: %s", alert.Name, err)
+		return
+	}
+	hash, err := webhook.ComputeAuthHashBase64(body)
+	if err != nil {
+		glog.Errorf("Failed to sign webhook payload for alert %q: %s", alert.Name, err)
+		return
+	}
+	headers := map[string]string{webhook.REQUEST_AUTH_HASH_HEADER: hash}
+	if err := retry(a.str, func() error {
+		return postJSON(a.url, body, headers)
+	}); err != nil {
+		glog.Errorf("%s failed for alert %q: %s", a.str, alert.Name, err)
+	}
+}
+
+func (a *WebhookAction) Fire(alert *Alert) {
+	a.send(alert, WEBHOOK_EVENT_FIRE, alert.Message)
+}
+
+func (a *WebhookAction) Followup(alert *Alert, msg string) {
+	a.send(alert, WEBHOOK_EVENT_FOLLOWUP, msg)
+}
+
+func (a *WebhookAction) String() string {
+	return a.str
+}
+
+func NewWebhookAction(url, str string) Action {
+	return &WebhookAction{
+		url: url,
+		str: str,
+	}
+}
+
+// ChatAction posts a message about the Alert to a chat room. The room must be
+// one of those read by InitChatRooms.
+type ChatAction struct {
+	room string
+	str  string
+}
+
+func (a *ChatAction) send(alert *Alert, text string) {
+	url, ok := chatRooms[a.room]
+	if !ok {
+		glog.Errorf("%s failed for alert %q: unknown chat room %q", a.str, alert.Name, a.room)
+		return
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		glog.Errorf("Failed to encode chat message for alert %q: %s", alert.Name, err)
+		return
+	}
+	if err := retry(a.str, func() error {
+		return postJSON(url, body, nil)
+	}); err != nil {
+		glog.Errorf("%s failed for alert %q: %s", a.str, alert.Name, err)
+	}
+}
+
+func (a *ChatAction) Fire(alert *Alert) {
+	a.send(alert, fmt.Sprintf(CHAT_FIRE_TMPL, alert.Name, alert.Message, getLinkToAlert(alert)))
+}
+
+func (a *ChatAction) Followup(alert *Alert, msg string) {
+	a.send(alert, fmt.Sprintf(CHAT_FOLLOWUP_TMPL, alert.Name, msg, getLinkToAlert(alert)))
+}
+
+func (a *ChatAction) String() string {
+	return a.str
+}
+
+func NewChatAction(room, str string) Action {
+	return &ChatAction{
+		room: room,
+		str:  str,
+	}
+}
+
+// IssueTrackerAction files an issue in the given component when the Alert
+// fires, comments on it for each followup and closes it when the Alert is
+// dismissed. The IssueTracker must be set with InitIssueTracker.
+type IssueTrackerAction struct {
+	component string
+	str       string
+}
+
+// issueLabel returns the label which identifies the issue for the Alert.
+func issueLabel(alert *Alert) string {
+	return fmt.Sprintf("%s%d", ISSUE_LABEL_PREFIX, alert.Id)
+}
+
+// findIssues returns the open issues filed for the Alert.
+func findIssues(alert *Alert) ([]issues.Issue, error) {
+	return issueTracker.FromQuery("label:" + issueLabel(alert))
+}
+
+func (a *IssueTrackerAction) Fire(alert *Alert) {
+	if issueTracker == nil {
+		glog.Errorf("%s failed for alert %q: no issue tracker", a.str, alert.Name)
+		return
+	}
+	req := issues.IssueRequest{
+		Status:      ISSUE_STATUS_NEW,
+		Labels:      []string{issueLabel(alert), "Type-Defect"},
+		Components:  []string{a.component},
+		Summary:     fmt.Sprintf(ISSUE_SUMMARY_TMPL, alert.Name),
+		Description: fmt.Sprintf(ISSUE_DESCRIPTION, alert.Message, getLinkToAlert(alert)),
+	}
+	if err := retry(a.str, func() error {
+		// A previous attempt may have filed the issue despite failing.
+		found, err := findIssues(alert)
+		if err != nil {
+			return err
+		}
+		if len(found) > 0 {
+			return nil
+		}
+		return issueTracker.AddIssue(req)
+	}); err != nil {
+		glog.Errorf("%s failed for alert %q: %s", a.str, alert.Name, err)
+	}
+}
+
+func (a *IssueTrackerAction) Followup(alert *Alert, msg string) {
+	if issueTracker == nil {
+		glog.Errorf("%s failed for alert %q: no issue tracker", a.str, alert.Name)
+		return
+	}
+	comment := issues.CommentRequest{
+		Content: msg,
+	}
+	if alert.DismissedAt != 0 {
+		comment.Updates = &issues.CommentUpdates{
+			Status: ISSUE_STATUS_FIXED,
+		}
+	}
+	var found []issues.Issue
+	if err := retry(a.str, func() error {
+		var err error
+		found, err = findIssues(alert)
+		return err
+	}); err != nil {
+		glog.Errorf("%s failed to find issues for alert %q: %s", a.str, alert.Name, err)
+		return
+	}
+	if len(found) == 0 {
+		glog.Warningf("%s: no open issue for alert %q", a.str, alert.Name)
+		return
+	}
+	for _, issue := range found {
+		id := strconv.FormatInt(issue.ID, 10)
+		if err := retry(a.str, func() error {
+			return issueTracker.AddComment(id, comment)
+		}); err != nil {
+			glog.Errorf("%s failed to comment on issue %s for alert %q: %s", a.str, id, alert.Name, err)
+		}
+	}
+}
+
+func (a *IssueTrackerAction) String() string {
+	return a.str
+}
+
+func NewIssueTrackerAction(component, str string) Action {
+	return &IssueTrackerAction{
+		component: component,
+		str:       str,
+	}
+}
+
+// parseArg returns the argument of an action string of the form "Name(arg)",
+// and whether the string has that form.
+func parseArg(str, name string) (string, bool) {
+	if strings.HasPrefix(str, name+"(") && strings.HasSuffix(str, ")") {
+		return strings.TrimSpace(str[len(name)+1 : len(str)-1]), true
+	}
+	return "", false
+}
@@ -0,0 +1,250 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/gorilla/mux"
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/issues"
+	"go.skia.org/infra/go/mockhttpclient"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/webhook"
+)
+
+// testBackOff retries immediately, up to three times.
+type testBackOff struct {
+	retries int
+}
+
+func (b *testBackOff) NextBackOff() time.Duration {
+	if b.retries >= 3 {
+		return backoff.Stop
+	}
+	b.retries++
+	return 0
+}
+
+func (b *testBackOff) Reset() {
+	b.retries = 0
+}
+
+// setHTTPClient makes the Actions use the given client and retry failures
+// immediately. Returns a function which restores the defaults.
+func setHTTPClient(c *http.Client) func() {
+	oldClient := httpClient
+	oldBackOff := newBackOff
+	httpClient = c
+	newBackOff = func() backoff.BackOff {
+		return &testBackOff{}
+	}
+	return func() {
+		httpClient = oldClient
+		newBackOff = oldBackOff
+	}
+}
+
+// encodeJSON returns the given value encoded the way go/issues encodes requests.
+func encodeJSON(t *testing.T, v interface{}) []byte {
+	b := new(bytes.Buffer)
+	assert.NoError(t, json.NewEncoder(b).Encode(v))
+	return b.Bytes()
+}
+
+func TestParseHTTPActions(t *testing.T) {
+	testutils.SmallTest(t)
+	for _, str := range []string{"Webhook(https://example.com/hook)", "Chat(skia-infra)", "IssueTracker(Infra>Alerts)"} {
+		a, err := ParseAction(str)
+		assert.NoError(t, err)
+		expect.Equal(t, str, a.String())
+	}
+	a, err := ParseAction("Webhook(https://example.com/hook)")
+	assert.NoError(t, err)
+	expect.Equal(t, "https://example.com/hook", a.(*WebhookAction).url)
+	a, err = ParseAction("Chat( skia-infra )")
+	assert.NoError(t, err)
+	expect.Equal(t, "skia-infra", a.(*ChatAction).room)
+	a, err = ParseAction("IssueTracker(Infra>Alerts)")
+	assert.NoError(t, err)
+	expect.Equal(t, "Infra>Alerts", a.(*IssueTrackerAction).component)
+
+	for _, str := range []string{"Webhook()", "Chat(", "IssueTracker"} {
+		_, err := ParseAction(str)
+		expect.Error(t, err, str)
+	}
+}
+
+func TestWebhookAction(t *testing.T) {
+	testutils.SmallTest(t)
+	webhook.InitRequestSaltForTesting()
+	payloads := []*webhookPayload{}
+	calls := 0
+	r := mux.NewRouter()
+	r.Schemes("https").Host("example.com").Path("/hook").Methods("POST").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := mockhttpclient.MuxSafeT(t)
+		calls++
+		// Fail the first request to exercise the retries.
+		if calls == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		data, err := webhook.AuthenticateRequest(r)
+		assert.NoError(t, err)
+		p := &webhookPayload{}
+		assert.NoError(t, json.Unmarshal(data, p))
+		payloads = append(payloads, p)
+	})
+	defer setHTTPClient(mockhttpclient.NewMuxClient(r))()
+
+	alert := makeAlert()
+	a, err := ParseAction("Webhook(https://example.com/hook)")
+	assert.NoError(t, err)
+	a.Fire(alert)
+	a.Followup(alert, "me: Dismissed")
+	expect.Equal(t, 3, calls)
+	assert.Len(t, payloads, 2)
+	expect.Equal(t, WEBHOOK_EVENT_FIRE, payloads[0].Event)
+	expect.Equal(t, alert.Message, payloads[0].Message)
+	expect.Equal(t, getLinkToAlert(alert), payloads[0].Link)
+	expect.Equal(t, alert.Id, payloads[0].Alert.Id)
+	expect.Equal(t, alert.Name, payloads[0].Alert.Name)
+	expect.Equal(t, WEBHOOK_EVENT_FOLLOWUP, payloads[1].Event)
+	expect.Equal(t, "me: Dismissed", payloads[1].Message)
+}
+
+func TestChatAction(t *testing.T) {
+	testutils.SmallTest(t)
+	f, err := ioutil.TempFile("", "chat_rooms")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.Remove(f.Name()))
+	}()
+	_, err = f.WriteString(`{"skia-infra": "https://chat.example.com/room/123"}`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	oldRooms := chatRooms
+	defer func() {
+		chatRooms = oldRooms
+	}()
+	assert.NoError(t, InitChatRooms(f.Name()))
+
+	alert := makeAlert()
+	urlMock := mockhttpclient.NewURLMock()
+	defer setHTTPClient(urlMock.Client())()
+	fire, err := json.Marshal(map[string]string{"text": "Alert \"My Dummy Alert\" triggered: This is a test!\n" + getLinkToAlert(alert)})
+	assert.NoError(t, err)
+	followup, err := json.Marshal(map[string]string{"text": "Alert \"My Dummy Alert\": me: Looking\n" + getLinkToAlert(alert)})
+	assert.NoError(t, err)
+	// The first request fails and is retried.
+	urlMock.MockOnce("https://chat.example.com/room/123", mockhttpclient.MockGetError("Bad Request", http.StatusBadRequest))
+	urlMock.MockOnce("https://chat.example.com/room/123", mockhttpclient.MockPostDialogue("application/json", fire, []byte{}))
+	urlMock.MockOnce("https://chat.example.com/room/123", mockhttpclient.MockPostDialogue("application/json", followup, []byte{}))
+
+	a := NewChatAction("skia-infra", "Chat(skia-infra)")
+	a.Fire(alert)
+	a.Followup(alert, "me: Looking")
+	expect.True(t, urlMock.Empty())
+
+	// Unknown rooms are not posted to.
+	NewChatAction("unknown", "Chat(unknown)").Fire(alert)
+	expect.True(t, urlMock.Empty())
+}
+
+func TestIssueTrackerAction(t *testing.T) {
+	testutils.SmallTest(t)
+	urlMock := mockhttpclient.NewURLMock()
+	defer setHTTPClient(urlMock.Client())()
+	oldTracker := issueTracker
+	defer func() {
+		issueTracker = oldTracker
+	}()
+	InitIssueTracker(issues.NewMonorailIssueTracker(urlMock.Client()))
+
+	alert := makeAlert()
+	q := url.Values{}
+	q.Add("q", "label:AlertServer-9")
+	q.Add("fields", "items/id,items/state,items/title")
+	queryURL := issues.MONORAIL_BASE_URL + "?" + q.Encode()
+	noIssues := encodeJSON(t, &issues.IssueResponse{Items: []issues.Issue{}})
+	oneIssue := encodeJSON(t, &issues.IssueResponse{Items: []issues.Issue{{ID: 1234, Title: "Skia Alert: My Dummy Alert", State: "open"}}})
+	commentURL := issues.MONORAIL_BASE_URL + "/1234/comments"
+
+	// Fire files an issue, retrying if the tracker fails.
+	urlMock.MockOnce(queryURL, mockhttpclient.MockGetDialogue(noIssues))
+	urlMock.MockOnce(issues.MONORAIL_BASE_URL, mockhttpclient.MockGetError("Internal Server Error", http.StatusInternalServerError))
+	urlMock.MockOnce(queryURL, mockhttpclient.MockGetDialogue(noIssues))
+	urlMock.MockOnce(issues.MONORAIL_BASE_URL, mockhttpclient.MockPostDialogue("application/json", encodeJSON(t, &issues.IssueRequest{
+		Status:      ISSUE_STATUS_NEW,
+		Labels:      []string{"AlertServer-9", "Type-Defect"},
+		Components:  []string{"Infra>Alerts"},
+		Summary:     "Skia Alert: My Dummy Alert",
+		Description: "This is a test!\n\nThis issue was filed by the Skia alert server and will be closed when the alert is dismissed.\nTo snooze or dismiss this alert, visit https://alerts.skia.org",
+	}), []byte("{}")))
+	a := NewIssueTrackerAction("Infra>Alerts", "IssueTracker(Infra>Alerts)")
+	a.Fire(alert)
+	expect.True(t, urlMock.Empty())
+
+	// Fire does not file a duplicate issue.
+	urlMock.MockOnce(queryURL, mockhttpclient.MockGetDialogue(oneIssue))
+	a.Fire(alert)
+	expect.True(t, urlMock.Empty())
+
+	// Followups are added as comments.
+	urlMock.MockOnce(queryURL, mockhttpclient.MockGetDialogue(oneIssue))
+	urlMock.MockOnce(commentURL, mockhttpclient.MockPostDialogue("application/json", encodeJSON(t, &issues.CommentRequest{
+		Content: "me: Looking",
+	}), []byte("{}")))
+	a.Followup(alert, "me: Looking")
+	expect.True(t, urlMock.Empty())
+
+	// Dismissing the alert closes the issue.
+	alert.DismissedAt = alert.LastFired
+	urlMock.MockOnce(queryURL, mockhttpclient.MockGetDialogue(oneIssue))
+	urlMock.MockOnce(commentURL, mockhttpclient.MockPostDialogue("application/json", encodeJSON(t, &issues.CommentRequest{
+		Content: "me: Dismissed",
+		Updates: &issues.CommentUpdates{Status: ISSUE_STATUS_FIXED},
+	}), []byte("{}")))
+	a.Followup(alert, "me: Dismissed")
+	expect.True(t, urlMock.Empty())
+
+	// Nothing is commented if the issue is already closed.
+	urlMock.MockOnce(queryURL, mockhttpclient.MockGetDialogue(noIssues))
+	a.Followup(alert, "me: Unsnoozed")
+	expect.True(t, urlMock.Empty())
+}
+
+func TestRunAction(t *testing.T) {
+	testutils.SmallTest(t)
+	alert := &Alert{Id: 1, Name: "My Alert"}
+	print := NewPrintAction()
+	email := NewEmailAction([]string{"infra@skia.org"}, "Email(infra@skia.org)")
+
+	release := make(chan bool)
+	calls := make(chan string, 10)
+	runAction(alert, print, func() {
+		<-release
+		calls <- "fire"
+	})
+	runAction(alert, print, func() { calls <- "followup" })
+	// Other Actions of the Alert aren't held up.
+	runAction(alert, email, func() { calls <- "email" })
+	expect.Equal(t, "email", <-calls)
+
+	// The followup waits for the fire to return.
+	select {
+	case c := <-calls:
+		t.Fatalf("%s ran before fire returned", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	expect.Equal(t, "fire", <-calls)
+	expect.Equal(t, "followup", <-calls)
+}
@@ -0,0 +1,175 @@
+package alerting
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+/*
+	On-call schedules, which route new Alerts to the actions of whoever is on
+	call at the time.
+
+	Example schedule file:
+
+	timezone = "America/New_York"
+
+	[[route]]
+	[route.match]
+	category = "infra"
+
+	# Email the infra gardener during working hours...
+	[[route.shift]]
+	start = "09:00"
+	end = "18:00"
+	actions = ["Email(infra-gardener@skia.org)"]
+
+	# ...and escalate to the on-call rotation outside of them.
+	[[route.shift]]
+	start = "18:00"
+	end = "09:00"
+	actions = ["Chat(skia-infra)", "IssueTracker(Infra)"]
+*/
+
+const SHIFT_TIME_FORMAT = "15:04"
+
+// Shift is a daily period of time during which Actions are added to the
+// matching Alerts. A Shift whose End is before its Start spans midnight.
+type Shift struct {
+	Start   string   `toml:"start"`
+	End     string   `toml:"end"`
+	Actions []string `toml:"actions"`
+	start   int
+	end     int
+	actions []Action
+}
+
+// parseShiftTime returns the number of minutes after midnight of a time in
+// SHIFT_TIME_FORMAT.
+func parseShiftTime(s string) (int, error) {
+	t, err := time.Parse(SHIFT_TIME_FORMAT, s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid shift time %q: %s", s, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// init parses the Shift's times and actions.
+func (s *Shift) init() error {
+	var err error
+	if s.start, err = parseShiftTime(s.Start); err != nil {
+		return err
+	}
+	if s.end, err = parseShiftTime(s.End); err != nil {
+		return err
+	}
+	if s.start == s.end {
+		return fmt.Errorf("Shift %s-%s is empty.", s.Start, s.End)
+	}
+	if s.actions, err = ParseActions(s.Actions); err != nil {
+		return err
+	}
+	return nil
+}
+
+// contains indicates whether the given number of minutes after midnight is
+// within the Shift.
+func (s *Shift) contains(minute int) bool {
+	if s.start < s.end {
+		return s.start <= minute && minute < s.end
+	}
+	return minute >= s.start || minute < s.end
+}
+
+// Route adds the Actions of the current Shift to the matching Alerts.
+type Route struct {
+	Match AlertMatcher `toml:"match"`
+	Shift []*Shift     `toml:"shift"`
+}
+
+// Schedule routes Alerts to whoever is on call.
+type Schedule struct {
+	// Timezone is the name of the location used for the times of Shifts,
+	// eg. "America/New_York". Defaults to UTC.
+	Timezone string   `toml:"timezone"`
+	Route    []*Route `toml:"route"`
+	loc      *time.Location
+}
+
+// ParseSchedule reads a Schedule from the given TOML file.
+func ParseSchedule(filename string) (*Schedule, error) {
+	s := &Schedule{}
+	if _, err := toml.DecodeFile(filename, s); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", filename, err)
+	}
+	if err := s.init(); err != nil {
+		return nil, fmt.Errorf("Invalid schedule in %s: %s", filename, err)
+	}
+	return s, nil
+}
+
+// init validates the Schedule and parses its Routes.
+func (s *Schedule) init() error {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return fmt.Errorf("Invalid timezone %q: %s", s.Timezone, err)
+	}
+	s.loc = loc
+	for _, r := range s.Route {
+		if err := r.Match.init(); err != nil {
+			return err
+		}
+		for _, shift := range r.Shift {
+			if err := shift.init(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Actions returns the actions of the Shift which contains the given time, in
+// the first Route which matches the Alert.
+func (s *Schedule) Actions(a *Alert, now time.Time) []Action {
+	now = now.In(s.loc)
+	minute := now.Hour()*60 + now.Minute()
+	for _, r := range s.Route {
+		if !r.Match.Matches(a) {
+			continue
+		}
+		for _, shift := range r.Shift {
+			if shift.contains(minute) {
+				return shift.actions
+			}
+		}
+		return []Action{}
+	}
+	return []Action{}
+}
+
+// route adds the actions of whoever is on call to the Alert. Assumes the
+// caller holds a lock.
+func (am *AlertManager) route(a *Alert, now time.Time) {
+	if am.schedule == nil {
+		return
+	}
+	existing := map[string]bool{}
+	for _, action := range a.Actions {
+		existing[action.String()] = true
+	}
+	for _, action := range am.schedule.Actions(a, now) {
+		if !existing[action.String()] {
+			existing[action.String()] = true
+			a.Actions = append(a.Actions, action)
+		}
+	}
+}
+
+// SetSchedule sets the on-call Schedule used to route new Alerts. A nil
+// Schedule disables routing.
+func (am *AlertManager) SetSchedule(s *Schedule) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	am.schedule = s
+}
@@ -0,0 +1,80 @@
+package alerting
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+)
+
+const TEST_SCHEDULE = `
+timezone = "America/New_York"
+
+[[route]]
+[route.match]
+category = "infra"
+
+[[route.shift]]
+start = "09:00"
+end = "18:00"
+actions = ["Email(infra-gardener@skia.org)"]
+
+[[route.shift]]
+start = "18:00"
+end = "09:00"
+actions = ["Chat(skia-infra)", "Print"]
+`
+
+// actionStrings returns the String() of each of the Actions.
+func actionStrings(actions []Action) []string {
+	rv := make([]string, 0, len(actions))
+	for _, a := range actions {
+		rv = append(rv, a.String())
+	}
+	return rv
+}
+
+func TestSchedule(t *testing.T) {
+	testutils.SmallTest(t)
+	f := writeTempFile(t, TEST_SCHEDULE)
+	defer func() {
+		assert.NoError(t, os.Remove(f))
+	}()
+	s, err := ParseSchedule(f)
+	assert.NoError(t, err)
+
+	loc, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+	infra := &Alert{Name: "Disk full", Category: "infra"}
+	day := time.Date(2016, 6, 1, 9, 0, 0, 0, loc)
+	night := time.Date(2016, 6, 1, 23, 30, 0, 0, loc)
+	morning := time.Date(2016, 6, 1, 8, 59, 0, 0, loc)
+	expect.Equal(t, []string{"Email(infra-gardener@skia.org)"}, actionStrings(s.Actions(infra, day)))
+	expect.Equal(t, []string{"Chat(skia-infra)", "Print"}, actionStrings(s.Actions(infra, night)))
+	expect.Equal(t, []string{"Chat(skia-infra)", "Print"}, actionStrings(s.Actions(infra, morning)))
+	// The time zone of the given time does not matter.
+	expect.Equal(t, []string{"Email(infra-gardener@skia.org)"}, actionStrings(s.Actions(infra, day.UTC())))
+	// Unmatched alerts are not routed.
+	expect.Len(t, s.Actions(&Alert{Category: "skia"}, day), 0)
+
+	// Routed actions are added to the alert, without duplicates.
+	am := &AlertManager{schedule: s}
+	a := &Alert{Category: "infra", Actions: []Action{NewPrintAction()}}
+	am.route(a, night)
+	expect.Equal(t, []string{"Print", "Chat(skia-infra)"}, actionStrings(a.Actions))
+
+	for _, bad := range []string{
+		"timezone = \"Nowhere/Special\"\n",
+		"[[route]]\n[[route.shift]]\nstart = \"25:00\"\nend = \"09:00\"\n",
+		"[[route]]\n[[route.shift]]\nstart = \"09:00\"\nend = \"09:00\"\n",
+		"[[route]]\n[[route.shift]]\nstart = \"09:00\"\nend = \"10:00\"\nactions = [\"Bogus\"]\n",
+	} {
+		f := writeTempFile(t, bad)
+		_, err := ParseSchedule(f)
+		expect.Error(t, err, bad)
+		assert.NoError(t, os.Remove(f))
+	}
+}
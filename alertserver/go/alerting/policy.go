@@ -0,0 +1,316 @@
+package alerting
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.skia.org/infra/go/config"
+)
+
+/*
+	Policies for grouping and inhibiting the notifications sent for Alerts.
+
+	Example policy file:
+
+	# Collapse all infra alerts which trigger within five minutes of each other,
+	# per host, into a single notification.
+	[[group]]
+	name = "infra"
+	window = "5m"
+	group_by = ["host"]
+	[group.match]
+	category = "infra"
+
+	# Don't notify about failed queries while InfluxDB is down.
+	[[inhibit]]
+	[inhibit.source]
+	name = "^InfluxDB is down$"
+	[inhibit.target]
+	name = "^Failed to execute query$"
+*/
+
+const (
+	// GROUP_BY_CATEGORY may be used in GroupRule.GroupBy to group Alerts by
+	// category. All other GroupBy values refer to tags.
+	GROUP_BY_CATEGORY = "category"
+
+	GROUP_NAME_TMPL    = "%d alerts in group %s"
+	GROUP_MESSAGE_TMPL = "The following alerts triggered:\n%s"
+	INHIBITED_MSG_TMPL = "Notifications were inhibited by active alert %q."
+	GROUPED_MSG_TMPL   = "Notifications were delayed to be grouped by %q."
+	NOTIFIED_MSG       = "Notifications were sent."
+)
+
+// The states of the notification for an Alert, see notificationState.
+const (
+	NOTIFICATION_SENT = iota
+	NOTIFICATION_INHIBITED
+	NOTIFICATION_GROUPED
+)
+
+// AlertMatcher selects Alerts. Empty fields match any Alert.
+type AlertMatcher struct {
+	// Name is a regular expression matched against the name of the Alert.
+	Name     string            `toml:"name"`
+	Category string            `toml:"category"`
+	Tags     map[string]string `toml:"tags"`
+	nameRe   *regexp.Regexp
+}
+
+// init compiles the AlertMatcher's regular expression.
+func (m *AlertMatcher) init() error {
+	if m.Name == "" {
+		return nil
+	}
+	re, err := regexp.Compile(m.Name)
+	if err != nil {
+		return fmt.Errorf("Invalid name pattern %q: %s", m.Name, err)
+	}
+	m.nameRe = re
+	return nil
+}
+
+// Matches indicates whether the Alert is selected by the AlertMatcher.
+func (m *AlertMatcher) Matches(a *Alert) bool {
+	if m.nameRe != nil && !m.nameRe.MatchString(a.Name) {
+		return false
+	}
+	if m.Category != "" && m.Category != a.Category {
+		return false
+	}
+	for k, v := range m.Tags {
+		if a.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// GroupRule collapses the notifications for matching Alerts which trigger
+// within Window of the first one into a single notification. Alerts are only
+// grouped together if they share the values of each of the GroupBy fields.
+type GroupRule struct {
+	Name    string              `toml:"name"`
+	Match   AlertMatcher        `toml:"match"`
+	GroupBy []string            `toml:"group_by"`
+	Window  config.TomlDuration `toml:"window"`
+}
+
+// key returns the key of the group the Alert belongs to.
+func (r *GroupRule) key(a *Alert) string {
+	parts := make([]string, 0, len(r.GroupBy)+1)
+	parts = append(parts, r.Name)
+	for _, field := range r.GroupBy {
+		value := a.Tags[field]
+		if field == GROUP_BY_CATEGORY {
+			value = a.Category
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s", field, value))
+	}
+	return strings.Join(parts, ",")
+}
+
+// InhibitRule suppresses the notifications for new Alerts matching Target
+// while an Alert matching Source is active. The notifications are sent once no
+// Alert matching Source is active anymore, if the target is still active.
+type InhibitRule struct {
+	Source AlertMatcher `toml:"source"`
+	Target AlertMatcher `toml:"target"`
+}
+
+// Policy determines how notifications are sent for new Alerts.
+type Policy struct {
+	Group   []*GroupRule   `toml:"group"`
+	Inhibit []*InhibitRule `toml:"inhibit"`
+}
+
+// ParsePolicy reads a Policy from the given TOML file.
+func ParsePolicy(filename string) (*Policy, error) {
+	p := &Policy{}
+	if _, err := toml.DecodeFile(filename, p); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", filename, err)
+	}
+	if err := p.init(); err != nil {
+		return nil, fmt.Errorf("Invalid policy in %s: %s", filename, err)
+	}
+	return p, nil
+}
+
+// init validates the Policy and compiles its AlertMatchers.
+func (p *Policy) init() error {
+	names := map[string]bool{}
+	for _, g := range p.Group {
+		if g.Name == "" {
+			return fmt.Errorf("Group rule is missing a name.")
+		}
+		if names[g.Name] {
+			return fmt.Errorf("Found multiple group rules with the same name: %s", g.Name)
+		}
+		names[g.Name] = true
+		if g.Window.Duration <= 0 {
+			return fmt.Errorf("Group rule %s must have a positive window.", g.Name)
+		}
+		if err := g.Match.init(); err != nil {
+			return fmt.Errorf("Group rule %s: %s", g.Name, err)
+		}
+	}
+	for _, i := range p.Inhibit {
+		if err := i.Source.init(); err != nil {
+			return fmt.Errorf("Inhibit rule source: %s", err)
+		}
+		if err := i.Target.init(); err != nil {
+			return fmt.Errorf("Inhibit rule target: %s", err)
+		}
+	}
+	return nil
+}
+
+// groupRule returns the first GroupRule which matches the Alert, or nil if
+// notifications for the Alert should not be grouped.
+func (p *Policy) groupRule(a *Alert) *GroupRule {
+	for _, g := range p.Group {
+		if g.Match.Matches(a) {
+			return g
+		}
+	}
+	return nil
+}
+
+// inhibitedBy returns an active Alert which inhibits notifications for the
+// given Alert, or nil if there is none.
+func (p *Policy) inhibitedBy(a *Alert, active map[int64]*Alert) *Alert {
+	for _, i := range p.Inhibit {
+		if !i.Target.Matches(a) {
+			continue
+		}
+		for _, source := range active {
+			if source.Name != a.Name && i.Source.Matches(source) {
+				return source
+			}
+		}
+	}
+	return nil
+}
+
+// notificationState returns the state of the notification for the given Alert
+// according to its latest comment by USER_ALERTSERVER about it. The state is
+// kept in comments so that it survives restarts.
+func notificationState(a *Alert) int {
+	inhibited := strings.SplitN(INHIBITED_MSG_TMPL, "%", 2)[0]
+	grouped := strings.SplitN(GROUPED_MSG_TMPL, "%", 2)[0]
+	for i := len(a.Comments) - 1; i >= 0; i-- {
+		c := a.Comments[i]
+		if c.User != USER_ALERTSERVER {
+			continue
+		}
+		if strings.HasPrefix(c.Message, inhibited) {
+			return NOTIFICATION_INHIBITED
+		} else if strings.HasPrefix(c.Message, grouped) {
+			return NOTIFICATION_GROUPED
+		} else if c.Message == NOTIFIED_MSG {
+			return NOTIFICATION_SENT
+		}
+	}
+	return NOTIFICATION_SENT
+}
+
+// alertGroup is a pending notification for a group of Alerts.
+type alertGroup struct {
+	rule     *GroupRule
+	deadline time.Time
+	ids      []int64
+}
+
+// members returns the members of the group which are still active.
+func (g *alertGroup) members(active map[int64]*Alert) []*Alert {
+	members := make([]*Alert, 0, len(g.ids))
+	for _, id := range g.ids {
+		if a, ok := active[id]; ok && a.DismissedAt == 0 {
+			members = append(members, a)
+		}
+	}
+	return members
+}
+
+// notification returns the Alert whose actions should be fired for the
+// members of the group which are still active, or nil if there are none. If
+// only one member is active, that Alert is returned.
+func (g *alertGroup) notification(active map[int64]*Alert) *Alert {
+	members := g.members(active)
+	if len(members) == 0 {
+		return nil
+	} else if len(members) == 1 {
+		return members[0]
+	}
+	lines := make([]string, 0, len(members))
+	actions := []Action{}
+	seen := map[string]bool{}
+	for _, m := range members {
+		lines = append(lines, fmt.Sprintf("- %s: %s", m.Name, m.Message))
+		for _, action := range m.Actions {
+			if !seen[action.String()] {
+				seen[action.String()] = true
+				actions = append(actions, action)
+			}
+		}
+	}
+	first := members[0]
+	return &Alert{
+		Id:        first.Id,
+		Name:      fmt.Sprintf(GROUP_NAME_TMPL, len(members), g.rule.Name),
+		Category:  first.Category,
+		Triggered: first.Triggered,
+		Message:   fmt.Sprintf(GROUP_MESSAGE_TMPL, strings.Join(lines, "\n")),
+		LastFired: first.LastFired,
+		Comments:  []*Comment{},
+		Actions:   actions,
+	}
+}
+
+// addToGroup adds the Alert to the pending group notification it belongs to,
+// starting a new group if necessary. Assumes the caller holds a write lock.
+func (am *AlertManager) addToGroup(rule *GroupRule, a *Alert, now time.Time) {
+	key := rule.key(a)
+	g, ok := am.groups[key]
+	if !ok {
+		g = &alertGroup{
+			rule:     rule,
+			deadline: now.Add(rule.Window.Duration),
+			ids:      []int64{},
+		}
+		am.groups[key] = g
+	}
+	g.ids = append(g.ids, a.Id)
+}
+
+// readyGroups removes and returns the groups whose window has passed, ordered
+// by deadline. Assumes the caller holds a write lock.
+func (am *AlertManager) readyGroups(now time.Time) []*alertGroup {
+	rv := []*alertGroup{}
+	for key, g := range am.groups {
+		if !now.Before(g.deadline) {
+			rv = append(rv, g)
+			delete(am.groups, key)
+		}
+	}
+	sort.Sort(groupsByDeadline(rv))
+	return rv
+}
+
+type groupsByDeadline []*alertGroup
+
+func (s groupsByDeadline) Len() int           { return len(s) }
+func (s groupsByDeadline) Less(i, j int) bool { return s[i].deadline.Before(s[j].deadline) }
+func (s groupsByDeadline) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// SetPolicy sets the Policy used for new Alerts. A nil Policy causes the
+// actions of each new Alert to be fired immediately.
+func (am *AlertManager) SetPolicy(p *Policy) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	am.policy = p
+}
@@ -0,0 +1,190 @@
+package alerting
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+)
+
+const TEST_POLICY = `
+[[group]]
+name = "infra"
+window = "5m"
+group_by = ["host"]
+[group.match]
+category = "infra"
+
+[[group]]
+name = "everything"
+window = "1m"
+group_by = ["category"]
+
+[[inhibit]]
+[inhibit.source]
+name = "^InfluxDB is down$"
+[inhibit.target]
+name = "^Failed to execute query$"
+`
+
+// writeTempFile writes the given contents to a temporary file and returns its
+// name.
+func writeTempFile(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "alerting_test")
+	assert.NoError(t, err)
+	_, err = f.WriteString(contents)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	return f.Name()
+}
+
+func parseTestPolicy(t *testing.T) *Policy {
+	f := writeTempFile(t, TEST_POLICY)
+	defer func() {
+		assert.NoError(t, os.Remove(f))
+	}()
+	p, err := ParsePolicy(f)
+	assert.NoError(t, err)
+	return p
+}
+
+func TestParsePolicy(t *testing.T) {
+	testutils.SmallTest(t)
+	p := parseTestPolicy(t)
+	assert.Len(t, p.Group, 2)
+	expect.Equal(t, "infra", p.Group[0].Name)
+	expect.Equal(t, 5*time.Minute, p.Group[0].Window.Duration)
+	expect.Equal(t, []string{"host"}, p.Group[0].GroupBy)
+	assert.Len(t, p.Inhibit, 1)
+
+	for _, bad := range []string{
+		"[[group]]\nwindow = \"5m\"\n",
+		"[[group]]\nname = \"a\"\n",
+		"[[group]]\nname = \"a\"\nwindow = \"5m\"\n[[group]]\nname = \"a\"\nwindow = \"5m\"\n",
+		"[[inhibit]]\n[inhibit.source]\nname = \"(\"\n",
+		"not toml",
+	} {
+		f := writeTempFile(t, bad)
+		_, err := ParsePolicy(f)
+		expect.Error(t, err, bad)
+		assert.NoError(t, os.Remove(f))
+	}
+}
+
+func TestAlertMatcher(t *testing.T) {
+	testutils.SmallTest(t)
+	a := &Alert{Name: "Build failed", Category: "infra", Tags: map[string]string{"host": "a"}}
+	m := &AlertMatcher{}
+	assert.NoError(t, m.init())
+	expect.True(t, m.Matches(a))
+	m = &AlertMatcher{Name: "failed$", Category: "infra", Tags: map[string]string{"host": "a"}}
+	assert.NoError(t, m.init())
+	expect.True(t, m.Matches(a))
+	m = &AlertMatcher{Name: "^failed"}
+	assert.NoError(t, m.init())
+	expect.False(t, m.Matches(a))
+	expect.False(t, (&AlertMatcher{Category: "skia"}).Matches(a))
+	expect.False(t, (&AlertMatcher{Tags: map[string]string{"host": "b"}}).Matches(a))
+}
+
+func TestInhibit(t *testing.T) {
+	testutils.SmallTest(t)
+	p := parseTestPolicy(t)
+	query := &Alert{Name: "Failed to execute query"}
+	other := &Alert{Name: "Build failed"}
+	active := map[int64]*Alert{
+		1: query,
+	}
+	expect.Nil(t, p.inhibitedBy(query, active))
+	influx := &Alert{Id: 2, Name: "InfluxDB is down"}
+	active[2] = influx
+	expect.Equal(t, influx, p.inhibitedBy(query, active))
+	expect.Nil(t, p.inhibitedBy(other, active))
+	expect.Nil(t, p.inhibitedBy(influx, active))
+}
+
+func TestGrouping(t *testing.T) {
+	testutils.SmallTest(t)
+	p := parseTestPolicy(t)
+	am := &AlertManager{groups: map[string]*alertGroup{}}
+	now := time.Now()
+	email := NewEmailAction([]string{"infra@skia.org"}, "Email(infra@skia.org)")
+	alerts := map[int64]*Alert{
+		1: {Id: 1, Name: "Disk full", Category: "infra", Message: "Disk is full", Tags: map[string]string{"host": "a"}, Actions: []Action{email}},
+		2: {Id: 2, Name: "Load high", Category: "infra", Message: "Load is high", Tags: map[string]string{"host": "a"}, Actions: []Action{email, NewPrintAction()}},
+		3: {Id: 3, Name: "Disk full", Category: "infra", Message: "Disk is full", Tags: map[string]string{"host": "b"}, Actions: []Action{email}},
+		4: {Id: 4, Name: "Build failed", Category: "skia", Message: "Build failed"},
+	}
+	for id := int64(1); id <= 4; id++ {
+		a := alerts[id]
+		rule := p.groupRule(a)
+		assert.NotNil(t, rule)
+		am.addToGroup(rule, a, now)
+	}
+	expect.Equal(t, "infra,host=a", p.Group[0].key(alerts[1]))
+	expect.Equal(t, "everything,category=skia", p.Group[1].key(alerts[4]))
+	assert.Len(t, am.groups, 3)
+
+	// Nothing is ready before the window has passed.
+	expect.Len(t, am.readyGroups(now.Add(30*time.Second)), 0)
+	ready := am.readyGroups(now.Add(time.Minute))
+	assert.Len(t, ready, 1)
+	expect.Equal(t, alerts[4], ready[0].notification(alerts))
+	ready = am.readyGroups(now.Add(5 * time.Minute))
+	assert.Len(t, ready, 2)
+	expect.Len(t, am.groups, 0)
+
+	var hostA, hostB *alertGroup
+	for _, g := range ready {
+		if len(g.ids) == 2 {
+			hostA = g
+		} else {
+			hostB = g
+		}
+	}
+	assert.NotNil(t, hostA)
+	assert.NotNil(t, hostB)
+	// Groups with a single active member notify for that member.
+	expect.Equal(t, alerts[3], hostB.notification(alerts))
+	// Multiple members collapse into one notification with the union of
+	// their actions.
+	n := hostA.notification(alerts)
+	expect.Equal(t, int64(1), n.Id)
+	expect.Equal(t, "2 alerts in group infra", n.Name)
+	expect.Equal(t, "The following alerts triggered:\n- Disk full: Disk is full\n- Load high: Load is high", n.Message)
+	assert.Len(t, n.Actions, 2)
+	expect.Equal(t, "Email(infra@skia.org)", n.Actions[0].String())
+	expect.Equal(t, "Print", n.Actions[1].String())
+
+	// Dismissed members are left out.
+	alerts[2].DismissedAt = now.Unix()
+	expect.Equal(t, alerts[1], hostA.notification(alerts))
+	delete(alerts, 1)
+	expect.Nil(t, hostA.notification(alerts))
+}
+
+func TestNotificationState(t *testing.T) {
+	testutils.SmallTest(t)
+	a := &Alert{Name: "Failed to execute query"}
+	expect.Equal(t, NOTIFICATION_SENT, notificationState(a))
+
+	comment := func(user, msg string) {
+		a.Comments = append(a.Comments, &Comment{User: user, Time: time.Now().Unix(), Message: msg})
+	}
+	comment(USER_ALERTSERVER, fmt.Sprintf(INHIBITED_MSG_TMPL, "InfluxDB is down"))
+	expect.Equal(t, NOTIFICATION_INHIBITED, notificationState(a))
+	// Comments by other users don't change the state.
+	comment("me@google.com", "Notifications were delayed to be grouped by \"infra\".")
+	expect.Equal(t, NOTIFICATION_INHIBITED, notificationState(a))
+	comment(USER_ALERTSERVER, fmt.Sprintf(GROUPED_MSG_TMPL, "infra"))
+	expect.Equal(t, NOTIFICATION_GROUPED, notificationState(a))
+	comment(USER_ALERTSERVER, "Auto-dismissed.")
+	expect.Equal(t, NOTIFICATION_GROUPED, notificationState(a))
+	comment(USER_ALERTSERVER, NOTIFIED_MSG)
+	expect.Equal(t, NOTIFICATION_SENT, notificationState(a))
+}
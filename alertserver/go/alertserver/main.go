@@ -29,21 +29,28 @@ import (
 import (
 	"go.skia.org/infra/alertserver/go/alerting"
 	"go.skia.org/infra/alertserver/go/rules"
+	"go.skia.org/infra/go/auth"
 	"go.skia.org/infra/go/common"
 	"go.skia.org/infra/go/email"
 	"go.skia.org/infra/go/httputils"
 	"go.skia.org/infra/go/influxdb"
 	"go.skia.org/infra/go/influxdb_init"
+	"go.skia.org/infra/go/issues"
 	"go.skia.org/infra/go/login"
 	"go.skia.org/infra/go/metadata"
 	"go.skia.org/infra/go/skiaversion"
 	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/go/webhook"
 )
 
 const (
 	GMAIL_TOKEN_CACHE_FILE = "google_email_token.data"
 	PARAM_INCLUDE_CATEGORY = "category"
 	PARAM_EXCLUDE_CATEGORY = "excludeCategory"
+
+	// DEFAULT_HISTORY_WINDOW is how far back the alert history goes when no
+	// "begin" is given.
+	DEFAULT_HISTORY_WINDOW = 30 * 24 * time.Hour
 )
 
 var (
@@ -66,6 +73,11 @@ var (
 	testing               = flag.Bool("testing", false, "Set to true for locally testing rules. No email will be sent.")
 	validateAndExit       = flag.Bool("validate_and_exit", false, "If set, just validate the config file and then exit.")
 	resourcesDir          = flag.String("resources_dir", "", "The directory to find templates, JS, and CSS files. If blank the current directory will be used.")
+	policyFile            = flag.String("policy_file", "", "TOML file containing the grouping and inhibition rules for alert notifications.")
+	scheduleFile          = flag.String("oncall_schedule_file", "", "TOML file containing the on-call schedule used to route alerts.")
+	prometheusURL         = flag.String("prometheus_url", "", "Base URL of the Prometheus server queried by rules with source \"prometheus\".")
+	ragemonURL            = flag.String("ragemon_url", "", "Base URL of the rageserve queried by rules with source \"ragemon\", eg. \"http://localhost:8000\".")
+	chatRoomsFile         = flag.String("chat_rooms_file", "", "JSON file mapping chat room names to their webhook URLs, for use by Chat(room) actions.")
 
 	influxHost     = flag.String("influxdb_host", influxdb.DEFAULT_HOST, "The InfluxDB hostname.")
 	influxUser     = flag.String("influxdb_name", influxdb.DEFAULT_USER, "The InfluxDB username.")
@@ -331,6 +343,114 @@ func rulesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// parseHistoryTime parses a time given as a date, eg. "2016-01-02", an RFC3339
+// timestamp or a number of seconds since the epoch. Empty strings result in
+// the zero time. If endOfDay is true, dates result in the start of the next
+// day, so that an exclusive end given as a date includes that day.
+func parseHistoryTime(s string, endOfDay bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	secs, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time %q", s)
+	}
+	return time.Unix(secs, 0), nil
+}
+
+// getAlertHistory returns the Alerts selected by the "rule", "category",
+// "begin" and "end" query parameters. Alerts which triggered in [begin, end)
+// are returned, where an end given as a date includes that whole day. If
+// begin is not given, it defaults to DEFAULT_HISTORY_WINDOW before end, or
+// before now if end is not given either.
+func getAlertHistory(r *http.Request) ([]*alerting.Alert, error) {
+	begin, err := parseHistoryTime(r.FormValue("begin"), false)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseHistoryTime(r.FormValue("end"), true)
+	if err != nil {
+		return nil, err
+	}
+	if begin.IsZero() {
+		if end.IsZero() {
+			begin = time.Now().Add(-DEFAULT_HISTORY_WINDOW)
+		} else {
+			begin = end.Add(-DEFAULT_HISTORY_WINDOW)
+		}
+	}
+	return alerting.GetAlertHistory(&alerting.HistoryQuery{
+		Rule:     r.FormValue("rule"),
+		Category: r.FormValue("category"),
+		Begin:    begin,
+		End:      end,
+	})
+}
+
+// historyJsonHandler returns the Alerts selected as described for
+// getAlertHistory and statistics about them.
+func historyJsonHandler(w http.ResponseWriter, r *http.Request) {
+	alerts, err := getAlertHistory(r)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve alert history.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	history := struct {
+		Alerts []*alerting.Alert     `json:"alerts"`
+		Stats  []*alerting.RuleStats `json:"stats"`
+	}{
+		Alerts: alerts,
+		Stats:  alerting.ComputeStats(alerts),
+	}
+	if err := json.NewEncoder(w).Encode(&history); err != nil {
+		glog.Errorf("Failed to write or encode output: %s", err)
+	}
+}
+
+func historyExportHandler(w http.ResponseWriter, r *http.Request) {
+	alerts, err := getAlertHistory(r)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve alert history.")
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=alert_history.csv")
+	if err := alerting.WriteHistoryCSV(w, alerts); err != nil {
+		glog.Errorf("Failed to write alert history: %s", err)
+	}
+}
+
+func incidentReportHandler(w http.ResponseWriter, r *http.Request) {
+	alertId, err := strconv.ParseInt(mux.Vars(r)["alertId"], 10, 64)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Invalid alert ID.")
+		return
+	}
+	alerts, err := alerting.GetAlertHistory(&alerting.HistoryQuery{Id: alertId})
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve alert.")
+		return
+	}
+	if len(alerts) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	if err := alerting.WriteIncidentReport(w, alerts[0]); err != nil {
+		glog.Errorf("Failed to write incident report: %s", err)
+	}
+}
+
 func runServer(serverURL string) {
 	r := mux.NewRouter()
 	r.PathPrefix("/res/").HandlerFunc(httputils.MakeResourceHandler(*resourcesDir))
@@ -342,6 +462,9 @@ func runServer(serverURL string) {
 	alerts.HandleFunc("/{alertId:[0-9]+}/{action}", postAlertsJsonHandler).Methods("POST")
 	alerts.HandleFunc("/multi/{action}", postMultiAlertsJsonHandler).Methods("POST")
 	r.HandleFunc("/json/rules", rulesJsonHandler)
+	r.HandleFunc("/json/history", historyJsonHandler)
+	r.HandleFunc("/history/export", historyExportHandler)
+	r.HandleFunc("/history/{alertId:[0-9]+}/report", incidentReportHandler)
 	r.HandleFunc("/json/version", skiaversion.JsonHandler)
 	r.HandleFunc("/oauth2callback/", login.OAuth2CallbackHandler)
 	r.HandleFunc("/logout/", login.LogoutHandler)
@@ -441,11 +564,54 @@ func main() {
 		glog.Fatal(err)
 	}
 
+	// Initialize the non-email alert actions.
+	if *chatRoomsFile != "" {
+		if err := alerting.InitChatRooms(*chatRoomsFile); err != nil {
+			glog.Fatal(err)
+		}
+	}
+	if *testing {
+		webhook.InitRequestSaltForTesting()
+	} else {
+		if err := webhook.InitRequestSaltFromMetadata(); err != nil {
+			glog.Errorf("Webhook actions will fail; unable to read the webhook request salt: %s", err)
+		}
+		issueClient, err := auth.NewDefaultJWTServiceAccountClient("https://www.googleapis.com/auth/userinfo.email")
+		if err != nil {
+			glog.Errorf("IssueTracker actions will fail; unable to create an authenticated client: %s", err)
+		} else {
+			alerting.InitIssueTracker(issues.NewMonorailIssueTracker(issueClient))
+		}
+	}
+
 	// Create the AlertManager.
 	alertManager, err = alerting.MakeAlertManager(parsedPollInterval, emailAuth)
 	if err != nil {
 		glog.Fatalf("Failed to create AlertManager: %v", err)
 	}
+	if *policyFile != "" {
+		policy, err := alerting.ParsePolicy(*policyFile)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		alertManager.SetPolicy(policy)
+	}
+	if *scheduleFile != "" {
+		schedule, err := alerting.ParseSchedule(*scheduleFile)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		alertManager.SetSchedule(schedule)
+	}
+	if *prometheusURL != "" {
+		rules.RegisterSource(rules.SOURCE_PROMETHEUS, rules.NewPrometheusSource(*prometheusURL, httputils.NewTimeoutClient()))
+	}
+	if *ragemonURL != "" {
+		rules.RegisterSource(rules.SOURCE_RAGEMON, rules.NewRagemonSource(*ragemonURL, httputils.NewTimeoutClient()))
+	}
+	if *testing {
+		rules.RegisterSource(rules.SOURCE_FAKE, rules.NewFakeSource())
+	}
 	rulesList, err = rules.MakeRules(*alertsFile, dbClient, parsedPollInterval, alertManager, *testing)
 	if err != nil {
 		glog.Fatalf("Failed to set up rules: %v", err)
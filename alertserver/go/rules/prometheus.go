@@ -0,0 +1,146 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	// PROMETHEUS_MAX_POINTS is the maximum number of Points requested for
+	// each Series of a windowed query.
+	PROMETHEUS_MAX_POINTS = 100
+
+	PROMETHEUS_RESULT_VECTOR = "vector"
+	PROMETHEUS_RESULT_MATRIX = "matrix"
+)
+
+// PrometheusSource is a Source which queries a Prometheus-style HTTP query
+// API. Queries are PromQL expressions. Without a window, the instant query
+// endpoint is used, which returns multiple Points per Series only for range
+// vector expressions such as "up[10m]"; with a window, the range query
+// endpoint is used.
+type PrometheusSource struct {
+	client *http.Client
+	url    string
+}
+
+// NewPrometheusSource returns a PrometheusSource for the server at the given
+// base URL, eg. "http://localhost:9090".
+func NewPrometheusSource(url string, client *http.Client) *PrometheusSource {
+	return &PrometheusSource{
+		client: client,
+		url:    url,
+	}
+}
+
+// prometheusResponse is the JSON response of the Prometheus query API.
+type prometheusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			// Value is set for vectors and Values for matrices. Each
+			// sample is a [<unix time>, "<value>"] pair.
+			Value  []interface{}   `json:"value"`
+			Values [][]interface{} `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// parsePrometheusSample converts a [<unix time>, "<value>"] pair to a Point.
+func parsePrometheusSample(sample []interface{}) (Point, error) {
+	if len(sample) != 2 {
+		return Point{}, fmt.Errorf("Invalid sample: %v", sample)
+	}
+	ts, ok := sample[0].(float64)
+	if !ok {
+		return Point{}, fmt.Errorf("Invalid sample time: %v", sample[0])
+	}
+	s, ok := sample[1].(string)
+	if !ok {
+		return Point{}, fmt.Errorf("Invalid sample value: %v", sample[1])
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return Point{}, &valueError{err}
+	}
+	sec := int64(ts)
+	return Point{
+		Time:   time.Unix(sec, int64((ts-float64(sec))*float64(time.Second))).UTC(),
+		Values: []float64{v},
+	}, nil
+}
+
+// Query implements Source.
+func (s *PrometheusSource) Query(database, query string, n int, window time.Duration) ([]*Series, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	u := s.url + "/api/v1/query"
+	if window > 0 {
+		end := timeNow()
+		step := window / PROMETHEUS_MAX_POINTS
+		if step < time.Second {
+			step = time.Second
+		}
+		params.Set("start", strconv.FormatInt(end.Add(-window).Unix(), 10))
+		params.Set("end", strconv.FormatInt(end.Unix(), 10))
+		params.Set("step", strconv.FormatInt(int64(step/time.Second), 10))
+		u = s.url + "/api/v1/query_range"
+	}
+	resp, err := s.client.Get(u + "?" + params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Prometheus: %s", err)
+	}
+	defer util.Close(resp.Body)
+	var r prometheusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("Failed to decode Prometheus response (%s): %s", resp.Status, err)
+	}
+	if r.Status != "success" {
+		return nil, fmt.Errorf("Prometheus query failed (%s): %s", resp.Status, r.Error)
+	}
+	rv := make([]*Series, 0, len(r.Data.Result))
+	for _, result := range r.Data.Result {
+		samples := [][]interface{}{}
+		switch r.Data.ResultType {
+		case PROMETHEUS_RESULT_VECTOR:
+			samples = append(samples, result.Value)
+		case PROMETHEUS_RESULT_MATRIX:
+			samples = result.Values
+		default:
+			return nil, fmt.Errorf("Unsupported Prometheus result type %q", r.Data.ResultType)
+		}
+		series := &Series{
+			Tags:   result.Metric,
+			Points: make([]Point, 0, len(samples)),
+		}
+		for _, sample := range samples {
+			p, err := parsePrometheusSample(sample)
+			if err != nil {
+				return nil, err
+			}
+			series.Points = append(series.Points, p)
+		}
+		rv = append(rv, series)
+	}
+	sortPoints(rv)
+	return rv, nil
+}
+
+// NumValues implements Source.
+func (s *PrometheusSource) NumValues(query string) int {
+	return 1
+}
+
+// SupportsWindow implements Source.
+func (s *PrometheusSource) SupportsWindow() bool {
+	return true
+}
@@ -0,0 +1,119 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.skia.org/infra/go/query"
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	// RAGEMON_DEFAULT_WINDOW is the window queried by rules which use a
+	// RagemonSource without specifying one.
+	RAGEMON_DEFAULT_WINDOW = 5 * time.Minute
+
+	// RAGEMON_MAX_POINTS is the maximum number of Points requested for each
+	// Series.
+	RAGEMON_MAX_POINTS = 100
+)
+
+// RagemonSource is a Source which queries the /query endpoint of rageserve.
+// Queries are in URL query format and are matched against the structured keys
+// of the timeseries, eg. "app=perf&meas=errors".
+type RagemonSource struct {
+	client *http.Client
+	url    string
+}
+
+// NewRagemonSource returns a RagemonSource for the rageserve at the given base
+// URL, eg. "http://localhost:8000".
+func NewRagemonSource(url string, client *http.Client) *RagemonSource {
+	return &RagemonSource{
+		client: client,
+		url:    url,
+	}
+}
+
+// ragemonResponse is the JSON response of the rageserve /query endpoint.
+type ragemonResponse struct {
+	Timestamps []int64               `json:"timestamps"`
+	Series     map[string][]*float32 `json:"series"`
+}
+
+// Query implements Source.
+func (s *RagemonSource) Query(database, q string, n int, window time.Duration) ([]*Series, error) {
+	if _, err := url.ParseQuery(q); err != nil {
+		return nil, fmt.Errorf("Invalid ragemon query %q: %s", q, err)
+	}
+	if strings.Contains(q, `"`) {
+		return nil, fmt.Errorf("Invalid ragemon query %q: must not contain quotes", q)
+	}
+	if window == 0 {
+		window = RAGEMON_DEFAULT_WINDOW
+	}
+	step := window / RAGEMON_MAX_POINTS
+	if step < time.Second {
+		step = time.Second
+	}
+	end := timeNow()
+	params := url.Values{}
+	params.Set("expr", fmt.Sprintf(`filter("%s")`, q))
+	params.Set("begin", strconv.FormatInt(end.Add(-window).Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", step.String())
+	resp, err := s.client.Get(s.url + "/query?" + params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query ragemon: %s", err)
+	}
+	defer util.Close(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Ragemon query %q failed: %s", q, resp.Status)
+	}
+	var r ragemonResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("Failed to decode ragemon response: %s", err)
+	}
+	rv := make([]*Series, 0, len(r.Series))
+	for key, values := range r.Series {
+		if len(values) != len(r.Timestamps) {
+			return nil, fmt.Errorf("Ragemon returned %d values for %d timestamps for key %q", len(values), len(r.Timestamps), key)
+		}
+		tags, err := query.ParseKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ragemon key %q: %s", key, err)
+		}
+		series := &Series{
+			Tags:   tags,
+			Points: make([]Point, 0, len(values)),
+		}
+		for i, v := range values {
+			// Intervals without any points are null.
+			if v == nil {
+				continue
+			}
+			series.Points = append(series.Points, Point{
+				Time:   time.Unix(r.Timestamps[i], 0).UTC(),
+				Values: []float64{float64(*v)},
+			})
+		}
+		rv = append(rv, series)
+	}
+	sortPoints(rv)
+	return rv, nil
+}
+
+// NumValues implements Source.
+func (s *RagemonSource) NumValues(query string) int {
+	return 1
+}
+
+// SupportsWindow implements Source.
+func (s *RagemonSource) SupportsWindow() bool {
+	return true
+}
@@ -32,6 +32,16 @@ type Rule struct {
 	client         queryable
 	AutoDismiss    int64 `json:"autoDismiss"`
 	Actions        []string
+	// Source is the name of the Source which is queried.
+	Source string `json:"source"`
+	// Window is how far back the Source is queried. Conditions may use the
+	// WINDOW_VARIABLES, which aggregate the values within the window.
+	Window time.Duration `json:"window"`
+	// For is how long the conditions must have held, at each point, for the
+	// rule to fire. It may not be longer than Window, if set. The Source is
+	// queried for a little more than For, see queryWindow.
+	For    time.Duration `json:"for"`
+	source Source
 }
 
 // Alerter is a target for adding alerts.
@@ -45,6 +55,20 @@ type Alerter interface {
 // three return values, the others will just be undefined.
 var CONDITION_VARIABLES = []string{"x", "y", "z"}
 
+// Each of the CONDITION_VARIABLES followed by one of the WINDOW_VARIABLES, eg.
+// "x_rate", is an aggregate of the values of that variable over the rule's
+// window:
+//   - min, max, avg: the minimum, maximum and average.
+//   - delta: the difference between the latest and the oldest value.
+//   - rate: delta per second.
+var WINDOW_VARIABLES = []string{"min", "max", "avg", "delta", "rate"}
+
+// FOR_MARGIN_DIVISOR determines how far beyond For the Source is queried for
+// rules with a For duration: For/FOR_MARGIN_DIVISOR. Sources align Points to
+// their step, so the first Point may be after the start of the queried window
+// and the last Point before now; the margin makes the Series cover For anyway.
+const FOR_MARGIN_DIVISOR = 10
+
 func formatMsg(msg string, tags map[string]string) string {
 	rv := msg
 	for k, v := range tags {
@@ -62,11 +86,13 @@ func (r *Rule) fire(am Alerter, tags map[string]string) error {
 	}
 	a := alerting.Alert{
 		Name:        formatMsg(r.Name, tags),
+		Rule:        r.Name,
 		Category:    r.Category,
 		Message:     formatMsg(r.Message, tags),
 		Nag:         int64(r.Nag),
 		AutoDismiss: r.AutoDismiss,
 		Actions:     actions,
+		Tags:        tags,
 	}
 	return am.AddAlert(&a)
 }
@@ -107,33 +133,47 @@ func (r *Rule) queryEvaluationAlert(queryErr error, am Alerter) error {
 	})
 }
 
+// getSource returns the Source which the rule queries.
+func (r *Rule) getSource() Source {
+	if r.source != nil {
+		return r.source
+	}
+	return &influxSource{r.client}
+}
+
+// queryWindow returns how far back the Source is queried. This is the Window,
+// extended to cover For plus a margin if necessary.
+func (r *Rule) queryWindow() time.Duration {
+	if r.For == 0 {
+		return r.Window
+	}
+	w := r.For + r.For/FOR_MARGIN_DIVISOR
+	if w < r.Window {
+		return r.Window
+	}
+	return w
+}
+
 func (r *Rule) tick(am Alerter) error {
-	res, err := executeQuery(r.client, r.Database, r.Query, len(r.Conditions))
+	res, err := r.getSource().Query(r.Database, r.Query, len(r.Conditions), r.queryWindow())
 	if err != nil {
+		if _, ok := err.(*valueError); ok {
+			return r.queryEvaluationAlert(err, am)
+		}
 		// We shouldn't fail to execute a query. Trigger an alert.
 		return r.queryExecutionAlert(err, am)
 	}
 	if len(res) == 0 && !r.EmptyResultsOk {
 		return r.queryExecutionAlert(fmt.Errorf("Query returned no series: %q", r.Query), am)
 	}
-	// Evaluate the query comparison for each returned value.
-	for _, v := range res {
-		values := v.Values
-		xf := make([]float64, 0, len(values))
-		for _, vf := range values {
-			f, err := vf.Float64()
-			if err != nil {
-				return r.queryEvaluationAlert(err, am)
-			}
-			xf = append(xf, f)
-		}
-
-		doAlert, err := r.evaluate(xf)
+	// Evaluate the query comparison for each returned series.
+	for _, series := range res {
+		doAlert, err := r.evaluateSeries(series)
 		if err != nil {
 			return r.queryEvaluationAlert(err, am)
 		}
 		if doAlert {
-			if err := r.fire(am, v.Tags); err != nil {
+			if err := r.fire(am, series.Tags); err != nil {
 				return err
 			}
 		}
@@ -141,19 +181,112 @@ func (r *Rule) tick(am Alerter) error {
 	return nil
 }
 
-// evaluate takes a slice of floats to represent the value(s) returned from the query.  It creates
-// variables (e.g. 'x', 'y', etc) in scope of a go program and then attempts to evaluate the
-// conditions.  It returns the result of anding all of the results of the conditions or an error.
-func (r *Rule) evaluate(xf []float64) (bool, error) {
-	if len(xf) > len(CONDITION_VARIABLES) {
-		return false, fmt.Errorf("Too many return values for query.  We support a max of %d (%q), but there were %d (%f)", len(CONDITION_VARIABLES), CONDITION_VARIABLES, len(xf), xf)
+// windowVariables returns the WINDOW_VARIABLES for the given Points, which
+// must be sorted from oldest to newest.
+func windowVariables(points []Point) (map[string]float64, error) {
+	rv := map[string]float64{}
+	if len(points) == 0 {
+		return rv, nil
+	}
+	first := points[0]
+	last := points[len(points)-1]
+	seconds := last.Time.Sub(first.Time).Seconds()
+	for i, name := range CONDITION_VARIABLES {
+		if i >= len(last.Values) {
+			break
+		}
+		min, max, sum := last.Values[i], last.Values[i], 0.0
+		for _, p := range points {
+			if len(p.Values) != len(last.Values) {
+				return nil, fmt.Errorf("Points have differing numbers of values: %d vs %d", len(p.Values), len(last.Values))
+			}
+			v := p.Values[i]
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+			sum += v
+		}
+		delta := last.Values[i] - first.Values[i]
+		rate := 0.0
+		if seconds > 0 {
+			rate = delta / seconds
+		}
+		rv[name+"_min"] = min
+		rv[name+"_max"] = max
+		rv[name+"_avg"] = sum / float64(len(points))
+		rv[name+"_delta"] = delta
+		rv[name+"_rate"] = rate
+	}
+	return rv, nil
+}
+
+// evaluateSeries determines whether the rule should fire for the given Series.
+// Without For, the conditions are evaluated for the latest Point. Otherwise,
+// they must hold for every Point within For of the latest one, and the Series
+// must cover that whole period. The WINDOW_VARIABLES only aggregate the Points
+// within the Window, even if the Series reaches further back to cover For.
+func (r *Rule) evaluateSeries(s *Series) (bool, error) {
+	if len(s.Points) == 0 {
+		return false, nil
+	}
+	windowPoints := s.Points
+	if r.Window > 0 && r.queryWindow() > r.Window {
+		windowStart := timeNow().Add(-r.Window)
+		windowPoints = []Point{}
+		for _, p := range s.Points {
+			if !p.Time.Before(windowStart) {
+				windowPoints = append(windowPoints, p)
+			}
+		}
+		if len(windowPoints) == 0 {
+			windowPoints = s.Points[len(s.Points)-1:]
+		}
 	}
+	vars, err := windowVariables(windowPoints)
+	if err != nil {
+		return false, err
+	}
+	last := s.Points[len(s.Points)-1]
+	points := []Point{last}
+	if r.For > 0 {
+		start := last.Time.Add(-r.For)
+		if s.Points[0].Time.After(start) {
+			return false, nil
+		}
+		points = []Point{}
+		for _, p := range s.Points {
+			if !p.Time.Before(start) {
+				points = append(points, p)
+			}
+		}
+	}
+	for _, p := range points {
+		if len(p.Values) > len(CONDITION_VARIABLES) {
+			return false, fmt.Errorf("Too many return values for query.  We support a max of %d (%q), but there were %d (%f)", len(CONDITION_VARIABLES), CONDITION_VARIABLES, len(p.Values), p.Values)
+		}
+		for i, v := range p.Values {
+			vars[CONDITION_VARIABLES[i]] = v
+		}
+		ok, err := r.evaluate(vars)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// evaluate takes the values of the variables (e.g. 'x', 'y', 'x_rate', etc) returned from the
+// query.  It creates them in scope of a go program and then attempts to evaluate the
+// conditions.  It returns the result of anding all of the results of the conditions or an error.
+func (r *Rule) evaluate(vars map[string]float64) (bool, error) {
 	pkg := types.NewPackage("evaluateme", "evaluateme")
 	result := true
-	// Load all the inputs into CONDITION_VARIABLES
-	for i, f := range xf {
+	for name, f := range vars {
 		v := constant.MakeFloat64(f)
-		pkg.Scope().Insert(types.NewConst(0, pkg, CONDITION_VARIABLES[i], types.Typ[types.Float64], v))
+		pkg.Scope().Insert(types.NewConst(0, pkg, name, types.Typ[types.Float64], v))
 	}
 
 	for _, condition := range r.Conditions {
@@ -232,7 +365,46 @@ func newRule(r parsedRule, client *influxdb.Client, testing bool, tickInterval t
 			return nil, fmt.Errorf("Invalid nag duration %q: %v", nag, err)
 		}
 	}
-	numQueryReturns := countNumQueryReturns(query)
+	sourceName, ok := r["source"].(string)
+	if !ok {
+		sourceName = SOURCE_INFLUXDB
+	}
+	var source Source
+	if sourceName != SOURCE_INFLUXDB {
+		source = getSource(sourceName)
+		if source == nil {
+			return nil, fmt.Errorf("Unknown source %q", sourceName)
+		}
+	}
+	window := time.Duration(0)
+	if w, ok := r["window"].(string); ok {
+		var err error
+		window, err = time.ParseDuration(w)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid window %q: %v", w, err)
+		}
+	}
+	forDuration := time.Duration(0)
+	if f, ok := r["for"].(string); ok {
+		var err error
+		forDuration, err = time.ParseDuration(f)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid for duration %q: %v", f, err)
+		}
+	}
+	supportsWindow := source != nil && source.SupportsWindow()
+	if !supportsWindow && (window != 0 || forDuration != 0) {
+		return nil, fmt.Errorf("Source %q does not support window or for", sourceName)
+	}
+	if window != 0 && forDuration > window {
+		return nil, fmt.Errorf("For duration %s is longer than the window %s", forDuration, window)
+	}
+	numQueryReturns := len(CONDITION_VARIABLES)
+	if source == nil {
+		numQueryReturns = countNumQueryReturns(query)
+	} else {
+		numQueryReturns = source.NumValues(query)
+	}
 	if numQueryReturns > len(CONDITION_VARIABLES) {
 		return nil, fmt.Errorf("Too many return values in query %q.  We only support %d variables and found %d return values", query, len(CONDITION_VARIABLES), numQueryReturns)
 	}
@@ -257,9 +429,20 @@ func newRule(r parsedRule, client *influxdb.Client, testing bool, tickInterval t
 		client:         client,
 		AutoDismiss:    dismissInterval,
 		Actions:        actionStrings,
+		Source:         sourceName,
+		Window:         window,
+		For:            forDuration,
+		source:         source,
 	}
 	// Verify that the condition can be evaluated.
-	_, err := rule.evaluate(make([]float64, len(CONDITION_VARIABLES)))
+	vars := map[string]float64{}
+	for _, name := range CONDITION_VARIABLES {
+		vars[name] = 0
+		for _, suffix := range WINDOW_VARIABLES {
+			vars[name+"_"+suffix] = 0
+		}
+	}
+	_, err := rule.evaluate(vars)
 	if err != nil {
 		return nil, err
 	}
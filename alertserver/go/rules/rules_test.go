@@ -427,3 +427,151 @@ nag = "1h10m"
 		}
 	}
 }
+
+// noWindowSource is a FakeSource which doesn't support windows.
+type noWindowSource struct {
+	*FakeSource
+}
+
+func (s *noWindowSource) SupportsWindow() bool {
+	return false
+}
+
+func TestWindowedRule(t *testing.T) {
+	testutils.SmallTest(t)
+	now := time.Unix(1465000000, 0).UTC()
+	defer setNow(now)()
+	source := NewFakeSource()
+	RegisterSource("windowtest", source)
+	// One point per minute for ten minutes. Host a is steadily increasing;
+	// host b spikes at the end.
+	pointsA := []Point{}
+	pointsB := []Point{}
+	for i := 0; i <= 10; i++ {
+		ts := now.Add(time.Duration(i-10) * time.Minute)
+		pointsA = append(pointsA, Point{Time: ts, Values: []float64{float64(60 * i)}})
+		v := 1.0
+		if i == 10 {
+			v = 100
+		}
+		pointsB = append(pointsB, Point{Time: ts, Values: []float64{v}})
+	}
+	source.SetSeries("errors", []*Series{
+		{Tags: map[string]string{"host": "a"}, Points: pointsA},
+		{Tags: map[string]string{"host": "b"}, Points: pointsB},
+	})
+
+	testingMode := false
+	parse := func(sourceName, extra string) (*Rule, error) {
+		var cfg struct {
+			Rule []parsedRule
+		}
+		_, err := toml.Decode(`[[rule]]
+name = "errors %(host)s"
+message = "errors"
+database = ""
+source = "`+sourceName+`"
+query = "errors"
+category = "testing"
+actions = ["Print"]
+auto-dismiss = false
+`+extra, &cfg)
+		assert.NoError(t, err)
+		return newRule(cfg.Rule[0], nil, testingMode, 10)
+	}
+	fired := func(r *Rule) []string {
+		am := &mockAlerter{}
+		assert.NoError(t, r.tick(am))
+		rv := []string{}
+		for _, a := range am.Alerts {
+			rv = append(rv, a.Name)
+		}
+		return rv
+	}
+
+	// Latest value.
+	r, err := parse("windowtest", "conditions = [\"x > 50\"]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"errors a", "errors b"}, fired(r))
+
+	// Rate of change over the window.
+	r, err = parse("windowtest", "window = \"10m\"\nconditions = [\"x_rate >= 1\"]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"errors a"}, fired(r))
+	r, err = parse("windowtest", "window = \"10m\"\nconditions = [\"x_max > 5 * x_avg\"]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"errors b"}, fired(r))
+
+	// Sustained for a duration.
+	r, err = parse("windowtest", "window = \"10m\"\nfor = \"5m\"\nconditions = [\"x > 0\"]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"errors a", "errors b"}, fired(r))
+	r, err = parse("windowtest", "window = \"10m\"\nfor = \"5m\"\nconditions = [\"x > 50\"]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"errors a"}, fired(r))
+	// A rule whose duration equals its window fires, with or without the
+	// window.
+	r, err = parse("windowtest", "window = \"10m\"\nfor = \"10m\"\nconditions = [\"x >= 0\"]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"errors a", "errors b"}, fired(r))
+	r, err = parse("windowtest", "for = \"10m\"\nconditions = [\"x >= 0\"]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"errors a", "errors b"}, fired(r))
+	// The window variables don't include the extra Points queried for the
+	// duration.
+	r, err = parse("windowtest", "window = \"9m30s\"\nfor = \"9m30s\"\nconditions = [\"x_min >= 60\"]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"errors a"}, fired(r))
+	// The Series must cover the whole duration.
+	r, err = parse("windowtest", "window = \"15m\"\nfor = \"15m\"\nconditions = [\"x >= 0\"]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, fired(r))
+
+	// Invalid configurations.
+	_, err = parse("windowtest", "window = \"5m\"\nfor = \"10m\"\nconditions = [\"x > 0\"]\n")
+	assert.Error(t, err)
+	_, err = parse("windowtest", "window = \"bogus\"\nconditions = [\"x > 0\"]\n")
+	assert.Error(t, err)
+	_, err = parse("windowtest", "conditions = [\"y_rate > 0\"]\n")
+	assert.Error(t, err)
+	_, err = parse("unknown", "conditions = [\"x > 0\"]\n")
+	assert.Error(t, err)
+	testingMode = true
+	_, err = parse("unknown", "conditions = [\"x > 0\"]\n")
+	assert.Error(t, err)
+	testingMode = false
+	RegisterSource("nowindowtest", &noWindowSource{source})
+	_, err = parse("nowindowtest", "conditions = [\"x > 0\"]\n")
+	assert.NoError(t, err)
+	_, err = parse("nowindowtest", "window = \"10m\"\nconditions = [\"x > 0\"]\n")
+	assert.Error(t, err)
+}
+
+func TestInfluxSourceWindow(t *testing.T) {
+	testutils.SmallTest(t)
+	var cfg struct {
+		Rule []parsedRule
+	}
+	_, err := toml.Decode(`[[rule]]
+name = "randombits"
+message = "randombits"
+database = "graphite"
+query = "select mean(value) from random_bits where time > now() - 5s"
+category = "testing"
+conditions = ["x_rate > 0.5"]
+actions = ["Print"]
+auto-dismiss = false
+window = "5m"
+`, &cfg)
+	assert.NoError(t, err)
+	_, err = newRule(cfg.Rule[0], nil, false, 10)
+	assert.Error(t, err)
+
+	// Window variables are available, but constant, for InfluxDB rules.
+	r := getRule()
+	r.Conditions = []string{"x_delta == 0", "x_avg == x"}
+	am := &mockAlerter{}
+	assert.NoError(t, r.tick(am))
+	assert.Equal(t, 1, len(am.Alerts))
+	assert.Equal(t, map[string]string{"tagKey": "tagValue"}, am.Alerts[0].Tags)
+}
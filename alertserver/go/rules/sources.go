@@ -0,0 +1,200 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	Sources of the metrics which rules are evaluated against.
+*/
+
+const (
+	// The names of the Sources which may be used in the "source" field of a
+	// rule. SOURCE_INFLUXDB is the default.
+	SOURCE_INFLUXDB   = "influxdb"
+	SOURCE_RAGEMON    = "ragemon"
+	SOURCE_PROMETHEUS = "prometheus"
+	SOURCE_FAKE       = "fake"
+)
+
+var (
+	// sources are the registered Sources, by name.
+	sources      = map[string]Source{}
+	sourcesMutex sync.RWMutex
+
+	// timeNow returns the current time. Replaced in tests.
+	timeNow = time.Now
+)
+
+// Point is a single sample of a Series.
+type Point struct {
+	Time   time.Time
+	Values []float64
+}
+
+// Series is a sequence of Points, oldest first, which share the same tags.
+type Series struct {
+	Tags   map[string]string
+	Points []Point
+}
+
+// Source is a backend which rules query for metrics.
+type Source interface {
+	// Query returns the Series matching the query. Sources which support
+	// windows return the Points of the last window of time; otherwise window
+	// is zero and each Series contains a single Point. The database and the
+	// number of values expected in each Point, n, are only used by some
+	// Sources.
+	Query(database, query string, n int, window time.Duration) ([]*Series, error)
+
+	// NumValues returns the number of values in each Point returned for the
+	// given query.
+	NumValues(query string) int
+
+	// SupportsWindow indicates whether the Source can return multiple Points
+	// per Series.
+	SupportsWindow() bool
+}
+
+// RegisterSource makes the Source available to rules under the given name.
+func RegisterSource(name string, s Source) {
+	sourcesMutex.Lock()
+	defer sourcesMutex.Unlock()
+	sources[name] = s
+}
+
+// getSource returns the Source registered under the given name, or nil.
+func getSource(name string) Source {
+	sourcesMutex.RLock()
+	defer sourcesMutex.RUnlock()
+	return sources[name]
+}
+
+// valueError indicates that a Source returned a value which is not a number.
+type valueError struct {
+	error
+}
+
+// influxSource is a Source backed by InfluxDB.
+type influxSource struct {
+	client queryable
+}
+
+// Query implements Source.
+func (s *influxSource) Query(database, query string, n int, window time.Duration) ([]*Series, error) {
+	points, err := executeQuery(s.client, database, query, n)
+	if err != nil {
+		return nil, err
+	}
+	now := timeNow()
+	rv := make([]*Series, 0, len(points))
+	for _, p := range points {
+		values := make([]float64, 0, len(p.Values))
+		for _, v := range p.Values {
+			f, err := v.Float64()
+			if err != nil {
+				return nil, &valueError{err}
+			}
+			values = append(values, f)
+		}
+		rv = append(rv, &Series{
+			Tags:   p.Tags,
+			Points: []Point{{Time: now, Values: values}},
+		})
+	}
+	return rv, nil
+}
+
+// NumValues implements Source.
+func (s *influxSource) NumValues(query string) int {
+	return countNumQueryReturns(query)
+}
+
+// SupportsWindow implements Source.
+func (s *influxSource) SupportsWindow() bool {
+	return false
+}
+
+// FakeSource is a Source which returns canned Series, for testing rules
+// locally.
+type FakeSource struct {
+	series map[string][]*Series
+	mutex  sync.Mutex
+}
+
+// NewFakeSource returns a FakeSource without any Series.
+func NewFakeSource() *FakeSource {
+	return &FakeSource{
+		series: map[string][]*Series{},
+	}
+}
+
+// SetSeries sets the Series returned for the given query.
+func (s *FakeSource) SetSeries(query string, series []*Series) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.series[query] = series
+}
+
+// Query implements Source. Points older than the window are not returned.
+func (s *FakeSource) Query(database, query string, n int, window time.Duration) ([]*Series, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	series, ok := s.series[query]
+	if !ok {
+		return nil, fmt.Errorf("No fake series for query %q", query)
+	}
+	start := time.Time{}
+	if window > 0 {
+		start = timeNow().Add(-window)
+	}
+	rv := make([]*Series, 0, len(series))
+	for _, ser := range series {
+		points := []Point{}
+		for _, p := range ser.Points {
+			if !p.Time.Before(start) {
+				points = append(points, p)
+			}
+		}
+		rv = append(rv, &Series{
+			Tags:   ser.Tags,
+			Points: points,
+		})
+	}
+	return rv, nil
+}
+
+// NumValues implements Source. If no Series have been set for the query, all
+// of the CONDITION_VARIABLES are assumed to be available.
+func (s *FakeSource) NumValues(query string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, ser := range s.series[query] {
+		for _, p := range ser.Points {
+			return len(p.Values)
+		}
+	}
+	return len(CONDITION_VARIABLES)
+}
+
+// SupportsWindow implements Source.
+func (s *FakeSource) SupportsWindow() bool {
+	return true
+}
+
+// pointsByTime sorts Points from oldest to newest.
+type pointsByTime []Point
+
+func (p pointsByTime) Len() int           { return len(p) }
+func (p pointsByTime) Less(i, j int) bool { return p[i].Time.Before(p[j].Time) }
+func (p pointsByTime) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// sortPoints sorts the Points of each Series from oldest to newest.
+func sortPoints(series []*Series) {
+	for _, s := range series {
+		sort.Sort(pointsByTime(s.Points))
+	}
+}
@@ -0,0 +1,164 @@
+package rules
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/mockhttpclient"
+	"go.skia.org/infra/go/testutils"
+)
+
+// setNow makes timeNow return the given time. Returns a function which
+// restores it.
+func setNow(now time.Time) func() {
+	timeNow = func() time.Time {
+		return now
+	}
+	return func() {
+		timeNow = time.Now
+	}
+}
+
+func TestPrometheusSource(t *testing.T) {
+	testutils.SmallTest(t)
+	now := time.Unix(1465000000, 0).UTC()
+	defer setNow(now)()
+	urlMock := mockhttpclient.NewURLMock()
+	s := NewPrometheusSource("http://prom:9090", urlMock.Client())
+
+	// Instant query.
+	params := url.Values{}
+	params.Set("query", "up")
+	urlMock.MockOnce("http://prom:9090/api/v1/query?"+params.Encode(), mockhttpclient.MockGetDialogue([]byte(`{
+		"status": "success",
+		"data": {"resultType": "vector", "result": [
+			{"metric": {"job": "a"}, "value": [1465000000.5, "1"]},
+			{"metric": {"job": "b"}, "value": [1465000000, "0"]}
+		]}
+	}`)))
+	series, err := s.Query("", "up", 1, 0)
+	assert.NoError(t, err)
+	assert.Len(t, series, 2)
+	expect.Equal(t, map[string]string{"job": "a"}, series[0].Tags)
+	expect.Equal(t, []Point{{Time: now.Add(500 * time.Millisecond), Values: []float64{1}}}, series[0].Points)
+	expect.Equal(t, []Point{{Time: now, Values: []float64{0}}}, series[1].Points)
+
+	// Range query.
+	params.Set("start", "1464999400")
+	params.Set("end", "1465000000")
+	params.Set("step", "6")
+	urlMock.MockOnce("http://prom:9090/api/v1/query_range?"+params.Encode(), mockhttpclient.MockGetDialogue([]byte(`{
+		"status": "success",
+		"data": {"resultType": "matrix", "result": [
+			{"metric": {"job": "a"}, "values": [[1464999994, "3"], [1464999400, "2"]]}
+		]}
+	}`)))
+	series, err = s.Query("", "up", 1, 10*time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, series, 1)
+	// Points are sorted.
+	expect.Equal(t, []Point{
+		{Time: time.Unix(1464999400, 0).UTC(), Values: []float64{2}},
+		{Time: time.Unix(1464999994, 0).UTC(), Values: []float64{3}},
+	}, series[0].Points)
+
+	// Errors.
+	params = url.Values{}
+	params.Set("query", "bad(")
+	urlMock.MockOnce("http://prom:9090/api/v1/query?"+params.Encode(), mockhttpclient.MockGetDialogue([]byte(`{"status": "error", "error": "parse error"}`)))
+	_, err = s.Query("", "bad(", 1, 0)
+	assert.Error(t, err)
+	expect.Contains(t, err.Error(), "parse error")
+	params.Set("query", "nan")
+	urlMock.MockOnce("http://prom:9090/api/v1/query?"+params.Encode(), mockhttpclient.MockGetDialogue([]byte(`{
+		"status": "success",
+		"data": {"resultType": "vector", "result": [{"metric": {}, "value": [1465000000, "x"]}]}
+	}`)))
+	_, err = s.Query("", "nan", 1, 0)
+	_, ok := err.(*valueError)
+	expect.True(t, ok, "%v", err)
+	expect.True(t, urlMock.Empty())
+}
+
+// ragemonParams returns the parameters of a RagemonSource request.
+func ragemonParams(q string, begin, end int64, step string) string {
+	params := url.Values{}
+	params.Set("expr", `filter("`+q+`")`)
+	params.Set("begin", strconv.FormatInt(begin, 10))
+	params.Set("end", strconv.FormatInt(end, 10))
+	params.Set("step", step)
+	return params.Encode()
+}
+
+func TestRagemonSource(t *testing.T) {
+	testutils.SmallTest(t)
+	now := time.Unix(1465000000, 0).UTC()
+	defer setNow(now)()
+	urlMock := mockhttpclient.NewURLMock()
+	s := NewRagemonSource("http://rageserve:8000", urlMock.Client())
+
+	urlMock.MockOnce("http://rageserve:8000/query?"+ragemonParams("app=perf&meas=errors", 1464999700, 1465000000, "3s"), mockhttpclient.MockGetDialogue([]byte(`{
+		"timestamps": [1464999900, 1464999930, 1464999960],
+		"series": {",app=perf,meas=errors,": [2, null, 5]}
+	}`)))
+	series, err := s.Query("", "app=perf&meas=errors", 1, 0)
+	assert.NoError(t, err)
+	assert.Len(t, series, 1)
+	expect.Equal(t, map[string]string{"app": "perf", "meas": "errors"}, series[0].Tags)
+	expect.Equal(t, []Point{
+		{Time: time.Unix(1464999900, 0).UTC(), Values: []float64{2}},
+		{Time: time.Unix(1464999960, 0).UTC(), Values: []float64{5}},
+	}, series[0].Points)
+
+	urlMock.MockOnce("http://rageserve:8000/query?"+ragemonParams("app=perf", 1464996400, 1465000000, "36s"), mockhttpclient.MockGetDialogue([]byte(`{
+		"timestamps": [1464999960],
+		"series": {",app=perf,meas=errors,": [5], ",app=perf,meas=requests,": [100]}
+	}`)))
+	series, err = s.Query("", "app=perf", 1, time.Hour)
+	assert.NoError(t, err)
+	expect.Len(t, series, 2)
+
+	// Errors.
+	_, err = s.Query("", "app=%zz", 1, 0)
+	expect.Error(t, err)
+	_, err = s.Query("", `app="perf"`, 1, 0)
+	expect.Error(t, err)
+	urlMock.MockOnce("http://rageserve:8000/query?"+ragemonParams("app=perf", 1464999700, 1465000000, "3s"), mockhttpclient.MockGetDialogue([]byte(`{
+		"timestamps": [1464999960],
+		"series": {",app=perf,meas=errors,": [5, 6]}
+	}`)))
+	_, err = s.Query("", "app=perf", 1, 0)
+	expect.Error(t, err)
+	expect.True(t, urlMock.Empty())
+}
+
+func TestFakeSource(t *testing.T) {
+	testutils.SmallTest(t)
+	now := time.Unix(1465000000, 0).UTC()
+	defer setNow(now)()
+	s := NewFakeSource()
+	expect.Equal(t, len(CONDITION_VARIABLES), s.NumValues("q"))
+	_, err := s.Query("", "q", 1, 0)
+	expect.Error(t, err)
+
+	s.SetSeries("q", []*Series{{
+		Tags: map[string]string{"host": "a"},
+		Points: []Point{
+			{Time: now.Add(-time.Hour), Values: []float64{1, 2}},
+			{Time: now, Values: []float64{3, 4}},
+		},
+	}})
+	expect.Equal(t, 2, s.NumValues("q"))
+	series, err := s.Query("", "q", 1, 0)
+	assert.NoError(t, err)
+	assert.Len(t, series, 1)
+	expect.Len(t, series[0].Points, 2)
+	series, err = s.Query("", "q", 1, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, series, 1)
+	expect.Equal(t, []Point{{Time: now, Values: []float64{3, 4}}}, series[0].Points)
+}
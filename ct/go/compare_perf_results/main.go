@@ -0,0 +1,53 @@
+package main
+
+// Compares the nopatch and withpatch CSV outputs of a chromium perf run statistically and writes
+// HTML and JSON reports to the output directory. Multiple CSV files of a run can be specified as
+// a comma separated list, eg. the samples of all workers.
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/ct/go/perf_comparer"
+	"go.skia.org/infra/go/common"
+)
+
+var (
+	noPatchCSV        = flag.String("nopatch_csv", "", "Comma separated CSV files of the nopatch run.")
+	withPatchCSV      = flag.String("withpatch_csv", "", "Comma separated CSV files of the withpatch run.")
+	outputDir         = flag.String("output_dir", "", "The directory to write the HTML and JSON reports to.")
+	varianceThreshold = flag.Float64("variance_threshold", 0.0, "Pages whose absolute percent change is below this threshold are not listed.")
+	discardOutliers   = flag.Float64("discard_outliers", 0.0, "The percentage of pages to discard at each end of each metric.")
+	significanceLevel = flag.Float64("significance_level", perf_comparer.DEFAULT_SIGNIFICANCE_LEVEL, "Differences with a p-value below this level are significant.")
+)
+
+func main() {
+	defer common.LogPanic()
+	common.Init()
+
+	if *noPatchCSV == "" || *withPatchCSV == "" {
+		glog.Fatal("Must specify --nopatch_csv and --withpatch_csv")
+	}
+	if *outputDir == "" {
+		glog.Fatal("Must specify --output_dir")
+	}
+	noPatchSamples, err := perf_comparer.ReadCSVFiles(strings.Split(*noPatchCSV, ",")...)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	withPatchSamples, err := perf_comparer.ReadCSVFiles(strings.Split(*withPatchCSV, ",")...)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	report := perf_comparer.Compare(noPatchSamples, withPatchSamples, perf_comparer.Options{
+		VarianceThreshold: *varianceThreshold,
+		DiscardOutliers:   *discardOutliers,
+		SignificanceLevel: *significanceLevel,
+	})
+	if err := report.WriteFiles(*outputDir); err != nil {
+		glog.Fatal(err)
+	}
+	glog.Infof("Wrote report of %d comparisons to %s", len(report.Comparisons), filepath.Join(*outputDir, perf_comparer.HTML_REPORT_NAME))
+}
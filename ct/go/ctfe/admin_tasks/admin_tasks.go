@@ -27,6 +27,19 @@ var (
 	recreateWebpageArchivesRunsHistoryTemplate *template.Template = nil
 )
 
+func init() {
+	task_common.RegisterTaskType(task_common.TaskType{
+		Prototype: func() task_common.Task { return &RecreatePageSetsDBTask{} },
+		GetURI:    ctfeutil.GET_RECREATE_PAGE_SETS_TASKS_POST_URI,
+		DeleteURI: ctfeutil.DELETE_RECREATE_PAGE_SETS_TASK_POST_URI,
+	})
+	task_common.RegisterTaskType(task_common.TaskType{
+		Prototype: func() task_common.Task { return &RecreateWebpageArchivesDBTask{} },
+		GetURI:    ctfeutil.GET_RECREATE_WEBPAGE_ARCHIVES_TASKS_POST_URI,
+		DeleteURI: ctfeutil.DELETE_RECREATE_WEBPAGE_ARCHIVES_TASK_POST_URI,
+	})
+}
+
 func ReloadTemplates(resourcesDir string) {
 	addTaskTemplate = template.Must(template.ParseFiles(
 		filepath.Join(resourcesDir, "templates/admin_tasks.html"),
@@ -65,6 +78,15 @@ func (dbTask RecreatePageSetsDBTask) GetPopulatedAddTaskVars() task_common.AddTa
 	return taskVars
 }
 
+func (task RecreatePageSetsDBTask) GetMasterScript() (*task_common.MasterScript, error) {
+	return &task_common.MasterScript{
+		Name: "create_pagesets_on_workers",
+		Args: []string{
+			"--pageset_type=" + task.PageSets,
+		},
+	}, nil
+}
+
 func (task RecreatePageSetsDBTask) GetUpdateTaskVars() task_common.UpdateTaskVars {
 	return &RecreatePageSetsUpdateVars{}
 }
@@ -111,6 +133,15 @@ func (dbTask RecreateWebpageArchivesDBTask) GetPopulatedAddTaskVars() task_commo
 	return taskVars
 }
 
+func (task RecreateWebpageArchivesDBTask) GetMasterScript() (*task_common.MasterScript, error) {
+	return &task_common.MasterScript{
+		Name: "capture_archives_on_workers",
+		Args: []string{
+			"--pageset_type=" + task.PageSets,
+		},
+	}, nil
+}
+
 func (task RecreateWebpageArchivesDBTask) GetUpdateTaskVars() task_common.UpdateTaskVars {
 	return &RecreateWebpageArchivesUpdateVars{}
 }
@@ -143,6 +174,10 @@ type AddRecreatePageSetsTaskVars struct {
 	PageSets string `json:"page_sets"`
 }
 
+func (task *AddRecreatePageSetsTaskVars) GetNumPages() int {
+	return ctfeutil.GetNumPages(task.PageSets, "")
+}
+
 func (task *AddRecreatePageSetsTaskVars) GetInsertQueryAndBinds() (string, []interface{}, error) {
 	if task.PageSets == "" {
 		return "", nil, fmt.Errorf("Invalid parameters")
@@ -172,6 +207,10 @@ type AddRecreateWebpageArchivesTaskVars struct {
 	ChromiumBuild chromium_builds.DBTask `json:"chromium_build"`
 }
 
+func (task *AddRecreateWebpageArchivesTaskVars) GetNumPages() int {
+	return ctfeutil.GetNumPages(task.PageSets, "")
+}
+
 func (task *AddRecreateWebpageArchivesTaskVars) GetInsertQueryAndBinds() (string, []interface{}, error) {
 	if task.PageSets == "" ||
 		task.ChromiumBuild.ChromiumRev == "" ||
@@ -26,6 +26,14 @@ var (
 	runsHistoryTemplate *template.Template = nil
 )
 
+func init() {
+	task_common.RegisterTaskType(task_common.TaskType{
+		Prototype: func() task_common.Task { return &DBTask{} },
+		GetURI:    ctfeutil.GET_CAPTURE_SKPS_TASKS_POST_URI,
+		DeleteURI: ctfeutil.DELETE_CAPTURE_SKPS_TASK_POST_URI,
+	})
+}
+
 func ReloadTemplates(resourcesDir string) {
 	addTaskTemplate = template.Must(template.ParseFiles(
 		filepath.Join(resourcesDir, "templates/capture_skps.html"),
@@ -68,6 +76,18 @@ func (dbTask DBTask) GetPopulatedAddTaskVars() task_common.AddTaskVars {
 	return taskVars
 }
 
+func (task DBTask) GetMasterScript() (*task_common.MasterScript, error) {
+	return &task_common.MasterScript{
+		Name: "capture_skps_on_workers",
+		Args: []string{
+			"--description=" + task.Description,
+			"--pageset_type=" + task.PageSets,
+			"--chromium_build=" + ctutil.ChromiumBuildDir(task.ChromiumRev, task.SkiaRev, ""),
+			"--target_platform=Linux",
+		},
+	}, nil
+}
+
 func (task DBTask) GetUpdateTaskVars() task_common.UpdateTaskVars {
 	return &UpdateVars{}
 }
@@ -94,6 +114,10 @@ type AddTaskVars struct {
 	Description   string                 `json:"desc"`
 }
 
+func (task *AddTaskVars) GetNumPages() int {
+	return ctfeutil.GetNumPages(task.PageSets, "")
+}
+
 func (task *AddTaskVars) GetInsertQueryAndBinds() (string, []interface{}, error) {
 	if task.PageSets == "" ||
 		task.ChromiumBuild.ChromiumRev == "" ||
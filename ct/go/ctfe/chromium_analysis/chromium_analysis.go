@@ -29,6 +29,14 @@ var (
 	httpClient = httputils.NewTimeoutClient()
 )
 
+func init() {
+	task_common.RegisterTaskType(task_common.TaskType{
+		Prototype: func() task_common.Task { return &DBTask{} },
+		GetURI:    ctfeutil.GET_CHROMIUM_ANALYSIS_TASKS_POST_URI,
+		DeleteURI: ctfeutil.DELETE_CHROMIUM_ANALYSIS_TASK_POST_URI,
+	})
+}
+
 func ReloadTemplates(resourcesDir string) {
 	addTaskTemplate = template.Must(template.ParseFiles(
 		filepath.Join(resourcesDir, "templates/chromium_analysis.html"),
@@ -92,6 +100,34 @@ func (task DBTask) GetResultsLink() string {
 	}
 }
 
+func (task DBTask) GetMasterScript() (*task_common.MasterScript, error) {
+	files := map[string]string{}
+	for fileSuffix, patch := range map[string]string{
+		".chromium.patch":      task.ChromiumPatch,
+		".catapult.patch":      task.CatapultPatch,
+		".benchmark.patch":     task.BenchmarkPatch,
+		".custom_webpages.csv": task.CustomWebpages,
+	} {
+		// Add an extra newline at the end because git sometimes rejects patches due to
+		// missing newlines.
+		files[fileSuffix] = patch + "\n"
+	}
+	return &task_common.MasterScript{
+		Name: "run_chromium_analysis_on_workers",
+		Args: []string{
+			"--description=" + task.Description,
+			"--pageset_type=" + task.PageSets,
+			"--benchmark_name=" + task.Benchmark,
+			"--benchmark_extra_args=" + task.BenchmarkArgs,
+			"--browser_extra_args=" + task.BrowserArgs,
+			"--run_in_parallel=" + strconv.FormatBool(task.RunInParallel),
+			"--target_platform=" + task.Platform,
+			"--run_on_gce=" + strconv.FormatBool(task.RunOnGCE),
+		},
+		Files: files,
+	}, nil
+}
+
 func (task DBTask) GetUpdateTaskVars() task_common.UpdateTaskVars {
 	return &UpdateVars{}
 }
@@ -127,6 +163,10 @@ type AddTaskVars struct {
 	RunOnGCE       bool   `json:"run_on_gce"`
 }
 
+func (task *AddTaskVars) GetNumPages() int {
+	return ctfeutil.GetNumPages(task.PageSets, task.CustomWebpages)
+}
+
 func (task *AddTaskVars) GetInsertQueryAndBinds() (string, []interface{}, error) {
 	if task.Benchmark == "" ||
 		task.PageSets == "" ||
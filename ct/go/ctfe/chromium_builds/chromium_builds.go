@@ -50,6 +50,16 @@ var (
 	httpClient = httputils.NewTimeoutClient()
 )
 
+func init() {
+	task_common.RegisterTaskType(task_common.TaskType{
+		Prototype: func() task_common.Task { return &DBTask{} },
+		GetURI:    ctfeutil.GET_CHROMIUM_BUILD_TASKS_POST_URI,
+		DeleteURI: ctfeutil.DELETE_CHROMIUM_BUILD_TASK_POST_URI,
+		// Other tasks may be waiting for the build.
+		Priority: 1,
+	})
+}
+
 func ReloadTemplates(resourcesDir string) {
 	addTaskTemplate = template.Must(template.ParseFiles(
 		filepath.Join(resourcesDir, "templates/chromium_builds.html"),
@@ -91,6 +101,17 @@ func (dbTask DBTask) GetPopulatedAddTaskVars() task_common.AddTaskVars {
 	return taskVars
 }
 
+func (task DBTask) GetMasterScript() (*task_common.MasterScript, error) {
+	return &task_common.MasterScript{
+		Name: "build_chromium",
+		Args: []string{
+			"--target_platform=Linux",
+			"--chromium_hash=" + task.ChromiumRev,
+			"--skia_hash=" + task.SkiaRev,
+		},
+	}, nil
+}
+
 func (task DBTask) GetUpdateTaskVars() task_common.UpdateTaskVars {
 	return &UpdateVars{}
 }
@@ -26,6 +26,14 @@ var (
 	runsHistoryTemplate *template.Template = nil
 )
 
+func init() {
+	task_common.RegisterTaskType(task_common.TaskType{
+		Prototype: func() task_common.Task { return &DBTask{} },
+		GetURI:    ctfeutil.GET_CHROMIUM_PERF_TASKS_POST_URI,
+		DeleteURI: ctfeutil.DELETE_CHROMIUM_PERF_TASK_POST_URI,
+	})
+}
+
 func ReloadTemplates(resourcesDir string) {
 	addTaskTemplate = template.Must(template.ParseFiles(
 		filepath.Join(resourcesDir, "templates/chromium_perf.html"),
@@ -60,6 +68,7 @@ type DBTask struct {
 	Results              sql.NullString `db:"results"`
 	NoPatchRawOutput     sql.NullString `db:"nopatch_raw_output"`
 	WithPatchRawOutput   sql.NullString `db:"withpatch_raw_output"`
+	StatsResults         sql.NullString `db:"stats_results"`
 }
 
 func (task DBTask) GetTaskName() string {
@@ -89,14 +98,52 @@ func (dbTask DBTask) GetPopulatedAddTaskVars() task_common.AddTaskVars {
 	return taskVars
 }
 
+// GetResultsLink returns the link to the statistical comparison of the nopatch and withpatch
+// runs, which links to the full results, or to the full results for tasks without a statistical
+// comparison.
 func (task DBTask) GetResultsLink() string {
-	if task.Results.Valid {
+	if task.StatsResults.Valid {
+		return task.StatsResults.String
+	} else if task.Results.Valid {
 		return task.Results.String
 	} else {
 		return ""
 	}
 }
 
+func (task DBTask) GetMasterScript() (*task_common.MasterScript, error) {
+	// TODO(benjaminwagner): Since run_chromium_perf_on_workers only reads these in order to
+	// upload to Google Storage, eventually we should move the upload step to the poller to avoid
+	// writing to disk.
+	files := map[string]string{}
+	for fileSuffix, patch := range map[string]string{
+		".chromium.patch":      task.ChromiumPatch,
+		".skia.patch":          task.SkiaPatch,
+		".catapult.patch":      task.CatapultPatch,
+		".benchmark.patch":     task.BenchmarkPatch,
+		".custom_webpages.csv": task.CustomWebpages,
+	} {
+		// Add an extra newline at the end because git sometimes rejects patches due to
+		// missing newlines.
+		files[fileSuffix] = patch + "\n"
+	}
+	return &task_common.MasterScript{
+		Name: "run_chromium_perf_on_workers",
+		Args: []string{
+			"--description=" + task.Description,
+			"--pageset_type=" + task.PageSets,
+			"--benchmark_name=" + task.Benchmark,
+			"--benchmark_extra_args=" + task.BenchmarkArgs,
+			"--browser_extra_args_nopatch=" + task.BrowserArgsNoPatch,
+			"--browser_extra_args_withpatch=" + task.BrowserArgsWithPatch,
+			"--repeat_benchmark=" + strconv.FormatInt(task.RepeatRuns, 10),
+			"--run_in_parallel=" + strconv.FormatBool(task.RunInParallel),
+			"--target_platform=" + task.Platform,
+		},
+		Files: files,
+	}, nil
+}
+
 func (task DBTask) GetUpdateTaskVars() task_common.UpdateTaskVars {
 	return &UpdateVars{}
 }
@@ -135,6 +182,10 @@ type AddTaskVars struct {
 	BenchmarkPatch       string `json:"benchmark_patch"`
 }
 
+func (task *AddTaskVars) GetNumPages() int {
+	return ctfeutil.GetNumPages(task.PageSets, task.CustomWebpages)
+}
+
 func (task *AddTaskVars) GetInsertQueryAndBinds() (string, []interface{}, error) {
 	if task.Benchmark == "" ||
 		task.Platform == "" ||
@@ -208,6 +259,7 @@ type UpdateVars struct {
 	Results            sql.NullString
 	NoPatchRawOutput   sql.NullString
 	WithPatchRawOutput sql.NullString
+	StatsResults       sql.NullString
 }
 
 func (vars *UpdateVars) UriPath() string {
@@ -219,6 +271,7 @@ func (task *UpdateVars) GetUpdateExtraClausesAndBinds() ([]string, []interface{}
 		{Name: "NoPatchRawOutput", Value: task.NoPatchRawOutput.String, Limit: 255},
 		{Name: "WithPatchRawOutput", Value: task.WithPatchRawOutput.String, Limit: 255},
 		{Name: "Results", Value: task.Results.String, Limit: 255},
+		{Name: "StatsResults", Value: task.StatsResults.String, Limit: 255},
 	}); err != nil {
 		return nil, nil, err
 	}
@@ -236,6 +289,10 @@ func (task *UpdateVars) GetUpdateExtraClausesAndBinds() ([]string, []interface{}
 		clauses = append(clauses, "withpatch_raw_output = ?")
 		args = append(args, task.WithPatchRawOutput.String)
 	}
+	if task.StatsResults.Valid {
+		clauses = append(clauses, "stats_results = ?")
+		args = append(args, task.StatsResults.String)
+	}
 	return clauses, args, nil
 }
 
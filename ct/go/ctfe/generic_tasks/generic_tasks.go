@@ -0,0 +1,391 @@
+/*
+	Handlers and types for generic tasks.
+
+	A generic task type only declares its form fields, the master script that
+	runs it and how to link to its results. The parameters of generic tasks are
+	stored as JSON in the GenericTasks table, so adding a generic task type
+	needs neither a DB migration nor new handlers or pages.
+*/
+
+package generic_tasks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"text/template"
+
+	"github.com/gorilla/mux"
+
+	"go.skia.org/infra/ct/go/ctfe/task_common"
+	ctfeutil "go.skia.org/infra/ct/go/ctfe/util"
+	"go.skia.org/infra/ct/go/db"
+	ctutil "go.skia.org/infra/ct/go/util"
+	"go.skia.org/infra/go/httputils"
+	skutil "go.skia.org/infra/go/util"
+)
+
+const (
+	// The task name of all generic tasks. The type of an individual generic task
+	// is in its task_type column.
+	TASK_NAME = "Generic"
+
+	// Types of form fields.
+	FIELD_TEXT     = "text"
+	FIELD_TEXTAREA = "textarea"
+	FIELD_CHECKBOX = "checkbox"
+	FIELD_SELECT   = "select"
+)
+
+var (
+	addTaskTemplate     *template.Template = nil
+	runsHistoryTemplate *template.Template = nil
+
+	// All registered Specs, keyed by name.
+	specs = map[string]*Spec{}
+	// Mutex that controls access to specs.
+	specsMtx = sync.Mutex{}
+
+	fieldTypes = []string{FIELD_TEXT, FIELD_TEXTAREA, FIELD_CHECKBOX, FIELD_SELECT}
+)
+
+func init() {
+	task_common.RegisterTaskType(task_common.TaskType{
+		Prototype: func() task_common.Task { return &DBTask{} },
+		GetURI:    ctfeutil.GET_GENERIC_TASKS_POST_URI,
+		DeleteURI: ctfeutil.DELETE_GENERIC_TASK_POST_URI,
+	})
+}
+
+func ReloadTemplates(resourcesDir string) {
+	addTaskTemplate = template.Must(template.ParseFiles(
+		filepath.Join(resourcesDir, "templates/generic_tasks.html"),
+		filepath.Join(resourcesDir, "templates/header.html"),
+		filepath.Join(resourcesDir, "templates/titlebar.html"),
+	))
+	runsHistoryTemplate = template.Must(template.ParseFiles(
+		filepath.Join(resourcesDir, "templates/generic_task_runs_history.html"),
+		filepath.Join(resourcesDir, "templates/header.html"),
+		filepath.Join(resourcesDir, "templates/titlebar.html"),
+	))
+}
+
+// Field is a form field of a generic task type. The value entered in the
+// field is stored in the task parameters under Name.
+type Field struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	// One of FIELD_TEXT, FIELD_TEXTAREA, FIELD_CHECKBOX or FIELD_SELECT.
+	Type string `json:"type"`
+	// The values to choose from, only used by FIELD_SELECT.
+	Options  []string `json:"options,omitempty"`
+	Required bool     `json:"required"`
+	// The maximum length of the value, 0 means no limit.
+	MaxLength int `json:"max_length"`
+}
+
+// Spec declares a generic task type.
+type Spec struct {
+	// The name of the task type, stored in the task_type column.
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Fields      []Field `json:"fields"`
+	// If true then only admins can add tasks of this type.
+	AdminOnly bool `json:"admin_only"`
+	// Returns the master script that runs a task with the given description and
+	// parameters.
+	MasterScript func(description string, params map[string]string) *task_common.MasterScript `json:"-"`
+	// Returns the results link of a task that completed successfully given its
+	// parameters and the results reported by the master script. If nil then the
+	// results are used as the results link.
+	ResultsLink func(params map[string]string, results string) string `json:"-"`
+}
+
+// Validate returns an error if the given task parameters do not match the
+// fields of the Spec.
+func (spec *Spec) Validate(params map[string]string) error {
+	fields := map[string]Field{}
+	for _, f := range spec.Fields {
+		fields[f.Name] = f
+	}
+	for name := range params {
+		if _, ok := fields[name]; !ok {
+			return fmt.Errorf("Unknown parameter %q for %s tasks", name, spec.Name)
+		}
+	}
+	for _, f := range spec.Fields {
+		value := params[f.Name]
+		if value == "" {
+			if f.Required {
+				return fmt.Errorf("Missing required parameter %q", f.Name)
+			}
+			continue
+		}
+		if f.MaxLength > 0 && len(value) > f.MaxLength {
+			return fmt.Errorf("Value of %q is too long; limit %d bytes", f.Name, f.MaxLength)
+		}
+		switch f.Type {
+		case FIELD_CHECKBOX:
+			if _, err := strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("Value of %q is not a boolean: %q", f.Name, value)
+			}
+		case FIELD_SELECT:
+			if !skutil.In(value, f.Options) {
+				return fmt.Errorf("Value of %q is not one of %v: %q", f.Name, f.Options, value)
+			}
+		}
+	}
+	return nil
+}
+
+// Register adds a generic task type. It should be called from an init
+// function of the package that declares the Spec, and that package should be
+// imported by task_types. Panics if the Spec is invalid or if a Spec with the
+// same name is already registered.
+func Register(spec *Spec) {
+	if spec.Name == "" || spec.MasterScript == nil {
+		panic(fmt.Sprintf("Generic task type %q must have a name and a master script", spec.Name))
+	}
+	names := map[string]bool{}
+	for _, f := range spec.Fields {
+		if f.Name == "" || names[f.Name] {
+			panic(fmt.Sprintf("Generic task type %s has a missing or duplicate field name %q", spec.Name, f.Name))
+		}
+		if !skutil.In(f.Type, fieldTypes) {
+			panic(fmt.Sprintf("Generic task type %s has field %s of unknown type %q", spec.Name, f.Name, f.Type))
+		}
+		names[f.Name] = true
+	}
+	specsMtx.Lock()
+	defer specsMtx.Unlock()
+	if _, ok := specs[spec.Name]; ok {
+		panic(fmt.Sprintf("Generic task type %s is registered twice", spec.Name))
+	}
+	specs[spec.Name] = spec
+}
+
+// GetSpec returns the registered Spec with the given name.
+func GetSpec(name string) (*Spec, error) {
+	specsMtx.Lock()
+	defer specsMtx.Unlock()
+	spec, ok := specs[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown generic task type %q", name)
+	}
+	return spec, nil
+}
+
+// Specs returns all registered Specs sorted by name.
+func Specs() []*Spec {
+	specsMtx.Lock()
+	defer specsMtx.Unlock()
+	ret := make([]*Spec, 0, len(specs))
+	for _, spec := range specs {
+		ret = append(ret, spec)
+	}
+	sort.Sort(specSlice(ret))
+	return ret
+}
+
+type specSlice []*Spec
+
+func (p specSlice) Len() int           { return len(p) }
+func (p specSlice) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p specSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type DBTask struct {
+	task_common.CommonCols
+
+	TaskType    string         `db:"task_type"`
+	Parameters  string         `db:"parameters"`
+	Description string         `db:"description"`
+	Results     sql.NullString `db:"results"`
+}
+
+func (task DBTask) GetTaskName() string {
+	return TASK_NAME
+}
+
+// getSpecAndParameters returns the Spec of the task and its decoded parameters.
+func (task DBTask) getSpecAndParameters() (*Spec, map[string]string, error) {
+	spec, err := GetSpec(task.TaskType)
+	if err != nil {
+		return nil, nil, err
+	}
+	params := map[string]string{}
+	if err := json.Unmarshal([]byte(task.Parameters), &params); err != nil {
+		return nil, nil, fmt.Errorf("Failed to decode parameters of %s task %d: %s", task.TaskType, task.Id, err)
+	}
+	return spec, params, nil
+}
+
+func (task DBTask) GetResultsLink() string {
+	if !task.Results.Valid {
+		return ""
+	}
+	spec, params, err := task.getSpecAndParameters()
+	if err != nil || spec.ResultsLink == nil {
+		return task.Results.String
+	}
+	return spec.ResultsLink(params, task.Results.String)
+}
+
+func (task DBTask) GetMasterScript() (*task_common.MasterScript, error) {
+	spec, params, err := task.getSpecAndParameters()
+	if err != nil {
+		return nil, err
+	}
+	return spec.MasterScript(task.Description, params), nil
+}
+
+func (dbTask DBTask) GetPopulatedAddTaskVars() task_common.AddTaskVars {
+	taskVars := &AddTaskVars{}
+	taskVars.Username = dbTask.Username
+	taskVars.TsAdded = ctutil.GetCurrentTs()
+	taskVars.RepeatAfterDays = strconv.FormatInt(dbTask.RepeatAfterDays, 10)
+	taskVars.TaskType = dbTask.TaskType
+	taskVars.Parameters = map[string]string{}
+	// Invalid parameters are caught by GetInsertQueryAndBinds.
+	_ = json.Unmarshal([]byte(dbTask.Parameters), &taskVars.Parameters)
+	taskVars.Description = dbTask.Description
+	return taskVars
+}
+
+func (task DBTask) GetUpdateTaskVars() task_common.UpdateTaskVars {
+	return &UpdateVars{}
+}
+
+func (task DBTask) TableName() string {
+	return db.TABLE_GENERIC_TASKS
+}
+
+func (task DBTask) Select(query string, args ...interface{}) (interface{}, error) {
+	result := []DBTask{}
+	err := db.DB.Select(&result, query, args...)
+	return result, err
+}
+
+func addTaskView(w http.ResponseWriter, r *http.Request) {
+	ctfeutil.ExecuteSimpleTemplate(addTaskTemplate, w, r)
+}
+
+type AddTaskVars struct {
+	task_common.AddTaskCommonVars
+
+	TaskType    string            `json:"task_type"`
+	Parameters  map[string]string `json:"parameters"`
+	Description string            `json:"desc"`
+}
+
+func (task *AddTaskVars) IsAdminTask() bool {
+	spec, err := GetSpec(task.TaskType)
+	return err == nil && spec.AdminOnly
+}
+
+func (task *AddTaskVars) GetInsertQueryAndBinds() (string, []interface{}, error) {
+	if task.TaskType == "" || task.Description == "" {
+		return "", nil, fmt.Errorf("Invalid parameters")
+	}
+	spec, err := GetSpec(task.TaskType)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := spec.Validate(task.Parameters); err != nil {
+		return "", nil, err
+	}
+	params, err := json.Marshal(task.Parameters)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to encode parameters: %s", err)
+	}
+	if err := ctfeutil.CheckLengths([]ctfeutil.LengthCheck{
+		{Name: "task_type", Value: task.TaskType, Limit: 100},
+		{Name: "parameters", Value: string(params), Limit: db.LONG_TEXT_MAX_LENGTH},
+		{Name: "desc", Value: task.Description, Limit: 255},
+	}); err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("INSERT INTO %s (username,task_type,parameters,description,ts_added,repeat_after_days) VALUES (?,?,?,?,?,?);",
+			db.TABLE_GENERIC_TASKS),
+		[]interface{}{
+			task.Username,
+			task.TaskType,
+			string(params),
+			task.Description,
+			task.TsAdded,
+			task.RepeatAfterDays,
+		},
+		nil
+}
+
+func addTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task_common.AddTaskHandler(w, r, &AddTaskVars{})
+}
+
+func getTasksHandler(w http.ResponseWriter, r *http.Request) {
+	task_common.GetTasksHandler(&DBTask{}, w, r)
+}
+
+type UpdateVars struct {
+	task_common.UpdateTaskCommonVars
+
+	Results sql.NullString
+}
+
+func (vars *UpdateVars) UriPath() string {
+	return ctfeutil.UPDATE_GENERIC_TASK_POST_URI
+}
+
+func (task *UpdateVars) GetUpdateExtraClausesAndBinds() ([]string, []interface{}, error) {
+	if err := ctfeutil.CheckLengths([]ctfeutil.LengthCheck{
+		{Name: "Results", Value: task.Results.String, Limit: 255},
+	}); err != nil {
+		return nil, nil, err
+	}
+	clauses := []string{}
+	args := []interface{}{}
+	if task.Results.Valid {
+		clauses = append(clauses, "results = ?")
+		args = append(args, task.Results.String)
+	}
+	return clauses, args, nil
+}
+
+func updateTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task_common.UpdateTaskHandler(&UpdateVars{}, db.TABLE_GENERIC_TASKS, w, r)
+}
+
+func deleteTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task_common.DeleteTaskHandler(&DBTask{}, w, r)
+}
+
+func redoTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task_common.RedoTaskHandler(&DBTask{}, w, r)
+}
+
+func runsHistoryView(w http.ResponseWriter, r *http.Request) {
+	ctfeutil.ExecuteSimpleTemplate(runsHistoryTemplate, w, r)
+}
+
+func specsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(Specs()); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to encode JSON: %v", err))
+		return
+	}
+}
+
+func AddHandlers(r *mux.Router) {
+	r.HandleFunc("/"+ctfeutil.GENERIC_TASK_URI, addTaskView).Methods("GET")
+	r.HandleFunc("/"+ctfeutil.GENERIC_TASK_RUNS_URI, runsHistoryView).Methods("GET")
+	r.HandleFunc("/"+ctfeutil.GENERIC_TASK_SPECS_POST_URI, specsHandler).Methods("POST")
+	r.HandleFunc("/"+ctfeutil.ADD_GENERIC_TASK_POST_URI, addTaskHandler).Methods("POST")
+	r.HandleFunc("/"+ctfeutil.GET_GENERIC_TASKS_POST_URI, getTasksHandler).Methods("POST")
+	r.HandleFunc("/"+ctfeutil.UPDATE_GENERIC_TASK_POST_URI, updateTaskHandler).Methods("POST")
+	r.HandleFunc("/"+ctfeutil.DELETE_GENERIC_TASK_POST_URI, deleteTaskHandler).Methods("POST")
+	r.HandleFunc("/"+ctfeutil.REDO_GENERIC_TASK_POST_URI, redoTaskHandler).Methods("POST")
+}
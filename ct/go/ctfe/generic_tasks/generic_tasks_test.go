@@ -0,0 +1,116 @@
+package generic_tasks
+
+import (
+	"database/sql"
+	"testing"
+
+	"go.skia.org/infra/ct/go/ctfe/task_common"
+	"go.skia.org/infra/go/testutils"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+)
+
+func testSpec() *Spec {
+	return &Spec{
+		Name: "TestTask",
+		Fields: []Field{
+			{Name: "benchmark", Type: FIELD_TEXT, Required: true, MaxLength: 10},
+			{Name: "parallel", Type: FIELD_CHECKBOX},
+			{Name: "platform", Type: FIELD_SELECT, Options: []string{"Android", "Linux"}},
+		},
+		MasterScript: func(description string, params map[string]string) *task_common.MasterScript {
+			return &task_common.MasterScript{
+				Name: "run_test_on_workers",
+				Args: []string{
+					"--description=" + description,
+					"--benchmark_name=" + params["benchmark"],
+				},
+			}
+		},
+		ResultsLink: func(params map[string]string, results string) string {
+			return results + "#" + params["benchmark"]
+		},
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	testutils.SmallTest(t)
+	spec := testSpec()
+	expect.NoError(t, spec.Validate(map[string]string{"benchmark": "b"}))
+	expect.NoError(t, spec.Validate(map[string]string{"benchmark": "b", "parallel": "true", "platform": "Linux"}))
+	expect.Error(t, spec.Validate(map[string]string{}))
+	expect.Error(t, spec.Validate(map[string]string{"benchmark": "much_too_long"}))
+	expect.Error(t, spec.Validate(map[string]string{"benchmark": "b", "parallel": "yes please"}))
+	expect.Error(t, spec.Validate(map[string]string{"benchmark": "b", "platform": "Windows"}))
+	expect.Error(t, spec.Validate(map[string]string{"benchmark": "b", "unknown": "x"}))
+}
+
+func TestRegister(t *testing.T) {
+	testutils.SmallTest(t)
+	Register(testSpec())
+	defer func() {
+		specsMtx.Lock()
+		defer specsMtx.Unlock()
+		delete(specs, "TestTask")
+	}()
+	spec, err := GetSpec("TestTask")
+	assert.NoError(t, err)
+	expect.Equal(t, "TestTask", spec.Name)
+	_, err = GetSpec("Unknown")
+	expect.Error(t, err)
+	expect.Len(t, Specs(), 1)
+
+	expect.Panics(t, func() { Register(testSpec()) })
+	expect.Panics(t, func() { Register(&Spec{Name: "NoMasterScript"}) })
+	duplicateFields := testSpec()
+	duplicateFields.Name = "DuplicateFields"
+	duplicateFields.Fields = append(duplicateFields.Fields, Field{Name: "benchmark", Type: FIELD_TEXT})
+	expect.Panics(t, func() { Register(duplicateFields) })
+}
+
+func TestDBTask(t *testing.T) {
+	testutils.SmallTest(t)
+	Register(testSpec())
+	defer func() {
+		specsMtx.Lock()
+		defer specsMtx.Unlock()
+		delete(specs, "TestTask")
+	}()
+	task := DBTask{
+		CommonCols: task_common.CommonCols{
+			Id:              42,
+			Username:        "nobody@chromium.org",
+			RepeatAfterDays: 2,
+		},
+		TaskType:    "TestTask",
+		Parameters:  `{"benchmark":"b"}`,
+		Description: "description",
+	}
+	script, err := task.GetMasterScript()
+	assert.NoError(t, err)
+	expect.Equal(t, "run_test_on_workers", script.Name)
+	expect.Equal(t, []string{"--description=description", "--benchmark_name=b"}, script.Args)
+
+	expect.Equal(t, "", task.GetResultsLink())
+	task.Results = sql.NullString{String: "https://example.com/results", Valid: true}
+	expect.Equal(t, "https://example.com/results#b", task.GetResultsLink())
+
+	addTaskVars := task.GetPopulatedAddTaskVars().(*AddTaskVars)
+	expect.Equal(t, "TestTask", addTaskVars.TaskType)
+	expect.Equal(t, map[string]string{"benchmark": "b"}, addTaskVars.Parameters)
+	expect.Equal(t, "2", addTaskVars.RepeatAfterDays)
+	expect.False(t, addTaskVars.IsAdminTask())
+	_, binds, err := addTaskVars.GetInsertQueryAndBinds()
+	assert.NoError(t, err)
+	expect.Equal(t, `{"benchmark":"b"}`, binds[2])
+
+	addTaskVars.Parameters = map[string]string{}
+	_, _, err = addTaskVars.GetInsertQueryAndBinds()
+	expect.Error(t, err)
+
+	task.TaskType = "Unknown"
+	_, err = task.GetMasterScript()
+	expect.Error(t, err)
+	expect.Equal(t, "https://example.com/results", task.GetResultsLink())
+}
@@ -26,6 +26,14 @@ var (
 	runsHistoryTemplate *template.Template = nil
 )
 
+func init() {
+	task_common.RegisterTaskType(task_common.TaskType{
+		Prototype: func() task_common.Task { return &DBTask{} },
+		GetURI:    ctfeutil.GET_LUA_SCRIPT_TASKS_POST_URI,
+		DeleteURI: ctfeutil.DELETE_LUA_SCRIPT_TASK_POST_URI,
+	})
+}
+
 func ReloadTemplates(resourcesDir string) {
 	addTaskTemplate = template.Must(template.ParseFiles(
 		filepath.Join(resourcesDir, "templates/lua_scripts.html"),
@@ -81,6 +89,27 @@ func (dbTask DBTask) GetPopulatedAddTaskVars() task_common.AddTaskVars {
 	return taskVars
 }
 
+func (task DBTask) GetMasterScript() (*task_common.MasterScript, error) {
+	// TODO(benjaminwagner): Since run_lua_on_workers only reads the lua script in order to
+	// upload to Google Storage, eventually we should move the upload step to the poller to avoid
+	// writing to disk. Not sure if we can/should do the same for the aggregator script.
+	files := map[string]string{
+		".lua": task.LuaScript,
+	}
+	if task.LuaAggregatorScript != "" {
+		files[".aggregator"] = task.LuaAggregatorScript
+	}
+	return &task_common.MasterScript{
+		Name: "run_lua_on_workers",
+		Args: []string{
+			"--description=" + task.Description,
+			"--pageset_type=" + task.PageSets,
+			"--chromium_build=" + ctutil.ChromiumBuildDir(task.ChromiumRev, task.SkiaRev, ""),
+		},
+		Files: files,
+	}, nil
+}
+
 func (task DBTask) GetUpdateTaskVars() task_common.UpdateTaskVars {
 	return &UpdateVars{}
 }
@@ -108,6 +137,10 @@ type AddTaskVars struct {
 	Description         string              `json:"desc"`
 }
 
+func (task *AddTaskVars) GetNumPages() int {
+	return ctfeutil.GetNumPages(task.SkpRepository.PageSets, "")
+}
+
 func (task *AddTaskVars) GetInsertQueryAndBinds() (string, []interface{}, error) {
 	if task.SkpRepository.PageSets == "" ||
 		task.SkpRepository.ChromiumRev == "" ||
@@ -21,8 +21,10 @@ import (
 	"go.skia.org/infra/ct/go/ctfe/chromium_analysis"
 	"go.skia.org/infra/ct/go/ctfe/chromium_builds"
 	"go.skia.org/infra/ct/go/ctfe/chromium_perf"
+	"go.skia.org/infra/ct/go/ctfe/generic_tasks"
 	"go.skia.org/infra/ct/go/ctfe/lua_scripts"
 	"go.skia.org/infra/ct/go/ctfe/pending_tasks"
+	"go.skia.org/infra/ct/go/ctfe/quotas"
 	"go.skia.org/infra/ct/go/ctfe/task_common"
 	"go.skia.org/infra/ct/go/ctfe/task_types"
 	ctfeutil "go.skia.org/infra/ct/go/ctfe/util"
@@ -50,6 +52,7 @@ var (
 	workdir                = flag.String("workdir", ".", "Directory to use for scratch work.")
 	resourcesDir           = flag.String("resources_dir", "", "The directory to find templates, JS, and CSS files. If blank the current directory will be used.")
 	tasksSchedulerWaitTime = flag.Duration("tasks_scheduler_wait_time", 5*time.Minute, "How often the repeated tasks scheduler should run.")
+	quotasConfig           = flag.String("quotas_config", "", "JSON file with the quotas of users' tasks, see quotas.Config. Quotas are disabled if empty.")
 )
 
 func reloadTemplates() {
@@ -65,6 +68,7 @@ func reloadTemplates() {
 	lua_scripts.ReloadTemplates(*resourcesDir)
 	chromium_builds.ReloadTemplates(*resourcesDir)
 	admin_tasks.ReloadTemplates(*resourcesDir)
+	generic_tasks.ReloadTemplates(*resourcesDir)
 	pending_tasks.ReloadTemplates(*resourcesDir)
 }
 
@@ -100,7 +104,10 @@ func runServer(serverURL string) {
 	lua_scripts.AddHandlers(r)
 	chromium_builds.AddHandlers(r)
 	admin_tasks.AddHandlers(r)
+	generic_tasks.AddHandlers(r)
 	pending_tasks.AddHandlers(r)
+	task_types.AddHandlers(r)
+	quotas.AddHandlers(r)
 	task_common.AddHandlers(r)
 
 	// Common handlers used by different pages.
@@ -258,6 +265,10 @@ func main() {
 		glog.Fatal(err)
 	}
 
+	if err := quotas.Init(*quotasConfig); err != nil {
+		glog.Fatal(err)
+	}
+
 	startCtfeMetrics()
 
 	// Start the repeated tasks scheduler.
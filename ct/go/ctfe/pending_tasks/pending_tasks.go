@@ -11,16 +11,12 @@ import (
 	"io"
 	"net/http"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"text/template"
 
 	"github.com/gorilla/mux"
 
-	"go.skia.org/infra/ct/go/ctfe/admin_tasks"
-	"go.skia.org/infra/ct/go/ctfe/capture_skps"
-	"go.skia.org/infra/ct/go/ctfe/chromium_analysis"
-	"go.skia.org/infra/ct/go/ctfe/chromium_builds"
-	"go.skia.org/infra/ct/go/ctfe/chromium_perf"
-	"go.skia.org/infra/ct/go/ctfe/lua_scripts"
 	"go.skia.org/infra/ct/go/ctfe/task_common"
 	"go.skia.org/infra/ct/go/ctfe/task_types"
 	ctfeutil "go.skia.org/infra/ct/go/ctfe/util"
@@ -70,72 +66,159 @@ func GetOldestPendingTask() (task_common.Task, error) {
 	return oldestTask, nil
 }
 
-// Union of all task types, to be easily marshalled/unmarshalled to/from JSON. At most one field
-// should be non-nil when serialized as JSON.
-type oldestPendingTask struct {
-	ChromiumAnalysis        *chromium_analysis.DBTask
-	ChromiumPerf            *chromium_perf.DBTask
-	CaptureSkps             *capture_skps.DBTask
-	LuaScript               *lua_scripts.DBTask
-	ChromiumBuild           *chromium_builds.DBTask
-	RecreatePageSets        *admin_tasks.RecreatePageSetsDBTask
-	RecreateWebpageArchives *admin_tasks.RecreateWebpageArchivesDBTask
+// GetPendingTasks returns all pending tasks of all types in the order in which they should be
+// picked up, see OrderPendingTasks.
+func GetPendingTasks() ([]task_common.Task, error) {
+	pending := []task_common.Task{}
+	priorities := map[string]int{}
+	for _, taskType := range task_types.TaskTypes() {
+		prototype := taskType.Prototype()
+		query := fmt.Sprintf("SELECT * FROM %s WHERE ts_started IS NULL ORDER BY ts_added;", prototype.TableName())
+		data, err := prototype.Select(query)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to query DB: %v", err)
+		}
+		pending = append(pending, task_common.AsTaskSlice(data)...)
+		priorities[taskType.Name()] = taskType.Priority
+	}
+	OrderPendingTasks(pending, priorities)
+	return pending, nil
+}
+
+// OrderPendingTasks sorts tasks in the order in which they should be picked up. Tasks of types
+// with a higher priority come first. Tasks of the same priority are interleaved by user, so that
+// every user's oldest task comes before any user's second oldest task, and so on. Remaining ties
+// are broken by the time the tasks were added. priorities maps task names to the priority of
+// their type; missing task names have priority 0.
+func OrderPendingTasks(tasks []task_common.Task, priorities map[string]int) {
+	sort.Sort(taskSlice{tasks: tasks, priorities: priorities})
+	// Now that tasks of the same priority are oldest first, number each user's tasks.
+	turns := make(map[*task_common.CommonCols]int, len(tasks))
+	userTasks := map[string]int{}
+	for _, task := range tasks {
+		key := fmt.Sprintf("%d %s", priorities[task.GetTaskName()], task.GetCommonCols().Username)
+		turns[task.GetCommonCols()] = userTasks[key]
+		userTasks[key]++
+	}
+	sort.Stable(taskSlice{tasks: tasks, priorities: priorities, turns: turns})
+}
+
+// taskSlice sorts tasks by priority, then by turn if turns is not nil, then by the time they were
+// added.
+type taskSlice struct {
+	tasks      []task_common.Task
+	priorities map[string]int
+	turns      map[*task_common.CommonCols]int
+}
+
+func (p taskSlice) Len() int      { return len(p.tasks) }
+func (p taskSlice) Swap(i, j int) { p.tasks[i], p.tasks[j] = p.tasks[j], p.tasks[i] }
+func (p taskSlice) Less(i, j int) bool {
+	a, b := p.tasks[i], p.tasks[j]
+	if pa, pb := p.priorities[a.GetTaskName()], p.priorities[b.GetTaskName()]; pa != pb {
+		return pa > pb
+	}
+	if p.turns != nil {
+		if ta, tb := p.turns[a.GetCommonCols()], p.turns[b.GetCommonCols()]; ta != tb {
+			return ta < tb
+		}
+	}
+	return a.GetCommonCols().TsAdded.Int64 < b.GetCommonCols().TsAdded.Int64
+}
+
+// Returns the JSON representation of task used by EncodeTask and EncodeTasks, an object with at
+// most one key, the name of the task type, mapped to the task.
+func taskJsonRepr(task task_common.Task) (map[string]task_common.Task, error) {
+	ret := map[string]task_common.Task{}
+	if task == nil {
+		return ret, nil
+	}
+	taskType, err := task_types.ForName(task.GetTaskName())
+	if err != nil {
+		return nil, err
+	}
+	if reflect.TypeOf(taskType.Prototype()) != reflect.TypeOf(task) {
+		return nil, fmt.Errorf("Task type %s is registered for %T, not %T", taskType.Name(), taskType.Prototype(), task)
+	}
+	ret[taskType.Name()] = task
+	return ret, nil
+}
+
+// Returns the task in the JSON representation written by taskJsonRepr, or nil if the
+// representation has no task.
+func decodeTaskJsonRepr(pending map[string]*json.RawMessage) (task_common.Task, error) {
+	for name, raw := range pending {
+		if raw == nil {
+			continue
+		}
+		taskType, err := task_types.ForName(name)
+		if err != nil {
+			return nil, err
+		}
+		task := taskType.Prototype()
+		if err := json.Unmarshal(*raw, task); err != nil {
+			return nil, err
+		}
+		return task, nil
+	}
+	return nil, nil
 }
 
-// Writes JSON representation of oldestTask to taskJson. Returns an error if oldestTask's type is
-// unknown, if there was an error encoding to JSON, or there is an error writing to taskJson. Does
-// not close taskJson.
+// Writes JSON representation of oldestTask to taskJson. The JSON is an object with at most one key,
+// the name of the task type, mapped to the task. Returns an error if oldestTask's type is not
+// registered, if there was an error encoding to JSON, or there is an error writing to taskJson.
+// Does not close taskJson.
 func EncodeTask(taskJson io.Writer, oldestTask task_common.Task) error {
-	oldestTaskJsonRepr := oldestPendingTask{}
-	switch task := oldestTask.(type) {
-	case nil:
-		// No fields set.
-	case *chromium_analysis.DBTask:
-		oldestTaskJsonRepr.ChromiumAnalysis = task
-	case *chromium_perf.DBTask:
-		oldestTaskJsonRepr.ChromiumPerf = task
-	case *capture_skps.DBTask:
-		oldestTaskJsonRepr.CaptureSkps = task
-	case *lua_scripts.DBTask:
-		oldestTaskJsonRepr.LuaScript = task
-	case *chromium_builds.DBTask:
-		oldestTaskJsonRepr.ChromiumBuild = task
-	case *admin_tasks.RecreatePageSetsDBTask:
-		oldestTaskJsonRepr.RecreatePageSets = task
-	case *admin_tasks.RecreateWebpageArchivesDBTask:
-		oldestTaskJsonRepr.RecreateWebpageArchives = task
-	default:
-		return fmt.Errorf("Missing case for %T", oldestTask)
+	oldestTaskJsonRepr, err := taskJsonRepr(oldestTask)
+	if err != nil {
+		return err
 	}
 	return json.NewEncoder(taskJson).Encode(oldestTaskJsonRepr)
 }
 
 // Reads JSON response from ctfeutil.GET_OLDEST_PENDING_TASK_URI and returns either the Task decoded
 // from the response or nil if there are no pending tasks. Returns an error if there is a problem
-// decoding the JSON. Does not close taskJson.
+// decoding the JSON or if the task type is not registered. Does not close taskJson.
 func DecodeTask(taskJson io.Reader) (task_common.Task, error) {
-	pending := oldestPendingTask{}
+	pending := map[string]*json.RawMessage{}
 	if err := json.NewDecoder(taskJson).Decode(&pending); err != nil {
 		return nil, err
 	}
-	switch {
-	case pending.ChromiumAnalysis != nil:
-		return pending.ChromiumAnalysis, nil
-	case pending.ChromiumPerf != nil:
-		return pending.ChromiumPerf, nil
-	case pending.CaptureSkps != nil:
-		return pending.CaptureSkps, nil
-	case pending.LuaScript != nil:
-		return pending.LuaScript, nil
-	case pending.ChromiumBuild != nil:
-		return pending.ChromiumBuild, nil
-	case pending.RecreatePageSets != nil:
-		return pending.RecreatePageSets, nil
-	case pending.RecreateWebpageArchives != nil:
-		return pending.RecreateWebpageArchives, nil
-	default:
-		return nil, nil
+	return decodeTaskJsonRepr(pending)
+}
+
+// Writes JSON representation of tasks to tasksJson, a list in the same order as tasks with an
+// element as written by EncodeTask for each task. Does not close tasksJson.
+func EncodeTasks(tasksJson io.Writer, tasks []task_common.Task) error {
+	tasksJsonRepr := make([]map[string]task_common.Task, 0, len(tasks))
+	for _, task := range tasks {
+		repr, err := taskJsonRepr(task)
+		if err != nil {
+			return err
+		}
+		tasksJsonRepr = append(tasksJsonRepr, repr)
+	}
+	return json.NewEncoder(tasksJson).Encode(tasksJsonRepr)
+}
+
+// Reads JSON response from ctfeutil.GET_PENDING_TASKS_URI and returns the Tasks decoded from the
+// response, in the same order. Does not close tasksJson.
+func DecodeTasks(tasksJson io.Reader) ([]task_common.Task, error) {
+	pending := []map[string]*json.RawMessage{}
+	if err := json.NewDecoder(tasksJson).Decode(&pending); err != nil {
+		return nil, err
+	}
+	tasks := make([]task_common.Task, 0, len(pending))
+	for _, repr := range pending {
+		task, err := decodeTaskJsonRepr(repr)
+		if err != nil {
+			return nil, err
+		}
+		if task != nil {
+			tasks = append(tasks, task)
+		}
 	}
+	return tasks, nil
 }
 
 func getOldestPendingTaskHandler(w http.ResponseWriter, r *http.Request) {
@@ -154,6 +237,21 @@ func getOldestPendingTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func getPendingTasksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pendingTasks, err := GetPendingTasks()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to get pending tasks")
+		return
+	}
+
+	if err := EncodeTasks(w, pendingTasks); err != nil {
+		httputils.ReportError(w, r, err, "Failed to encode JSON for pending tasks")
+		return
+	}
+}
+
 // GetPendingTaskCount returns the total number of pending tasks of all types. On error, the first
 // return value will be -1 and the second return value will be non-nil.
 func GetPendingTaskCount() (int64, error) {
@@ -184,4 +282,5 @@ func AddHandlers(r *mux.Router) {
 	// Task Queue handlers.
 	r.HandleFunc("/"+ctfeutil.PENDING_TASKS_URI, pendingTasksView).Methods("GET")
 	r.HandleFunc("/"+ctfeutil.GET_OLDEST_PENDING_TASK_URI, getOldestPendingTaskHandler).Methods("GET")
+	r.HandleFunc("/"+ctfeutil.GET_PENDING_TASKS_URI, getPendingTasksHandler).Methods("GET")
 }
@@ -9,6 +9,7 @@ import (
 	"go.skia.org/infra/ct/go/ctfe/capture_skps"
 	"go.skia.org/infra/ct/go/ctfe/chromium_builds"
 	"go.skia.org/infra/ct/go/ctfe/chromium_perf"
+	"go.skia.org/infra/ct/go/ctfe/generic_tasks"
 	"go.skia.org/infra/ct/go/ctfe/lua_scripts"
 	"go.skia.org/infra/ct/go/ctfe/task_common"
 	"go.skia.org/infra/go/testutils"
@@ -86,4 +87,86 @@ func TestEncodeTaskDecodeTaskRoundTrip(t *testing.T) {
 		ChromiumRev: "c14d891d44f0afff64e56ed7c9702df1d807b1ee",
 		SkiaRev:     "586101c79b0490b50623e76c71a5fd67d8d92b08",
 	})
+	test(&generic_tasks.DBTask{
+		CommonCols:  common,
+		TaskType:    "SomeTask",
+		Parameters:  `{"benchmark":"rasterize_and_record_micro"}`,
+		Description: "description",
+		Results:     sql.NullString{String: "https://example.com/results", Valid: true},
+	})
+}
+
+func TestEncodeTaskDecodeTaskNoTask(t *testing.T) {
+	testutils.SmallTest(t)
+	buf := bytes.Buffer{}
+	assert.NoError(t, EncodeTask(&buf, nil))
+	task, err := DecodeTask(&buf)
+	assert.NoError(t, err)
+	expect.Nil(t, task)
+}
+
+func TestDecodeTaskUnknownType(t *testing.T) {
+	testutils.SmallTest(t)
+	_, err := DecodeTask(bytes.NewBufferString(`{"NoSuchTask": {"Id": 42}}`))
+	expect.Error(t, err)
+}
+
+func TestEncodeTasksDecodeTasksRoundTrip(t *testing.T) {
+	testutils.SmallTest(t)
+	tasks := []task_common.Task{
+		&chromium_builds.DBTask{
+			CommonCols:  task_common.CommonCols{Id: 1, Username: "a@chromium.org"},
+			ChromiumRev: "c14d891d44f0afff64e56ed7c9702df1d807b1ee",
+		},
+		&admin_tasks.RecreatePageSetsDBTask{
+			CommonCols: task_common.CommonCols{Id: 2, Username: "b@chromium.org"},
+			PageSets:   "All",
+		},
+	}
+	buf := bytes.Buffer{}
+	assert.NoError(t, EncodeTasks(&buf, tasks))
+	newTasks, err := DecodeTasks(&buf)
+	assert.NoError(t, err)
+	expect.Equal(t, tasks, newTasks)
+
+	buf.Reset()
+	assert.NoError(t, EncodeTasks(&buf, []task_common.Task{}))
+	newTasks, err = DecodeTasks(&buf)
+	assert.NoError(t, err)
+	expect.Empty(t, newTasks)
+}
+
+func TestOrderPendingTasks(t *testing.T) {
+	testutils.SmallTest(t)
+	task := func(id int64, username string, tsAdded int64) *capture_skps.DBTask {
+		return &capture_skps.DBTask{
+			CommonCols: task_common.CommonCols{
+				Id:       id,
+				Username: username,
+				TsAdded:  sql.NullInt64{Int64: tsAdded, Valid: true},
+			},
+		}
+	}
+	build := &chromium_builds.DBTask{
+		CommonCols: task_common.CommonCols{
+			Id:       7,
+			Username: "c@chromium.org",
+			TsAdded:  sql.NullInt64{Int64: 20160101000009, Valid: true},
+		},
+	}
+	tasks := []task_common.Task{
+		task(3, "a@chromium.org", 20160101000003),
+		build,
+		task(1, "a@chromium.org", 20160101000001),
+		task(5, "b@chromium.org", 20160101000005),
+		task(2, "a@chromium.org", 20160101000002),
+		task(6, "b@chromium.org", 20160101000006),
+	}
+	OrderPendingTasks(tasks, map[string]int{build.GetTaskName(): 1})
+	ids := []int64{}
+	for _, task := range tasks {
+		ids = append(ids, task.GetCommonCols().Id)
+	}
+	// The build comes first because of its priority, then users take turns.
+	expect.Equal(t, []int64{7, 1, 5, 2, 6, 3}, ids)
 }
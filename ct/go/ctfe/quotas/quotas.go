@@ -0,0 +1,415 @@
+/*
+	Per-user quotas for CTFE tasks.
+
+	Quotas limit the number of pending tasks of a user, the number of pages a
+	single task runs on and the run-hours a user's tasks take per week. They
+	are configured for all task types together and per task type. The
+	run-hours of a task are the wall-clock hours between its start and its
+	completion, regardless of the number of machines it runs on, because
+	tasks occupy the whole cluster while they run. The run-hours of tasks that
+	have not completed yet are estimated from the recently completed tasks of
+	the same type.
+
+	Admins can add tasks which exceed quotas by setting override_quotas.
+*/
+
+package quotas
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"go.skia.org/infra/ct/go/ctfe/task_common"
+	"go.skia.org/infra/ct/go/ctfe/task_types"
+	ctfeutil "go.skia.org/infra/ct/go/ctfe/util"
+	ctutil "go.skia.org/infra/ct/go/util"
+	"go.skia.org/infra/go/httputils"
+	skutil "go.skia.org/infra/go/util"
+)
+
+const (
+	// The period run-hours are limited for.
+	USAGE_PERIOD = 7 * 24 * time.Hour
+
+	// The number of recently completed tasks of a type that run-hours are
+	// estimated from.
+	ESTIMATE_HISTORY_SIZE = 20
+
+	// The maximum number of tasks of a type that are considered when computing
+	// usage.
+	MAX_USAGE_TASKS = 1000
+)
+
+// Limits are the quotas of a user. Zero values are unlimited.
+type Limits struct {
+	// The maximum number of tasks that are not completed yet.
+	MaxPendingTasks int `json:"max_pending_tasks"`
+	// The maximum number of pages a single task runs on.
+	MaxPages int `json:"max_pages"`
+	// The maximum run-hours of the tasks that were pending, running or
+	// completed during the last USAGE_PERIOD, including the added task.
+	MaxRunHoursPerWeek float64 `json:"max_run_hours_per_week"`
+}
+
+// Config holds all quotas. Example:
+//
+//	{
+//	  "default": {"max_pending_tasks": 5, "max_run_hours_per_week": 100},
+//	  "users": {"someone@google.com": {"max_pending_tasks": 20}},
+//	  "task_types": {"ChromiumPerf": {"max_pending_tasks": 2, "max_pages": 10000}}
+//	}
+type Config struct {
+	// The limits of each user's tasks of all types together.
+	Default Limits `json:"default"`
+	// Replaces Default for the given users.
+	Users map[string]Limits `json:"users"`
+	// The limits of each user's tasks of the given type.
+	TaskTypes map[string]Limits `json:"task_types"`
+}
+
+// UserLimits returns the limits of the given user's tasks of all types together.
+func (c *Config) UserLimits(username string) Limits {
+	if limits, ok := c.Users[username]; ok {
+		return limits
+	}
+	return c.Default
+}
+
+// ReadConfig reads a JSON Config from the given file.
+func ReadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open quotas config %s: %s", path, err)
+	}
+	defer skutil.Close(f)
+	config := &Config{}
+	if err := json.NewDecoder(f).Decode(config); err != nil {
+		return nil, fmt.Errorf("Could not decode quotas config %s: %s", path, err)
+	}
+	return config, nil
+}
+
+var (
+	// The current config. Nil if quotas are disabled.
+	config    *Config
+	configMtx = sync.RWMutex{}
+)
+
+// Init enables quotas with the config in the given file and makes
+// task_common.AddTaskHandler enforce them. Quotas stay disabled if path is
+// empty.
+func Init(path string) error {
+	if path == "" {
+		return nil
+	}
+	c, err := ReadConfig(path)
+	if err != nil {
+		return err
+	}
+	configMtx.Lock()
+	defer configMtx.Unlock()
+	config = c
+	task_common.CheckQuotasHook = CheckQuotas
+	return nil
+}
+
+func getConfig() *Config {
+	configMtx.RLock()
+	defer configMtx.RUnlock()
+	return config
+}
+
+// Usage describes the tasks of a user.
+type Usage struct {
+	// The number of tasks that are not completed yet.
+	PendingTasks int `json:"pending_tasks"`
+	// The run-hours of the tasks that were pending, running or completed
+	// during the last USAGE_PERIOD.
+	RunHours float64 `json:"run_hours"`
+}
+
+// UserUsage describes the tasks of a user along with the user's limits.
+type UserUsage struct {
+	Username string `json:"username"`
+	// The usage of tasks of all types together.
+	Total Usage `json:"total"`
+	// The usage of tasks of each type, keyed by task type name.
+	TaskTypes map[string]*Usage `json:"task_types"`
+	// The limits of tasks of all types together.
+	Limits Limits `json:"limits"`
+	// The limits of tasks of each type, keyed by task type name.
+	TaskTypeLimits map[string]Limits `json:"task_type_limits"`
+}
+
+func newUserUsage(username string, c *Config) *UserUsage {
+	ret := &UserUsage{
+		Username:       username,
+		TaskTypes:      map[string]*Usage{},
+		TaskTypeLimits: map[string]Limits{},
+	}
+	if c != nil {
+		ret.Limits = c.UserLimits(username)
+		for name, limits := range c.TaskTypes {
+			ret.TaskTypeLimits[name] = limits
+		}
+	}
+	return ret
+}
+
+// add adds the given task of the given type to the usage.
+func (u *UserUsage) add(taskType string, task task_common.Task, e estimate, now time.Time) {
+	if _, ok := u.TaskTypes[taskType]; !ok {
+		u.TaskTypes[taskType] = &Usage{}
+	}
+	hours := taskHours(task, e, now)
+	for _, usage := range []*Usage{&u.Total, u.TaskTypes[taskType]} {
+		if !task.GetCommonCols().TsCompleted.Valid {
+			usage.PendingTasks++
+		}
+		usage.RunHours += hours
+	}
+}
+
+// estimate estimates the run-hours of tasks of one type.
+type estimate struct {
+	// The average run-hours per page of tasks that run on pages.
+	HoursPerPage float64
+	// The average run-hours per task.
+	HoursPerTask float64
+}
+
+// newEstimate returns the estimate for tasks like the given completed tasks.
+func newEstimate(completed []task_common.Task) estimate {
+	var e estimate
+	numTasks, totalHours := 0, 0.0
+	pageTasksHours, totalPages := 0.0, 0
+	for _, task := range completed {
+		hours, ok := runHours(task)
+		if !ok {
+			continue
+		}
+		numTasks++
+		totalHours += hours
+		if pages := numPages(task); pages > 0 {
+			pageTasksHours += hours
+			totalPages += pages
+		}
+	}
+	if numTasks > 0 {
+		e.HoursPerTask = totalHours / float64(numTasks)
+	}
+	if totalPages > 0 {
+		e.HoursPerPage = pageTasksHours / float64(totalPages)
+	}
+	return e
+}
+
+// hours returns the estimated run-hours of a task running on the given
+// number of pages.
+func (e estimate) hours(numPages int) float64 {
+	if numPages > 0 && e.HoursPerPage > 0 {
+		return e.HoursPerPage * float64(numPages)
+	}
+	return e.HoursPerTask
+}
+
+// runHours returns the hours between TsStarted and TsCompleted of a completed
+// task. Returns false if the task did not start or complete.
+func runHours(task task_common.Task) (float64, bool) {
+	cols := task.GetCommonCols()
+	if !cols.TsStarted.Valid || !cols.TsCompleted.Valid {
+		return 0, false
+	}
+	started := getTime(cols.TsStarted.Int64)
+	completed := getTime(cols.TsCompleted.Int64)
+	return math.Max(0, completed.Sub(started).Hours()), true
+}
+
+// taskHours returns the run-hours of the given task: the run time of
+// completed tasks, the estimate of pending tasks and the larger of the run time
+// so far and the estimate of running tasks.
+func taskHours(task task_common.Task, e estimate, now time.Time) float64 {
+	cols := task.GetCommonCols()
+	if cols.TsCompleted.Valid {
+		// Tasks which completed without starting did not run.
+		hours, _ := runHours(task)
+		return hours
+	}
+	estimated := e.hours(numPages(task))
+	if cols.TsStarted.Valid {
+		return math.Max(estimated, now.Sub(getTime(cols.TsStarted.Int64)).Hours())
+	}
+	return estimated
+}
+
+// numPages returns the number of pages the given task runs on, 0 if it does
+// not run on pages.
+func numPages(task task_common.Task) int {
+	if vars, ok := task.GetPopulatedAddTaskVars().(task_common.PagesAddTaskVars); ok {
+		return vars.GetNumPages()
+	}
+	return 0
+}
+
+func getTime(ts int64) time.Time {
+	return ctutil.GetTimeFromTs(strconv.FormatInt(ts, 10))
+}
+
+// checkLimits returns an error if adding a task running on numPages pages and
+// taking the given run-hours exceeds the limits. The error message names
+// the tasks the limits apply to with scope.
+func checkLimits(limits Limits, usage Usage, numPages int, hours float64, scope string) error {
+	if limits.MaxPendingTasks > 0 && usage.PendingTasks >= limits.MaxPendingTasks {
+		return fmt.Errorf("%s: %d tasks are already pending, the limit is %d", scope, usage.PendingTasks, limits.MaxPendingTasks)
+	}
+	if limits.MaxPages > 0 && numPages > limits.MaxPages {
+		return fmt.Errorf("%s: the task runs on %d pages, the limit is %d", scope, numPages, limits.MaxPages)
+	}
+	if limits.MaxRunHoursPerWeek > 0 && usage.RunHours+hours > limits.MaxRunHoursPerWeek {
+		return fmt.Errorf("%s: tasks of the last week take %.1f run-hours and the task is estimated to take %.1f, the limit is %.1f per week", scope, usage.RunHours, hours, limits.MaxRunHoursPerWeek)
+	}
+	return nil
+}
+
+// taskTypeName returns the name of the task type whose tasks are added with
+// AddTaskVars of the same type as the given AddTaskVars.
+func taskTypeName(vars task_common.AddTaskVars) (string, error) {
+	for _, t := range task_types.TaskTypes() {
+		if reflect.TypeOf(t.Prototype().GetPopulatedAddTaskVars()) == reflect.TypeOf(vars) {
+			return t.Name(), nil
+		}
+	}
+	return "", fmt.Errorf("Unknown task type of %T", vars)
+}
+
+// CheckQuotas returns an error describing the exceeded quota if the given task
+// must not be added. Implements task_common.CheckQuotasHook.
+func CheckQuotas(vars task_common.AddTaskVars) error {
+	c := getConfig()
+	if c == nil {
+		return nil
+	}
+	username := vars.GetAddTaskCommonVars().Username
+	name, err := taskTypeName(vars)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	usages, estimates, err := getUsages(username, now)
+	if err != nil {
+		return fmt.Errorf("Could not compute usage of %s: %s", username, err)
+	}
+	usage, ok := usages[username]
+	if !ok {
+		usage = newUserUsage(username, c)
+	}
+	pages := 0
+	if pagesVars, ok := vars.(task_common.PagesAddTaskVars); ok {
+		pages = pagesVars.GetNumPages()
+	}
+	hours := estimates[name].hours(pages)
+	if err := checkLimits(c.UserLimits(username), usage.Total, pages, hours, fmt.Sprintf("All tasks of %s", username)); err != nil {
+		return err
+	}
+	if limits, ok := c.TaskTypes[name]; ok {
+		typeUsage := Usage{}
+		if u, ok := usage.TaskTypes[name]; ok {
+			typeUsage = *u
+		}
+		if err := checkLimits(limits, typeUsage, pages, hours, fmt.Sprintf("%s tasks of %s", name, username)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getUsages returns the usage of the given user, or of all users if username
+// is empty, keyed by username. Also returns the estimates of each task type,
+// keyed by task type name.
+func getUsages(username string, now time.Time) (map[string]*UserUsage, map[string]estimate, error) {
+	c := getConfig()
+	usages := map[string]*UserUsage{}
+	estimates := map[string]estimate{}
+	activeSince, err := strconv.ParseInt(now.Add(-USAGE_PERIOD).Format(ctutil.TS_FORMAT), 10, 64)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, prototype := range task_types.Prototypes() {
+		name := prototype.GetTaskName()
+		completed, err := selectTasks(prototype, task_common.QueryParams{
+			SuccessfulOnly: true,
+			Size:           ESTIMATE_HISTORY_SIZE,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		estimates[name] = newEstimate(completed)
+
+		active, err := selectTasks(prototype, task_common.QueryParams{
+			Username:    username,
+			ActiveSince: activeSince,
+			Size:        MAX_USAGE_TASKS,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, task := range active {
+			taskUsername := task.GetCommonCols().Username
+			if _, ok := usages[taskUsername]; !ok {
+				usages[taskUsername] = newUserUsage(taskUsername, c)
+			}
+			usages[taskUsername].add(name, task, estimates[name], now)
+		}
+	}
+	return usages, estimates, nil
+}
+
+func selectTasks(prototype task_common.Task, params task_common.QueryParams) ([]task_common.Task, error) {
+	query, args := task_common.DBTaskQuery(prototype, params)
+	data, err := prototype.Select(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query %s tasks: %s", prototype.GetTaskName(), err)
+	}
+	return task_common.AsTaskSlice(data), nil
+}
+
+// getUsageHandler returns the usage and limits of all users with tasks during
+// the last USAGE_PERIOD, or only of the user given by the username parameter,
+// sorted by username.
+func getUsageHandler(w http.ResponseWriter, r *http.Request) {
+	usages, _, err := getUsages(r.FormValue("username"), time.Now().UTC())
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to compute usage")
+		return
+	}
+	ret := make([]*UserUsage, 0, len(usages))
+	for _, usage := range usages {
+		ret = append(ret, usage)
+	}
+	sort.Sort(byUsername(ret))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ret); err != nil {
+		httputils.ReportError(w, r, err, "Failed to encode JSON")
+		return
+	}
+}
+
+type byUsername []*UserUsage
+
+func (p byUsername) Len() int           { return len(p) }
+func (p byUsername) Less(i, j int) bool { return p[i].Username < p[j].Username }
+func (p byUsername) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func AddHandlers(r *mux.Router) {
+	r.HandleFunc("/"+ctfeutil.QUOTA_USAGE_URI, getUsageHandler).Methods("GET")
+}
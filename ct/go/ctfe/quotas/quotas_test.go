@@ -0,0 +1,153 @@
+package quotas
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"go.skia.org/infra/ct/go/ctfe/chromium_builds"
+	"go.skia.org/infra/ct/go/ctfe/chromium_perf"
+	"go.skia.org/infra/ct/go/ctfe/task_common"
+	ctutil "go.skia.org/infra/ct/go/util"
+	"go.skia.org/infra/go/testutils"
+	skutil "go.skia.org/infra/go/util"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+)
+
+// perfTask returns a ChromiumPerf task on the given page set. TsStarted and
+// TsCompleted are set if non-zero.
+func perfTask(pageSets string, started, completed int64) *chromium_perf.DBTask {
+	task := &chromium_perf.DBTask{PageSets: pageSets}
+	task.Username = "nobody@chromium.org"
+	if started != 0 {
+		task.TsStarted = sql.NullInt64{Int64: started, Valid: true}
+	}
+	if completed != 0 {
+		task.TsCompleted = sql.NullInt64{Int64: completed, Valid: true}
+	}
+	return task
+}
+
+func TestReadConfig(t *testing.T) {
+	testutils.SmallTest(t)
+	f, err := ioutil.TempFile("", "quotas_test")
+	assert.NoError(t, err)
+	defer skutil.Remove(f.Name())
+	_, err = f.WriteString(`{
+		"default": {"max_pending_tasks": 5, "max_run_hours_per_week": 100},
+		"users": {"someone@google.com": {"max_pending_tasks": 20}},
+		"task_types": {"ChromiumPerf": {"max_pages": 10000}}
+	}`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	c, err := ReadConfig(f.Name())
+	assert.NoError(t, err)
+	expect.Equal(t, Limits{MaxPendingTasks: 5, MaxRunHoursPerWeek: 100}, c.UserLimits("nobody@chromium.org"))
+	expect.Equal(t, Limits{MaxPendingTasks: 20}, c.UserLimits("someone@google.com"))
+	expect.Equal(t, Limits{MaxPages: 10000}, c.TaskTypes["ChromiumPerf"])
+
+	_, err = ReadConfig(f.Name() + ".missing")
+	expect.Error(t, err)
+	_, err = ReadConfig(os.DevNull)
+	expect.Error(t, err)
+}
+
+func TestEstimate(t *testing.T) {
+	testutils.SmallTest(t)
+	e := newEstimate([]task_common.Task{
+		// 2 hours for 10k pages.
+		perfTask(ctutil.PAGESET_TYPE_10k, 20160101100000, 20160101120000),
+		// 20 hours for 100k pages.
+		perfTask(ctutil.PAGESET_TYPE_100k, 20160102000000, 20160102200000),
+		// Ignored because it did not start.
+		perfTask(ctutil.PAGESET_TYPE_100k, 0, 20160103000000),
+	})
+	expect.InDelta(t, 11, e.HoursPerTask, 1e-9)
+	expect.InDelta(t, 22.0/110000, e.HoursPerPage, 1e-12)
+	expect.InDelta(t, 2, e.hours(10000), 1e-9)
+	// Tasks without pages use the average per task.
+	expect.InDelta(t, 11, e.hours(0), 1e-9)
+
+	expect.Equal(t, estimate{}, newEstimate([]task_common.Task{}))
+	e = newEstimate([]task_common.Task{&chromium_builds.DBTask{
+		CommonCols: task_common.CommonCols{
+			TsStarted:   sql.NullInt64{Int64: 20160101100000, Valid: true},
+			TsCompleted: sql.NullInt64{Int64: 20160101103000, Valid: true},
+		},
+	}})
+	expect.InDelta(t, 0.5, e.HoursPerTask, 1e-9)
+	expect.Equal(t, 0.0, e.HoursPerPage)
+	expect.InDelta(t, 0.5, e.hours(1000), 1e-9)
+}
+
+func TestTaskHours(t *testing.T) {
+	testutils.SmallTest(t)
+	e := estimate{HoursPerPage: 0.001, HoursPerTask: 3}
+	now := ctutil.GetTimeFromTs("20160101150000")
+	// Completed.
+	expect.InDelta(t, 2, taskHours(perfTask(ctutil.PAGESET_TYPE_10k, 20160101100000, 20160101120000), e, now), 1e-9)
+	// Completed without starting.
+	expect.Equal(t, 0.0, taskHours(perfTask(ctutil.PAGESET_TYPE_10k, 0, 20160101120000), e, now))
+	// Pending.
+	expect.InDelta(t, 10, taskHours(perfTask(ctutil.PAGESET_TYPE_10k, 0, 0), e, now), 1e-9)
+	// Running for less and for longer than estimated.
+	expect.InDelta(t, 10, taskHours(perfTask(ctutil.PAGESET_TYPE_10k, 20160101140000, 0), e, now), 1e-9)
+	expect.InDelta(t, 15, taskHours(perfTask(ctutil.PAGESET_TYPE_10k, 20160101000000, 0), e, now), 1e-9)
+}
+
+func TestUserUsage(t *testing.T) {
+	testutils.SmallTest(t)
+	c := &Config{TaskTypes: map[string]Limits{"ChromiumPerf": {MaxPages: 10}}}
+	u := newUserUsage("nobody@chromium.org", c)
+	expect.Equal(t, Limits{MaxPages: 10}, u.TaskTypeLimits["ChromiumPerf"])
+	e := estimate{HoursPerTask: 3}
+	now := time.Now()
+	u.add("ChromiumPerf", perfTask("", 0, 0), e, now)
+	u.add("ChromiumPerf", perfTask("", 20160101100000, 20160101120000), e, now)
+	u.add("ChromiumBuild", &chromium_builds.DBTask{}, e, now)
+	expect.Equal(t, Usage{PendingTasks: 2, RunHours: 8}, u.Total)
+	expect.Equal(t, Usage{PendingTasks: 1, RunHours: 5}, *u.TaskTypes["ChromiumPerf"])
+	expect.Equal(t, Usage{PendingTasks: 1, RunHours: 3}, *u.TaskTypes["ChromiumBuild"])
+}
+
+func TestCheckLimits(t *testing.T) {
+	testutils.SmallTest(t)
+	usage := Usage{PendingTasks: 2, RunHours: 90}
+	expect.NoError(t, checkLimits(Limits{}, usage, 1000000, 1000, "scope"))
+	expect.NoError(t, checkLimits(Limits{MaxPendingTasks: 3, MaxPages: 10000, MaxRunHoursPerWeek: 100}, usage, 10000, 10, "scope"))
+
+	err := checkLimits(Limits{MaxPendingTasks: 2}, usage, 0, 0, "scope")
+	assert.Error(t, err)
+	expect.Contains(t, err.Error(), "scope: 2 tasks are already pending, the limit is 2")
+	err = checkLimits(Limits{MaxPages: 1000}, usage, 10000, 0, "scope")
+	assert.Error(t, err)
+	expect.Contains(t, err.Error(), "10000 pages")
+	err = checkLimits(Limits{MaxRunHoursPerWeek: 100}, usage, 0, 10.5, "scope")
+	assert.Error(t, err)
+	expect.Contains(t, err.Error(), "90.0 run-hours")
+}
+
+func TestTaskTypeName(t *testing.T) {
+	testutils.SmallTest(t)
+	name, err := taskTypeName(&chromium_perf.AddTaskVars{})
+	assert.NoError(t, err)
+	expect.Equal(t, "ChromiumPerf", name)
+	name, err = taskTypeName(&chromium_builds.AddTaskVars{})
+	assert.NoError(t, err)
+	expect.Equal(t, "ChromiumBuild", name)
+	_, err = taskTypeName(&unknownAddTaskVars{})
+	expect.Error(t, err)
+}
+
+type unknownAddTaskVars struct {
+	task_common.AddTaskCommonVars
+}
+
+func (task *unknownAddTaskVars) GetInsertQueryAndBinds() (string, []interface{}, error) {
+	return "", nil, nil
+}
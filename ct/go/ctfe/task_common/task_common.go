@@ -15,6 +15,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -70,6 +71,74 @@ type Task interface {
 	// Returns the results link for this task if it completed successfully and if
 	// the task supports results links.
 	GetResultsLink() string
+	// Returns the master script that the poller runs to execute this task.
+	GetMasterScript() (*MasterScript, error)
+}
+
+// MasterScript is the command line of a master script along with the files it
+// reads.
+type MasterScript struct {
+	// Name of the master script.
+	Name string
+	// The args specific to the task. The poller adds the args common to all
+	// master scripts, i.e. --emails, --gae_task_id, --run_id, --log_dir,
+	// --log_id and --local.
+	Args []string
+	// Maps a file suffix to the contents of the file. The poller writes each
+	// file to <os.TempDir()>/<run_id><suffix> before running the master script
+	// and removes it afterwards.
+	Files map[string]string
+}
+
+// TaskType describes a type of task. Task packages register their TaskTypes
+// with RegisterTaskType from an init function. The task_types package imports
+// all task packages, which makes the registered types known to CTFE and to the
+// poller.
+type TaskType struct {
+	// Returns a new, empty Task of this type.
+	Prototype func() Task
+	// The URI that returns tasks of this type.
+	GetURI string
+	// The URI that deletes a task of this type.
+	DeleteURI string
+	// Pending tasks of types with a higher priority are picked up before those
+	// of types with a lower priority, regardless of when they were added.
+	Priority int
+}
+
+// Name returns the name of the task type, which is the name returned by
+// GetTaskName of its Tasks.
+func (t TaskType) Name() string {
+	return t.Prototype().GetTaskName()
+}
+
+var (
+	// All registered task types, in the order they were registered.
+	taskTypes = []TaskType{}
+	// Mutex that controls access to taskTypes.
+	taskTypesMtx = sync.Mutex{}
+)
+
+// RegisterTaskType adds a task type to the registry. Panics if a task type of
+// the same name is already registered.
+func RegisterTaskType(t TaskType) {
+	taskTypesMtx.Lock()
+	defer taskTypesMtx.Unlock()
+	for _, existing := range taskTypes {
+		if existing.Name() == t.Name() {
+			panic(fmt.Sprintf("Task type %s is registered twice", t.Name()))
+		}
+	}
+	taskTypes = append(taskTypes, t)
+}
+
+// RegisteredTaskTypes returns all registered task types.
+func RegisteredTaskTypes() []TaskType {
+	taskTypesMtx.Lock()
+	defer taskTypesMtx.Unlock()
+	ret := make([]TaskType, len(taskTypes))
+	copy(ret, taskTypes)
+	return ret
 }
 
 func (dbrow *CommonCols) GetCommonCols() *CommonCols {
@@ -92,6 +161,9 @@ type AddTaskCommonVars struct {
 	Username        string
 	TsAdded         string
 	RepeatAfterDays string `json:"repeat_after_days"`
+	// If true, the task is added even if it exceeds the user's quotas. Only
+	// admins may override quotas.
+	OverrideQuotas bool `json:"override_quotas"`
 }
 
 type AddTaskVars interface {
@@ -108,21 +180,60 @@ func (vars *AddTaskCommonVars) IsAdminTask() bool {
 	return false
 }
 
+// PagesAddTaskVars is implemented by the AddTaskVars of tasks that run on
+// pages, which allows quotas to limit the size of tasks.
+type PagesAddTaskVars interface {
+	// Returns the number of pages the task runs on.
+	GetNumPages() int
+}
+
+// Called by AddTaskHandler before adding a task that does not override quotas.
+// AddTaskHandler holds the lock of the task's user until the task is added.
+// Returns an error describing the exceeded quota if the task must not be
+// added. Set by the quotas package.
+var CheckQuotasHook = func(task AddTaskVars) error {
+	return nil
+}
+
+var (
+	// Mutexes that serialize checking the quotas of a user and adding their
+	// task, so that concurrent requests cannot together exceed the quotas.
+	// Keyed by username.
+	userMutexes    = map[string]*sync.Mutex{}
+	userMutexesMtx = sync.Mutex{}
+)
+
+// lockUser locks the mutex of the given user and returns a func that unlocks
+// it.
+func lockUser(username string) func() {
+	userMutexesMtx.Lock()
+	mtx, ok := userMutexes[username]
+	if !ok {
+		mtx = &sync.Mutex{}
+		userMutexes[username] = mtx
+	}
+	userMutexesMtx.Unlock()
+	mtx.Lock()
+	return mtx.Unlock
+}
+
 func AddTaskHandler(w http.ResponseWriter, r *http.Request, task AddTaskVars) {
 	if !ctfeutil.UserHasEditRights(r) {
 		httputils.ReportError(w, r, nil, "Please login with google or chromium account to add tasks")
 		return
 	}
-	if task.IsAdminTask() && !ctfeutil.UserHasAdminRights(r) {
-		httputils.ReportError(w, r, nil, "Must be admin to add admin tasks; contact rmistry@")
-		return
-	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
 		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to add %T task", task))
 		return
 	}
 	defer skutil.Close(r.Body)
+	// Checked after decoding the request since some tasks, e.g. generic tasks,
+	// only know whether they are admin tasks once their type is known.
+	if task.IsAdminTask() && !ctfeutil.UserHasAdminRights(r) {
+		httputils.ReportError(w, r, nil, "Must be admin to add admin tasks; contact rmistry@")
+		return
+	}
 
 	task.GetAddTaskCommonVars().Username = login.LoggedInAs(r)
 	task.GetAddTaskCommonVars().TsAdded = ctutil.GetCurrentTs()
@@ -130,6 +241,19 @@ func AddTaskHandler(w http.ResponseWriter, r *http.Request, task AddTaskVars) {
 		httputils.ReportError(w, r, nil, "Username is too long, limit 255 bytes")
 		return
 	}
+	// The task must be inserted before another task of the user is checked
+	// against the quotas.
+	defer lockUser(task.GetAddTaskCommonVars().Username)()
+	if task.GetAddTaskCommonVars().OverrideQuotas {
+		if !ctfeutil.UserHasAdminRights(r) {
+			httputils.ReportError(w, r, nil, "Must be admin to override quotas")
+			return
+		}
+		glog.Infof("%s overrides quotas for %T task", task.GetAddTaskCommonVars().Username, task)
+	} else if err := CheckQuotasHook(task); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Quota exceeded: %s", err))
+		return
+	}
 
 	if _, err := AddTask(task); err != nil {
 		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to insert %T task: %s", task, err))
@@ -172,6 +296,9 @@ type QueryParams struct {
 	FutureRunsOnly bool
 	// Exclude tasks where page_sets is PAGESET_TYPE_DUMMY_1k.
 	ExcludeDummyPageSets bool
+	// If non-zero, include only tasks that are not yet completed or that
+	// completed at or after this timestamp, e.g. 20160102150405.
+	ActiveSince int64
 	// If true, SELECT COUNT(*). If false, SELECT * and include ORDER BY and LIMIT clauses.
 	CountQuery bool
 	// First term of LIMIT clause; ignored if countQuery is true.
@@ -206,6 +333,10 @@ func DBTaskQuery(prototype Task, params QueryParams) (string, []interface{}) {
 	if params.ExcludeDummyPageSets {
 		clauses = append(clauses, fmt.Sprintf("page_sets != '%s'", ctutil.PAGESET_TYPE_DUMMY_1k))
 	}
+	if params.ActiveSince != 0 {
+		clauses = append(clauses, "(ts_completed IS NULL OR ts_completed >= ?)")
+		args = append(args, params.ActiveSince)
+	}
 	if len(clauses) > 0 {
 		query += " WHERE "
 		query += strings.Join(clauses, " AND ")
@@ -600,7 +731,14 @@ func pageSetsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 var rietveldURLRegexp = regexp.MustCompile("^(https?://codereview\\.chromium\\.org)/(\\d{3,})/?$")
-var gerritURLRegexp = regexp.MustCompile("^(https?://(?:[a-z]+)-review\\.googlesource\\.com)/c/(\\d{3,})/?$")
+
+// Matches the Gerrit change URLs that users copy from their browser, e.g.
+// https://chromium-review.googlesource.com/c/123456,
+// https://skia-review.googlesource.com/123456/7,
+// https://chromium-review.googlesource.com/#/c/123456/7/ and
+// https://chromium-review.googlesource.com/c/chromium/src/+/123456/7.
+// The submatches are the Gerrit URL, the change number and the optional patchset number.
+var gerritURLRegexp = regexp.MustCompile("^(https?://[a-z0-9-]+-review\\.googlesource\\.com)/(?:#/)?(?:c/)?(?:[^+]+/\\+/)?(\\d{3,})(?:/(\\d+))?/?$")
 
 type clDetail struct {
 	Issue         int64  `json:"issue"`
@@ -609,6 +747,10 @@ type clDetail struct {
 	Project       string `json:"project"`
 	Patchsets     []int  `json:"patchsets"`
 	CodereviewURL string
+	// The URL of the Gerrit instance of the CL, empty for Rietveld CLs.
+	GerritURL string `json:"-"`
+	// The patchset specified in the CL URL, 0 if the latest patchset should be used.
+	Patchset int `json:"-"`
 }
 
 func getRietveldCLDetail(clURLString string) (clDetail, error) {
@@ -677,6 +819,82 @@ func getRietveldCLPatch(detail clDetail, patchsetID int) (string, error) {
 	return string(patchBytes), nil
 }
 
+func getGerritCLDetail(clURLString string) (clDetail, error) {
+	if clURLString == "" {
+		return clDetail{}, fmt.Errorf("No CL specified")
+	}
+
+	matches := gerritURLRegexp.FindStringSubmatch(clURLString)
+	if len(matches) < 4 || matches[1] == "" || matches[2] == "" {
+		// Don't return error, since user could still be typing.
+		return clDetail{}, nil
+	}
+	crURL := matches[1]
+	cl, err := strconv.ParseInt(matches[2], 10, 64)
+	if err != nil {
+		return clDetail{}, fmt.Errorf("Invalid Gerrit CL number: %s", err)
+	}
+	patchset := 0
+	if matches[3] != "" {
+		if patchset, err = strconv.Atoi(matches[3]); err != nil {
+			return clDetail{}, fmt.Errorf("Invalid Gerrit patchset number: %s", err)
+		}
+	}
+	g, err := gerrit.NewGerrit(crURL, "", httpClient)
+	if err != nil {
+		return clDetail{}, fmt.Errorf("Failed to talk to Gerrit: %s", err)
+	}
+	glog.Infof("Reading CL detail from %s", g.Url(cl))
+	change, err := g.GetIssueProperties(cl)
+	if err != nil {
+		return clDetail{}, fmt.Errorf("Unable to retrieve CL detail: %s", err)
+	}
+	detail := clDetail{
+		Issue:     cl,
+		Subject:   change.Subject,
+		Modified:  change.UpdatedString,
+		Project:   change.Project,
+		GerritURL: crURL,
+		Patchset:  patchset,
+	}
+	for _, id := range change.GetPatchsetIDs() {
+		detail.Patchsets = append(detail.Patchsets, int(id))
+	}
+	if len(detail.Patchsets) == 0 {
+		return clDetail{}, fmt.Errorf("CL has no patchsets")
+	}
+	if patchset <= 0 {
+		patchset = detail.Patchsets[len(detail.Patchsets)-1]
+	} else if patchset > detail.Patchsets[len(detail.Patchsets)-1] {
+		return clDetail{}, fmt.Errorf("CL %d has no patchset %d", cl, patchset)
+	}
+	detail.CodereviewURL = fmt.Sprintf("%s/%d", g.Url(cl), patchset)
+	return detail, nil
+}
+
+func getGerritCLPatch(detail clDetail, patchsetID int) (string, error) {
+	if len(detail.Patchsets) == 0 {
+		return "", fmt.Errorf("CL has no patchsets")
+	}
+	if patchsetID <= 0 {
+		// If no valid patchsetID has been specified then use the last patchset.
+		patchsetID = detail.Patchsets[len(detail.Patchsets)-1]
+	}
+	g, err := gerrit.NewGerrit(detail.GerritURL, "", httpClient)
+	if err != nil {
+		return "", fmt.Errorf("Failed to talk to Gerrit: %s", err)
+	}
+	glog.Infof("Downloading patchset %d of CL %s", patchsetID, g.Url(detail.Issue))
+	patch, err := g.GetPatch(detail.Issue, strconv.Itoa(patchsetID))
+	if err != nil {
+		return "", fmt.Errorf("Unable to retrieve CL patch: %s", err)
+	}
+	if int64(len(patch)) > db.LONG_TEXT_MAX_LENGTH {
+		return "", fmt.Errorf("Patch is too large; length is %d bytes.", len(patch))
+	}
+	return patch, nil
+}
+
 func gatherCLData(detail clDetail, patch string) (map[string]string, error) {
 	clData := map[string]string{}
 	clData["cl"] = strconv.FormatInt(detail.Issue, 10)
@@ -710,66 +928,33 @@ func getCLHandler(w http.ResponseWriter, r *http.Request) {
 	clURLString := r.FormValue("cl")
 
 	var detail clDetail
-	var patch string
 	var err error
 	if strings.Contains(clURLString, RIETVELD_URL) {
 		detail, err = getRietveldCLDetail(clURLString)
-		if err != nil {
-			httputils.ReportError(w, r, err, "Failed to get CL details")
-			return
-		}
-		if detail.Issue == 0 {
-			// Return successful empty response, since the user could still be typing.
-			if err := json.NewEncoder(w).Encode(map[string]interface{}{}); err != nil {
-				httputils.ReportError(w, r, err, "Failed to encode JSON")
-			}
-			return
-		}
-		patch, err = getRietveldCLPatch(detail, 0)
-		if err != nil {
-			httputils.ReportError(w, r, err, "Failed to get CL patch")
-			return
-		}
 	} else {
 		// If it is not Rietveld then assume it is Gerrit.
-		matches := gerritURLRegexp.FindStringSubmatch(clURLString)
-		if len(matches) < 3 || matches[1] == "" || matches[2] == "" {
-			// Return successful empty response, since the user could still be typing.
-			if err := json.NewEncoder(w).Encode(map[string]interface{}{}); err != nil {
-				httputils.ReportError(w, r, err, "Failed to encode JSON")
-			}
-			return
-		}
-		crURL := matches[1]
-		clString := matches[2]
-		g, err := gerrit.NewGerrit(crURL, "", httpClient)
-		if err != nil {
-			httputils.ReportError(w, r, err, "Failed to talk to Gerrit")
-			return
-		}
-		cl, err := strconv.ParseInt(clString, 10, 32)
-		if err != nil {
-			httputils.ReportError(w, r, err, "Invalid Gerrit CL number")
-			return
-		}
-		change, err := g.GetIssueProperties(cl)
-		if err != nil {
-			httputils.ReportError(w, r, err, "Failed to get issue properties from Gerrit")
-			return
-		}
-		latestPatchsetID := strconv.Itoa(len(change.Patchsets))
-		detail = clDetail{
-			Issue:         cl,
-			Subject:       change.Subject,
-			Modified:      change.UpdatedString,
-			Project:       change.Project,
-			CodereviewURL: fmt.Sprintf("%s/c/%d/%s", crURL, cl, latestPatchsetID),
-		}
-		patch, err = g.GetPatch(cl, latestPatchsetID)
-		if err != nil {
-			httputils.ReportError(w, r, err, "Failed to download patch from Gerrit")
-			return
+		detail, err = getGerritCLDetail(clURLString)
+	}
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to get CL details")
+		return
+	}
+	if detail.Issue == 0 {
+		// Return successful empty response, since the user could still be typing.
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{}); err != nil {
+			httputils.ReportError(w, r, err, "Failed to encode JSON")
 		}
+		return
+	}
+	var patch string
+	if detail.GerritURL != "" {
+		patch, err = getGerritCLPatch(detail, detail.Patchset)
+	} else {
+		patch, err = getRietveldCLPatch(detail, detail.Patchset)
+	}
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to get CL patch")
+		return
 	}
 
 	clData, err := gatherCLData(detail, patch)
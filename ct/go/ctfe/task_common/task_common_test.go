@@ -0,0 +1,159 @@
+package task_common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.skia.org/infra/go/mockhttpclient"
+	"go.skia.org/infra/go/testutils"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+)
+
+const (
+	GERRIT_URL         = "https://chromium-review.googlesource.com"
+	GERRIT_DETAIL_JSON = `)]}'
+{
+  "project": "chromium/src",
+  "subject": "Make things faster",
+  "created": "2016-11-01 10:00:00.000000000",
+  "updated": "2016-11-03 13:47:20.000000000",
+  "_number": 123456,
+  "revisions": {
+    "aaaa": {"_number": 1, "created": "2016-11-01 10:00:00.000000000"},
+    "bbbb": {"_number": 2, "created": "2016-11-02 10:00:00.000000000"}
+  }
+}`
+	PATCH_1 = "diff --git a/foo b/foo\n-old\n+patchset 1\n"
+	PATCH_2 = "diff --git a/foo b/foo\n-old\n+patchset 2\n"
+)
+
+// Replaces httpClient with a client that serves a Gerrit change with two patchsets. Returns a
+// function that restores httpClient.
+func mockGerrit() func() {
+	formatPatch := func(patch string) []byte {
+		return []byte(base64.StdEncoding.EncodeToString([]byte("Subject: Make things faster\n---\n" + patch)))
+	}
+	urlMock := mockhttpclient.NewURLMock()
+	urlMock.Mock(GERRIT_URL+"/changes/123456/detail?o=ALL_REVISIONS", mockhttpclient.MockGetDialogue([]byte(GERRIT_DETAIL_JSON)))
+	urlMock.Mock(GERRIT_URL+"/changes/123456/revisions/1/patch", mockhttpclient.MockGetDialogue(formatPatch(PATCH_1)))
+	urlMock.Mock(GERRIT_URL+"/changes/123456/revisions/2/patch", mockhttpclient.MockGetDialogue(formatPatch(PATCH_2)))
+	urlMock.Mock(GERRIT_URL+"/changes/654321/detail?o=ALL_REVISIONS", mockhttpclient.MockGetError("Not Found", http.StatusNotFound))
+	origClient := httpClient
+	httpClient = urlMock.Client()
+	return func() {
+		httpClient = origClient
+	}
+}
+
+func TestGerritURLRegexp(t *testing.T) {
+	testutils.SmallTest(t)
+	test := func(clURL, cl, patchset string) {
+		matches := gerritURLRegexp.FindStringSubmatch(clURL)
+		assert.Len(t, matches, 4, clURL)
+		expect.Equal(t, GERRIT_URL, matches[1], clURL)
+		expect.Equal(t, cl, matches[2], clURL)
+		expect.Equal(t, patchset, matches[3], clURL)
+	}
+	test(GERRIT_URL+"/c/123456", "123456", "")
+	test(GERRIT_URL+"/c/123456/", "123456", "")
+	test(GERRIT_URL+"/123456", "123456", "")
+	test(GERRIT_URL+"/c/123456/2", "123456", "2")
+	test(GERRIT_URL+"/#/c/123456/2/", "123456", "2")
+	test(GERRIT_URL+"/c/chromium/src/+/123456", "123456", "")
+	test(GERRIT_URL+"/c/chromium/src/+/123456/2", "123456", "2")
+	expect.Nil(t, gerritURLRegexp.FindStringSubmatch(GERRIT_URL+"/c/12"))
+	expect.Nil(t, gerritURLRegexp.FindStringSubmatch("https://codereview.chromium.org/123456"))
+}
+
+func TestGetGerritCLDetailAndPatch(t *testing.T) {
+	testutils.SmallTest(t)
+	defer mockGerrit()()
+
+	detail, err := getGerritCLDetail(GERRIT_URL + "/c/123456")
+	assert.NoError(t, err)
+	expect.Equal(t, int64(123456), detail.Issue)
+	expect.Equal(t, "Make things faster", detail.Subject)
+	expect.Equal(t, "chromium/src", detail.Project)
+	expect.Equal(t, []int{1, 2}, detail.Patchsets)
+	expect.Equal(t, 0, detail.Patchset)
+	expect.Equal(t, GERRIT_URL+"/c/123456/2", detail.CodereviewURL)
+	patch, err := getGerritCLPatch(detail, detail.Patchset)
+	assert.NoError(t, err)
+	expect.Equal(t, PATCH_2, patch)
+
+	detail, err = getGerritCLDetail(GERRIT_URL + "/c/chromium/src/+/123456/1")
+	assert.NoError(t, err)
+	expect.Equal(t, 1, detail.Patchset)
+	expect.Equal(t, GERRIT_URL+"/c/123456/1", detail.CodereviewURL)
+	patch, err = getGerritCLPatch(detail, detail.Patchset)
+	assert.NoError(t, err)
+	expect.Equal(t, PATCH_1, patch)
+
+	// Unknown patchset.
+	_, err = getGerritCLDetail(GERRIT_URL + "/c/123456/3")
+	expect.Error(t, err)
+	// Unknown change.
+	_, err = getGerritCLDetail(GERRIT_URL + "/c/654321")
+	expect.Error(t, err)
+	// Incomplete URL.
+	detail, err = getGerritCLDetail(GERRIT_URL + "/c/")
+	assert.NoError(t, err)
+	expect.Equal(t, int64(0), detail.Issue)
+}
+
+func TestGetCLHandlerGerrit(t *testing.T) {
+	testutils.SmallTest(t)
+	defer mockGerrit()()
+
+	getCLData := func(clURL string) map[string]string {
+		r, err := http.NewRequest("POST", "/_/cl_data?cl="+url.QueryEscape(clURL), nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		getCLHandler(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		clData := map[string]string{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&clData))
+		return clData
+	}
+
+	clData := getCLData(GERRIT_URL + "/c/123456/1")
+	expect.Equal(t, map[string]string{
+		"cl":             "123456",
+		"subject":        "Make things faster",
+		"url":            GERRIT_URL + "/c/123456/1",
+		"modified":       "20161103134720",
+		"chromium_patch": PATCH_1,
+		"skia_patch":     "",
+		"catapult_patch": "",
+	}, clData)
+
+	// The user could still be typing.
+	expect.Empty(t, getCLData(GERRIT_URL+"/c/12"))
+}
+
+func TestLockUser(t *testing.T) {
+	testutils.SmallTest(t)
+	unlockA := lockUser("a@google.com")
+	// Other users are not blocked.
+	lockUser("b@google.com")()
+
+	locked := make(chan bool)
+	go func() {
+		defer lockUser("a@google.com")()
+		locked <- true
+	}()
+	select {
+	case <-locked:
+		t.Fatal("User was locked twice.")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlockA()
+	<-locked
+}
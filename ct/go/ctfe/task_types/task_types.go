@@ -1,28 +1,79 @@
 /*
 	List of all task types.
+
+	Task packages register their task types with task_common.RegisterTaskType
+	from an init function. Importing a task package here is all that is needed
+	for CTFE and the poller to pick up its task types.
 */
 
 package task_types
 
 import (
-	"go.skia.org/infra/ct/go/ctfe/admin_tasks"
-	"go.skia.org/infra/ct/go/ctfe/capture_skps"
-	"go.skia.org/infra/ct/go/ctfe/chromium_analysis"
-	"go.skia.org/infra/ct/go/ctfe/chromium_builds"
-	"go.skia.org/infra/ct/go/ctfe/chromium_perf"
-	"go.skia.org/infra/ct/go/ctfe/lua_scripts"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	_ "go.skia.org/infra/ct/go/ctfe/admin_tasks"
+	_ "go.skia.org/infra/ct/go/ctfe/capture_skps"
+	_ "go.skia.org/infra/ct/go/ctfe/chromium_analysis"
+	_ "go.skia.org/infra/ct/go/ctfe/chromium_builds"
+	_ "go.skia.org/infra/ct/go/ctfe/chromium_perf"
+	_ "go.skia.org/infra/ct/go/ctfe/generic_tasks"
+	_ "go.skia.org/infra/ct/go/ctfe/lua_scripts"
 	"go.skia.org/infra/ct/go/ctfe/task_common"
+	ctfeutil "go.skia.org/infra/ct/go/ctfe/util"
+	"go.skia.org/infra/go/httputils"
 )
 
+// All task types supported by CTFE.
+func TaskTypes() []task_common.TaskType {
+	return task_common.RegisteredTaskTypes()
+}
+
 // Slice of all tasks supported by CTFE.
 func Prototypes() []task_common.Task {
-	return []task_common.Task{
-		&chromium_analysis.DBTask{},
-		&chromium_perf.DBTask{},
-		&capture_skps.DBTask{},
-		&lua_scripts.DBTask{},
-		&chromium_builds.DBTask{},
-		&admin_tasks.RecreatePageSetsDBTask{},
-		&admin_tasks.RecreateWebpageArchivesDBTask{},
+	ret := []task_common.Task{}
+	for _, t := range TaskTypes() {
+		ret = append(ret, t.Prototype())
+	}
+	return ret
+}
+
+// ForName returns the task type with the given name.
+func ForName(name string) (task_common.TaskType, error) {
+	for _, t := range TaskTypes() {
+		if t.Name() == name {
+			return t, nil
+		}
 	}
+	return task_common.TaskType{}, fmt.Errorf("Unknown task type %q", name)
+}
+
+// taskTypesHandler returns the name and URIs of all task types, which allows
+// pages that list tasks of all types to discover them.
+func taskTypesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	type taskTypeJSON struct {
+		Type      string `json:"type"`
+		GetURL    string `json:"get_url"`
+		DeleteURL string `json:"delete_url"`
+	}
+	ret := []taskTypeJSON{}
+	for _, t := range TaskTypes() {
+		ret = append(ret, taskTypeJSON{
+			Type:      t.Name(),
+			GetURL:    "/" + t.GetURI,
+			DeleteURL: "/" + t.DeleteURI,
+		})
+	}
+	if err := json.NewEncoder(w).Encode(ret); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to encode JSON: %v", err))
+		return
+	}
+}
+
+func AddHandlers(r *mux.Router) {
+	r.HandleFunc("/"+ctfeutil.TASK_TYPES_POST_URI, taskTypesHandler).Methods("POST")
 }
@@ -79,10 +79,23 @@ const (
 	DELETE_RECREATE_WEBPAGE_ARCHIVES_TASK_POST_URI = "_/delete_recreate_webpage_archives_task"
 	REDO_RECREATE_WEBPAGE_ARCHIVES_TASK_POST_URI   = "_/redo_recreate_webpage_archives_task"
 
+	GENERIC_TASK_URI             = "generic_tasks/"
+	GENERIC_TASK_RUNS_URI        = "generic_task_runs/"
+	GENERIC_TASK_SPECS_POST_URI  = "_/generic_task_specs"
+	ADD_GENERIC_TASK_POST_URI    = "_/add_generic_task"
+	GET_GENERIC_TASKS_POST_URI   = "_/get_generic_tasks"
+	UPDATE_GENERIC_TASK_POST_URI = "_/update_generic_task"
+	DELETE_GENERIC_TASK_POST_URI = "_/delete_generic_task"
+	REDO_GENERIC_TASK_POST_URI   = "_/redo_generic_task"
+
 	RUNS_HISTORY_URI = "history/"
 
 	PENDING_TASKS_URI           = "queue/"
 	GET_OLDEST_PENDING_TASK_URI = "_/get_oldest_pending_task"
+	GET_PENDING_TASKS_URI       = "_/get_pending_tasks"
+	TASK_TYPES_POST_URI         = "_/task_types"
+
+	QUOTA_USAGE_URI = "_/quota_usage"
 
 	PAGE_SETS_PARAMETERS_POST_URI = "_/page_sets/"
 	CL_DATA_POST_URI              = "_/cl_data"
@@ -125,6 +138,33 @@ func CheckLengths(checks []LengthCheck) error {
 	return nil
 }
 
+// GetNumPages returns the number of pages a task runs on: the number of custom
+// webpages if any are specified, otherwise the size of the page set. Returns 0
+// for unknown page sets.
+func GetNumPages(pageSets, customWebpages string) int {
+	if customWebpages != "" {
+		numPages := 0
+		r := csv.NewReader(strings.NewReader(customWebpages))
+		r.FieldsPerRecord = -1
+		for {
+			records, err := r.Read()
+			if err != nil {
+				break
+			}
+			for _, record := range records {
+				if strings.TrimSpace(record) != "" {
+					numPages++
+				}
+			}
+		}
+		return numPages
+	}
+	if info, ok := util.PagesetTypeToInfo[pageSets]; ok {
+		return info.NumPages
+	}
+	return 0
+}
+
 func GetQualifiedCustomWebpages(customWebpages, benchmarkArgs string) ([]string, error) {
 	qualifiedWebpages := []string{}
 	if customWebpages != "" {
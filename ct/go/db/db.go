@@ -22,6 +22,7 @@ const (
 	TABLE_CHROMIUM_BUILD_TASKS            = "ChromiumBuildTasks"
 	TABLE_RECREATE_PAGE_SETS_TASKS        = "RecreatePageSetsTasks"
 	TABLE_RECREATE_WEBPAGE_ARCHIVES_TASKS = "RecreateWebpageArchivesTasks"
+	TABLE_GENERIC_TASKS                   = "GenericTasks"
 
 	// From https://dev.mysql.com/doc/refman/5.0/en/storage-requirements.html
 	TEXT_MAX_LENGTH      = 1<<16 - 1
@@ -336,6 +337,34 @@ var v18_down = []string{
 	`ALTER TABLE ChromiumPerfTasks DROP custom_webpages`,
 }
 
+var v19_up = []string{
+	`CREATE TABLE IF NOT EXISTS GenericTasks (
+		id                     INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		username               VARCHAR(255) NOT NULL,
+		task_type              VARCHAR(100) NOT NULL,
+		parameters             LONGTEXT     NOT NULL,
+		description            VARCHAR(255) NOT NULL,
+		repeat_after_days      BIGINT       NOT NULL DEFAULT 0,
+		ts_added               BIGINT       NOT NULL,
+		ts_started             BIGINT,
+		ts_completed           BIGINT,
+		failure                TINYINT(1),
+		results                VARCHAR(255)
+	) CHARACTER SET utf8`,
+}
+
+var v19_down = []string{
+	`DROP TABLE IF EXISTS GenericTasks`,
+}
+
+var v20_up = []string{
+	`ALTER TABLE ChromiumPerfTasks ADD stats_results VARCHAR(255)`,
+}
+
+var v20_down = []string{
+	`ALTER TABLE ChromiumPerfTasks DROP stats_results`,
+}
+
 // Define the migration steps.
 // Note: Only add to this list, once a step has landed in version control it
 // must not be changed.
@@ -430,6 +459,16 @@ var migrationSteps = []database.MigrationStep{
 		MySQLUp:   v18_up,
 		MySQLDown: v18_down,
 	},
+	// version 19: Create Generic Tasks table.
+	{
+		MySQLUp:   v19_up,
+		MySQLDown: v19_down,
+	},
+	// version 20: Add stats_results to ChromiumPerfTasks.
+	{
+		MySQLUp:   v20_up,
+		MySQLDown: v20_down,
+	},
 }
 
 // MigrationSteps returns the database migration steps.
@@ -44,6 +44,7 @@ var (
 	ChromiumBuildTasksWebapp                 string
 	UpdateChromiumBuildTasksWebapp           string
 	GetOldestPendingTaskWebapp               string
+	GetPendingTasksWebapp                    string
 )
 
 var httpClient = httputils.NewTimeoutClient()
@@ -81,6 +82,7 @@ func initUrls(webapp_root string) {
 	ChromiumBuildTasksWebapp = webapp_root + ctfeutil.CHROMIUM_BUILD_URI
 	UpdateChromiumBuildTasksWebapp = webapp_root + ctfeutil.UPDATE_CHROMIUM_BUILD_TASK_POST_URI
 	GetOldestPendingTaskWebapp = webapp_root + ctfeutil.GET_OLDEST_PENDING_TASK_URI
+	GetPendingTasksWebapp = webapp_root + ctfeutil.GET_PENDING_TASKS_URI
 }
 
 func UpdateWebappTask(gaeTaskID int64, webappURL string, extraData map[string]string) error {
@@ -127,6 +129,20 @@ func GetOldestPendingTaskV2() (task_common.Task, error) {
 	return pending_tasks.DecodeTask(resp.Body)
 }
 
+// GetPendingTasksV2 returns all pending tasks in the order in which they should be picked up.
+func GetPendingTasksV2() ([]task_common.Task, error) {
+	resp, err := httpClient.Get(GetPendingTasksWebapp)
+	if err != nil {
+		return nil, err
+	}
+	defer skutil.Close(resp.Body)
+	if resp.StatusCode != 200 {
+		response, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GET %s returned %d: %s", GetPendingTasksWebapp, resp.StatusCode, response)
+	}
+	return pending_tasks.DecodeTasks(resp.Body)
+}
+
 func UpdateWebappTaskV2(vars task_common.UpdateTaskVars) error {
 	postUrl := WebappRoot + vars.UriPath()
 	glog.Infof("Updating %v on %s", vars, postUrl)
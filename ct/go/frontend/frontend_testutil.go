@@ -25,15 +25,15 @@ type UpdateTaskReq struct {
 	Error error
 }
 
-// MockServer implements http.Handler and can be given a task with which to respond to
-// ctfeutil.GET_OLDEST_PENDING_TASK_URI. It also collects any other requests and attempts to parse
-// the body as task_common.UpdateTaskCommonVars JSON. Safe for use in multiple goroutines.
+// MockServer implements http.Handler and can be given tasks with which to respond to
+// ctfeutil.GET_OLDEST_PENDING_TASK_URI and ctfeutil.GET_PENDING_TASKS_URI. It also collects any
+// other requests and attempts to parse the body as task_common.UpdateTaskCommonVars JSON. Safe for use in multiple goroutines.
 // Example usage:
 //	mockServer := MockServer{}
 //	mockServer.SetCurrentTask(&admin_tasks.RecreateWebpageArchivesDBTask{...})
 //	defer CloseTestServer(InitTestServer(&mockServer))
 //	...
-//	expect.Equal(t, 1, mockServer.OldestPendingTaskReqCount())
+//	expect.Equal(t, 1, mockServer.PendingTasksReqCount())
 //	assert.Len(t, mockServer.UpdateTaskReqs(), 1)
 //	updateReq := mockServer.UpdateTaskReqs()[0]
 //	expect.Equal(t, "/"+ctfeutil.UPDATE_RECREATE_WEBPAGE_ARCHIVES_TASK_POST_URI, updateReq.Url)
@@ -42,17 +42,27 @@ type UpdateTaskReq struct {
 //	...
 type MockServer struct {
 	mutex                     sync.RWMutex
-	currentTask               task_common.Task
+	pendingTasks              []task_common.Task
 	oldestPendingTaskReqCount int
+	pendingTasksReqCount      int
 	updateTaskReqs            []UpdateTaskReq
 }
 
-// SetCurrentTask provides the Task to be returned for a ctfeutil.GET_OLDEST_PENDING_TASK_URI
-// request.
+// SetCurrentTask provides the only pending Task, or no pending Task if currentTask is nil.
 func (ms *MockServer) SetCurrentTask(currentTask task_common.Task) {
+	if currentTask == nil {
+		ms.SetPendingTasks([]task_common.Task{})
+	} else {
+		ms.SetPendingTasks([]task_common.Task{currentTask})
+	}
+}
+
+// SetPendingTasks provides the Tasks to be returned for a ctfeutil.GET_PENDING_TASKS_URI request.
+// The first Task is returned for a ctfeutil.GET_OLDEST_PENDING_TASK_URI request.
+func (ms *MockServer) SetPendingTasks(pendingTasks []task_common.Task) {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
-	ms.currentTask = currentTask
+	ms.pendingTasks = pendingTasks
 }
 
 func (ms *MockServer) OldestPendingTaskReqCount() int {
@@ -61,6 +71,12 @@ func (ms *MockServer) OldestPendingTaskReqCount() int {
 	return ms.oldestPendingTaskReqCount
 }
 
+func (ms *MockServer) PendingTasksReqCount() int {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.pendingTasksReqCount
+}
+
 // Returns all update requests seen thus far.
 func (ms *MockServer) UpdateTaskReqs() []UpdateTaskReq {
 	ms.mutex.RLock()
@@ -76,7 +92,22 @@ func (ms *MockServer) HandleGetOldestPendingTask(w http.ResponseWriter, r *http.
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 	ms.oldestPendingTaskReqCount++
-	if err := pending_tasks.EncodeTask(w, ms.currentTask); err != nil {
+	var oldestTask task_common.Task
+	if len(ms.pendingTasks) > 0 {
+		oldestTask = ms.pendingTasks[0]
+	}
+	if err := pending_tasks.EncodeTask(w, oldestTask); err != nil {
+		httputils.ReportError(w, r, err, "Failed to encode JSON")
+		return
+	}
+}
+
+func (ms *MockServer) HandleGetPendingTasks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.pendingTasksReqCount++
+	if err := pending_tasks.EncodeTasks(w, ms.pendingTasks); err != nil {
 		httputils.ReportError(w, r, err, "Failed to encode JSON")
 		return
 	}
@@ -106,22 +137,25 @@ func (ms *MockServer) HandleUpdateTask(w http.ResponseWriter, r *http.Request) {
 func (ms *MockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path == "/"+ctfeutil.GET_OLDEST_PENDING_TASK_URI {
 		ms.HandleGetOldestPendingTask(w, r)
+	} else if r.URL.Path == "/"+ctfeutil.GET_PENDING_TASKS_URI {
+		ms.HandleGetPendingTasks(w, r)
 	} else {
 		ms.HandleUpdateTask(w, r)
 	}
 }
 
 // Creates an httptest.Server using h as its handler and calls Init to ensure
-// GetOldestPendingTaskV2 and UpdateWebappTaskV2 use the test server. Also calls
-// InitForTesting. Can be used as "defer CloseTestServer(InitTestServer(h))".
+// GetOldestPendingTaskV2, GetPendingTasksV2 and UpdateWebappTaskV2 use the test server. Also
+// calls InitForTesting. Can be used as "defer CloseTestServer(InitTestServer(h))".
 func InitTestServer(h http.Handler) *httptest.Server {
 	ts := httptest.NewServer(h)
 	InitForTesting(ts.URL + "/")
 	return ts
 }
 
-// Closes ts, resets CtfeV2, and resets the webapp Url for GetOldestPendingTaskV2 and
-// UpdateWebappTaskV2. Can be used as "defer CloseTestServer(InitTestServer(h))".
+// Closes ts, resets CtfeV2, and resets the webapp Url for GetOldestPendingTaskV2,
+// GetPendingTasksV2 and UpdateWebappTaskV2. Can be used as
+// "defer CloseTestServer(InitTestServer(h))".
 func CloseTestServer(ts *httptest.Server) {
 	ts.Close()
 	InitForTesting(WEBAPP_ROOT_V2)
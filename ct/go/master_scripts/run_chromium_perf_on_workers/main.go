@@ -17,6 +17,7 @@ import (
 	"go.skia.org/infra/ct/go/ctfe/chromium_perf"
 	"go.skia.org/infra/ct/go/frontend"
 	"go.skia.org/infra/ct/go/master_scripts/master_common"
+	"go.skia.org/infra/ct/go/perf_comparer"
 	"go.skia.org/infra/ct/go/util"
 	"go.skia.org/infra/go/common"
 	"go.skia.org/infra/go/email"
@@ -53,6 +54,8 @@ var (
 	customWebpagesLink  = util.MASTER_LOGSERVER_LINK
 	noPatchOutputLink   = util.MASTER_LOGSERVER_LINK
 	withPatchOutputLink = util.MASTER_LOGSERVER_LINK
+	// Only set if the statistical comparison of the runs succeeded.
+	statsOutputLink = ""
 )
 
 func sendEmail(recipients []string) {
@@ -103,6 +106,9 @@ func updateWebappTask() {
 	vars.Results = sql.NullString{String: htmlOutputLink, Valid: true}
 	vars.NoPatchRawOutput = sql.NullString{String: noPatchOutputLink, Valid: true}
 	vars.WithPatchRawOutput = sql.NullString{String: withPatchOutputLink, Valid: true}
+	if statsOutputLink != "" {
+		vars.StatsResults = sql.NullString{String: statsOutputLink, Valid: true}
+	}
 	skutil.LogErr(frontend.UpdateWebappTaskV2(&vars))
 }
 
@@ -221,7 +227,7 @@ func main() {
 	pathToPyFiles := util.GetPathToPyFiles(false)
 	for _, run := range []string{runIDNoPatch, runIDWithPatch} {
 		if strings.Contains(*benchmarkExtraArgs, "--output-format=csv-pivot-table") {
-			if noOutputSlaves, err = util.MergeUploadCSVFiles(run, pathToPyFiles, gs, numPages, MAX_PAGES_PER_SWARMING_BOT, true /* handleStrings */); err != nil {
+			if noOutputSlaves, err = util.MergeUploadCSVFiles(run, pathToPyFiles, gs, numPages, MAX_PAGES_PER_SWARMING_BOT, true /* handleStrings */, true /* fetchSamples */); err != nil {
 				glog.Errorf("Unable to merge and upload CSV files for %s: %s", run, err)
 			}
 			// Cleanup created dir after the run completes.
@@ -280,6 +286,15 @@ func main() {
 		return
 	}
 
+	// Compare the runs statistically. Failures are not fatal because the results of
+	// csv_comparer.py are still available.
+	statsOutputDir := filepath.Join(htmlOutputDir, "stats")
+	if err := writeStatsReport(runIDNoPatch, runIDWithPatch, noPatchCSVPath, withPatchCSVPath, statsOutputDir); err != nil {
+		glog.Errorf("Could not compare the runs statistically: %s", err)
+	} else {
+		statsOutputLink = htmlOutputLinkBase + "stats/" + perf_comparer.HTML_REPORT_NAME
+	}
+
 	// Copy the HTML files to Google Storage.
 	if err := gs.UploadDir(htmlOutputDir, htmlRemoteDir, true); err != nil {
 		glog.Errorf("Could not upload %s to %s: %s", htmlOutputDir, htmlRemoteDir, err)
@@ -288,3 +303,42 @@ func main() {
 
 	taskCompletedSuccessfully = true
 }
+
+// writeStatsReport compares the samples of the nopatch and withpatch runs using perf_comparer
+// and writes the reports to outputDir.
+func writeStatsReport(runIDNoPatch, runIDWithPatch, noPatchCSVPath, withPatchCSVPath, outputDir string) error {
+	noPatchSamples, err := getSamples(runIDNoPatch, noPatchCSVPath)
+	if err != nil {
+		return err
+	}
+	withPatchSamples, err := getSamples(runIDWithPatch, withPatchCSVPath)
+	if err != nil {
+		return err
+	}
+	report := perf_comparer.Compare(noPatchSamples, withPatchSamples, perf_comparer.Options{
+		VarianceThreshold: *varianceThreshold,
+		DiscardOutliers:   *discardOutliers,
+	})
+	report.ResultsLink = htmlOutputLink
+	report.NoPatchCSVLink = noPatchOutputLink
+	report.WithPatchCSVLink = withPatchOutputLink
+	return report.WriteFiles(outputDir)
+}
+
+// getSamples returns the individual values of the given run as copied by
+// util.MergeUploadCSVFiles, and removes the local copy. If there are none, the consolidated output
+// is used instead, which only has the average of the repeated runs of each page, so no differences
+// are significant.
+func getSamples(run, consolidatedCSVPath string) (perf_comparer.Samples, error) {
+	samplesDir := filepath.Join(util.StorageDir, util.BenchmarkRunsDir, run, util.SAMPLES_DIR_NAME)
+	defer skutil.RemoveAll(samplesDir)
+	samplesPaths, err := filepath.Glob(filepath.Join(samplesDir, "*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	if len(samplesPaths) == 0 {
+		glog.Warningf("Found no samples of %s, using %s instead", run, consolidatedCSVPath)
+		samplesPaths = []string{consolidatedCSVPath}
+	}
+	return perf_comparer.ReadCSVFiles(samplesPaths...)
+}
@@ -0,0 +1,481 @@
+/*
+	Statistical comparison of the nopatch and withpatch results of chromium perf runs.
+
+	The results of both runs are read from Telemetry CSV files. For every page and metric the
+	values of the repeated runs of the page are compared: the delta and percent change of their
+	means, and the p-value of a Mann-Whitney U test which tells whether the difference is likely
+	to be noise.
+*/
+
+package perf_comparer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	skutil "go.skia.org/infra/go/util"
+)
+
+const (
+	// Columns of the CSV files written by Telemetry's csv-pivot-table output format. Every row
+	// holds one value of one metric of one page.
+	PIVOT_PAGE_COLUMN  = "page"
+	PIVOT_NAME_COLUMN  = "name"
+	PIVOT_VALUE_COLUMN = "value"
+	PIVOT_UNITS_COLUMN = "units"
+
+	// Column of the CSV files written by the CT CSV mergers. Every row holds all metrics of one
+	// page, one metric per column.
+	PAGE_NAME_COLUMN = "page_name"
+
+	// The default significance level of the Mann-Whitney U test.
+	DEFAULT_SIGNIFICANCE_LEVEL = 0.05
+
+	// The minimum number of values of a page and metric for outliers to be filtered.
+	MIN_VALUES_FOR_OUTLIER_FILTERING = 4
+)
+
+// Samples holds the values of the repeated runs of each page and metric. It maps page names to
+// metric names to values.
+type Samples map[string]map[string][]float64
+
+// Add adds a value of the given page and metric.
+func (s Samples) Add(page, metric string, value float64) {
+	if _, ok := s[page]; !ok {
+		s[page] = map[string][]float64{}
+	}
+	s[page][metric] = append(s[page][metric], value)
+}
+
+// ReadCSV adds the values in the given CSV file to the Samples. Both the csv-pivot-table format
+// written by Telemetry and the format written by the CT CSV mergers are supported. Rows of the
+// same page are repeated runs of the page. Empty and non-numeric values are ignored, "-" is read
+// as 0.
+func (s Samples) ReadCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Failed to read CSV header: %s", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	_, hasPage := columns[PIVOT_PAGE_COLUMN]
+	_, hasName := columns[PIVOT_NAME_COLUMN]
+	_, hasValue := columns[PIVOT_VALUE_COLUMN]
+	pageNameIdx, hasPageName := columns[PAGE_NAME_COLUMN]
+	pivot := hasPage && hasName && hasValue
+	if !pivot && !hasPageName {
+		return fmt.Errorf("CSV has neither %q, %q and %q columns nor a %q column", PIVOT_PAGE_COLUMN, PIVOT_NAME_COLUMN, PIVOT_VALUE_COLUMN, PAGE_NAME_COLUMN)
+	}
+	get := func(row []string, idx int) string {
+		if idx < len(row) {
+			return row[idx]
+		}
+		return ""
+	}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Failed to read CSV row: %s", err)
+		}
+		if pivot {
+			page := get(row, columns[PIVOT_PAGE_COLUMN])
+			metric := get(row, columns[PIVOT_NAME_COLUMN])
+			if idx, ok := columns[PIVOT_UNITS_COLUMN]; ok && get(row, idx) != "" {
+				// Matches the column names written by the CT CSV mergers.
+				metric = fmt.Sprintf("%s (%s)", metric, get(row, idx))
+			}
+			if value, ok := parseValue(get(row, columns[PIVOT_VALUE_COLUMN])); ok && page != "" {
+				s.Add(page, metric, value)
+			}
+			continue
+		}
+		page := get(row, pageNameIdx)
+		if page == "" {
+			continue
+		}
+		for i, metric := range header {
+			if i == pageNameIdx {
+				continue
+			}
+			if value, ok := parseValue(get(row, i)); ok {
+				s.Add(page, metric, value)
+			}
+		}
+	}
+}
+
+// ReadCSVFiles returns the Samples in the given CSV files, see Samples.ReadCSV.
+func ReadCSVFiles(paths ...string) (Samples, error) {
+	s := Samples{}
+	for _, path := range paths {
+		if err := readCSVFile(s, path); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func readCSVFile(s Samples, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Failed to open %s: %s", path, err)
+	}
+	defer skutil.Close(f)
+	if err := s.ReadCSV(f); err != nil {
+		return fmt.Errorf("Failed to read %s: %s", path, err)
+	}
+	return nil
+}
+
+// parseValue parses a value of a CSV file. The second return value is false if the value should
+// be ignored.
+func parseValue(s string) (float64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	if s == "-" {
+		return 0, true
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
+
+// Options control how the Samples are compared.
+type Options struct {
+	// Pages whose absolute percent change is below this threshold are not listed in the report.
+	// They still count towards the metric summaries.
+	VarianceThreshold float64 `json:"variance_threshold"`
+	// The percentage of pages with the largest and of pages with the smallest percent change of
+	// each metric to discard as outliers.
+	DiscardOutliers float64 `json:"discard_outliers"`
+	// Differences with a p-value below this level are significant. DEFAULT_SIGNIFICANCE_LEVEL is
+	// used if 0.
+	SignificanceLevel float64 `json:"significance_level"`
+}
+
+// Stats describes the values of one page and metric of one run.
+type Stats struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	// The number of values after filtering outliers.
+	Count int `json:"count"`
+	// The number of values discarded as outliers.
+	Outliers int `json:"outliers"`
+}
+
+// Comparison is the comparison of one page and metric between the nopatch and withpatch runs.
+type Comparison struct {
+	Page      string `json:"page"`
+	Metric    string `json:"metric"`
+	NoPatch   Stats  `json:"nopatch"`
+	WithPatch Stats  `json:"withpatch"`
+	// The withpatch mean minus the nopatch mean.
+	Delta float64 `json:"delta"`
+	// The delta as a percentage of the nopatch mean, 0 if the nopatch mean is 0.
+	PercentChange float64 `json:"percent_change"`
+	// The two-sided p-value of the Mann-Whitney U test of the nopatch and withpatch values.
+	PValue      float64 `json:"p_value"`
+	Significant bool    `json:"significant"`
+}
+
+// MetricSummary summarizes the comparisons of all pages of one metric.
+type MetricSummary struct {
+	Metric string `json:"metric"`
+	// The sums of the means of all pages that were not discarded as outliers.
+	NoPatchTotal   float64 `json:"nopatch_total"`
+	WithPatchTotal float64 `json:"withpatch_total"`
+	PercentChange  float64 `json:"percent_change"`
+	NumPages       int     `json:"num_pages"`
+	NumSignificant int     `json:"num_significant"`
+	NumDiscarded   int     `json:"num_discarded"`
+}
+
+// Report is the result of Compare.
+type Report struct {
+	Options Options `json:"options"`
+	// Summaries of all metrics, sorted by decreasing absolute percent change.
+	Metrics []*MetricSummary `json:"metrics"`
+	// Comparisons above the variance threshold, sorted by impact: significant comparisons first,
+	// then by decreasing absolute percent change.
+	Comparisons []*Comparison `json:"comparisons"`
+	// Comparisons discarded as outliers.
+	Discarded []*Comparison `json:"discarded"`
+	// Pages which are only in one of the runs.
+	NoPatchOnlyPages   []string `json:"nopatch_only_pages"`
+	WithPatchOnlyPages []string `json:"withpatch_only_pages"`
+	// Optional links included in the HTML report.
+	ResultsLink      string `json:"results_link,omitempty"`
+	NoPatchCSVLink   string `json:"nopatch_csv_link,omitempty"`
+	WithPatchCSVLink string `json:"withpatch_csv_link,omitempty"`
+}
+
+// Compare compares the nopatch and withpatch Samples of all pages and metrics which are in both.
+func Compare(noPatch, withPatch Samples, opts Options) *Report {
+	if opts.SignificanceLevel == 0 {
+		opts.SignificanceLevel = DEFAULT_SIGNIFICANCE_LEVEL
+	}
+	report := &Report{
+		Options:            opts,
+		Metrics:            []*MetricSummary{},
+		Comparisons:        []*Comparison{},
+		Discarded:          []*Comparison{},
+		NoPatchOnlyPages:   []string{},
+		WithPatchOnlyPages: []string{},
+	}
+	byMetric := map[string][]*Comparison{}
+	for page, noPatchMetrics := range noPatch {
+		withPatchMetrics, ok := withPatch[page]
+		if !ok {
+			report.NoPatchOnlyPages = append(report.NoPatchOnlyPages, page)
+			continue
+		}
+		for metric, noPatchValues := range noPatchMetrics {
+			withPatchValues, ok := withPatchMetrics[metric]
+			if !ok {
+				continue
+			}
+			byMetric[metric] = append(byMetric[metric], compareValues(page, metric, noPatchValues, withPatchValues, opts.SignificanceLevel))
+		}
+	}
+	for page := range withPatch {
+		if _, ok := noPatch[page]; !ok {
+			report.WithPatchOnlyPages = append(report.WithPatchOnlyPages, page)
+		}
+	}
+	sort.Strings(report.NoPatchOnlyPages)
+	sort.Strings(report.WithPatchOnlyPages)
+
+	for metric, comparisons := range byMetric {
+		// Discard the pages with the largest and smallest percent changes.
+		sort.Sort(byPercentChange(comparisons))
+		numOutliers := int(float64(len(comparisons)) * opts.DiscardOutliers / 100)
+		if 2*numOutliers >= len(comparisons) {
+			numOutliers = 0
+		}
+		report.Discarded = append(report.Discarded, comparisons[:numOutliers]...)
+		report.Discarded = append(report.Discarded, comparisons[len(comparisons)-numOutliers:]...)
+		comparisons = comparisons[numOutliers : len(comparisons)-numOutliers]
+
+		summary := &MetricSummary{
+			Metric:       metric,
+			NumPages:     len(comparisons),
+			NumDiscarded: 2 * numOutliers,
+		}
+		for _, c := range comparisons {
+			summary.NoPatchTotal += c.NoPatch.Mean
+			summary.WithPatchTotal += c.WithPatch.Mean
+			if c.Significant {
+				summary.NumSignificant++
+			}
+			if math.Abs(c.PercentChange) >= opts.VarianceThreshold {
+				report.Comparisons = append(report.Comparisons, c)
+			}
+		}
+		summary.PercentChange = percentChange(summary.NoPatchTotal, summary.WithPatchTotal)
+		report.Metrics = append(report.Metrics, summary)
+	}
+	sort.Sort(byImpact(report.Comparisons))
+	sort.Sort(byImpact(report.Discarded))
+	sort.Sort(metricsByImpact(report.Metrics))
+	return report
+}
+
+// compareValues compares the values of one page and metric.
+func compareValues(page, metric string, noPatchValues, withPatchValues []float64, significanceLevel float64) *Comparison {
+	noPatchValues, noPatchOutliers := FilterOutliers(noPatchValues)
+	withPatchValues, withPatchOutliers := FilterOutliers(withPatchValues)
+	c := &Comparison{
+		Page:      page,
+		Metric:    metric,
+		NoPatch:   getStats(noPatchValues, noPatchOutliers),
+		WithPatch: getStats(withPatchValues, withPatchOutliers),
+		PValue:    MannWhitneyU(noPatchValues, withPatchValues),
+	}
+	c.Delta = c.WithPatch.Mean - c.NoPatch.Mean
+	c.PercentChange = percentChange(c.NoPatch.Mean, c.WithPatch.Mean)
+	c.Significant = c.PValue < significanceLevel
+	return c
+}
+
+func getStats(values []float64, outliers int) Stats {
+	s := Stats{
+		Count:    len(values),
+		Outliers: outliers,
+	}
+	if len(values) == 0 {
+		return s
+	}
+	for _, v := range values {
+		s.Mean += v
+	}
+	s.Mean /= float64(len(values))
+	if len(values) > 1 {
+		for _, v := range values {
+			s.StdDev += (v - s.Mean) * (v - s.Mean)
+		}
+		s.StdDev = math.Sqrt(s.StdDev / float64(len(values)-1))
+	}
+	return s
+}
+
+// percentChange returns the change from a to b as a percentage of a, 0 if a is 0.
+func percentChange(a, b float64) float64 {
+	if a == 0 {
+		return 0
+	}
+	return (b - a) / a * 100
+}
+
+// FilterOutliers returns the values inside Tukey's fences, i.e. the values that are at most 1.5
+// interquartile ranges below the first or above the third quartile, and the number of values
+// that were filtered. Values are only filtered if there are at least
+// MIN_VALUES_FOR_OUTLIER_FILTERING of them.
+func FilterOutliers(values []float64) ([]float64, int) {
+	if len(values) < MIN_VALUES_FOR_OUTLIER_FILTERING {
+		return values, 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	q1 := quantile(sorted, 0.25)
+	q3 := quantile(sorted, 0.75)
+	low, high := q1-1.5*(q3-q1), q3+1.5*(q3-q1)
+	ret := make([]float64, 0, len(values))
+	for _, v := range values {
+		if v >= low && v <= high {
+			ret = append(ret, v)
+		}
+	}
+	return ret, len(values) - len(ret)
+}
+
+// quantile returns the q-quantile of the sorted values, interpolating between the closest ranks.
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	i := int(math.Floor(pos))
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// MannWhitneyU returns the two-sided p-value of the Mann-Whitney U test of the hypothesis that
+// the values of x and y come from the same distribution. It uses the normal approximation of
+// the distribution of U, with corrections for ties and continuity, which is reasonable for the
+// small number of repeated runs of CT tasks but not exact. Returns 1 if there are no values to
+// compare.
+func MannWhitneyU(x, y []float64) float64 {
+	n1, n2 := float64(len(x)), float64(len(y))
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	all := make([]observation, 0, len(x)+len(y))
+	for _, v := range x {
+		all = append(all, observation{value: v, inX: true})
+	}
+	for _, v := range y {
+		all = append(all, observation{value: v})
+	}
+	sort.Sort(observationSlice(all))
+	// Sum the ranks of x, giving tied values the average of their ranks.
+	rankSumX := 0.0
+	tieCorrection := 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].inX {
+				rankSumX += rank
+			}
+		}
+		t := float64(j - i)
+		tieCorrection += t*t*t - t
+		i = j
+	}
+	u := rankSumX - n1*(n1+1)/2
+	n := n1 + n2
+	variance := n1 * n2 / 12 * ((n + 1) - tieCorrection/(n*(n-1)))
+	if variance <= 0 {
+		// All values are equal.
+		return 1
+	}
+	z := (math.Abs(u-n1*n2/2) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		z = 0
+	}
+	return math.Min(1, math.Erfc(z/math.Sqrt2))
+}
+
+// observation is a value passed to MannWhitneyU and whether it is one of the x values.
+type observation struct {
+	value float64
+	inX   bool
+}
+
+type observationSlice []observation
+
+func (p observationSlice) Len() int           { return len(p) }
+func (p observationSlice) Less(i, j int) bool { return p[i].value < p[j].value }
+func (p observationSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// byPercentChange sorts Comparisons by decreasing percent change.
+type byPercentChange []*Comparison
+
+func (p byPercentChange) Len() int      { return len(p) }
+func (p byPercentChange) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byPercentChange) Less(i, j int) bool {
+	if p[i].PercentChange != p[j].PercentChange {
+		return p[i].PercentChange > p[j].PercentChange
+	}
+	return p[i].Page < p[j].Page
+}
+
+// byImpact sorts Comparisons by impact: significant comparisons first, then by decreasing
+// absolute percent change.
+type byImpact []*Comparison
+
+func (p byImpact) Len() int      { return len(p) }
+func (p byImpact) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byImpact) Less(i, j int) bool {
+	if p[i].Significant != p[j].Significant {
+		return p[i].Significant
+	}
+	if a, b := math.Abs(p[i].PercentChange), math.Abs(p[j].PercentChange); a != b {
+		return a > b
+	}
+	if p[i].Metric != p[j].Metric {
+		return p[i].Metric < p[j].Metric
+	}
+	return p[i].Page < p[j].Page
+}
+
+// metricsByImpact sorts MetricSummaries by decreasing absolute percent change.
+type metricsByImpact []*MetricSummary
+
+func (p metricsByImpact) Len() int      { return len(p) }
+func (p metricsByImpact) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p metricsByImpact) Less(i, j int) bool {
+	if a, b := math.Abs(p[i].PercentChange), math.Abs(p[j].PercentChange); a != b {
+		return a > b
+	}
+	return p[i].Metric < p[j].Metric
+}
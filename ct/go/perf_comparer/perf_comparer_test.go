@@ -0,0 +1,211 @@
+package perf_comparer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.skia.org/infra/go/testutils"
+	skutil "go.skia.org/infra/go/util"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestReadCSVPivot(t *testing.T) {
+	testutils.SmallTest(t)
+	s := Samples{}
+	assert.NoError(t, s.ReadCSV(strings.NewReader(`page,name,units,value,run_index
+http://a (#1),mean_frame_time,ms,10,0
+http://a (#1),mean_frame_time,ms,12,1
+http://a (#1),total_size,,-,0
+http://b (#2),mean_frame_time,ms,not a number,0
+http://b (#2),mean_frame_time,ms,,1
+`)))
+	expect.Equal(t, Samples{
+		"http://a (#1)": {
+			"mean_frame_time (ms)": {10, 12},
+			"total_size":           {0},
+		},
+	}, s)
+}
+
+func TestReadCSVMerged(t *testing.T) {
+	testutils.SmallTest(t)
+	s := Samples{}
+	assert.NoError(t, s.ReadCSV(strings.NewReader(`mean_frame_time (ms),page_name,total_size
+10,http://a (#1),5
+11,http://a (#1),
+,http://b (#2),-
+`)))
+	expect.Equal(t, Samples{
+		"http://a (#1)": {
+			"mean_frame_time (ms)": {10, 11},
+			"total_size":           {5},
+		},
+		"http://b (#2)": {
+			"total_size": {0},
+		},
+	}, s)
+
+	expect.Error(t, Samples{}.ReadCSV(strings.NewReader("a,b\n1,2\n")))
+	expect.NoError(t, Samples{}.ReadCSV(strings.NewReader("")))
+}
+
+func TestReadCSVFiles(t *testing.T) {
+	testutils.SmallTest(t)
+	dir, err := ioutil.TempDir("", "perf_comparer_test")
+	assert.NoError(t, err)
+	defer skutil.RemoveAll(dir)
+	file1 := filepath.Join(dir, "1.csv")
+	file2 := filepath.Join(dir, "2.csv")
+	assert.NoError(t, ioutil.WriteFile(file1, []byte("page,name,value\na,m,1\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(file2, []byte("page,name,value\na,m,2\nb,m,3\n"), 0644))
+	s, err := ReadCSVFiles(file1, file2)
+	assert.NoError(t, err)
+	expect.Equal(t, Samples{"a": {"m": {1, 2}}, "b": {"m": {3}}}, s)
+
+	_, err = ReadCSVFiles(filepath.Join(dir, "missing.csv"))
+	expect.Error(t, err)
+}
+
+func TestFilterOutliers(t *testing.T) {
+	testutils.SmallTest(t)
+	values, outliers := FilterOutliers([]float64{10, 11, 10, 12, 11, 100})
+	expect.Equal(t, []float64{10, 11, 10, 12, 11}, values)
+	expect.Equal(t, 1, outliers)
+
+	// Too few values to filter.
+	values, outliers = FilterOutliers([]float64{10, 11, 100})
+	expect.Equal(t, []float64{10, 11, 100}, values)
+	expect.Equal(t, 0, outliers)
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	testutils.SmallTest(t)
+	// Completely separated samples.
+	p := MannWhitneyU([]float64{1, 2, 3, 4, 5, 6, 7, 8}, []float64{11, 12, 13, 14, 15, 16, 17, 18})
+	expect.True(t, p < 0.01, "p = %f", p)
+	// Interleaved samples.
+	p = MannWhitneyU([]float64{1, 3, 5, 7, 9}, []float64{2, 4, 6, 8, 10})
+	expect.True(t, p > 0.5, "p = %f", p)
+	// Identical values.
+	expect.Equal(t, 1.0, MannWhitneyU([]float64{5, 5, 5}, []float64{5, 5, 5}))
+	// No values.
+	expect.Equal(t, 1.0, MannWhitneyU([]float64{}, []float64{1}))
+	// Single values are never significant.
+	expect.True(t, MannWhitneyU([]float64{1}, []float64{100}) > DEFAULT_SIGNIFICANCE_LEVEL)
+	// Symmetric.
+	expect.InDelta(t, MannWhitneyU([]float64{1, 2, 4}, []float64{3, 5, 6}), MannWhitneyU([]float64{3, 5, 6}, []float64{1, 2, 4}), 1e-12)
+}
+
+func TestCompare(t *testing.T) {
+	testutils.SmallTest(t)
+	noPatch := Samples{
+		"a":         {"m": {10, 10.1, 9.9, 10, 10.2, 9.8}, "n": {1}},
+		"b":         {"m": {20, 21, 19, 20, 22, 18}},
+		"c":         {"m": {0, 0}},
+		"nopatch":   {"m": {1}},
+		"unmatched": {"x": {1}},
+	}
+	withPatch := Samples{
+		"a":         {"m": {20, 20.1, 19.9, 20, 20.2, 19.8}, "n": {1}},
+		"b":         {"m": {20, 19, 21, 22, 18, 20}},
+		"c":         {"m": {1, 1}},
+		"withpatch": {"m": {1}},
+		"unmatched": {"y": {1}},
+	}
+	report := Compare(noPatch, withPatch, Options{})
+	expect.Equal(t, DEFAULT_SIGNIFICANCE_LEVEL, report.Options.SignificanceLevel)
+	expect.Equal(t, []string{"nopatch"}, report.NoPatchOnlyPages)
+	expect.Equal(t, []string{"withpatch"}, report.WithPatchOnlyPages)
+	expect.Len(t, report.Discarded, 0)
+
+	assert.Len(t, report.Comparisons, 4)
+	a := report.Comparisons[0]
+	expect.Equal(t, "a", a.Page)
+	expect.Equal(t, "m", a.Metric)
+	expect.True(t, a.Significant)
+	expect.InDelta(t, 10, a.NoPatch.Mean, 1e-9)
+	expect.InDelta(t, 20, a.WithPatch.Mean, 1e-9)
+	expect.InDelta(t, 10, a.Delta, 1e-9)
+	expect.InDelta(t, 100, a.PercentChange, 1e-9)
+	expect.Equal(t, 6, a.NoPatch.Count)
+	// Not significant, sorted by absolute percent change. The percent change of c is 0 since its
+	// nopatch mean is 0.
+	for _, c := range report.Comparisons[1:] {
+		expect.False(t, c.Significant, "%s %s", c.Page, c.Metric)
+		expect.Equal(t, 0.0, c.PercentChange, "%s %s", c.Page, c.Metric)
+	}
+	expect.InDelta(t, 1, report.Comparisons[1].Delta+report.Comparisons[2].Delta+report.Comparisons[3].Delta, 1e-9)
+
+	assert.Len(t, report.Metrics, 2)
+	m := report.Metrics[0]
+	expect.Equal(t, "m", m.Metric)
+	expect.Equal(t, 3, m.NumPages)
+	expect.Equal(t, 1, m.NumSignificant)
+	expect.InDelta(t, 30, m.NoPatchTotal, 1e-9)
+	expect.InDelta(t, 41, m.WithPatchTotal, 1e-9)
+	expect.InDelta(t, 11.0/30*100, m.PercentChange, 1e-9)
+	expect.Equal(t, "n", report.Metrics[1].Metric)
+}
+
+func TestCompareOptions(t *testing.T) {
+	testutils.SmallTest(t)
+	noPatch := Samples{}
+	withPatch := Samples{}
+	for i, change := range []float64{-50, -1, 0, 2, 5, 10, 20, 100} {
+		page := string(rune('a' + i))
+		noPatch.Add(page, "m", 100)
+		withPatch.Add(page, "m", 100+change)
+	}
+	report := Compare(noPatch, withPatch, Options{
+		DiscardOutliers:   20,
+		VarianceThreshold: 3,
+	})
+	// One page discarded at each end.
+	assert.Len(t, report.Discarded, 2)
+	expect.Equal(t, "h", report.Discarded[0].Page)
+	expect.Equal(t, "a", report.Discarded[1].Page)
+	assert.Len(t, report.Metrics, 1)
+	expect.Equal(t, 6, report.Metrics[0].NumPages)
+	expect.Equal(t, 2, report.Metrics[0].NumDiscarded)
+	// Pages below the variance threshold are not listed.
+	pages := []string{}
+	for _, c := range report.Comparisons {
+		pages = append(pages, c.Page)
+	}
+	expect.Equal(t, []string{"g", "f", "e"}, pages)
+	expect.InDelta(t, 36.0/600*100, report.Metrics[0].PercentChange, 1e-9)
+}
+
+func TestReportOutput(t *testing.T) {
+	testutils.SmallTest(t)
+	report := Compare(Samples{"http://a": {"m": {1, 2}}}, Samples{"http://a": {"m": {3, 4}}}, Options{})
+	report.ResultsLink = "https://example.com/index.html"
+
+	var buf bytes.Buffer
+	assert.NoError(t, report.WriteHTML(&buf))
+	expect.Contains(t, buf.String(), "http://a")
+	expect.Contains(t, buf.String(), "https://example.com/index.html")
+
+	buf.Reset()
+	assert.NoError(t, report.WriteJSON(&buf))
+	decoded := &Report{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), decoded))
+	expect.Equal(t, report, decoded)
+
+	dir, err := ioutil.TempDir("", "perf_comparer_test")
+	assert.NoError(t, err)
+	defer skutil.RemoveAll(dir)
+	assert.NoError(t, report.WriteFiles(filepath.Join(dir, "stats")))
+	for _, name := range []string{HTML_REPORT_NAME, JSON_REPORT_NAME} {
+		_, err := ioutil.ReadFile(filepath.Join(dir, "stats", name))
+		expect.NoError(t, err)
+	}
+	expect.False(t, math.IsNaN(report.Comparisons[0].PValue))
+}
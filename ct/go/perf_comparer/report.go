@@ -0,0 +1,151 @@
+package perf_comparer
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+
+	skutil "go.skia.org/infra/go/util"
+)
+
+const (
+	// The names of the files written by Report.WriteFiles.
+	HTML_REPORT_NAME = "index.html"
+	JSON_REPORT_NAME = "report.json"
+)
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"num": func(v float64) string {
+		return fmt.Sprintf("%.4g", v)
+	},
+	"percent": func(v float64) string {
+		return fmt.Sprintf("%+.2f%%", v)
+	},
+	"pvalue": func(v float64) string {
+		return fmt.Sprintf("%.4f", v)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Chromium perf statistical comparison</title>
+<style>
+  body { font-family: Arial, sans-serif; font-size: 13px; }
+  table { border-collapse: collapse; margin-bottom: 2em; }
+  th, td { border: 1px solid #ccc; padding: 3px 8px; text-align: right; }
+  th { background-color: #eee; }
+  td.name { text-align: left; }
+  tr.significant { background-color: #fff3cd; }
+</style>
+</head>
+<body>
+<h2>Chromium perf statistical comparison</h2>
+<p>
+{{if .ResultsLink}}<a href="{{.ResultsLink}}">Full results</a><br/>{{end}}
+{{if .NoPatchCSVLink}}<a href="{{.NoPatchCSVLink}}">Raw nopatch CSV</a><br/>{{end}}
+{{if .WithPatchCSVLink}}<a href="{{.WithPatchCSVLink}}">Raw withpatch CSV</a><br/>{{end}}
+<a href="` + JSON_REPORT_NAME + `">JSON report</a>
+</p>
+<p>
+Significance level: {{.Options.SignificanceLevel}} (two-sided Mann-Whitney U test).
+Variance threshold: {{.Options.VarianceThreshold}}%.
+Discarded outliers: {{.Options.DiscardOutliers}}% of pages at each end, per metric.
+</p>
+
+<h3>Metrics</h3>
+<table>
+<tr><th>Metric</th><th>Nopatch total</th><th>Withpatch total</th><th>% change</th><th>Pages</th><th>Significant pages</th><th>Discarded pages</th></tr>
+{{range .Metrics}}
+<tr>
+  <td class="name">{{.Metric}}</td><td>{{num .NoPatchTotal}}</td><td>{{num .WithPatchTotal}}</td><td>{{percent .PercentChange}}</td>
+  <td>{{.NumPages}}</td><td>{{.NumSignificant}}</td><td>{{.NumDiscarded}}</td>
+</tr>
+{{end}}
+</table>
+
+<h3>Pages</h3>
+{{template "comparisons" .Comparisons}}
+
+{{if .Discarded}}
+<h3>Discarded outliers</h3>
+{{template "comparisons" .Discarded}}
+{{end}}
+
+{{if .NoPatchOnlyPages}}
+<h3>Pages only in the nopatch run</h3>
+<ul>{{range .NoPatchOnlyPages}}<li>{{.}}</li>{{end}}</ul>
+{{end}}
+{{if .WithPatchOnlyPages}}
+<h3>Pages only in the withpatch run</h3>
+<ul>{{range .WithPatchOnlyPages}}<li>{{.}}</li>{{end}}</ul>
+{{end}}
+</body>
+</html>
+{{define "comparisons"}}
+<table>
+<tr>
+  <th>Page</th><th>Metric</th>
+  <th>Nopatch mean</th><th>Nopatch stddev</th><th>Nopatch runs</th>
+  <th>Withpatch mean</th><th>Withpatch stddev</th><th>Withpatch runs</th>
+  <th>Delta</th><th>% change</th><th>p-value</th>
+</tr>
+{{range .}}
+<tr{{if .Significant}} class="significant"{{end}}>
+  <td class="name">{{.Page}}</td><td class="name">{{.Metric}}</td>
+  <td>{{num .NoPatch.Mean}}</td><td>{{num .NoPatch.StdDev}}</td><td>{{.NoPatch.Count}}{{if .NoPatch.Outliers}} (+{{.NoPatch.Outliers}} outliers){{end}}</td>
+  <td>{{num .WithPatch.Mean}}</td><td>{{num .WithPatch.StdDev}}</td><td>{{.WithPatch.Count}}{{if .WithPatch.Outliers}} (+{{.WithPatch.Outliers}} outliers){{end}}</td>
+  <td>{{num .Delta}}</td><td>{{percent .PercentChange}}</td><td>{{pvalue .PValue}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+`))
+
+// WriteJSON writes the Report as JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to encode report: %s", err)
+	}
+	if _, err := w.Write(b); err != nil {
+		return fmt.Errorf("Failed to write report: %s", err)
+	}
+	return nil
+}
+
+// WriteHTML writes the Report as an HTML page.
+func (r *Report) WriteHTML(w io.Writer) error {
+	if err := reportTemplate.Execute(w, r); err != nil {
+		return fmt.Errorf("Failed to execute report template: %s", err)
+	}
+	return nil
+}
+
+// WriteFiles writes the HTML and JSON reports to HTML_REPORT_NAME and JSON_REPORT_NAME in the
+// given directory, which is created if necessary.
+func (r *Report) WriteFiles(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Failed to create %s: %s", dir, err)
+	}
+	if err := writeFile(filepath.Join(dir, HTML_REPORT_NAME), r.WriteHTML); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, JSON_REPORT_NAME), r.WriteJSON)
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Failed to create %s: %s", path, err)
+	}
+	if err := write(f); err != nil {
+		skutil.Close(f)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Failed to close %s: %s", path, err)
+	}
+	return nil
+}
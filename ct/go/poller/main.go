@@ -1,8 +1,9 @@
 /*
 	The Cluster Telemetry poller checks for new pending tasks by polling the Cluster Telemetry
-	frontend. Pending tasks are picked up according to the order they were added to CTFE.
-	When picked up, tasks are immediately executed. There could be multiple tasks running at the
-	same time.
+	frontend. Pending tasks are picked up in the order returned by CTFE, which takes task
+	priorities and fairness between users into account. When picked up, tasks are immediately
+	executed. Multiple tasks can run at the same time, limited by the number of tasks of each type
+	and the total number of tasks allowed to run concurrently.
 */
 
 package main
@@ -20,12 +21,6 @@ import (
 
 	"github.com/skia-dev/glog"
 
-	"go.skia.org/infra/ct/go/ctfe/admin_tasks"
-	"go.skia.org/infra/ct/go/ctfe/capture_skps"
-	"go.skia.org/infra/ct/go/ctfe/chromium_analysis"
-	"go.skia.org/infra/ct/go/ctfe/chromium_builds"
-	"go.skia.org/infra/ct/go/ctfe/chromium_perf"
-	"go.skia.org/infra/ct/go/ctfe/lua_scripts"
 	"go.skia.org/infra/ct/go/ctfe/task_common"
 	"go.skia.org/infra/ct/go/frontend"
 	"go.skia.org/infra/ct/go/master_scripts/master_common"
@@ -39,19 +34,27 @@ import (
 
 // flags
 var (
-	dryRun         = flag.Bool("dry_run", false, "If true, just log the commands that would be executed; don't actually execute the commands. Still polls CTFE for pending tasks, but does not post updates.")
-	influxHost     = flag.String("influxdb_host", influxdb.DEFAULT_HOST, "The InfluxDB hostname.")
-	influxUser     = flag.String("influxdb_name", influxdb.DEFAULT_USER, "The InfluxDB username.")
-	influxPassword = flag.String("influxdb_password", influxdb.DEFAULT_PASSWORD, "The InfluxDB password.")
-	influxDatabase = flag.String("influxdb_database", influxdb.DEFAULT_DATABASE, "The InfluxDB database.")
-	pollInterval   = flag.Duration("poll_interval", 30*time.Second, "How often to poll CTFE for new pending tasks.")
+	dryRun                 = flag.Bool("dry_run", false, "If true, just log the commands that would be executed; don't actually execute the commands. Still polls CTFE for pending tasks, but does not post updates.")
+	influxHost             = flag.String("influxdb_host", influxdb.DEFAULT_HOST, "The InfluxDB hostname.")
+	influxUser             = flag.String("influxdb_name", influxdb.DEFAULT_USER, "The InfluxDB username.")
+	influxPassword         = flag.String("influxdb_password", influxdb.DEFAULT_PASSWORD, "The InfluxDB password.")
+	influxDatabase         = flag.String("influxdb_database", influxdb.DEFAULT_DATABASE, "The InfluxDB database.")
+	pollInterval           = flag.Duration("poll_interval", 30*time.Second, "How often to poll CTFE for new pending tasks.")
+	maxConcurrentTasks     = flag.Int("max_concurrent_tasks", 4, "The maximum number of tasks of all types that run at the same time.")
+	defaultTaskConcurrency = flag.Int("default_task_concurrency", 1, "The maximum number of tasks of one type that run at the same time, unless overridden by --task_concurrency. With the default of 1, tasks of different types run at the same time, up to --max_concurrent_tasks; set --max_concurrent_tasks=1 to run only one task at a time as the poller used to.")
+	maxCheckoutAge         = flag.Duration("max_checkout_age", time.Hour, "Once the local checkout has not been synced for this long, new tasks wait until the running tasks finish so that it can be synced.")
+	taskConcurrencyFlag    = flag.String("task_concurrency", "", "Comma-separated list of TaskName=N pairs, e.g. \"LuaScript=2,ChromiumBuild=1\", which override --default_task_concurrency for the given task types.")
 	// Value of --log_dir flag to pass to subcommands. Will be set in main.
 	logDir = "/b/storage/glog"
-	// Mutex that controls updating and building of the local checkout.
-	repoMtx = sync.Mutex{}
+	// The local checkout, shared by all running tasks.
+	repo = newCheckout()
 	// Map that holds all picked up tasks. Used to ensure same task is not picked up more than once.
 	pickedUpTasks = map[string]string{}
-	// Mutex that controls access to the above map.
+	// Number of running tasks of each task type.
+	runningTasks = map[string]int{}
+	// Maximum number of running tasks of each task type, parsed from --task_concurrency.
+	taskConcurrency = map[string]int{}
+	// Mutex that controls access to the above maps.
 	tasksMtx = sync.Mutex{}
 )
 
@@ -100,8 +103,6 @@ func (t TaskType) String() string {
 
 // Runs "git pull; make all".
 func updateAndBuild() error {
-	repoMtx.Lock()
-	defer repoMtx.Unlock()
 	makefilePath := ctutil.CtTreeDir
 
 	// TODO(benjaminwagner): Should this also do 'go get -u ...' and/or 'gclient sync'?
@@ -126,245 +127,160 @@ func updateAndBuild() error {
 	})
 }
 
-// Specifies the methods that poll requires for each type of task.
-type Task interface {
-	GetTaskName() string
-	GetCommonCols() *task_common.CommonCols
-	// Writes any files required by the task and then uses exec.Run to run the task command.
-	Execute() error
-	// Returns the corresponding UpdateTaskVars instance of this Task. The
-	// returned instance is not populated.
-	GetUpdateTaskVars() task_common.UpdateTaskVars
+// checkout is the local checkout used by all running tasks. It is reference counted: it is only
+// synced and rebuilt when no running task uses it, so that the binaries of a running task do not
+// change underneath it. Once the checkout is older than --max_checkout_age it is no longer handed
+// out; new users wait until the current users are done and the checkout has been synced.
+type checkout struct {
+	mtx sync.Mutex
+	// drained is signalled when the last user releases the checkout.
+	drained *sync.Cond
+	users   int
+	// synced is the time the checkout was last synced and built.
+	synced time.Time
 }
 
-// Generates a hopefully-unique ID for this execution of this task.
-func runId(task Task) string {
-	// TODO(benjaminwagner): May need a way to ensure that run IDs are unique. It is currently
-	// possible, though very unlikely, for GetOldestPendingTaskV2 to take
-	// ~(pollInterval - 1 second) and for the returned task to fail very quickly, in which case
-	// the next task would could start within the same second as the first task.
-	return strings.SplitN(task.GetCommonCols().Username, "@", 2)[0] + "-" + ctutil.GetCurrentTs()
+func newCheckout() *checkout {
+	c := &checkout{}
+	c.drained = sync.NewCond(&c.mtx)
+	return c
 }
 
-// Define frontend.ChromiumAnalysisDBTask here so we can add methods.
-type ChromiumAnalysisTask struct {
-	chromium_analysis.DBTask
-}
-
-func (task *ChromiumAnalysisTask) Execute() error {
-	runId := runId(task)
-	for fileSuffix, patch := range map[string]string{
-		".chromium.patch":      task.ChromiumPatch,
-		".catapult.patch":      task.CatapultPatch,
-		".benchmark.patch":     task.BenchmarkPatch,
-		".custom_webpages.csv": task.CustomWebpages,
-	} {
-		// Add an extra newline at the end because git sometimes rejects patches due to
-		// missing newlines.
-		patch = patch + "\n"
-		patchPath := filepath.Join(os.TempDir(), runId+fileSuffix)
-		if err := ioutil.WriteFile(patchPath, []byte(patch), 0666); err != nil {
+// acquire adds a user of the checkout, syncing and building it first if there are no other
+// users. If the checkout is too old to be handed out, acquire waits for the other users to
+// release it before syncing.
+func (c *checkout) acquire() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for c.users > 0 && time.Since(c.synced) > *maxCheckoutAge {
+		glog.Infof("Checkout was synced at %s; waiting for %d running tasks before syncing it", c.synced, c.users)
+		c.drained.Wait()
+	}
+	if c.users == 0 {
+		if err := updateAndBuild(); err != nil {
 			return err
 		}
-		defer skutil.Remove(patchPath)
+		c.synced = time.Now()
 	}
-	return exec.Run(&exec.Command{
-		Name: "run_chromium_analysis_on_workers",
-		Args: []string{
-			"--emails=" + task.Username,
-			"--description=" + task.Description,
-			"--gae_task_id=" + strconv.FormatInt(task.Id, 10),
-			"--pageset_type=" + task.PageSets,
-			"--benchmark_name=" + task.Benchmark,
-			"--benchmark_extra_args=" + task.BenchmarkArgs,
-			"--browser_extra_args=" + task.BrowserArgs,
-			"--run_in_parallel=" + strconv.FormatBool(task.RunInParallel),
-			"--target_platform=" + task.Platform,
-			"--run_on_gce=" + strconv.FormatBool(task.RunOnGCE),
-			"--run_id=" + runId,
-			"--log_dir=" + logDir,
-			"--log_id=" + runId,
-			fmt.Sprintf("--local=%t", *master_common.Local),
-		},
-	})
+	c.users++
+	return nil
 }
 
-// Define frontend.ChromiumPerfDBTask here so we can add methods.
-type ChromiumPerfTask struct {
-	chromium_perf.DBTask
+// release removes a user added by acquire.
+func (c *checkout) release() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.users--
+	if c.users == 0 {
+		c.drained.Broadcast()
+	}
 }
 
-func (task *ChromiumPerfTask) Execute() error {
-	runId := runId(task)
-	// TODO(benjaminwagner): Since run_chromium_perf_on_workers only reads these in order to
-	// upload to Google Storage, eventually we should move the upload step here to avoid writing
-	// to disk.
-	for fileSuffix, patch := range map[string]string{
-		".chromium.patch":      task.ChromiumPatch,
-		".skia.patch":          task.SkiaPatch,
-		".catapult.patch":      task.CatapultPatch,
-		".benchmark.patch":     task.BenchmarkPatch,
-		".custom_webpages.csv": task.CustomWebpages,
-	} {
-		// Add an extra newline at the end because git sometimes rejects patches due to
-		// missing newlines.
-		patch = patch + "\n"
-		patchPath := filepath.Join(os.TempDir(), runId+fileSuffix)
-		if err := ioutil.WriteFile(patchPath, []byte(patch), 0666); err != nil {
-			return err
+// Parses the value of --task_concurrency.
+func parseTaskConcurrency(value string) (map[string]int, error) {
+	ret := map[string]int{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
 		}
-		defer skutil.Remove(patchPath)
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("Invalid task concurrency %q; expected TaskName=N", pair)
+		}
+		limit, err := strconv.Atoi(split[1])
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("Invalid task concurrency %q; expected TaskName=N", pair)
+		}
+		ret[split[0]] = limit
 	}
-	return exec.Run(&exec.Command{
-		Name: "run_chromium_perf_on_workers",
-		Args: []string{
-			"--emails=" + task.Username,
-			"--description=" + task.Description,
-			"--gae_task_id=" + strconv.FormatInt(task.Id, 10),
-			"--pageset_type=" + task.PageSets,
-			"--benchmark_name=" + task.Benchmark,
-			"--benchmark_extra_args=" + task.BenchmarkArgs,
-			"--browser_extra_args_nopatch=" + task.BrowserArgsNoPatch,
-			"--browser_extra_args_withpatch=" + task.BrowserArgsWithPatch,
-			"--repeat_benchmark=" + strconv.FormatInt(task.RepeatRuns, 10),
-			"--run_in_parallel=" + strconv.FormatBool(task.RunInParallel),
-			"--target_platform=" + task.Platform,
-			"--run_id=" + runId,
-			"--log_dir=" + logDir,
-			"--log_id=" + runId,
-			fmt.Sprintf("--local=%t", *master_common.Local),
-		},
-	})
-}
-
-// Define frontend.CaptureSkpsDBTask here so we can add methods.
-type CaptureSkpsTask struct {
-	capture_skps.DBTask
-}
-
-func (task *CaptureSkpsTask) Execute() error {
-	runId := runId(task)
-	chromiumBuildDir := ctutil.ChromiumBuildDir(task.ChromiumRev, task.SkiaRev, "")
-	return exec.Run(&exec.Command{
-		Name: "capture_skps_on_workers",
-		Args: []string{
-			"--emails=" + task.Username,
-			"--description=" + task.Description,
-			"--gae_task_id=" + strconv.FormatInt(task.Id, 10),
-			"--pageset_type=" + task.PageSets,
-			"--chromium_build=" + chromiumBuildDir,
-			"--target_platform=Linux",
-			"--run_id=" + runId,
-			"--log_dir=" + logDir,
-			"--log_id=" + runId,
-			fmt.Sprintf("--local=%t", *master_common.Local),
-		},
-	})
-}
-
-// Define frontend.LuaScriptDBTask here so we can add methods.
-type LuaScriptTask struct {
-	lua_scripts.DBTask
+	return ret, nil
 }
 
-func (task *LuaScriptTask) Execute() error {
-	runId := runId(task)
-	chromiumBuildDir := ctutil.ChromiumBuildDir(task.ChromiumRev, task.SkiaRev, "")
-	// TODO(benjaminwagner): Since run_lua_on_workers only reads the lua script in order to
-	// upload to Google Storage, eventually we should move the upload step here to avoid writing
-	// to disk. Not sure if we can/should do the same for the aggregator script.
-	luaScriptName := runId + ".lua"
-	luaScriptPath := filepath.Join(os.TempDir(), luaScriptName)
-	if err := ioutil.WriteFile(luaScriptPath, []byte(task.LuaScript), 0666); err != nil {
-		return err
+// Marks the given task as picked up and running if it is not already picked up and if the
+// concurrency limits allow another task of its type to run. Returns true if the task was marked.
+func reserveTask(taskName string, id int64) bool {
+	tasksMtx.Lock()
+	defer tasksMtx.Unlock()
+	if _, exists := pickedUpTasks[fmt.Sprintf("%s.%d", taskName, id)]; exists {
+		return false
 	}
-	defer skutil.Remove(luaScriptPath)
-	if task.LuaAggregatorScript != "" {
-		luaAggregatorName := runId + ".aggregator"
-		luaAggregatorPath := filepath.Join(os.TempDir(), luaAggregatorName)
-		if err := ioutil.WriteFile(luaAggregatorPath, []byte(task.LuaAggregatorScript), 0666); err != nil {
-			return err
-		}
-		defer skutil.Remove(luaAggregatorPath)
+	limit, ok := taskConcurrency[taskName]
+	if !ok {
+		limit = *defaultTaskConcurrency
 	}
-	return exec.Run(&exec.Command{
-		Name: "run_lua_on_workers",
-		Args: []string{
-			"--emails=" + task.Username,
-			"--description=" + task.Description,
-			"--gae_task_id=" + strconv.FormatInt(task.Id, 10),
-			"--pageset_type=" + task.PageSets,
-			"--chromium_build=" + chromiumBuildDir,
-			"--run_id=" + runId,
-			"--log_dir=" + logDir,
-			"--log_id=" + runId,
-			fmt.Sprintf("--local=%t", *master_common.Local),
-		},
-	})
-}
-
-// Define frontend.ChromiumBuildDBTask here so we can add methods.
-type ChromiumBuildTask struct {
-	chromium_builds.DBTask
+	if runningTasks[taskName] >= limit {
+		return false
+	}
+	total := 0
+	for _, n := range runningTasks {
+		total += n
+	}
+	if total >= *maxConcurrentTasks {
+		return false
+	}
+	pickedUpTasks[fmt.Sprintf("%s.%d", taskName, id)] = "1"
+	runningTasks[taskName]++
+	return true
 }
 
-func (task *ChromiumBuildTask) Execute() error {
-	runId := runId(task)
-	return exec.Run(&exec.Command{
-		Name: "build_chromium",
-		Args: []string{
-			"--emails=" + task.Username,
-			"--gae_task_id=" + strconv.FormatInt(task.Id, 10),
-			"--run_id=" + runId,
-			"--target_platform=Linux",
-			"--chromium_hash=" + task.ChromiumRev,
-			"--skia_hash=" + task.SkiaRev,
-			"--log_dir=" + logDir,
-			"--log_id=" + runId,
-			fmt.Sprintf("--local=%t", *master_common.Local),
-		},
-	})
+// Reverts reserveTask.
+func releaseTask(taskName string, id int64) {
+	tasksMtx.Lock()
+	defer tasksMtx.Unlock()
+	delete(pickedUpTasks, fmt.Sprintf("%s.%d", taskName, id))
+	runningTasks[taskName]--
 }
 
-// Define frontend.RecreatePageSetsDBTask here so we can add methods.
-type RecreatePageSetsTask struct {
-	admin_tasks.RecreatePageSetsDBTask
+// Specifies the methods that poll requires for each type of task.
+type Task interface {
+	GetTaskName() string
+	GetCommonCols() *task_common.CommonCols
+	// Writes any files required by the task and then uses exec.Run to run the task command.
+	Execute() error
+	// Returns the corresponding UpdateTaskVars instance of this Task. The
+	// returned instance is not populated.
+	GetUpdateTaskVars() task_common.UpdateTaskVars
 }
 
-func (task *RecreatePageSetsTask) Execute() error {
-	runId := runId(task)
-	return exec.Run(&exec.Command{
-		Name: "create_pagesets_on_workers",
-		Args: []string{
-			"--emails=" + task.Username,
-			"--gae_task_id=" + strconv.FormatInt(task.Id, 10),
-			"--run_id=" + runId,
-			"--pageset_type=" + task.PageSets,
-			"--log_dir=" + logDir,
-			"--log_id=" + runId,
-			fmt.Sprintf("--local=%t", *master_common.Local),
-		},
-	})
+// Generates a hopefully-unique ID for this execution of this task.
+func runId(task Task) string {
+	// TODO(benjaminwagner): May need a way to ensure that run IDs are unique. It is currently
+	// possible, though very unlikely, for GetOldestPendingTaskV2 to take
+	// ~(pollInterval - 1 second) and for the returned task to fail very quickly, in which case
+	// the next task would could start within the same second as the first task.
+	return strings.SplitN(task.GetCommonCols().Username, "@", 2)[0] + "-" + ctutil.GetCurrentTs()
 }
 
-// Define frontend.RecreateWebpageArchivesDBTask here so we can add methods.
-type RecreateWebpageArchivesTask struct {
-	admin_tasks.RecreateWebpageArchivesDBTask
+// PollerTask executes a task of any registered type by running its master script.
+type PollerTask struct {
+	task_common.Task
 }
 
-func (task *RecreateWebpageArchivesTask) Execute() error {
+func (task *PollerTask) Execute() error {
+	script, err := task.GetMasterScript()
+	if err != nil {
+		return fmt.Errorf("Failed to get the master script of task %s %d: %s", task.GetTaskName(), task.GetCommonCols().Id, err)
+	}
 	runId := runId(task)
+	for fileSuffix, contents := range script.Files {
+		path := filepath.Join(os.TempDir(), runId+fileSuffix)
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			return err
+		}
+		defer skutil.Remove(path)
+	}
+	args := []string{
+		"--emails=" + task.GetCommonCols().Username,
+		"--gae_task_id=" + strconv.FormatInt(task.GetCommonCols().Id, 10),
+		"--run_id=" + runId,
+		"--log_dir=" + logDir,
+		"--log_id=" + runId,
+		fmt.Sprintf("--local=%t", *master_common.Local),
+	}
 	return exec.Run(&exec.Command{
-		Name: "capture_archives_on_workers",
-		Args: []string{
-			"--emails=" + task.Username,
-			"--gae_task_id=" + strconv.FormatInt(task.Id, 10),
-			"--run_id=" + runId,
-			"--pageset_type=" + task.PageSets,
-			"--log_dir=" + logDir,
-			"--log_id=" + runId,
-			fmt.Sprintf("--local=%t", *master_common.Local),
-		},
+		Name: script.Name,
+		Args: append(args, script.Args...),
 	})
 }
 
@@ -373,25 +289,7 @@ func asPollerTask(otherTask task_common.Task) Task {
 	if otherTask == nil {
 		return nil
 	}
-	switch t := otherTask.(type) {
-	case *chromium_perf.DBTask:
-		return &ChromiumPerfTask{DBTask: *t}
-	case *capture_skps.DBTask:
-		return &CaptureSkpsTask{DBTask: *t}
-	case *lua_scripts.DBTask:
-		return &LuaScriptTask{DBTask: *t}
-	case *chromium_builds.DBTask:
-		return &ChromiumBuildTask{DBTask: *t}
-	case *admin_tasks.RecreatePageSetsDBTask:
-		return &RecreatePageSetsTask{RecreatePageSetsDBTask: *t}
-	case *admin_tasks.RecreateWebpageArchivesDBTask:
-		return &RecreateWebpageArchivesTask{RecreateWebpageArchivesDBTask: *t}
-	case *chromium_analysis.DBTask:
-		return &ChromiumAnalysisTask{DBTask: *t}
-	default:
-		glog.Errorf("Missing case for %T in asPollerTask", otherTask)
-		return nil
-	}
+	return &PollerTask{Task: otherTask}
 }
 
 // Notifies the frontend that task failed.
@@ -402,59 +300,55 @@ func updateWebappTaskSetFailed(task Task) error {
 	return frontend.UpdateWebappTaskV2(updateVars)
 }
 
-// pollAndExecOnce looks for the oldest pending task in CTFE. If one is found, then
-// the local checkout is synced and built, and the picked up task is started in a
-// go routine. The function returns without waiting for the task to finish and the
-// WaitGroup of the goroutine is returned to the caller. The caller can then call
-// wg.Wait() if they would like to wait for the task to finish.
+// pollAndExecOnce looks for pending tasks in CTFE. Pending tasks are picked up in the order
+// returned by CTFE, skipping those that are already running and those whose type has reached its
+// concurrency limit. For each picked up task the shared local checkout is acquired, which syncs
+// and builds it if no other task uses it and waits for the running tasks if it is too old, and the
+// task is started in a go routine. The function
+// returns without waiting for the tasks to finish and the WaitGroup of the goroutines is returned
+// to the caller. The caller can then call wg.Wait() if they would like to wait for the tasks to
+// finish.
 func pollAndExecOnce() *sync.WaitGroup {
-	pending, err := frontend.GetOldestPendingTaskV2()
+	pending, err := frontend.GetPendingTasksV2()
 	var wg sync.WaitGroup
 	if err != nil {
 		glog.Error(err)
 		return &wg
 	}
-	task := asPollerTask(pending)
-	if task == nil {
-		return &wg
-	}
-
-	taskName, id := task.GetTaskName(), task.GetCommonCols().Id
-	tasksMtx.Lock()
-	_, exists := pickedUpTasks[fmt.Sprintf("%s.%d", taskName, id)]
-	tasksMtx.Unlock()
-	if exists {
-		return &wg
-	}
-	tasksMtx.Lock()
-	pickedUpTasks[fmt.Sprintf("%s.%d", taskName, id)] = "1"
-	tasksMtx.Unlock()
+	for _, pendingTask := range pending {
+		task := asPollerTask(pendingTask)
+		taskName, id := task.GetTaskName(), task.GetCommonCols().Id
+		if !reserveTask(taskName, id) {
+			continue
+		}
 
-	glog.Infof("Preparing to execute task %s %d", taskName, id)
-	if err = updateAndBuild(); err != nil {
-		glog.Error(err)
-		return &wg
-	}
-	glog.Infof("Executing task %s %d", taskName, id)
-	// Increment the WaitGroup counter.
-	wg.Add(1)
-	go func() {
-		// Decrement the counter when the goroutine completes.
-		defer wg.Done()
-		if err = task.Execute(); err == nil {
-			glog.Infof("Completed task %s %d", taskName, id)
-		} else {
-			glog.Errorf("Task %s %d failed: %v", taskName, id, err)
-			if !*dryRun {
-				if err := updateWebappTaskSetFailed(task); err != nil {
-					glog.Error(err)
+		glog.Infof("Preparing to execute task %s %d", taskName, id)
+		if err = repo.acquire(); err != nil {
+			glog.Error(err)
+			releaseTask(taskName, id)
+			// Other tasks would fail to acquire the checkout as well.
+			return &wg
+		}
+		glog.Infof("Executing task %s %d", taskName, id)
+		// Increment the WaitGroup counter.
+		wg.Add(1)
+		go func(task Task, taskName string, id int64) {
+			// Decrement the counter when the goroutine completes.
+			defer wg.Done()
+			defer repo.release()
+			defer releaseTask(taskName, id)
+			if err := task.Execute(); err == nil {
+				glog.Infof("Completed task %s %d", taskName, id)
+			} else {
+				glog.Errorf("Task %s %d failed: %v", taskName, id, err)
+				if !*dryRun {
+					if err := updateWebappTaskSetFailed(task); err != nil {
+						glog.Error(err)
+					}
 				}
 			}
-		}
-		tasksMtx.Lock()
-		delete(pickedUpTasks, fmt.Sprintf("%s.%d", taskName, id))
-		tasksMtx.Unlock()
-	}()
+		}(task, taskName, id)
+	}
 	// Return the WaitGroup to allow some callers to call wg.Wait()
 	return &wg
 }
@@ -467,6 +361,11 @@ func main() {
 		logDir = logDirFlag.Value.String()
 	}
 
+	var err error
+	if taskConcurrency, err = parseTaskConcurrency(*taskConcurrencyFlag); err != nil {
+		glog.Fatal(err)
+	}
+
 	if *dryRun {
 		exec.SetRunForTesting(func(command *exec.Command) error {
 			glog.Infof("dry_run: %s", exec.DebugString(command))
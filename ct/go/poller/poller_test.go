@@ -10,11 +10,13 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"go.skia.org/infra/ct/go/ctfe/admin_tasks"
 	"go.skia.org/infra/ct/go/ctfe/capture_skps"
 	"go.skia.org/infra/ct/go/ctfe/chromium_builds"
 	"go.skia.org/infra/ct/go/ctfe/chromium_perf"
+	"go.skia.org/infra/ct/go/ctfe/generic_tasks"
 	"go.skia.org/infra/ct/go/ctfe/lua_scripts"
 	"go.skia.org/infra/ct/go/ctfe/task_common"
 	ctfeutil "go.skia.org/infra/ct/go/ctfe/util"
@@ -59,9 +61,9 @@ func assertFileContents(t *testing.T, filepath, expected string) {
 	assert.Equal(t, expected, string(actual))
 }
 
-func pendingChromiumPerfTask() ChromiumPerfTask {
-	return ChromiumPerfTask{
-		DBTask: chromium_perf.DBTask{
+func pendingChromiumPerfTask() PollerTask {
+	return PollerTask{
+		Task: &chromium_perf.DBTask{
 			CommonCols:           pendingCommonCols(),
 			Benchmark:            "benchmark",
 			Platform:             "Linux",
@@ -111,9 +113,9 @@ func TestChromiumPerfExecute(t *testing.T) {
 	expect.NotNil(t, cmd.Timeout)
 }
 
-func pendingCaptureSkpsTask() CaptureSkpsTask {
-	return CaptureSkpsTask{
-		DBTask: capture_skps.DBTask{
+func pendingCaptureSkpsTask() PollerTask {
+	return PollerTask{
+		Task: &capture_skps.DBTask{
 			CommonCols:  pendingCommonCols(),
 			PageSets:    "All",
 			ChromiumRev: "c14d891d44f0afff64e56ed7c9702df1d807b1ee",
@@ -144,9 +146,9 @@ func TestCaptureSkpsExecute(t *testing.T) {
 	expect.NotNil(t, cmd.Timeout)
 }
 
-func pendingLuaScriptTaskWithAggregator() LuaScriptTask {
-	return LuaScriptTask{
-		DBTask: lua_scripts.DBTask{
+func pendingLuaScriptTaskWithAggregator() PollerTask {
+	return PollerTask{
+		Task: &lua_scripts.DBTask{
 			CommonCols:          pendingCommonCols(),
 			PageSets:            "All",
 			ChromiumRev:         "c14d891d44f0afff64e56ed7c9702df1d807b1ee",
@@ -189,8 +191,8 @@ func TestLuaScriptExecuteWithAggregator(t *testing.T) {
 
 func TestLuaScriptExecuteWithoutAggregator(t *testing.T) {
 	testutils.SmallTest(t)
-	task := LuaScriptTask{
-		DBTask: lua_scripts.DBTask{
+	task := PollerTask{
+		Task: &lua_scripts.DBTask{
 			CommonCols:          pendingCommonCols(),
 			PageSets:            "All",
 			ChromiumRev:         "c14d891d44f0afff64e56ed7c9702df1d807b1ee",
@@ -225,9 +227,9 @@ func TestLuaScriptExecuteWithoutAggregator(t *testing.T) {
 	expect.NotNil(t, cmd.Timeout)
 }
 
-func pendingChromiumBuildTask() ChromiumBuildTask {
-	return ChromiumBuildTask{
-		DBTask: chromium_builds.DBTask{
+func pendingChromiumBuildTask() PollerTask {
+	return PollerTask{
+		Task: &chromium_builds.DBTask{
 			CommonCols:    pendingCommonCols(),
 			ChromiumRev:   "c14d891d44f0afff64e56ed7c9702df1d807b1ee",
 			ChromiumRevTs: sql.NullInt64{Int64: 20080726180513, Valid: true},
@@ -258,9 +260,9 @@ func TestChromiumBuildExecute(t *testing.T) {
 	expect.NotNil(t, cmd.Timeout)
 }
 
-func pendingRecreatePageSetsTask() RecreatePageSetsTask {
-	return RecreatePageSetsTask{
-		RecreatePageSetsDBTask: admin_tasks.RecreatePageSetsDBTask{
+func pendingRecreatePageSetsTask() PollerTask {
+	return PollerTask{
+		Task: &admin_tasks.RecreatePageSetsDBTask{
 			CommonCols: pendingCommonCols(),
 			PageSets:   "All",
 		},
@@ -286,9 +288,9 @@ func TestRecreatePageSetsExecute(t *testing.T) {
 	expect.NotNil(t, cmd.Timeout)
 }
 
-func pendingRecreateWebpageArchivesTask() RecreateWebpageArchivesTask {
-	return RecreateWebpageArchivesTask{
-		RecreateWebpageArchivesDBTask: admin_tasks.RecreateWebpageArchivesDBTask{
+func pendingRecreateWebpageArchivesTask() PollerTask {
+	return PollerTask{
+		Task: &admin_tasks.RecreateWebpageArchivesDBTask{
 			CommonCols:  pendingCommonCols(),
 			PageSets:    "All",
 			ChromiumRev: "c14d891d44f0afff64e56ed7c9702df1d807b1ee",
@@ -316,38 +318,90 @@ func TestRecreateWebpageArchivesExecute(t *testing.T) {
 	expect.NotNil(t, cmd.Timeout)
 }
 
+func TestGenericTaskExecute(t *testing.T) {
+	testutils.SmallTest(t)
+	generic_tasks.Register(&generic_tasks.Spec{
+		Name:   "PollerTest",
+		Fields: []generic_tasks.Field{{Name: "script", Type: generic_tasks.FIELD_TEXTAREA}},
+		MasterScript: func(description string, params map[string]string) *task_common.MasterScript {
+			return &task_common.MasterScript{
+				Name:  "run_poller_test_on_workers",
+				Args:  []string{"--description=" + description},
+				Files: map[string]string{".script": params["script"]},
+			}
+		},
+	})
+	task := PollerTask{
+		Task: &generic_tasks.DBTask{
+			CommonCols:  pendingCommonCols(),
+			TaskType:    "PollerTest",
+			Parameters:  `{"script":"echo hello"}`,
+			Description: "description",
+		},
+	}
+	mockRun := exec.CommandCollector{}
+	exec.SetRunForTesting(mockRun.Run)
+	defer exec.SetRunForTesting(exec.DefaultRun)
+	mockRun.SetDelegateRun(func(cmd *exec.Command) error {
+		runId := getRunId(t, cmd)
+		assertFileContents(t, filepath.Join(os.TempDir(), runId+".script"), "echo hello")
+		return nil
+	})
+	err := task.Execute()
+	assert.NoError(t, err)
+	assert.Len(t, mockRun.Commands(), 1)
+	cmd := mockRun.Commands()[0]
+	expect.Equal(t, "run_poller_test_on_workers", cmd.Name)
+	expect.Contains(t, cmd.Args, "--gae_task_id=42")
+	expect.Contains(t, cmd.Args, "--description=description")
+	expect.Contains(t, cmd.Args, "--emails=nobody@chromium.org")
+	runId := getRunId(t, cmd)
+	expect.Contains(t, cmd.Args, "--log_id="+runId)
+
+	// Tasks of unknown generic types fail without running anything.
+	task = PollerTask{
+		Task: &generic_tasks.DBTask{
+			CommonCols: pendingCommonCols(),
+			TaskType:   "Unknown",
+			Parameters: "{}",
+		},
+	}
+	assert.Error(t, task.Execute())
+	assert.Len(t, mockRun.Commands(), 1)
+}
+
 func TestAsPollerTask(t *testing.T) {
 	testutils.SmallTest(t)
 	expect.Nil(t, asPollerTask(nil))
 	{
 		taskStruct := pendingChromiumPerfTask()
-		taskInterface := asPollerTask(&taskStruct.DBTask)
-		expect.Equal(t, taskStruct, *taskInterface.(*ChromiumPerfTask))
+		taskInterface := asPollerTask(taskStruct.Task)
+		expect.Equal(t, taskStruct, *taskInterface.(*PollerTask))
 	}
 	{
 		taskStruct := pendingCaptureSkpsTask()
-		taskInterface := asPollerTask(&taskStruct.DBTask)
-		expect.Equal(t, taskStruct, *taskInterface.(*CaptureSkpsTask))
+		taskInterface := asPollerTask(taskStruct.Task)
+		expect.Equal(t, taskStruct, *taskInterface.(*PollerTask))
 	}
 	{
 		taskStruct := pendingLuaScriptTaskWithAggregator()
-		taskInterface := asPollerTask(&taskStruct.DBTask)
-		expect.Equal(t, taskStruct, *taskInterface.(*LuaScriptTask))
+		taskInterface := asPollerTask(taskStruct.Task)
+		expect.Equal(t, taskStruct, *taskInterface.(*PollerTask))
 	}
 	{
 		taskStruct := pendingChromiumBuildTask()
-		taskInterface := asPollerTask(&taskStruct.DBTask)
-		expect.Equal(t, taskStruct, *taskInterface.(*ChromiumBuildTask))
+		taskInterface := asPollerTask(taskStruct.Task)
+		expect.Equal(t, taskStruct, *taskInterface.(*PollerTask))
 	}
 	{
 		taskStruct := pendingRecreatePageSetsTask()
-		taskInterface := asPollerTask(&taskStruct.RecreatePageSetsDBTask)
-		expect.Equal(t, taskStruct, *taskInterface.(*RecreatePageSetsTask))
+		taskInterface := asPollerTask(taskStruct.Task)
+		expect.Equal(t, taskStruct, *taskInterface.(*PollerTask))
 	}
 	{
 		taskStruct := pendingRecreateWebpageArchivesTask()
-		taskInterface := asPollerTask(&taskStruct.RecreateWebpageArchivesDBTask)
-		expect.Equal(t, taskStruct, *taskInterface.(*RecreateWebpageArchivesTask))
+		taskInterface := asPollerTask(taskStruct.Task)
+		expect.Equal(t, taskStruct, *taskInterface.(*PollerTask))
 	}
 }
 
@@ -395,7 +449,7 @@ func TestPollAndExecOnce(t *testing.T) {
 	testutils.SmallTest(t)
 	task := pendingRecreateWebpageArchivesTask()
 	mockServer := frontend.MockServer{}
-	mockServer.SetCurrentTask(&task.RecreateWebpageArchivesDBTask)
+	mockServer.SetCurrentTask(task.Task)
 	defer frontend.CloseTestServer(frontend.InitTestServer(&mockServer))
 	mockExec := exec.CommandCollector{}
 	exec.SetRunForTesting(mockExec.Run)
@@ -403,7 +457,7 @@ func TestPollAndExecOnce(t *testing.T) {
 	wg := pollAndExecOnce()
 	wg.Wait()
 	// Expect only one poll.
-	expect.Equal(t, 1, mockServer.OldestPendingTaskReqCount())
+	expect.Equal(t, 1, mockServer.PendingTasksReqCount())
 	// Expect three commands: git pull; make all; capture_archives_on_workers ...
 	commands := mockExec.Commands()
 	assert.Len(t, commands, 3)
@@ -419,7 +473,7 @@ func TestPollAndExecOnceMultipleTasks(t *testing.T) {
 	testutils.SmallTest(t)
 	task1 := pendingRecreateWebpageArchivesTask()
 	mockServer := frontend.MockServer{}
-	mockServer.SetCurrentTask(&task1.RecreateWebpageArchivesDBTask)
+	mockServer.SetCurrentTask(task1.Task)
 	defer frontend.CloseTestServer(frontend.InitTestServer(&mockServer))
 	mockExec := exec.CommandCollector{}
 	exec.SetRunForTesting(mockExec.Run)
@@ -429,13 +483,13 @@ func TestPollAndExecOnceMultipleTasks(t *testing.T) {
 	wg1.Wait() // Wait for task to return to make asserting commands deterministic.
 	// Update current task.
 	task2 := pendingChromiumPerfTask()
-	mockServer.SetCurrentTask(&task2.DBTask)
+	mockServer.SetCurrentTask(task2.Task)
 	// Poll frontend and execute the second task.
 	wg2 := pollAndExecOnce()
 	wg2.Wait() // Wait for task to return to make asserting commands deterministic.
 
 	// Expect two pending task requests.
-	expect.Equal(t, 2, mockServer.OldestPendingTaskReqCount())
+	expect.Equal(t, 2, mockServer.PendingTasksReqCount())
 	// Expect six commands: git pull; make all; capture_archives_on_workers ...; git pull;
 	// make all; run_chromium_perf_on_workers ...
 	commands := mockExec.Commands()
@@ -454,7 +508,7 @@ func TestPollAndExecOnceError(t *testing.T) {
 	testutils.SmallTest(t)
 	task := pendingRecreateWebpageArchivesTask()
 	mockServer := frontend.MockServer{}
-	mockServer.SetCurrentTask(&task.RecreateWebpageArchivesDBTask)
+	mockServer.SetCurrentTask(task.Task)
 	defer frontend.CloseTestServer(frontend.InitTestServer(&mockServer))
 	commandCollector := exec.CommandCollector{}
 	mockRun := exec.MockRun{}
@@ -465,7 +519,7 @@ func TestPollAndExecOnceError(t *testing.T) {
 	wg := pollAndExecOnce()
 	wg.Wait()
 	// Expect only one poll.
-	expect.Equal(t, 1, mockServer.OldestPendingTaskReqCount())
+	expect.Equal(t, 1, mockServer.PendingTasksReqCount())
 	// Expect three commands: git pull; make all; capture_archives_on_workers ...
 	commands := commandCollector.Commands()
 	assert.Len(t, commands, 3)
@@ -501,9 +555,99 @@ func TestPollAndExecOnceNoTasks(t *testing.T) {
 	wg1.Wait()
 	wg2.Wait()
 	wg3.Wait()
-	expect.Equal(t, 3, mockServer.OldestPendingTaskReqCount())
+	expect.Equal(t, 3, mockServer.PendingTasksReqCount())
 	// Expect no commands.
 	expect.Empty(t, mockExec.Commands())
 	// No updates expected.
 	expect.Empty(t, mockServer.UpdateTaskReqs())
 }
+
+func TestPollAndExecOnceConcurrentTasks(t *testing.T) {
+	testutils.SmallTest(t)
+	task1 := pendingRecreateWebpageArchivesTask()
+	task2 := pendingChromiumPerfTask()
+	task3 := pendingChromiumPerfTask()
+	task3.GetCommonCols().Id = 43
+	mockServer := frontend.MockServer{}
+	mockServer.SetPendingTasks([]task_common.Task{task1.Task, task2.Task, task3.Task})
+	defer frontend.CloseTestServer(frontend.InitTestServer(&mockServer))
+	mockExec := exec.CommandCollector{}
+	exec.SetRunForTesting(mockExec.Run)
+	defer exec.SetRunForTesting(exec.DefaultRun)
+	// Keep the first ChromiumPerf task running until all pending tasks were considered.
+	release := make(chan bool)
+	mockExec.SetDelegateRun(func(cmd *exec.Command) error {
+		if cmd.Name == "run_chromium_perf_on_workers" {
+			<-release
+		}
+		return nil
+	})
+	wg := pollAndExecOnce()
+	close(release)
+	wg.Wait()
+	expect.Equal(t, 1, mockServer.PendingTasksReqCount())
+	// Expect the checkout to be built once for both tasks that were started, and only one of the
+	// ChromiumPerf tasks to be started.
+	commands := mockExec.Commands()
+	assert.Len(t, commands, 4)
+	expect.Equal(t, "git pull", exec.DebugString(commands[0]))
+	expect.Equal(t, "make all", exec.DebugString(commands[1]))
+	names := []string{commands[2].Name, commands[3].Name}
+	expect.Contains(t, names, "capture_archives_on_workers")
+	expect.Contains(t, names, "run_chromium_perf_on_workers")
+	for _, cmd := range commands[2:] {
+		expect.Contains(t, cmd.Args, "--gae_task_id=42")
+	}
+	expect.Empty(t, mockServer.UpdateTaskReqs())
+	// All tasks are done.
+	expect.Empty(t, pickedUpTasks)
+	expect.Equal(t, 0, runningTasks[task2.GetTaskName()])
+	expect.Equal(t, 0, repo.users)
+}
+
+func TestCheckoutResyncsWhenStale(t *testing.T) {
+	testutils.SmallTest(t)
+	mockExec := exec.CommandCollector{}
+	exec.SetRunForTesting(mockExec.Run)
+	defer exec.SetRunForTesting(exec.DefaultRun)
+	c := newCheckout()
+	// The first user syncs the checkout, the second shares it.
+	assert.NoError(t, c.acquire())
+	assert.NoError(t, c.acquire())
+	expect.Len(t, mockExec.Commands(), 2)
+	// Once the checkout is too old, new users wait for the current users to finish.
+	c.mtx.Lock()
+	c.synced = time.Now().Add(-2 * *maxCheckoutAge)
+	c.mtx.Unlock()
+	acquired := make(chan error)
+	go func() {
+		acquired <- c.acquire()
+	}()
+	c.release()
+	select {
+	case <-acquired:
+		t.Fatal("Stale checkout was handed out while in use.")
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.release()
+	assert.NoError(t, <-acquired)
+	expect.Len(t, mockExec.Commands(), 4)
+	expect.Equal(t, 1, c.users)
+	c.release()
+}
+
+func TestParseTaskConcurrency(t *testing.T) {
+	testutils.SmallTest(t)
+	limits, err := parseTaskConcurrency("")
+	assert.NoError(t, err)
+	expect.Empty(t, limits)
+	limits, err = parseTaskConcurrency("LuaScript=2, ChromiumBuild=0")
+	assert.NoError(t, err)
+	expect.Equal(t, map[string]int{"LuaScript": 2, "ChromiumBuild": 0}, limits)
+	_, err = parseTaskConcurrency("LuaScript")
+	expect.Error(t, err)
+	_, err = parseTaskConcurrency("LuaScript=many")
+	expect.Error(t, err)
+	_, err = parseTaskConcurrency("LuaScript=-1")
+	expect.Error(t, err)
+}
@@ -30,6 +30,12 @@ const (
 	CHROMIUM_ANALYSIS_TASKS_DIR_NAME = "chromium_analysis_runs"
 	FIX_ARCHIVE_TASKS_DIR_NAME       = "fix_archive_runs"
 
+	// The individual values of benchmark runs, before repeated runs are averaged. Workers
+	// upload them with this suffix next to their outputs and MergeUploadCSVFiles copies them into
+	// the SAMPLES_DIR_NAME subdirectory of the local output dir.
+	SAMPLES_OUTPUT_SUFFIX = ".samples"
+	SAMPLES_DIR_NAME      = "samples"
+
 	// Limit the number of times CT tries to get a remote file before giving up.
 	MAX_URI_GET_TRIES = 4
 
@@ -214,6 +220,9 @@ var (
 	ChromiumAnalysisRunsDir = filepath.Join(TASKS_DIR_NAME, CHROMIUM_ANALYSIS_TASKS_DIR_NAME)
 	FixArchivesRunsDir      = filepath.Join(TASKS_DIR_NAME, FIX_ARCHIVE_TASKS_DIR_NAME)
 
+	// The columns of the pivot table CSVs written to SAMPLES_OUTPUT_SUFFIX files.
+	SAMPLES_HEADERS = []string{"page", "name", "units", "value"}
+
 	// Map CT benchmarks to the names recognized by Telemetry.
 	BenchmarksToTelemetryName = map[string]string{
 		BENCHMARK_SKPICTURE_PRINTER: "skpicture_printer_ct",
@@ -456,9 +456,18 @@ func GetPathToPyFiles(runOnSwarming bool) string {
 	}
 }
 
-func MergeUploadCSVFiles(runID, pathToPyFiles string, gs *GsUtil, totalPages, numPerWorker int, handleStrings bool) ([]string, error) {
+// MergeUploadCSVFiles merges the outputs of all slaves of the given run and uploads the result to
+// Google Storage. If fetchSamples is true then the individual values uploaded by the slaves are
+// copied into the SAMPLES_DIR_NAME subdirectory of the local output dir, where the caller must
+// remove them once it is done with them. Returns the slaves that had no output.
+func MergeUploadCSVFiles(runID, pathToPyFiles string, gs *GsUtil, totalPages, numPerWorker int, handleStrings, fetchSamples bool) ([]string, error) {
 	localOutputDir := filepath.Join(StorageDir, BenchmarkRunsDir, runID)
 	util.MkdirAll(localOutputDir, 0700)
+	localSamplesDir := filepath.Join(localOutputDir, SAMPLES_DIR_NAME)
+	if fetchSamples {
+		// Don't mix in the samples of an earlier run with the same ID.
+		util.RemoveAll(localSamplesDir)
+	}
 	noOutputSlaves := []string{}
 	// Copy outputs from all slaves locally.
 	numTasks := int(math.Ceil(float64(totalPages) / float64(numPerWorker)))
@@ -492,6 +501,14 @@ func MergeUploadCSVFiles(runID, pathToPyFiles string, gs *GsUtil, totalPages, nu
 			noOutputSlaves = append(noOutputSlaves, strconv.Itoa(i+1))
 			continue
 		}
+		// Copy the individual values of the slave locally. They are optional because older
+		// workers do not upload them.
+		if fetchSamples {
+			workerRemoteSamplesPath := filepath.Join(BenchmarkRunsDir, runID, strconv.Itoa(startRange), "outputs", runID+SAMPLES_OUTPUT_SUFFIX)
+			if err := copyRemoteFile(gs, workerRemoteSamplesPath, filepath.Join(localSamplesDir, strconv.Itoa(startRange)+".csv")); err != nil {
+				glog.Warningf("Could not copy samples of slave %d: %s", i+1, err)
+			}
+		}
 	}
 	// Call csv_merger.py to merge all results into a single results CSV.
 	pathToCsvMerger := filepath.Join(pathToPyFiles, "csv_merger.py")
@@ -584,12 +601,39 @@ func RunBenchmark(fileInfoName, pathToPagesets, pathToPyFiles, localOutputDir, c
 	return nil
 }
 
-func MergeUploadCSVFilesOnWorkers(localOutputDir, pathToPyFiles, runID, remoteDir string, gs *GsUtil, startRange int, handleStrings bool) error {
+// copyRemoteFile copies the given file in Google Storage to localPath, creating its directory if
+// necessary.
+func copyRemoteFile(gs *GsUtil, remotePath, localPath string) error {
+	respBody, err := gs.GetRemoteFileContents(remotePath)
+	if err != nil {
+		return fmt.Errorf("Could not fetch %s: %s", remotePath, err)
+	}
+	defer util.Close(respBody)
+	if err := os.MkdirAll(filepath.Dir(localPath), 0700); err != nil {
+		return fmt.Errorf("Unable to create dir %s: %s", filepath.Dir(localPath), err)
+	}
+	out, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("Unable to create file %s: %s", localPath, err)
+	}
+	defer util.Close(out)
+	if _, err = io.Copy(out, respBody); err != nil {
+		return fmt.Errorf("Unable to copy to file %s: %s", localPath, err)
+	}
+	return nil
+}
+
+// MergeUploadCSVFilesOnWorkers merges the pivot table CSVs of the pages in localOutputDir and
+// uploads the result to Google Storage. If uploadSamples is true then the individual values of all
+// pages are also uploaded, for the statistical comparison of perf runs.
+func MergeUploadCSVFilesOnWorkers(localOutputDir, pathToPyFiles, runID, remoteDir string, gs *GsUtil, startRange int, handleStrings, uploadSamples bool) error {
 	// Move all results into a single directory.
 	fileInfos, err := ioutil.ReadDir(localOutputDir)
 	if err != nil {
 		return fmt.Errorf("Unable to read %s: %s", localOutputDir, err)
 	}
+	// The individual values of all pages, which are averaged by csv_pivot_table_merger.py.
+	samples := [][]string{}
 	for _, fileInfo := range fileInfos {
 		if !fileInfo.IsDir() {
 			continue
@@ -618,6 +662,9 @@ func MergeUploadCSVFilesOnWorkers(localOutputDir, pathToPyFiles, runID, remoteDi
 			glog.Errorf("Could not write to %s: %s", newFile, err)
 			continue
 		}
+		if uploadSamples {
+			samples = append(samples, getSampleRows(headers, values)...)
+		}
 	}
 	// Call csv_pivot_table_merger.py to merge all results into a single results CSV.
 	pathToCsvMerger := filepath.Join(pathToPyFiles, "csv_pivot_table_merger.py")
@@ -640,9 +687,60 @@ func MergeUploadCSVFilesOnWorkers(localOutputDir, pathToPyFiles, runID, remoteDi
 	if err := gs.UploadFile(outputFileName, localOutputDir, remoteOutputDir); err != nil {
 		return fmt.Errorf("Unable to upload %s to %s: %s", outputFileName, remoteOutputDir, err)
 	}
+	if uploadSamples {
+		// The samples are only used by the optional comparison of runs, so failing to upload
+		// them doesn't fail the run.
+		if err := uploadSampleRows(samples, runID, remoteOutputDir, gs); err != nil {
+			glog.Errorf("Could not upload samples for %s: %s", runID, err)
+		}
+	}
+	return nil
+}
+
+// uploadSampleRows copies the individual values to Google Storage for the statistical comparison
+// of runs. The file is written outside of the output dir so that it is not picked up by CSV
+// mergers.
+func uploadSampleRows(samples [][]string, runID, remoteOutputDir string, gs *GsUtil) error {
+	samplesFileName := runID + SAMPLES_OUTPUT_SUFFIX
+	samplesDir, err := ioutil.TempDir("", "samples")
+	if err != nil {
+		return fmt.Errorf("Unable to create temp dir: %s", err)
+	}
+	defer util.RemoveAll(samplesDir)
+	if err := createCSV(filepath.Join(samplesDir, samplesFileName), SAMPLES_HEADERS, samples); err != nil {
+		return err
+	}
+	if err := gs.UploadFile(samplesFileName, samplesDir, remoteOutputDir); err != nil {
+		return fmt.Errorf("Unable to upload %s to %s: %s", samplesFileName, remoteOutputDir, err)
+	}
 	return nil
 }
 
+// getSampleRows returns the SAMPLES_HEADERS columns of the given rows of a pivot table CSV.
+// Columns which are missing in the CSV are left empty.
+func getSampleRows(headers []string, values [][]string) [][]string {
+	indices := make([]int, len(SAMPLES_HEADERS))
+	for i, sampleHeader := range SAMPLES_HEADERS {
+		indices[i] = -1
+		for j, header := range headers {
+			if header == sampleHeader {
+				indices[i] = j
+			}
+		}
+	}
+	rows := make([][]string, 0, len(values))
+	for _, value := range values {
+		row := make([]string, len(indices))
+		for i, idx := range indices {
+			if idx >= 0 && idx < len(value) {
+				row[i] = value[idx]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
 func getRowsFromCSV(csvPath string) ([]string, [][]string, error) {
 	csvFile, err := os.Open(csvPath)
 	defer util.Close(csvFile)
@@ -661,6 +759,20 @@ func getRowsFromCSV(csvPath string) ([]string, [][]string, error) {
 	return rawCSVdata[0], rawCSVdata[1:], nil
 }
 
+// createCSV creates a CSV file with the given headers and values.
+func createCSV(csvPath string, headers []string, values [][]string) error {
+	csvFile, err := os.Create(csvPath)
+	if err != nil {
+		return fmt.Errorf("Could not create %s: %s", csvPath, err)
+	}
+	defer util.Close(csvFile)
+	writer := csv.NewWriter(csvFile)
+	if err := writer.WriteAll(append([][]string{headers}, values...)); err != nil {
+		return fmt.Errorf("Could not write to %s: %s", csvPath, err)
+	}
+	return nil
+}
+
 func writeRowsToCSV(csvPath string, headers []string, values [][]string) error {
 	csvFile, err := os.OpenFile(csvPath, os.O_WRONLY, 666)
 	defer util.Close(csvFile)
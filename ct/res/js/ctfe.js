@@ -53,6 +53,14 @@ this.ctfe = this.ctfe || function() {
     }
   }
 
+  /**
+   * Returns a promise for the list of task types known to CTFE. Each task type
+   * has the fields type, get_url and delete_url.
+   **/
+  ctfe.getTaskDescriptors = function() {
+    return sk.post("/_/task_types").then(JSON.parse);
+  }
+
   /**
    * Functions to work with information about page sets.
    */
@@ -7,6 +7,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
@@ -17,15 +18,19 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/skia-dev/glog"
+	"go.skia.org/infra/datahopper/go/task_stats"
 	"go.skia.org/infra/go/auth"
 	"go.skia.org/infra/go/buildbot"
 	"go.skia.org/infra/go/common"
 	"go.skia.org/infra/go/git/repograph"
 	"go.skia.org/infra/go/gs"
+	"go.skia.org/infra/go/httputils"
 	"go.skia.org/infra/go/influxdb"
 	"go.skia.org/infra/go/metrics2"
 	"go.skia.org/infra/go/swarming"
 	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/db/remote_db"
 	"golang.org/x/net/context"
 	"google.golang.org/api/option"
 )
@@ -54,6 +59,9 @@ var (
 	grpcPort = flag.String("grpc_port", ":8000", "Port on which to run the buildbot data gRPC server.")
 	httpPort = flag.String("http_port", ":8001", "Port on which to run the HTTP server.")
 
+	taskSchedulerDbUrl = flag.String("task_db_url", "http://skia-task-scheduler:8008/db/", "Where the Skia task scheduler database is hosted.")
+	taskStatsWindow    = flag.Duration("task_stats_window", 7*24*time.Hour, "Window over which to compute task failure and flakiness statistics.")
+
 	influxHost     = flag.String("influxdb_host", influxdb.DEFAULT_HOST, "The InfluxDB hostname.")
 	influxUser     = flag.String("influxdb_name", influxdb.DEFAULT_USER, "The InfluxDB username.")
 	influxPassword = flag.String("influxdb_password", influxdb.DEFAULT_PASSWORD, "The InfluxDB password.")
@@ -115,18 +123,18 @@ func main() {
 	}
 
 	// Data generation goroutines.
-	db, err := buildbot.NewLocalDB(path.Join(w, "buildbot.db"))
+	buildDb, err := buildbot.NewLocalDB(path.Join(w, "buildbot.db"))
 	if err != nil {
 		glog.Fatal(err)
 	}
 
 	// Buildbot data ingestion.
-	if err := buildbot.IngestNewBuildsLoop(db, repos); err != nil {
+	if err := buildbot.IngestNewBuildsLoop(buildDb, repos); err != nil {
 		glog.Fatal(err)
 	}
 
 	// Run a server for the buildbot data.
-	if _, err := buildbot.RunBuildServer(*grpcPort, db); err != nil {
+	if _, err := buildbot.RunBuildServer(*grpcPort, buildDb); err != nil {
 		glog.Fatal(err)
 	}
 
@@ -140,7 +148,7 @@ func main() {
 				glog.Error(err)
 				continue
 			}
-			ingestedBuilds, err := db.NumIngestedBuilds()
+			ingestedBuilds, err := buildDb.NumIngestedBuilds()
 			if err != nil {
 				glog.Error(err)
 				continue
@@ -156,7 +164,7 @@ func main() {
 		for _ = range time.Tick(10 * time.Minute) {
 			end := time.Now().UTC()
 			glog.Info("Loading build and buildstep duration data from %s to %s", start, end)
-			builds, err := db.GetBuildsFromDateRange(start, end)
+			builds, err := buildDb.GetBuildsFromDateRange(start, end)
 			if err != nil {
 				glog.Errorf("Failed to obtain build and buildstep duration data: %s", err)
 				continue
@@ -481,9 +489,26 @@ func main() {
 		}
 	}()
 
+	// Task failure and flakiness statistics. The leaderboard is served by
+	// the backup server.
+	taskDb, err := remote_db.NewClient(*taskSchedulerDbUrl)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	taskCache, err := db.NewTaskCache(taskDb, *taskStatsWindow)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	analyzer := task_stats.NewAnalyzer(taskCache, *taskStatsWindow)
+	if err := analyzer.Update(); err != nil {
+		glog.Fatal(err)
+	}
+	go analyzer.UpdateLoop(10 * time.Minute)
+	http.Handle("/json/task_stats", httputils.LoggingGzipRequestResponse(analyzer))
+
 	// Run a backup server.
 	go func() {
-		glog.Fatal(buildbot.RunBackupServer(db, *httpPort))
+		glog.Fatal(buildbot.RunBackupServer(buildDb, *httpPort))
 	}()
 
 	// Wait while the above goroutines generate data.
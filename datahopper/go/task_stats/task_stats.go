@@ -0,0 +1,423 @@
+/*
+	Derived failure and flakiness statistics for task scheduler tasks.
+*/
+
+package task_stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/httputils"
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+const (
+	MEASUREMENT_TASKS_FAILURE_RATE   = "task-stats.failure-rate"
+	MEASUREMENT_TASKS_FLAKE_RATE     = "task-stats.flake-rate"
+	MEASUREMENT_TASKS_PENDING_MEDIAN = "task-stats.pending-time.median"
+	MEASUREMENT_TASKS_PENDING_P90    = "task-stats.pending-time.p90"
+	MEASUREMENT_TASKS_RUN_MEDIAN     = "task-stats.run-time.median"
+	MEASUREMENT_TASKS_RUN_P90        = "task-stats.run-time.p90"
+	MEASUREMENT_TASKS_RED_STREAK     = "task-stats.longest-red-streak"
+
+	// Values for Stats.Group.
+	GROUP_TASK_SPEC = "task-spec"
+	GROUP_BOT       = "bot"
+
+	// Values for the "sort" parameter of the leaderboard.
+	SORT_FAILURE_RATE = "failureRate"
+	SORT_FLAKE_RATE   = "flakeRate"
+	SORT_RED_STREAK   = "redStreak"
+	SORT_PENDING_P90  = "pendingP90"
+	SORT_RUN_P90      = "runP90"
+)
+
+// Stats are the statistics for the tasks of one task spec or one bot within
+// the analysis window. Durations are in milliseconds.
+type Stats struct {
+	Group string `json:"group"`
+	// Repo is only set for task specs, since bots are shared across repos.
+	Repo string `json:"repo,omitempty"`
+	Name string `json:"name"`
+
+	// Finished is the number of tasks which finished, successfully or not.
+	Finished    int     `json:"finished"`
+	Failures    int     `json:"failures"`
+	FailureRate float64 `json:"failureRate"`
+
+	// Flakes is the number of failed tasks which were retried at the same
+	// commit and the retry succeeded. FlakeRate is Flakes / Finished.
+	Flakes    int     `json:"flakes"`
+	FlakeRate float64 `json:"flakeRate"`
+
+	PendingMedianMs int64 `json:"pendingMedianMs"`
+	PendingP90Ms    int64 `json:"pendingP90Ms"`
+	RunMedianMs     int64 `json:"runMedianMs"`
+	RunP90Ms        int64 `json:"runP90Ms"`
+
+	// LongestRedStreak is the largest number of consecutive failed tasks.
+	LongestRedStreak int `json:"longestRedStreak"`
+}
+
+// tags returns the metric tags for the Stats.
+func (s *Stats) tags() map[string]string {
+	tags := map[string]string{
+		"group": s.Group,
+		"name":  s.Name,
+	}
+	if s.Repo != "" {
+		tags["repo"] = s.Repo
+	}
+	return tags
+}
+
+// failed returns true iff the task finished with a failure or mishap.
+func failed(t *db.Task) bool {
+	return t.Status == db.TASK_STATUS_FAILURE || t.Status == db.TASK_STATUS_MISHAP
+}
+
+// finished returns true iff the task finished with a result.
+func finished(t *db.Task) bool {
+	return t.Status == db.TASK_STATUS_SUCCESS || failed(t)
+}
+
+// percentile returns the given percentile of the sorted durations, using the
+// nearest-rank method, in milliseconds.
+func percentile(sorted []time.Duration, p int) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return int64(sorted[rank-1] / time.Millisecond)
+}
+
+// durations implements sort.Interface.
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// computeGroup returns the Stats for one group of tasks. The tasks must be
+// sorted in the order used for the red streak. flakes is the set of IDs of
+// failed tasks which are known to be flakes.
+func computeGroup(group, repo, name string, tasks []*db.Task, flakes map[string]bool) *Stats {
+	s := &Stats{
+		Group: group,
+		Repo:  repo,
+		Name:  name,
+	}
+	pending := durations{}
+	run := durations{}
+	streak := 0
+	for _, t := range tasks {
+		if !t.Started.IsZero() {
+			pending = append(pending, t.Started.Sub(t.Created))
+		}
+		if !finished(t) {
+			continue
+		}
+		s.Finished++
+		if !t.Started.IsZero() && !t.Finished.IsZero() {
+			run = append(run, t.Finished.Sub(t.Started))
+		}
+		if failed(t) {
+			s.Failures++
+			if flakes[t.Id] {
+				s.Flakes++
+			}
+			streak++
+			if streak > s.LongestRedStreak {
+				s.LongestRedStreak = streak
+			}
+		} else {
+			streak = 0
+		}
+	}
+	if s.Finished > 0 {
+		s.FailureRate = float64(s.Failures) / float64(s.Finished)
+		s.FlakeRate = float64(s.Flakes) / float64(s.Finished)
+	}
+	sort.Sort(pending)
+	sort.Sort(run)
+	s.PendingMedianMs = percentile(pending, 50)
+	s.PendingP90Ms = percentile(pending, 90)
+	s.RunMedianMs = percentile(run, 50)
+	s.RunP90Ms = percentile(run, 90)
+	return s
+}
+
+// findFlakes returns the IDs of failed tasks which have a successful retry at
+// the same commit.
+func findFlakes(tasks []*db.Task) map[string]bool {
+	byId := make(map[string]*db.Task, len(tasks))
+	for _, t := range tasks {
+		byId[t.Id] = t
+	}
+	flakes := map[string]bool{}
+	for _, t := range tasks {
+		if t.RetryOf == "" || t.Status != db.TASK_STATUS_SUCCESS {
+			continue
+		}
+		orig, ok := byId[t.RetryOf]
+		if ok && failed(orig) && orig.Revision == t.Revision {
+			flakes[orig.Id] = true
+		}
+	}
+	return flakes
+}
+
+// taskSlice sorts tasks by the given time.
+type taskSlice struct {
+	tasks []*db.Task
+	ts    func(*db.Task) time.Time
+}
+
+func (s taskSlice) Len() int           { return len(s.tasks) }
+func (s taskSlice) Less(i, j int) bool { return s.ts(s.tasks[i]).Before(s.ts(s.tasks[j])) }
+func (s taskSlice) Swap(i, j int)      { s.tasks[i], s.tasks[j] = s.tasks[j], s.tasks[i] }
+
+// Compute returns the Stats for each task spec and for each bot which ran
+// any of the given tasks. Red streaks are computed in order of creation for
+// task specs and in order of start for bots. Forced and try job tasks are
+// excluded from the task spec Stats, since their failures are not failures
+// of the task spec at its commit, but are included in the bot Stats, since
+// they run on the same bots.
+func Compute(tasks []*db.Task) ([]*Stats, []*Stats) {
+	flakes := findFlakes(tasks)
+
+	// map[repo][name][]*db.Task
+	bySpec := map[string]map[string][]*db.Task{}
+	byBot := map[string][]*db.Task{}
+	for _, t := range tasks {
+		if !t.IsForceRun() && !t.IsTryJob() {
+			if _, ok := bySpec[t.Repo]; !ok {
+				bySpec[t.Repo] = map[string][]*db.Task{}
+			}
+			bySpec[t.Repo][t.Name] = append(bySpec[t.Repo][t.Name], t)
+		}
+		if t.SwarmingBotId != "" {
+			byBot[t.SwarmingBotId] = append(byBot[t.SwarmingBotId], t)
+		}
+	}
+
+	specStats := []*Stats{}
+	for repo, specs := range bySpec {
+		for name, specTasks := range specs {
+			sort.Sort(taskSlice{specTasks, func(t *db.Task) time.Time { return t.Created }})
+			specStats = append(specStats, computeGroup(GROUP_TASK_SPEC, repo, name, specTasks, flakes))
+		}
+	}
+	botStats := []*Stats{}
+	for bot, botTasks := range byBot {
+		sort.Sort(taskSlice{botTasks, func(t *db.Task) time.Time { return t.Started }})
+		botStats = append(botStats, computeGroup(GROUP_BOT, "", bot, botTasks, flakes))
+	}
+	SortStats(specStats, SORT_FAILURE_RATE)
+	SortStats(botStats, SORT_FAILURE_RATE)
+	return specStats, botStats
+}
+
+// sortKeys are the values by which Stats may be sorted.
+var sortKeys = map[string]func(*Stats) float64{
+	SORT_FAILURE_RATE: func(s *Stats) float64 { return s.FailureRate },
+	SORT_FLAKE_RATE:   func(s *Stats) float64 { return s.FlakeRate },
+	SORT_RED_STREAK:   func(s *Stats) float64 { return float64(s.LongestRedStreak) },
+	SORT_PENDING_P90:  func(s *Stats) float64 { return float64(s.PendingP90Ms) },
+	SORT_RUN_P90:      func(s *Stats) float64 { return float64(s.RunP90Ms) },
+}
+
+// SortStats sorts the Stats in place, worst first, by the given key. Ties are
+// broken by name. Returns an error if the key is unknown.
+func SortStats(stats []*Stats, key string) error {
+	f, ok := sortKeys[key]
+	if !ok {
+		return fmt.Errorf("Unknown sort key %q", key)
+	}
+	sort.Sort(statsSlice{stats, f})
+	return nil
+}
+
+// statsSlice sorts Stats by the given key, worst first.
+type statsSlice struct {
+	stats []*Stats
+	key   func(*Stats) float64
+}
+
+func (s statsSlice) Len() int      { return len(s.stats) }
+func (s statsSlice) Swap(i, j int) { s.stats[i], s.stats[j] = s.stats[j], s.stats[i] }
+func (s statsSlice) Less(i, j int) bool {
+	a, b := s.key(s.stats[i]), s.key(s.stats[j])
+	if a != b {
+		return a > b
+	}
+	if s.stats[i].Repo != s.stats[j].Repo {
+		return s.stats[i].Repo < s.stats[j].Repo
+	}
+	return s.stats[i].Name < s.stats[j].Name
+}
+
+// Leaderboard is the JSON leaderboard served by Analyzer.
+type Leaderboard struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	TaskSpecs []*Stats  `json:"taskSpecs"`
+	Bots      []*Stats  `json:"bots"`
+}
+
+// metric is implemented by all of the metrics2 metric types.
+type metric interface {
+	Delete() error
+}
+
+// Analyzer periodically computes Stats from a TaskCache over a rolling
+// window, reports them as metrics, and serves them as a leaderboard.
+type Analyzer struct {
+	cache       db.TaskCache
+	window      time.Duration
+	mtx         sync.RWMutex
+	leaderboard *Leaderboard
+	oldMetrics  []metric
+}
+
+// NewAnalyzer returns an Analyzer which computes Stats for the tasks in the
+// given TaskCache which were created within the given window.
+func NewAnalyzer(cache db.TaskCache, window time.Duration) *Analyzer {
+	return &Analyzer{
+		cache:  cache,
+		window: window,
+		leaderboard: &Leaderboard{
+			TaskSpecs: []*Stats{},
+			Bots:      []*Stats{},
+		},
+		oldMetrics: []metric{},
+	}
+}
+
+// Update updates the TaskCache and recomputes the Stats.
+func (a *Analyzer) Update() error {
+	if err := a.cache.Update(); err != nil {
+		return err
+	}
+	end := time.Now()
+	start := end.Add(-a.window)
+	tasks, err := a.cache.GetTasksFromDateRange(start, end)
+	if err != nil {
+		return err
+	}
+	specStats, botStats := Compute(tasks)
+	a.updateMetrics(append(append([]*Stats{}, specStats...), botStats...))
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.leaderboard = &Leaderboard{
+		Start:     start,
+		End:       end,
+		TaskSpecs: specStats,
+		Bots:      botStats,
+	}
+	return nil
+}
+
+// updateMetrics reports the given Stats, replacing the metrics from the
+// previous update so that task specs and bots which disappear are removed.
+func (a *Analyzer) updateMetrics(stats []*Stats) {
+	failedDelete := []metric{}
+	for _, m := range a.oldMetrics {
+		if err := m.Delete(); err != nil {
+			glog.Warningf("Failed to delete metric: %s", err)
+			failedDelete = append(failedDelete, m)
+		}
+	}
+	a.oldMetrics = failedDelete
+	for _, s := range stats {
+		tags := s.tags()
+		rates := map[string]float64{
+			MEASUREMENT_TASKS_FAILURE_RATE: s.FailureRate,
+			MEASUREMENT_TASKS_FLAKE_RATE:   s.FlakeRate,
+		}
+		for measurement, v := range rates {
+			m := metrics2.GetFloat64Metric(measurement, tags)
+			m.Update(v)
+			a.oldMetrics = append(a.oldMetrics, m)
+		}
+		values := map[string]int64{
+			MEASUREMENT_TASKS_PENDING_MEDIAN: s.PendingMedianMs,
+			MEASUREMENT_TASKS_PENDING_P90:    s.PendingP90Ms,
+			MEASUREMENT_TASKS_RUN_MEDIAN:     s.RunMedianMs,
+			MEASUREMENT_TASKS_RUN_P90:        s.RunP90Ms,
+			MEASUREMENT_TASKS_RED_STREAK:     int64(s.LongestRedStreak),
+		}
+		for measurement, v := range values {
+			m := metrics2.GetInt64Metric(measurement, tags)
+			m.Update(v)
+			a.oldMetrics = append(a.oldMetrics, m)
+		}
+	}
+}
+
+// UpdateLoop calls Update periodically.
+func (a *Analyzer) UpdateLoop(period time.Duration) {
+	for _ = range time.Tick(period) {
+		if err := a.Update(); err != nil {
+			glog.Errorf("Failed to update task stats: %s", err)
+		}
+	}
+}
+
+// ServeHTTP serves the leaderboard as JSON. The "sort" parameter selects the
+// ordering, and the "n" parameter limits the number of task specs and bots.
+func (a *Analyzer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sortKey := r.FormValue("sort")
+	if sortKey == "" {
+		sortKey = SORT_FAILURE_RATE
+	}
+	if _, ok := sortKeys[sortKey]; !ok {
+		httputils.ReportError(w, r, nil, fmt.Sprintf("Unknown sort key %q", sortKey))
+		return
+	}
+	n := -1
+	if nStr := r.FormValue("n"); nStr != "" {
+		var err error
+		n, err = strconv.Atoi(nStr)
+		if err != nil || n < 0 {
+			httputils.ReportError(w, r, err, fmt.Sprintf("Invalid value for n: %q", nStr))
+			return
+		}
+	}
+
+	a.mtx.RLock()
+	lb := a.leaderboard
+	a.mtx.RUnlock()
+	rv := &Leaderboard{
+		Start:     lb.Start,
+		End:       lb.End,
+		TaskSpecs: append([]*Stats{}, lb.TaskSpecs...),
+		Bots:      append([]*Stats{}, lb.Bots...),
+	}
+	// The key was checked above.
+	_ = SortStats(rv.TaskSpecs, sortKey)
+	_ = SortStats(rv.Bots, sortKey)
+	if n >= 0 {
+		if len(rv.TaskSpecs) > n {
+			rv.TaskSpecs = rv.TaskSpecs[:n]
+		}
+		if len(rv.Bots) > n {
+			rv.Bots = rv.Bots[:n]
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rv); err != nil {
+		httputils.ReportError(w, r, err, "Failed to encode leaderboard.")
+	}
+}
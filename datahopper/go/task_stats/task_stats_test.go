@@ -0,0 +1,129 @@
+package task_stats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+// makeTask returns a finished task for the given spec and bot which was
+// created n minutes after ts, pending for n seconds and ran for n minutes.
+func makeTask(ts time.Time, n int, name, bot, revision string, status db.TaskStatus) *db.Task {
+	created := ts.Add(time.Duration(n) * time.Minute)
+	started := created.Add(time.Duration(n) * time.Second)
+	return &db.Task{
+		Id:            fmt.Sprintf("task%d", n),
+		Created:       created,
+		Started:       started,
+		Finished:      started.Add(time.Duration(n) * time.Minute),
+		Status:        status,
+		SwarmingBotId: bot,
+		TaskKey: db.TaskKey{
+			RepoState: db.RepoState{
+				Repo:     "skia.git",
+				Revision: revision,
+			},
+			Name: name,
+		},
+	}
+}
+
+func TestCompute(t *testing.T) {
+	testutils.SmallTest(t)
+	ts := time.Unix(1480683321, 0).UTC()
+	tasks := []*db.Task{
+		makeTask(ts, 1, "Build", "bot1", "a", db.TASK_STATUS_SUCCESS),
+		makeTask(ts, 2, "Build", "bot1", "b", db.TASK_STATUS_FAILURE),
+		makeTask(ts, 3, "Build", "bot2", "b", db.TASK_STATUS_SUCCESS),
+		makeTask(ts, 4, "Build", "bot1", "c", db.TASK_STATUS_FAILURE),
+		makeTask(ts, 5, "Build", "bot1", "d", db.TASK_STATUS_MISHAP),
+		makeTask(ts, 6, "Build", "bot2", "c", db.TASK_STATUS_FAILURE),
+		makeTask(ts, 7, "Test", "bot2", "d", db.TASK_STATUS_SUCCESS),
+	}
+	// task3 is a successful retry of task2, so task2 was a flake. task6 is
+	// a failed retry of task4.
+	tasks[2].RetryOf = tasks[1].Id
+	tasks[5].RetryOf = tasks[3].Id
+	// A pending task counts towards neither the results nor the times.
+	pending := makeTask(ts, 8, "Test", "", "d", db.TASK_STATUS_PENDING)
+	pending.Started = time.Time{}
+	pending.Finished = time.Time{}
+	tasks = append(tasks, pending)
+	// Failed try job and forced tasks count towards the bot but not the task
+	// spec.
+	tryJob := makeTask(ts, 9, "Build", "bot2", "d", db.TASK_STATUS_FAILURE)
+	tryJob.Issue = "1234"
+	tryJob.Patchset = "1"
+	tryJob.Server = "https://codereview.chromium.org"
+	forced := makeTask(ts, 10, "Test", "bot2", "d", db.TASK_STATUS_FAILURE)
+	forced.ForcedJobId = "job-id"
+	tasks = append(tasks, tryJob, forced)
+
+	specs, bots := Compute(tasks)
+	testutils.AssertDeepEqual(t, []*Stats{
+		&Stats{
+			Group:            GROUP_TASK_SPEC,
+			Repo:             "skia.git",
+			Name:             "Build",
+			Finished:         6,
+			Failures:         4,
+			FailureRate:      4.0 / 6.0,
+			Flakes:           1,
+			FlakeRate:        1.0 / 6.0,
+			PendingMedianMs:  3000,
+			PendingP90Ms:     6000,
+			RunMedianMs:      180000,
+			RunP90Ms:         360000,
+			LongestRedStreak: 3,
+		},
+		&Stats{
+			Group:           GROUP_TASK_SPEC,
+			Repo:            "skia.git",
+			Name:            "Test",
+			Finished:        1,
+			PendingMedianMs: 7000,
+			PendingP90Ms:    7000,
+			RunMedianMs:     420000,
+			RunP90Ms:        420000,
+		},
+	}, specs)
+
+	assert.Equal(t, 2, len(bots))
+	// bot1 failed the most.
+	expect.Equal(t, "bot1", bots[0].Name)
+	expect.Equal(t, "", bots[0].Repo)
+	expect.Equal(t, 4, bots[0].Finished)
+	expect.Equal(t, 3, bots[0].Failures)
+	expect.Equal(t, 1, bots[0].Flakes)
+	expect.Equal(t, 3, bots[0].LongestRedStreak)
+	expect.Equal(t, "bot2", bots[1].Name)
+	expect.Equal(t, 5, bots[1].Finished)
+	expect.Equal(t, 3, bots[1].Failures)
+	expect.Equal(t, 0, bots[1].Flakes)
+	expect.Equal(t, 2, bots[1].LongestRedStreak)
+
+	// Other orderings.
+	assert.NoError(t, SortStats(specs, SORT_RUN_P90))
+	expect.Equal(t, "Test", specs[0].Name)
+	assert.NoError(t, SortStats(bots, SORT_FLAKE_RATE))
+	expect.Equal(t, "bot1", bots[0].Name)
+	assert.Error(t, SortStats(bots, "bogus"))
+}
+
+func TestPercentile(t *testing.T) {
+	testutils.SmallTest(t)
+	expect.Equal(t, int64(0), percentile(durations{}, 50))
+	d := durations{}
+	for i := 1; i <= 10; i++ {
+		d = append(d, time.Duration(i)*time.Millisecond)
+	}
+	expect.Equal(t, int64(5), percentile(d, 50))
+	expect.Equal(t, int64(9), percentile(d, 90))
+	expect.Equal(t, int64(10), percentile(d, 100))
+	expect.Equal(t, int64(1), percentile(d, 0))
+}
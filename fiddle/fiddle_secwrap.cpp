@@ -1,5 +1,8 @@
 #include <unistd.h>
 #include <fcntl.h>
+#include <signal.h>
+#include <stdio.h>
+#include <stdlib.h>
 #include <sys/time.h>
 #include <sys/resource.h>
 #include <sys/ptrace.h>
@@ -14,6 +17,16 @@
 
 using namespace std;
 
+// fiddle_run passes a pipe as file descriptor 3, on which we report the
+// limits the child exceeded. It isn't passed on to the child, so unlike
+// stderr the child can't write to it.
+#define REPORT_FD 3
+
+// OUT_OF_MEMORY_REPORT must match types.OUT_OF_MEMORY_REPORT.
+#define OUT_OF_MEMORY_REPORT "out of memory\n"
+
+#define DEFAULT_MEMORY_LIMIT 1000000000
+
 static bool install_syscall_filter() {
     struct sock_filter filter[] = {
         VALIDATE_ARCHITECTURE,
@@ -28,7 +41,7 @@ static bool install_syscall_filter() {
         ALLOW_SYSCALL(write),
         ALLOW_SYSCALL(getdents),
         ALLOW_SYSCALL(close),
-        ALLOW_SYSCALL(mmap),
+        TRACE_SYSCALL(mmap),
         ALLOW_SYSCALL(mprotect),
         ALLOW_SYSCALL(munmap),
         ALLOW_SYSCALL(brk),
@@ -86,24 +99,57 @@ failed:
     return false;
 }
 
+// getLimit returns the value of the environment variable 'name', or
+// 'defaultValue' if it isn't set. fiddle_run passes the limits this way.
+static rlim_t getLimit(const char *name, rlim_t defaultValue) {
+    const char *value = getenv(name);
+    if (value == NULL || *value == 0) {
+        return defaultValue;
+    }
+    return (rlim_t) strtoull(value, NULL, 10);
+}
+
 static void setLimits() {
      struct rlimit n;
 
-     // Limit to 5 seconds of CPU.
-     n.rlim_cur = 5;
-     n.rlim_max = 5;
+     // Limit the seconds of CPU, 5 by default.
+     n.rlim_cur = getLimit("FIDDLE_CPU_LIMIT", 5);
+     n.rlim_max = n.rlim_cur;
      if (setrlimit(RLIMIT_CPU, &n)) {
          perror("setrlimit(RLIMIT_CPU)");
      }
 
-     // Limit to 1G of Address space.
-     n.rlim_cur = 1000000000;
-     n.rlim_max = 1000000000;
+     // Limit the address space, 1G by default.
+     n.rlim_cur = getLimit("FIDDLE_MEMORY_LIMIT", DEFAULT_MEMORY_LIMIT);
+     n.rlim_max = n.rlim_cur;
      if (setrlimit(RLIMIT_AS, &n)) {
-         perror("setrlimit(RLIMIT_CPU)");
+         perror("setrlimit(RLIMIT_AS)");
      }
  }
 
+// exceedsMemoryLimit returns true if mapping 'length' more bytes into the
+// address space of the child fails because of RLIMIT_AS, which is how
+// running out of memory shows up.
+static bool exceedsMemoryLimit(pid_t child, unsigned long length, rlim_t limit) {
+    char path[64];
+    snprintf(path, sizeof(path), "/proc/%d/status", child);
+    FILE *f = fopen(path, "r");
+    if (f == NULL) {
+        return false;
+    }
+    unsigned long long vmSizeKB = 0;
+    char line[256];
+    while (fgets(line, sizeof(line), f) != NULL) {
+        if (sscanf(line, "VmSize: %llu kB", &vmSizeKB) == 1) {
+            break;
+        }
+    }
+    fclose(f);
+    unsigned long long pageSize = sysconf(_SC_PAGESIZE);
+    unsigned long long mapped = (length + pageSize - 1) / pageSize * pageSize;
+    return vmSizeKB * 1024 + mapped > limit;
+}
+
 
 int do_child(int argc, char **argv) {
 
@@ -112,6 +158,8 @@ int do_child(int argc, char **argv) {
     memcpy(args, argv, argc * sizeof(char *));
     args[argc] = NULL;
 
+    close(REPORT_FD);
+
     if (ptrace(PTRACE_TRACEME, 0, 0, 0)) {
         perror("ptrace");
         exit(-1);
@@ -165,8 +213,12 @@ char *read_string(pid_t child, unsigned long addr) {
 
 int do_trace(pid_t child, char *allowed_exec) {
     int status;
+    rlim_t memoryLimit = getLimit("FIDDLE_MEMORY_LIMIT", DEFAULT_MEMORY_LIMIT);
+    bool reportedOutOfMemory = false;
     waitpid(child, &status, 0);
-    ptrace(PTRACE_SETOPTIONS, child, 0, PTRACE_O_TRACEEXEC | PTRACE_O_TRACESECCOMP);
+    // PTRACE_O_EXITKILL makes sure the child is killed if we are, e.g. when
+    // fiddle_run kills us after a timeout.
+    ptrace(PTRACE_SETOPTIONS, child, 0, PTRACE_O_TRACEEXEC | PTRACE_O_TRACESECCOMP | PTRACE_O_EXITKILL);
     ptrace(PTRACE_CONT, child, 0, 0);
 
 #define CHILD_FAIL(message) \
@@ -176,11 +228,16 @@ int do_trace(pid_t child, char *allowed_exec) {
 
     while(1) {
         waitpid(child, &status, 0);
+        // Pass on how the child ended so that fiddle_run can report the
+        // exit code or signal.
         if (WIFEXITED(status)) {
-            return 0;
+            return WEXITSTATUS(status);
         }
         if (WIFSIGNALED(status)) {
-            return 1;
+            int sig = WTERMSIG(status);
+            signal(sig, SIG_DFL);
+            kill(getpid(), sig);
+            return 128 + sig;
         }
 
         if (status>>8 == (SIGTRAP | (PTRACE_EVENT_SECCOMP<<8))) {
@@ -191,7 +248,13 @@ int do_trace(pid_t child, char *allowed_exec) {
             }
 
             int syscall = regs.orig_rax;
-            if (syscall == SYS_execve) {
+            if (syscall == SYS_mmap) {
+                if (!reportedOutOfMemory && exceedsMemoryLimit(child, regs.rsi, memoryLimit)) {
+                    if (write(REPORT_FD, OUT_OF_MEMORY_REPORT, strlen(OUT_OF_MEMORY_REPORT)) > 0) {
+                        reportedOutOfMemory = true;
+                    }
+                }
+            } else if (syscall == SYS_execve) {
                 char *name = read_string( child, regs.rdi );
                 if (strcmp(name, allowed_exec)) {
                     CHILD_FAIL( "Invalid exec." );
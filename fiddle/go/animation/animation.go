@@ -0,0 +1,49 @@
+// animation encodes the frames of an animated fiddle as an animated GIF.
+package animation
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"strings"
+)
+
+// Encode takes the base64 encoded PNGs of all the frames of an animation and
+// returns the base64 encoded animated GIF that displays each frame for
+// duration/len(frames) seconds.
+//
+//    frames - The base64 encoded PNG of each frame, in order.
+//    duration - The duration of the whole animation in seconds.
+//
+// Returns the base64 encoded GIF.
+func Encode(frames []string, duration float64) (string, error) {
+	if len(frames) == 0 {
+		return "", fmt.Errorf("An animation needs at least one frame.")
+	}
+	// GIF delays are in 100ths of a second.
+	delay := int(duration * 100 / float64(len(frames)))
+	if delay < 1 {
+		delay = 1
+	}
+	anim := &gif.GIF{}
+	for i, b64 := range frames {
+		img, err := png.Decode(base64.NewDecoder(base64.StdEncoding, strings.NewReader(b64)))
+		if err != nil {
+			return "", fmt.Errorf("Failed to decode frame %d: %s", i, err)
+		}
+		paletted := image.NewPaletted(img.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, img.Bounds(), img, img.Bounds().Min)
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, delay)
+	}
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, anim); err != nil {
+		return "", fmt.Errorf("Failed to encode animation: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
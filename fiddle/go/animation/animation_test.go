@@ -0,0 +1,52 @@
+package animation
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/go/testutils"
+)
+
+// encodedFrame returns a base64 encoded PNG filled with the given color.
+func encodedFrame(t *testing.T, c color.Color) string {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, png.Encode(buf, img))
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestEncode(t *testing.T) {
+	testutils.SmallTest(t)
+	frames := []string{
+		encodedFrame(t, color.NRGBA{R: 0xff, A: 0xff}),
+		encodedFrame(t, color.NRGBA{G: 0xff, A: 0xff}),
+		encodedFrame(t, color.NRGBA{B: 0xff, A: 0xff}),
+	}
+	b64, err := Encode(frames, 1.5)
+	assert.NoError(t, err)
+
+	b, err := base64.StdEncoding.DecodeString(b64)
+	assert.NoError(t, err)
+	anim, err := gif.DecodeAll(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(anim.Image))
+	assert.Equal(t, []int{50, 50, 50}, anim.Delay)
+	assert.Equal(t, image.Rect(0, 0, 4, 4), anim.Image[0].Bounds())
+
+	_, err = Encode([]string{}, 1)
+	assert.Error(t, err)
+
+	_, err = Encode([]string{"not a png"}, 1)
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -16,10 +17,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/golang/groupcache/lru"
 	"github.com/gorilla/mux"
 	"github.com/skia-dev/glog"
 	"go.skia.org/infra/fiddle/go/buildlib"
 	"go.skia.org/infra/fiddle/go/named"
+	"go.skia.org/infra/fiddle/go/regression"
 	"go.skia.org/infra/fiddle/go/runner"
 	"go.skia.org/infra/fiddle/go/source"
 	"go.skia.org/infra/fiddle/go/store"
@@ -37,19 +40,27 @@ import (
 
 const (
 	FIDDLE_HASH_LENGTH = 32
+
+	// OUTPUT_CACHE_SIZE is the number of entries in outputCache.
+	OUTPUT_CACHE_SIZE = 1000
 )
 
 // flags
 var (
+	cpuLimit          = flag.Int("cpu_limit", types.DefaultLimits.CPUSeconds, "The limit of CPU seconds for running a single frame of a fiddle.")
 	fiddleRoot        = flag.String("fiddle_root", "", "Directory location where all the work is done.")
 	influxDatabase    = flag.String("influxdb_database", influxdb.DEFAULT_DATABASE, "The InfluxDB database.")
 	influxHost        = flag.String("influxdb_host", influxdb.DEFAULT_HOST, "The InfluxDB hostname.")
 	influxPassword    = flag.String("influxdb_password", influxdb.DEFAULT_PASSWORD, "The InfluxDB password.")
 	influxUser        = flag.String("influxdb_name", influxdb.DEFAULT_USER, "The InfluxDB username.")
 	local             = flag.Bool("local", false, "Running locally if true. As opposed to in production.")
+	memoryLimit       = flag.Int64("memory_limit", types.DefaultLimits.MemoryBytes, "The limit of the address space of a running fiddle in bytes.")
 	port              = flag.String("port", ":8000", "HTTP service address (e.g., ':8000')")
 	preserveTemp      = flag.Bool("preserve_temp", false, "If true then preserve the build artifacts in the fiddle/tmp directory. Used for debugging only.")
+	regressionDir     = flag.String("regression_dir", "", "The directory to store the results of comparing named fiddles across Skia versions. Defaults to <fiddle_root>/regressions.")
 	resourcesDir      = flag.String("resources_dir", "", "The directory to find templates, JS, and CSS files. If blank the current directory will be used.")
+	runTimeout        = flag.Duration("run_timeout", types.DefaultLimits.Timeout, "The wall-clock limit for running a single frame of a fiddle.")
+	storeDir          = flag.String("store_dir", "", "If set then fiddles are stored in this local directory instead of in Google Storage.")
 	timeBetweenBuilds = flag.Duration("time_between_builds", time.Hour, "How long to wait between building LKGR of Skia.")
 )
 
@@ -64,6 +75,7 @@ type FiddleContext struct {
 	Name      string              `json:"name"`      // In a request can be the name to create for this fiddle.
 	Overwrite bool                `json:"overwrite"` // In a request, should a name be overwritten if it already exists.
 	Options   types.Options
+	Text      string `json:"text"` // The text output of the fiddle, if any.
 }
 
 // CompileError is a single line of compiler error output, along with the line
@@ -79,6 +91,10 @@ type RunResults struct {
 	CompileErrors []CompileError `json:"compile_errors"`
 	RunTimeError  string         `json:"runtime_error"`
 	FiddleHash    string         `json:"fiddleHash"`
+	Text          string         `json:"text"` // The text output of the fiddle, if any.
+
+	// Status is how running the fiddle ended, only present if it failed.
+	Status *types.Status `json:"status,omitempty"`
 }
 
 var (
@@ -115,8 +131,11 @@ var (
 	trailingToMedia = map[string]store.Media{
 		"_raster.png": store.CPU,
 		"_gpu.png":    store.GPU,
+		"_raster.gif": store.ANIM_CPU,
+		"_gpu.gif":    store.ANIM_GPU,
 		".pdf":        store.PDF,
 		".skp":        store.SKP,
+		".txt":        store.TXT,
 	}
 
 	// parseCompilerOutput parses the compiler output to look for lines
@@ -143,18 +162,31 @@ var (
 	// Note that slice items 2, 3, and 4 are the ones we are really interested in.
 	parseCompilerOutput = regexp.MustCompile("^(.*/)(draw.cpp:([0-9]+):([-0-9]+):.*)")
 	namedFailures       = metrics2.GetCounter("named-failures", nil)
+	namedChanged        = metrics2.GetCounter("named-changed", nil)
 	maybeSecViolations  = metrics2.GetCounter("maybe-sec-container-violation", nil)
 	runs                = metrics2.GetCounter("runs", nil)
 	tryNamedLiveness    = metrics2.NewLiveness("try-named")
 
 	build        *buildskia.ContinuousBuilder
-	fiddleStore  *store.Store
+	fiddleStore  store.Store
 	repo         *gitinfo.GitInfo
 	src          *source.Source
 	names        *named.Named
+	regressions  *regression.Store
 	failingNamed = []store.Named{}
 	failingMutex = sync.Mutex{}
 	depotTools   string
+	fiddleRunner runner.Runner
+	limits       *types.Limits
+
+	// outputCache caches the output of fiddles read from the store, keyed by
+	// the fiddle hash and the kind of output, since reading it lists the runs
+	// of the fiddle for every type of media.
+	outputCache = lru.New(OUTPUT_CACHE_SIZE)
+	// outputGeneration is incremented whenever output is removed from
+	// outputCache, so that output read concurrently isn't added back.
+	outputGeneration int64
+	outputMutex      = sync.Mutex{}
 )
 
 func loadTemplates() {
@@ -163,6 +195,7 @@ func loadTemplates() {
 		filepath.Join(*resourcesDir, "templates/iframe.html"),
 		filepath.Join(*resourcesDir, "templates/failing.html"),
 		filepath.Join(*resourcesDir, "templates/named.html"),
+		filepath.Join(*resourcesDir, "templates/regressions.html"),
 		// Sub templates used by other templates.
 		filepath.Join(*resourcesDir, "templates/header.html"),
 		filepath.Join(*resourcesDir, "templates/menu.html"),
@@ -208,6 +241,51 @@ func namedHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// regressionsHandler displays the named fiddles whose output changed
+// compared to the previous version of Skia.
+func regressionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	if *local {
+		loadTemplates()
+	}
+	if err := templates.ExecuteTemplate(w, "regressions.html", regressions.List(true)); err != nil {
+		glog.Errorf("Failed to expand template: %s", err)
+	}
+}
+
+// regressionsJSONHandler returns the results of comparing the named fiddles
+// to the previous version of Skia. Only the fiddles whose output changed are
+// returned unless the query parameter all=true is given.
+func regressionsJSONHandler(w http.ResponseWriter, r *http.Request) {
+	changedOnly := r.FormValue("all") != "true"
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(regressions.List(changedOnly)); err != nil {
+		httputils.ReportError(w, r, err, "Failed to JSON Encode response.")
+	}
+}
+
+// regressionImageHandler serves the before, after and diff images of the
+// named fiddle comparisons.
+//
+// The URLs look like:
+//
+//   /r/i/some_name/CPU/before
+//   /r/i/some_name/GPU/diff
+func regressionImageHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	body, err := regressions.GetImage(vars["name"], store.Media(vars["media"]), vars["kind"])
+	if err != nil {
+		http.NotFound(w, r)
+		glog.Errorf("Failed to retrieve regression image: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	if _, err := w.Write(body); err != nil {
+		glog.Errorf("Failed to write image: %s", err)
+	}
+}
+
 // iframeHandle handles permalinks to individual fiddles.
 func iframeHandle(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
@@ -229,6 +307,7 @@ func iframeHandle(w http.ResponseWriter, r *http.Request) {
 		Hash:    id,
 		Code:    code,
 		Options: *options,
+		Text:    fiddleText(fiddleHash),
 	}
 	w.Header().Set("Content-Type", "text/html")
 	if err := templates.ExecuteTemplate(w, "iframe.html", context); err != nil {
@@ -259,6 +338,7 @@ func individualHandle(w http.ResponseWriter, r *http.Request) {
 		Hash:    id,
 		Code:    code,
 		Options: *options,
+		Text:    fiddleText(fiddleHash),
 	}
 	w.Header().Set("Content-Type", "text/html")
 	if err := templates.ExecuteTemplate(w, "index.html", context); err != nil {
@@ -272,15 +352,20 @@ func individualHandle(w http.ResponseWriter, r *http.Request) {
 //
 //   /i/cbb8dee39e9f1576cd97c2d504db8eee_raster.png
 //   /i/cbb8dee39e9f1576cd97c2d504db8eee_gpu.png
+//   /i/cbb8dee39e9f1576cd97c2d504db8eee_raster.gif
+//   /i/cbb8dee39e9f1576cd97c2d504db8eee_gpu.gif
 //   /i/cbb8dee39e9f1576cd97c2d504db8eee.pdf
 //   /i/cbb8dee39e9f1576cd97c2d504db8eee.skp
+//   /i/cbb8dee39e9f1576cd97c2d504db8eee.txt
 //
 // or
 //
 //   /i/@some_name.png
 //   /i/@some_name_gpu.png
+//   /i/@some_name_gpu.gif
 //   /i/@some_name.pdf
 //   /i/@some_name.skp
+//   /i/@some_name.txt
 func imageHandler(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	fiddleHash, media, err := names.DereferenceImageID(id)
@@ -304,6 +389,118 @@ func imageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// cachedOutput returns the output of the given kind for the fiddle from
+// outputCache, calling load to read it from the store if it isn't cached.
+func cachedOutput(fiddleHash, kind string, load func() interface{}) interface{} {
+	key := fiddleHash + "-" + kind
+	outputMutex.Lock()
+	v, ok := outputCache.Get(key)
+	generation := outputGeneration
+	outputMutex.Unlock()
+	if ok {
+		return v
+	}
+	v = load()
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	if generation == outputGeneration {
+		outputCache.Add(key, v)
+	}
+	return v
+}
+
+// forgetOutput removes the output of the fiddle from outputCache. It must be
+// called whenever new output is stored for the fiddle.
+func forgetOutput(fiddleHash string) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	outputCache.Remove(fiddleHash + "-text")
+	outputCache.Remove(fiddleHash + "-output")
+	outputGeneration++
+}
+
+// fiddleText returns the text output of the most recent run of the fiddle, or
+// the empty string if it didn't produce any.
+func fiddleText(fiddleHash string) string {
+	return cachedOutput(fiddleHash, "text", func() interface{} {
+		b, _, _, err := fiddleStore.GetMedia(fiddleHash, store.TXT)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}).(string)
+}
+
+// fiddleOutput returns the base64 encoded media of the most recent run of the
+// fiddle. Media the fiddle didn't produce is left empty.
+func fiddleOutput(fiddleHash string) types.Output {
+	return cachedOutput(fiddleHash, "output", func() interface{} {
+		output := types.Output{}
+		media := map[store.Media]*string{
+			store.CPU:      &output.Raster,
+			store.GPU:      &output.Gpu,
+			store.PDF:      &output.Pdf,
+			store.SKP:      &output.Skp,
+			store.ANIM_CPU: &output.AnimatedRaster,
+			store.ANIM_GPU: &output.AnimatedGpu,
+		}
+		for m, dst := range media {
+			b, _, _, err := fiddleStore.GetMedia(fiddleHash, m)
+			if err != nil {
+				continue
+			}
+			*dst = base64.StdEncoding.EncodeToString(b)
+		}
+		return output
+	}).(types.Output)
+}
+
+// EmbedResults is the JSON served by embedHandler.
+type EmbedResults struct {
+	FiddleHash string        `json:"fiddleHash"`
+	Code       string        `json:"code"`
+	Options    types.Options `json:"options"`
+	Output     types.Output  `json:"output"` // Base64 encoded media, only present if the fiddle produced it.
+	Text       string        `json:"text"`
+}
+
+// embedHandler serves the code, options and output of a fiddle as JSON so
+// that fiddles can be embedded in other pages.
+//
+// The URLs look like:
+//
+//   /_/embed/cbb8dee39e9f1576cd97c2d504db8eee
+//
+// or
+//
+//   /_/embed/@some_name
+func embedHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	fiddleHash, err := names.DereferenceID(id)
+	if err != nil {
+		http.NotFound(w, r)
+		glog.Errorf("Invalid id: %s", err)
+		return
+	}
+	code, options, err := fiddleStore.GetCode(fiddleHash)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	res := &EmbedResults{
+		FiddleHash: fiddleHash,
+		Code:       code,
+		Options:    *options,
+		Output:     fiddleOutput(fiddleHash),
+		Text:       fiddleText(fiddleHash),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		glog.Errorf("Failed to write response: %s", err)
+	}
+}
+
 // sourceHandler serves up source image thumbnails.
 //
 // The URLs look like:
@@ -345,6 +542,10 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 		httputils.ReportError(w, r, err, "Failed to decode request.")
 		return
 	}
+	if err := req.Options.Validate(); err != nil {
+		httputils.ReportError(w, r, err, "Invalid options.")
+		return
+	}
 	glog.Infof("Request: %#v", *req)
 	current := build.Current()
 	glog.Infof("Building at: %s", current.Hash)
@@ -353,7 +554,7 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		httputils.ReportError(w, r, err, "Failed to write the fiddle.")
 	}
-	res, err := runner.Run(checkout, *fiddleRoot, depotTools, current.Hash, *local, tmpDir)
+	res, err := fiddleRunner.Run(checkout, current.Hash, tmpDir, &req.Options, limits)
 	if !*local && !*preserveTemp {
 		if err := os.RemoveAll(tmpDir); err != nil {
 			glog.Errorf("Failed to remove temp dir: %s", err)
@@ -363,10 +564,18 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 		httputils.ReportError(w, r, err, "Failed to run the fiddle")
 		return
 	}
+	resp.Text = res.Execute.Text
 	maybeSecViolation := false
-	if res.Execute.Errors != "" {
+	if status := res.Execute.Status; status != nil && (status.TimedOut || status.OutOfMemory) {
+		// Exceeding the resource limits isn't a security violation.
+		resp.Status = status
+		resp.RunTimeError = status.Message()
+	} else if res.Execute.Errors != "" || res.Errors != "" {
 		maybeSecViolation = true
 		resp.RunTimeError = "Failed to run, possibly violated security container."
+		if status != nil && status.Failed() {
+			resp.Status = status
+		}
 	}
 	// Take the compiler output and strip off all the implementation dependant information
 	// and format it to be retured in RunResults.
@@ -404,6 +613,7 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 		res = nil
 	}
 	fiddleHash, err := fiddleStore.Put(req.Code, req.Options, current.Hash, current.Timestamp, res)
+	forgetOutput(fiddleHash)
 	if err != nil {
 		httputils.ReportError(w, r, err, "Failed to store the fiddle.")
 		return
@@ -459,9 +669,31 @@ func makeResourceHandler() func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+// decodeOutputs returns the base64 decoded CPU and GPU images of the results,
+// keyed by media.
+func decodeOutputs(res *types.Result) (map[store.Media][]byte, error) {
+	ret := map[store.Media][]byte{}
+	for media, b64 := range map[store.Media]string{
+		store.CPU: res.Execute.Output.Raster,
+		store.GPU: res.Execute.Output.Gpu,
+	} {
+		b, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return nil, fmt.Errorf("Media wasn't properly encoded base64: %s", err)
+		}
+		ret[media] = b
+	}
+	return ret, nil
+}
+
+// singleStepTryNamed runs all the named fiddles at the current version of
+// Skia, records the ones that fail to compile or run, and compares the output
+// of the rest to the output stored for the previous version of Skia. The new
+// output is then stored so that it becomes the baseline for the next run.
 func singleStepTryNamed() {
 	glog.Infoln("Begin: Try all named fiddles.")
 	namedFailures.Reset()
+	namedChanged.Reset()
 	allNames, err := fiddleStore.ListAllNames()
 	if err != nil {
 		glog.Errorf("Failed to list all named fiddles: %s", err)
@@ -481,13 +713,23 @@ func singleStepTryNamed() {
 			glog.Errorf("Can't get code for %s: %s", name.Name, err)
 			continue
 		}
+		// Retrieve the previous output before the new output is stored.
+		before := map[store.Media][]byte{}
+		for _, media := range regression.COMPARED_MEDIA {
+			b, _, _, err := fiddleStore.GetMedia(fiddleHash, media)
+			if err != nil {
+				glog.Warningf("No previous output to compare against for %s: %s", name.Name, err)
+				continue
+			}
+			before[media] = b
+		}
 		checkout := filepath.Join(*fiddleRoot, "versions", current.Hash)
 		tmpDir, err := runner.WriteDrawCpp(checkout, *fiddleRoot, code, options, *local)
 		if err != nil {
 			glog.Errorf("Failed to write fiddle for %s: %s", name.Name, err)
 			continue
 		}
-		res, err := runner.Run(checkout, *fiddleRoot, depotTools, current.Hash, *local, tmpDir)
+		res, err := fiddleRunner.Run(checkout, current.Hash, tmpDir, options, limits)
 		if err != nil {
 			glog.Errorf("Failed to run fiddle for %s: %s", name.Name, err)
 			namedFailures.Inc(1)
@@ -498,6 +740,8 @@ func singleStepTryNamed() {
 			glog.Errorf("Failed to compile or run the named fiddle: %s", name.Name)
 			namedFailures.Inc(1)
 			failing = append(failing, name)
+		} else {
+			compareNamed(name.Name, fiddleHash, current, before, res)
 		}
 		if !*local && !*preserveTemp {
 			if err := os.RemoveAll(tmpDir); err != nil {
@@ -512,6 +756,30 @@ func singleStepTryNamed() {
 	failingNamed = failing
 }
 
+// compareNamed compares the output of a named fiddle to the previous output
+// and stores the new output.
+func compareNamed(name, fiddleHash string, current *vcsinfo.LongCommit, before map[store.Media][]byte, res *types.Result) {
+	after, err := decodeOutputs(res)
+	if err != nil {
+		glog.Errorf("Failed to decode output of %s: %s", name, err)
+		return
+	}
+	result, err := regressions.Add(name, fiddleHash, current.Hash, before, after)
+	if err != nil {
+		glog.Errorf("Failed to compare output of %s: %s", name, err)
+		return
+	}
+	if result.Changed {
+		glog.Warningf("The output of the named fiddle %s changed at %s.", name, current.Hash)
+		namedChanged.Inc(1)
+	}
+	err = fiddleStore.PutMedia(fiddleHash, current.Hash, current.Timestamp, res)
+	forgetOutput(fiddleHash)
+	if err != nil {
+		glog.Errorf("Failed to store output of %s: %s", name, err)
+	}
+}
+
 // StartTryNamed starts the Go routine that daily tests all of the named
 // fiddles and reports the ones that fail to build or run.
 func StartTryNamed() {
@@ -541,13 +809,23 @@ func main() {
 		glog.Fatal("The --fiddle_root flag is required.")
 	}
 	depotTools = filepath.Join(*fiddleRoot, "depot_tools")
+	fiddleRunner = runner.New(*fiddleRoot, *local)
+	limits = &types.Limits{
+		Timeout:     *runTimeout,
+		CPUSeconds:  *cpuLimit,
+		MemoryBytes: *memoryLimit,
+	}
 	loadTemplates()
 	var err error
 	repo, err = gitinfo.CloneOrUpdate(common.REPO_SKIA, filepath.Join(*fiddleRoot, "skia"), true)
 	if err != nil {
 		glog.Fatalf("Failed to clone Skia: %s", err)
 	}
-	fiddleStore, err = store.New()
+	if *storeDir != "" {
+		fiddleStore, err = store.NewLocal(*storeDir)
+	} else {
+		fiddleStore, err = store.New()
+	}
 	if err != nil {
 		glog.Fatalf("Failed to connect to store: %s", err)
 	}
@@ -559,6 +837,13 @@ func main() {
 		glog.Fatalf("Failed to initialize source images: %s", err)
 	}
 	names = named.New(fiddleStore)
+	if *regressionDir == "" {
+		*regressionDir = filepath.Join(*fiddleRoot, "regressions")
+	}
+	regressions, err = regression.New(*regressionDir)
+	if err != nil {
+		glog.Fatalf("Failed to initialize regression results: %s", err)
+	}
 	build = buildskia.New(*fiddleRoot, depotTools, repo, buildlib.BuildLib, 64, *timeBetweenBuilds, true)
 	build.Start()
 	StartTryNamed()
@@ -570,6 +855,10 @@ func main() {
 	r.HandleFunc("/s/{id:[0-9]+}", sourceHandler)
 	r.HandleFunc("/f/", failedHandler)
 	r.HandleFunc("/named/", namedHandler)
+	r.HandleFunc("/r/", regressionsHandler)
+	r.HandleFunc("/r/i/{name:[0-9a-zA-Z_]+}/{media:CPU|GPU}/{kind:before|after|diff}", regressionImageHandler)
+	r.HandleFunc("/_/regressions", regressionsJSONHandler)
+	r.HandleFunc("/_/embed/{id:[@0-9a-zA-Z_]+}", embedHandler)
 	r.HandleFunc("/", mainHandler)
 	r.HandleFunc("/_/run", runHandler)
 	r.HandleFunc("/oauth2callback/", login.OAuth2CallbackHandler)
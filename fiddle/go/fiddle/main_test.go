@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/fiddle/go/named"
+	"go.skia.org/infra/fiddle/go/store"
+	"go.skia.org/infra/fiddle/go/types"
+	"go.skia.org/infra/go/testutils"
+)
+
+func TestEmbedHandler(t *testing.T) {
+	testutils.MediumTest(t)
+	dir, err := ioutil.TempDir("", "fiddle-embed")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, dir)
+
+	fiddleStore, err = store.NewLocal(dir)
+	assert.NoError(t, err)
+	names = named.New(fiddleStore)
+
+	b64 := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	options := types.Options{
+		Width:  128,
+		Height: 64,
+	}
+	results := &types.Result{}
+	results.Execute.Output.Raster = b64("cpu-1")
+	results.Execute.Output.Gpu = b64("gpu-1")
+	results.Execute.Output.Pdf = b64("pdf-1")
+	results.Execute.Output.Skp = b64("skp-1")
+	results.Execute.Text = "x = 1\n"
+	fiddleHash, err := fiddleStore.Put("void draw(SkCanvas* canvas) {}", options, "aaaa", time.Unix(1000, 0), results)
+	assert.NoError(t, err)
+	assert.NoError(t, names.Add("star", fiddleHash, "me@google.com", false))
+
+	r := mux.NewRouter()
+	r.HandleFunc("/_/embed/{id:[@0-9a-zA-Z_]+}", embedHandler)
+	get := func(id string) (*httptest.ResponseRecorder, *EmbedResults) {
+		req, err := http.NewRequest("GET", "/_/embed/"+id, nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			return w, nil
+		}
+		res := &EmbedResults{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(res))
+		return w, res
+	}
+
+	// Fiddles can be embedded from any page.
+	w, res := get(fiddleHash)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, fiddleHash, res.FiddleHash)
+	assert.Equal(t, "void draw(SkCanvas* canvas) {}", res.Code)
+	assert.Equal(t, options, res.Options)
+	assert.Equal(t, b64("cpu-1"), res.Output.Raster)
+	assert.Equal(t, b64("gpu-1"), res.Output.Gpu)
+	assert.Equal(t, b64("pdf-1"), res.Output.Pdf)
+	assert.Equal(t, "", res.Output.AnimatedRaster)
+	assert.Equal(t, "x = 1\n", res.Text)
+
+	w, res = get("@star")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, fiddleHash, res.FiddleHash)
+
+	// The output is cached until new output is stored for the fiddle.
+	results.Execute.Output.Raster = b64("cpu-2")
+	results.Execute.Text = "x = 2\n"
+	assert.NoError(t, fiddleStore.PutMedia(fiddleHash, "bbbb", time.Unix(2000, 0), results))
+	_, res = get(fiddleHash)
+	assert.Equal(t, b64("cpu-1"), res.Output.Raster)
+	assert.Equal(t, "x = 1\n", res.Text)
+	forgetOutput(fiddleHash)
+	_, res = get(fiddleHash)
+	assert.Equal(t, b64("cpu-2"), res.Output.Raster)
+	assert.Equal(t, "x = 2\n", res.Text)
+
+	w, _ = get("@unknown")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = get("cbb8dee39e9f1576cd97c2d504db8eee")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -7,16 +7,20 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	osexec "os/exec"
 	"path"
 	"path/filepath"
 	"syscall"
 	"time"
 
+	"go.skia.org/infra/fiddle/go/animation"
 	"go.skia.org/infra/fiddle/go/types"
 	"go.skia.org/infra/go/buildskia"
 	"go.skia.org/infra/go/common"
-	"go.skia.org/infra/go/exec"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/go/util/limitwriter"
 )
 
 // flags
@@ -24,6 +28,12 @@ var (
 	local      = flag.Bool("local", false, "Running locally if true. As opposed to in production.")
 	fiddleRoot = flag.String("fiddle_root", "", "Directory location where all the work is done.")
 	gitHash    = flag.String("git_hash", "", "The version of Skia code to run against.")
+	frames     = flag.Int("frames", 0, "The number of frames to render. The fiddle is animated if larger than 1.")
+	duration   = flag.Float64("duration", 0, "The duration of an animated fiddle in seconds.")
+
+	timeout     = flag.Duration("timeout", types.DefaultLimits.Timeout, "The wall-clock limit for running a single frame of the fiddle.")
+	cpuLimit    = flag.Int("cpu_limit", types.DefaultLimits.CPUSeconds, "The limit of CPU seconds for running a single frame of the fiddle. Only enforced when not running locally.")
+	memoryLimit = flag.Int64("memory_limit", types.DefaultLimits.MemoryBytes, "The limit of the address space of the fiddle in bytes. Only enforced when not running locally.")
 )
 
 func serializeOutput(res types.Result) {
@@ -54,10 +64,12 @@ func main() {
 
 	// Set limits on this process and all its children.
 
-	// Limit total CPU seconds.
+	// Limit total CPU seconds. fiddle_secwrap can only lower the limit for
+	// the fiddle, so make sure this doesn't get in the way of the CPU limit.
+	cpuSeconds := uint64(util.MaxInt(10, *cpuLimit))
 	rLimit := &syscall.Rlimit{
-		Cur: 10,
-		Max: 10,
+		Cur: cpuSeconds,
+		Max: cpuSeconds,
 	}
 	if err := syscall.Setrlimit(syscall.RLIMIT_CPU, rLimit); err != nil {
 		fmt.Println("Error Setting Rlimit ", err)
@@ -95,30 +107,185 @@ func main() {
 		args = []string{}
 	}
 
+	limits := types.Limits{
+		Timeout:     *timeout,
+		CPUSeconds:  *cpuLimit,
+		MemoryBytes: *memoryLimit,
+	}
+	if *frames <= 1 {
+		frame := runFrame(name, args, nil, limits)
+		res.Execute.Output = frame.output
+		res.Execute.Errors = frame.errors
+		res.Execute.Status = frame.status
+		res.Execute.Text = types.TruncateText(frame.text)
+		serializeOutput(res)
+		return
+	}
+
+	// Run the fiddle once per frame, passing in the time of each frame, and
+	// combine the rasterized frames into animations.
+	rasters := make([]string, 0, *frames)
+	gpus := make([]string, 0, *frames)
+	text := ""
+	for i := 0; i < *frames; i++ {
+		env := []string{fmt.Sprintf("FIDDLE_FRAME=%g", float64(i)/float64(*frames))}
+		frame := runFrame(name, args, env, limits)
+		if res.Execute.Status != nil {
+			frame.status.PeakMemoryKB = util.MaxInt64(frame.status.PeakMemoryKB, res.Execute.Status.PeakMemoryKB)
+		}
+		res.Execute.Status = frame.status
+		if frame.text != "" {
+			text += fmt.Sprintf("Frame %d:\n%s", i, frame.text)
+		}
+		res.Execute.Text = types.TruncateText(text)
+		if frame.errors != "" {
+			res.Execute.Errors = fmt.Sprintf("Frame %d: %s", i, frame.errors)
+			serializeOutput(res)
+			return
+		}
+		if i == 0 {
+			res.Execute.Output = frame.output
+		}
+		rasters = append(rasters, frame.output.Raster)
+		gpus = append(gpus, frame.output.Gpu)
+	}
+	var err error
+	if res.Execute.Output.AnimatedRaster, err = animation.Encode(rasters, *duration); err != nil {
+		res.Execute.Errors = err.Error()
+	} else if res.Execute.Output.AnimatedGpu, err = animation.Encode(gpus, *duration); err != nil {
+		res.Execute.Errors = err.Error()
+	}
+	serializeOutput(res)
+}
+
+// frameResult is the result of running the compiled fiddle once.
+type frameResult struct {
+	// output is the parsed output of the fiddle.
+	output types.Output
+
+	// errors are the errors that occurred while running the fiddle, the
+	// empty string on success.
+	errors string
+
+	// text is the text the fiddle printed, e.g. via SkDebugf.
+	text string
+
+	// status is how the fiddle exited.
+	status *types.Status
+}
+
+// runFrame runs the compiled fiddle once.
+//
+//    name - The executable to run.
+//    args - The arguments to the executable.
+//    env - Additional environment variables, may be nil.
+//    limits - The resource limits to run the fiddle under.
+//
+// The fiddle writes its JSON output to stdout, so the text it prints, e.g. via
+// SkDebugf, is read from stderr.
+//
+// This uses os/exec directly since we need the exit status and the resource
+// usage of the process, which go/exec doesn't expose.
+func runFrame(name string, args, env []string, limits types.Limits) *frameResult {
+	output := types.Output{}
+	errors := ""
+	status := &types.Status{
+		Limits: limits,
+	}
 	stderr := bytes.Buffer{}
 	stdout := bytes.Buffer{}
-	runCmd := &exec.Command{
-		Name:        name,
-		Args:        args,
-		Dir:         *fiddleRoot,
-		InheritPath: true,
-		Stdout:      &stdout,
-		Stderr:      &stderr,
-		Timeout:     20 * time.Second,
-	}
-	if err := exec.Run(runCmd); err != nil {
-		res.Execute.Errors = err.Error()
+	cmd := osexec.Command(name, args...)
+	cmd.Dir = *fiddleRoot
+	// fiddle_secwrap reads the limits from the environment.
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("FIDDLE_CPU_LIMIT=%d", limits.CPUSeconds),
+		fmt.Sprintf("FIDDLE_MEMORY_LIMIT=%d", limits.MemoryBytes),
+	)
+	cmd.Env = append(cmd.Env, env...)
+	// Only keep as much of the output as is used, one byte more of the text
+	// so that TruncateText can tell it was truncated.
+	cmd.Stdout = limitwriter.New(&stdout, types.MAX_JSON_OUTPUT)
+	cmd.Stderr = limitwriter.New(&stderr, types.MAX_TEXT_OUTPUT+1)
+	// fiddle_secwrap reports the limits the fiddle exceeded on file
+	// descriptor 3.
+	reportReader, reportWriter, err := os.Pipe()
+	if err != nil {
+		status.ExitCode = -1
+		return &frameResult{
+			output: output,
+			errors: fmt.Sprintf("Failed to create pipe: %s", err),
+			status: status,
+		}
 	}
-	if res.Execute.Errors != "" && stderr.String() != "" {
-		res.Execute.Errors += "\n"
+	defer util.Close(reportReader)
+	if !*local {
+		cmd.ExtraFiles = []*os.File{reportWriter}
 	}
-	res.Execute.Errors += stderr.String()
-	if err := json.Unmarshal(stdout.Bytes(), &res.Execute.Output); err != nil {
-		if res.Execute.Errors != "" {
-			res.Execute.Errors += "\n"
+	// Run in a new process group, so that on timeout the traced fiddle is
+	// killed along with fiddle_secwrap.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err = cmd.Start()
+	util.Close(reportWriter)
+	if err != nil {
+		status.ExitCode = -1
+		return &frameResult{
+			output: output,
+			errors: fmt.Sprintf("Failed to start fiddle: %s", err),
+			status: status,
 		}
-		res.Execute.Errors += err.Error()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case <-time.After(limits.Timeout):
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			errors = fmt.Sprintf("Failed to kill timed out fiddle: %s", err)
+		}
+		err = <-done
+		status.TimedOut = true
+	case err = <-done:
+	}
+	if err != nil && errors == "" {
+		errors = err.Error()
 	}
 
-	serializeOutput(res)
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+		if ws.Signaled() {
+			status.ExitCode = -1
+			status.Signal = ws.Signal().String()
+		} else {
+			status.ExitCode = ws.ExitStatus()
+		}
+	}
+	if rusage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+		// Maxrss is in kilobytes on Linux.
+		status.PeakMemoryKB = rusage.Maxrss
+	}
+	report, err := ioutil.ReadAll(reportReader)
+	if err != nil && errors == "" {
+		errors = fmt.Sprintf("Failed to read report: %s", err)
+	}
+	status.DetectLimits(string(report))
+
+	// The text output is only an error if the fiddle failed.
+	if errors != "" && stderr.String() != "" {
+		errors += "\n" + stderr.String()
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		if errors != "" {
+			errors += "\n"
+		}
+		errors += err.Error()
+	}
+	if status.Failed() && errors == "" {
+		errors = status.Message()
+	}
+	return &frameResult{
+		output: output,
+		errors: errors,
+		text:   stderr.String(),
+		status: status,
+	}
 }
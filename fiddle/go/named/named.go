@@ -27,8 +27,11 @@ var (
 	trailingToMedia = map[string]store.Media{
 		"_raster.png": store.CPU,
 		"_gpu.png":    store.GPU,
+		"_raster.gif": store.ANIM_CPU,
+		"_gpu.gif":    store.ANIM_GPU,
 		".pdf":        store.PDF,
 		".skp":        store.SKP,
+		".txt":        store.TXT,
 	}
 )
 
@@ -148,8 +151,8 @@ func (n *Named) DereferenceImageID(id string) (string, store.Media, error) {
 		return "", store.UNKNOWN, fmt.Errorf("Not a valid image id: %q", id)
 	}
 	id = id[:len(id)-len(trailing)]
-	// If this is a .png then we need to strip off the trailing "_raster" or "_gpu".
-	if trailing == ".png" {
+	// If this is a .png or .gif then we need to strip off the trailing "_raster" or "_gpu".
+	if trailing == ".png" || trailing == ".gif" {
 		parts := strings.Split(id, "_")
 		if len(parts) < 2 {
 			return "", store.UNKNOWN, fmt.Errorf("Not a valid image id form: %q", id)
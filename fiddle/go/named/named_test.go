@@ -78,6 +78,16 @@ func TestNamed(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, mediaHash, "cbb8dee39e9f1576cd97c2d504db8eee")
 	assert.Equal(t, media, store.CPU)
+
+	mediaHash, media, err = names.DereferenceImageID("@star_gpu.gif")
+	assert.NoError(t, err)
+	assert.Equal(t, mediaHash, "cbb8dee39e9f1576cd97c2d504db8eee")
+	assert.Equal(t, media, store.ANIM_GPU)
+
+	mediaHash, media, err = names.DereferenceImageID("@star.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, mediaHash, "cbb8dee39e9f1576cd97c2d504db8eee")
+	assert.Equal(t, media, store.TXT)
 }
 
 func TestAdd(t *testing.T) {
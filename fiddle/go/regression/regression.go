@@ -0,0 +1,237 @@
+// Package regression compares the output of named fiddles against the output
+// of the previous version of Skia and keeps track of the fiddles whose output
+// changed.
+package regression
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/fiddle/go/store"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/diff"
+)
+
+// The kinds of images that are kept for each comparison.
+const (
+	BEFORE = "before"
+	AFTER  = "after"
+	DIFF   = "diff"
+
+	// RESULT_FILENAME is the name of the file each Result is stored in.
+	RESULT_FILENAME = "result.json"
+)
+
+var (
+	// COMPARED_MEDIA is the media that is compared between runs.
+	COMPARED_MEDIA = []store.Media{store.CPU, store.GPU}
+
+	// KINDS are all the kinds of images that are kept for each comparison.
+	KINDS = []string{BEFORE, AFTER, DIFF}
+)
+
+// Comparison is the result of comparing one type of media of a named fiddle.
+type Comparison struct {
+	Media   store.Media       `json:"media"`
+	Metrics *diff.DiffMetrics `json:"metrics"`
+	Changed bool              `json:"changed"`
+}
+
+// Result is the result of comparing the output of a named fiddle to the
+// output it had for the previous version of Skia.
+type Result struct {
+	Name        string        `json:"name"`
+	FiddleHash  string        `json:"fiddleHash"`
+	GitHash     string        `json:"gitHash"` // The version of Skia the fiddle was run at.
+	Timestamp   time.Time     `json:"timestamp"`
+	Comparisons []*Comparison `json:"comparisons"`
+	Changed     bool          `json:"changed"`
+}
+
+// Compare compares the PNG images before and after and returns the
+// Comparison and the diff image encoded as a PNG.
+func Compare(media store.Media, before, after []byte) (*Comparison, []byte, error) {
+	beforeImg, err := png.Decode(bytes.NewReader(before))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to decode the previous %s image: %s", media, err)
+	}
+	afterImg, err := png.Decode(bytes.NewReader(after))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to decode the current %s image: %s", media, err)
+	}
+	metrics, diffImg := diff.CalcDiff(diff.GetNRGBA(beforeImg), diff.GetNRGBA(afterImg))
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, diffImg); err != nil {
+		return nil, nil, fmt.Errorf("Failed to encode the %s diff image: %s", media, err)
+	}
+	return &Comparison{
+		Media:   media,
+		Metrics: metrics,
+		Changed: metrics.NumDiffPixels > 0 || metrics.DimDiffer,
+	}, buf.Bytes(), nil
+}
+
+// Store persists the Results of the named fiddles, along with the images that
+// were compared, in a local directory. The layout of the directory is:
+//
+//    <dir>/<name>/result.json
+//    <dir>/<name>/cpu_before.png
+//    <dir>/<name>/cpu_after.png
+//    <dir>/<name>/cpu_diff.png
+//    <dir>/<name>/gpu_before.png
+//    ...
+type Store struct {
+	dir string
+
+	// mutex protects results.
+	mutex sync.Mutex
+
+	// results maps the name of a fiddle to its latest Result.
+	results map[string]*Result
+}
+
+// New creates a new Store in the given directory, loading any Results that
+// were previously stored there.
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create regression dir: %s", err)
+	}
+	s := &Store{
+		dir:     dir,
+		results: map[string]*Result{},
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*", RESULT_FILENAME))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list stored results: %s", err)
+	}
+	for _, filename := range matches {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %s", filename, err)
+		}
+		res := &Result{}
+		if err := json.Unmarshal(b, res); err != nil {
+			glog.Errorf("Skipping corrupt result %s: %s", filename, err)
+			continue
+		}
+		s.results[res.Name] = res
+	}
+	return s, nil
+}
+
+// imageFilename returns the name of the file that stores the given kind of
+// image for the given media.
+func imageFilename(media store.Media, kind string) string {
+	return fmt.Sprintf("%s_%s.png", strings.ToLower(string(media)), kind)
+}
+
+// Add compares the output of the named fiddle and stores the Result.
+//
+//    name - The name of the fiddle.
+//    fiddleHash - The hash of the fiddle.
+//    gitHash - The version of Skia the fiddle was just run at.
+//    before - The PNG images from the previous version of Skia, keyed by media.
+//    after - The PNG images from the current version of Skia, keyed by media.
+//
+// Media that is missing from either before or after is not compared.
+func (s *Store) Add(name, fiddleHash, gitHash string, before, after map[store.Media][]byte) (*Result, error) {
+	res := &Result{
+		Name:        name,
+		FiddleHash:  fiddleHash,
+		GitHash:     gitHash,
+		Timestamp:   time.Now().UTC(),
+		Comparisons: []*Comparison{},
+	}
+	images := map[string][]byte{}
+	for _, media := range COMPARED_MEDIA {
+		b, ok := before[media]
+		if !ok {
+			continue
+		}
+		a, ok := after[media]
+		if !ok {
+			continue
+		}
+		c, diffImg, err := Compare(media, b, a)
+		if err != nil {
+			return nil, err
+		}
+		res.Comparisons = append(res.Comparisons, c)
+		res.Changed = res.Changed || c.Changed
+		images[imageFilename(media, BEFORE)] = b
+		images[imageFilename(media, AFTER)] = a
+		images[imageFilename(media, DIFF)] = diffImg
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	nameDir := filepath.Join(s.dir, name)
+	// Remove the images of the previous comparison.
+	if err := os.RemoveAll(nameDir); err != nil {
+		return nil, fmt.Errorf("Failed to clean up previous result for %s: %s", name, err)
+	}
+	if err := os.MkdirAll(nameDir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create result dir for %s: %s", name, err)
+	}
+	for filename, b := range images {
+		if err := ioutil.WriteFile(filepath.Join(nameDir, filename), b, 0644); err != nil {
+			return nil, fmt.Errorf("Failed to write image for %s: %s", name, err)
+		}
+	}
+	b, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode result for %s: %s", name, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(nameDir, RESULT_FILENAME), b, 0644); err != nil {
+		return nil, fmt.Errorf("Failed to write result for %s: %s", name, err)
+	}
+	s.results[name] = res
+	return res, nil
+}
+
+// List returns the stored Results sorted by name. If changedOnly is true then
+// only the Results of fiddles whose output changed are returned.
+func (s *Store) List(changedOnly bool) []*Result {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := []*Result{}
+	for _, res := range s.results {
+		if changedOnly && !res.Changed {
+			continue
+		}
+		ret = append(ret, res)
+	}
+	sort.Sort(resultSlice(ret))
+	return ret
+}
+
+// GetImage returns the PNG image of the given kind for the given named
+// fiddle and media.
+func (s *Store) GetImage(name string, media store.Media, kind string) ([]byte, error) {
+	if !util.In(kind, KINDS) {
+		return nil, fmt.Errorf("Unknown image kind: %q", kind)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.results[name]; !ok {
+		return nil, fmt.Errorf("No result for %q", name)
+	}
+	return ioutil.ReadFile(filepath.Join(s.dir, name, imageFilename(media, kind)))
+}
+
+// resultSlice is a utility type for sorting Results by name.
+type resultSlice []*Result
+
+func (p resultSlice) Len() int           { return len(p) }
+func (p resultSlice) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p resultSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,103 @@
+package regression
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/fiddle/go/store"
+	"go.skia.org/infra/go/testutils"
+)
+
+func encodePNG(t *testing.T, c color.Color, changed int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < 16; i++ {
+		img.Set(i%4, i/4, c)
+	}
+	for i := 0; i < changed; i++ {
+		img.Set(i%4, i/4, color.NRGBA{0xff, 0x00, 0x00, 0xff})
+	}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, png.Encode(buf, img))
+	return buf.Bytes()
+}
+
+func TestCompare(t *testing.T) {
+	testutils.SmallTest(t)
+	white := color.NRGBA{0xff, 0xff, 0xff, 0xff}
+
+	c, diffImg, err := Compare(store.CPU, encodePNG(t, white, 0), encodePNG(t, white, 0))
+	assert.NoError(t, err)
+	assert.False(t, c.Changed)
+	assert.Equal(t, 0, c.Metrics.NumDiffPixels)
+	assert.NotEqual(t, 0, len(diffImg))
+
+	c, _, err = Compare(store.GPU, encodePNG(t, white, 0), encodePNG(t, white, 2))
+	assert.NoError(t, err)
+	assert.True(t, c.Changed)
+	assert.Equal(t, store.GPU, c.Media)
+	assert.Equal(t, 2, c.Metrics.NumDiffPixels)
+
+	_, _, err = Compare(store.CPU, []byte("not a png"), encodePNG(t, white, 0))
+	assert.Error(t, err)
+}
+
+func TestStore(t *testing.T) {
+	testutils.MediumTest(t)
+	dir, err := ioutil.TempDir("", "regression")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, dir)
+
+	white := color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	same := encodePNG(t, white, 0)
+	changed := encodePNG(t, white, 3)
+
+	s, err := New(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(s.List(false)))
+
+	res, err := s.Add("star", "cbb8dee39e9f1576cd97c2d504db8eee", "aaaa",
+		map[store.Media][]byte{store.CPU: same, store.GPU: same},
+		map[store.Media][]byte{store.CPU: same, store.GPU: changed})
+	assert.NoError(t, err)
+	assert.True(t, res.Changed)
+	assert.Equal(t, 2, len(res.Comparisons))
+	assert.False(t, res.Comparisons[0].Changed)
+	assert.True(t, res.Comparisons[1].Changed)
+
+	// Only media present in both before and after is compared.
+	res, err = s.Add("circle", "aaaadee39e9f1576cd97c2d504db8eee", "aaaa",
+		map[store.Media][]byte{store.CPU: same},
+		map[store.Media][]byte{store.CPU: same, store.GPU: changed})
+	assert.NoError(t, err)
+	assert.False(t, res.Changed)
+	assert.Equal(t, 1, len(res.Comparisons))
+
+	assert.Equal(t, 1, len(s.List(true)))
+	all := s.List(false)
+	assert.Equal(t, 2, len(all))
+	assert.Equal(t, "circle", all[0].Name)
+
+	b, err := s.GetImage("star", store.GPU, AFTER)
+	assert.NoError(t, err)
+	assert.Equal(t, changed, b)
+	_, err = s.GetImage("star", store.GPU, "unknown")
+	assert.Error(t, err)
+	_, err = s.GetImage("missing", store.GPU, DIFF)
+	assert.Error(t, err)
+	_, err = s.GetImage("circle", store.GPU, DIFF)
+	assert.Error(t, err)
+
+	// Results are reloaded from disk.
+	s, err = New(dir)
+	assert.NoError(t, err)
+	changedResults := s.List(true)
+	assert.Equal(t, 1, len(changedResults))
+	assert.Equal(t, "star", changedResults[0].Name)
+	assert.Equal(t, "aaaa", changedResults[0].GitHash)
+	assert.Equal(t, 3, changedResults[0].Comparisons[1].Metrics.NumDiffPixels)
+}
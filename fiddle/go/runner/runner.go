@@ -32,6 +32,27 @@ DrawOptions GetDrawOptions() {
 
 %s
 `
+
+	// ANIMATION_PREFIX is a format string for the code that is added to
+	// animated fiddles. It makes the duration of the animation and the time
+	// of the current frame available to the draw function. The names are
+	// prefixed with fiddle_ so they don't clash with names in the user's
+	// code. fiddle_run passes the time of the frame in the FIDDLE_FRAME
+	// environment variable.
+	ANIMATION_PREFIX = `#include <stdlib.h>
+// The duration of the animation in seconds.
+static const double fiddle_duration = %g;
+// The time of the current frame, normalized to [0, 1).
+static const double fiddle_frame = getenv("FIDDLE_FRAME") ? atof(getenv("FIDDLE_FRAME")) : 0.0;
+
+`
+
+	// COMPILE_TIMEOUT is how long fiddle_run is given to compile the fiddle.
+	COMPILE_TIMEOUT = 5 * time.Minute
+
+	// MAX_INVALID_OUTPUT is the number of bytes of invalid fiddle_run output
+	// that are reported back.
+	MAX_INVALID_OUTPUT = 10 * 1024
 )
 
 // prepCodeToCompile adds the line numbers and the right prefix code
@@ -49,6 +70,9 @@ func prepCodeToCompile(fiddleRoot, code string, opts *types.Options) string {
 		filename := fmt.Sprintf("%d.png", opts.Source)
 		sourceImage = fmt.Sprintf("%q", filepath.Join(fiddleRoot, "images", filename))
 	}
+	if opts.IsAnimated() {
+		code = fmt.Sprintf(ANIMATION_PREFIX, opts.Duration) + code
+	}
 	return fmt.Sprintf(PREFIX, sourceImage, opts.Width, opts.Height, code)
 }
 
@@ -118,18 +142,111 @@ func GitHashTimeStamp(fiddleRoot, gitHash string) (time.Time, error) {
 	return commit.Timestamp.In(time.UTC), nil
 }
 
-// Run executes fiddle_run and then parses the JSON output into types.Results.
+// Runner compiles and runs fiddles by executing fiddle_run.
+type Runner interface {
+	// Run executes fiddle_run and then parses the JSON output into
+	// types.Results.
+	//
+	//    checkout - The directory of the Skia checkout to build against.
+	//    gitHash - The git hash of the version of Skia we have checked out.
+	//    tmpDir - The directory that contains the user's draw.cpp file, as
+	//        returned from WriteDrawCpp.
+	//    opts - The user's options about how to run the code. For animated
+	//        fiddles fiddle_run runs the code once per frame.
+	//    limits - The resource limits to run the compiled fiddle under.
+	//
+	// Failures of the fiddle, including exceeding the limits, are reported
+	// in the returned types.Result. An error is only returned if fiddle_run
+	// itself failed to run.
+	Run(checkout, gitHash, tmpDir string, opts *types.Options, limits *types.Limits) (*types.Result, error)
+}
+
+// New returns a LocalRunner if local is true, otherwise a NspawnRunner.
 //
 //    fiddleRoot - The root of the fiddle working directory. See DESIGN.md.
-//    gitHash - The git hash of the version of Skia we have checked out.
-//    local - Boolean, true if we are running locally, else we should execute
-//        fiddle_run under fiddle_secwrap.
-//    tmpDir - The directory outside the container to mount as FIDDLE_ROOT/src
-//        that contains the user's draw.cpp file. Only used if local is false.
+func New(fiddleRoot string, local bool) Runner {
+	if local {
+		return &LocalRunner{fiddleRoot: fiddleRoot}
+	}
+	return &NspawnRunner{fiddleRoot: fiddleRoot}
+}
+
+// fiddleRunArgs returns the command line arguments to fiddle_run for the
+// options and limits.
+func fiddleRunArgs(fiddleRoot, gitHash string, opts *types.Options, limits *types.Limits) []string {
+	args := []string{
+		"--fiddle_root", fiddleRoot, "--git_hash", gitHash,
+		"--timeout", limits.Timeout.String(),
+		"--cpu_limit", fmt.Sprintf("%d", limits.CPUSeconds),
+		"--memory_limit", fmt.Sprintf("%d", limits.MemoryBytes),
+	}
+	if opts.IsAnimated() {
+		args = append(args, "--frames", fmt.Sprintf("%d", opts.Frames), "--duration", fmt.Sprintf("%g", opts.Duration))
+	}
+	return args
+}
+
+// truncate returns s shortened to at most max bytes.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "..."
+}
+
+// runFiddleRun runs the fiddle_run command and parses its output.
 //
-// Returns the parsed JSON that fiddle_run emits to stdout.
+// fiddle_run is given enough time to compile the fiddle and then run every
+// frame. If that time runs out, or fiddle_run emits anything other than
+// JSON, the failure is reported in the returned types.Result.
+func runFiddleRun(name string, args []string, opts *types.Options, limits *types.Limits) (*types.Result, error) {
+	frames := 1
+	if opts.IsAnimated() {
+		frames = opts.Frames
+	}
+	output := &bytes.Buffer{}
+	runCmd := &exec.Command{
+		Name:      name,
+		Args:      args,
+		LogStderr: true,
+		Stdout:    output,
+		Timeout:   COMPILE_TIMEOUT + time.Duration(frames)*limits.Timeout,
+	}
+	if err := exec.Run(runCmd); err != nil {
+		if exec.IsTimeout(err) {
+			status := &types.Status{
+				ExitCode: -1,
+				TimedOut: true,
+				Limits:   *limits,
+			}
+			return &types.Result{
+				Errors: "fiddle_run timed out.",
+				Execute: types.Execute{
+					Errors: status.Message(),
+					Status: status,
+				},
+			}, nil
+		}
+		return nil, fmt.Errorf("fiddle_run failed to run %#v: %s", *runCmd, err)
+	}
+	// Parse the output into types.Result.
+	res := &types.Result{}
+	if err := json.Unmarshal(output.Bytes(), res); err != nil {
+		glog.Errorf("Received erroneous output: %q", output.String())
+		return &types.Result{
+			Errors: fmt.Sprintf("Failed to decode results from run: %s", err),
+			Execute: types.Execute{
+				Errors: truncate(output.String(), MAX_INVALID_OUTPUT),
+			},
+		}, nil
+	}
+	return res, nil
+}
+
+// NspawnRunner runs fiddle_run in a systemd-nspawn container, which in turn
+// runs the compiled fiddle under fiddle_secwrap.
 //
-// If non-local this should run something like:
+// It runs something like:
 //
 // sudo systemd-nspawn -D /mnt/pd0/container/ --read-only --private-network
 //  --machine foo
@@ -161,42 +278,37 @@ func GitHashTimeStamp(fiddleRoot, gitHash string) (time.Time, error) {
 // the point of making the bindings and then xargs will be able to execute the
 // exe within the container.
 //
-func Run(checkout, fiddleRoot, depotTools, gitHash string, local bool, tmpDir string) (*types.Result, error) {
-	machine := ""
-	if !local {
-		machine = path.Base(tmpDir)
-	}
+type NspawnRunner struct {
+	fiddleRoot string
+}
+
+// Run implements Runner.
+func (n *NspawnRunner) Run(checkout, gitHash, tmpDir string, opts *types.Options, limits *types.Limits) (*types.Result, error) {
+	machine := path.Base(tmpDir)
 	name := "sudo"
 	args := []string{
 		"systemd-nspawn", "-D", "/mnt/pd0/container/",
 		"--read-only",        // Mount the root file system as read only.
 		"--private-network",  // Turn off networking.
 		"--machine", machine, // Give the container a unique name, so we can run fiddles concurrently.
-		"--overlay", fmt.Sprintf("%s:%s:%s", fiddleRoot, tmpDir, fiddleRoot), // Build our copy-on-write layered filesystem. See OVERLAY note above.
+		"--overlay", fmt.Sprintf("%s:%s:%s", n.fiddleRoot, tmpDir, n.fiddleRoot), // Build our copy-on-write layered filesystem. See OVERLAY note above.
 		"--bind-ro", tmpDir + "/draw.cpp" + ":" + filepath.Join(checkout, "skia", "tools", "fiddle", "draw.cpp"), // Mount the user's draw.cpp over the default draw.cpp.
 		"xargs", "--arg-file=/dev/null", // See Note above for explanation of xargs.
-		"/mnt/pd0/fiddle/bin/fiddle_run", "--fiddle_root", fiddleRoot, "--git_hash", gitHash,
-	}
-	if local {
-		name = "fiddle_run"
-		args = []string{"--fiddle_root", fiddleRoot, "--git_hash", gitHash, "--local", "--alsologtostderr"}
-	}
-	output := &bytes.Buffer{}
-	runCmd := &exec.Command{
-		Name:      name,
-		Args:      args,
-		LogStderr: true,
-		Stdout:    output,
+		"/mnt/pd0/fiddle/bin/fiddle_run",
 	}
-	if err := exec.Run(runCmd); err != nil {
-		return nil, fmt.Errorf("fiddle_run failed to run %#v: %s", *runCmd, err)
-	}
-	// Parse the output into types.Result.
-	res := &types.Result{}
-	if err := json.Unmarshal(output.Bytes(), res); err != nil {
-		glog.Errorf("Received erroneous output: %q", output.String())
-		return nil, fmt.Errorf("Failed to decode results from run: %s", err)
-	}
-	// TODO Clean up the tmp directory.
-	return res, nil
+	args = append(args, fiddleRunArgs(n.fiddleRoot, gitHash, opts, limits)...)
+	return runFiddleRun(name, args, opts, limits)
+}
+
+// LocalRunner runs fiddle_run directly, without a container or
+// fiddle_secwrap, so only the wall-clock limit is enforced. It is used when
+// running fiddle locally and in tests.
+type LocalRunner struct {
+	fiddleRoot string
+}
+
+// Run implements Runner.
+func (l *LocalRunner) Run(checkout, gitHash, tmpDir string, opts *types.Options, limits *types.Limits) (*types.Result, error) {
+	args := append(fiddleRunArgs(l.fiddleRoot, gitHash, opts, limits), "--local", "--alsologtostderr")
+	return runFiddleRun("fiddle_run", args, opts, limits)
 }
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.skia.org/infra/fiddle/go/types"
@@ -46,6 +47,33 @@ DrawOptions GetDrawOptions() {
   return DrawOptions(128, 256, true, true, true, true, path);
 }
 
+#line 1
+void draw(SkCanvas* canvas) {
+#line 2
+}
+`
+	got = prepCodeToCompile("/mnt/pd0/fiddle/", "void draw(SkCanvas* canvas) {\n}", opts)
+	assert.Equal(t, want, got)
+
+	opts = &types.Options{
+		Width:    128,
+		Height:   256,
+		Source:   0,
+		Frames:   10,
+		Duration: 2.5,
+	}
+	want = `#include "fiddle_main.h"
+DrawOptions GetDrawOptions() {
+  static const char *path = 0; // Either a string, or 0.
+  return DrawOptions(128, 256, true, true, true, true, path);
+}
+
+#include <stdlib.h>
+// The duration of the animation in seconds.
+static const double fiddle_duration = 2.5;
+// The time of the current frame, normalized to [0, 1).
+static const double fiddle_frame = getenv("FIDDLE_FRAME") ? atof(getenv("FIDDLE_FRAME")) : 0.0;
+
 #line 1
 void draw(SkCanvas* canvas) {
 #line 2
@@ -98,13 +126,20 @@ func TestWriteDrawCpp(t *testing.T) {
 // execString is the command line that would have been run through exec.
 var execString string
 
+// execOutput is the output the command writes to stdout.
+var execOutput = "{}"
+
+// execTimeout is the timeout the command was run with.
+var execTimeout time.Duration
+
 // testRun is a 'exec.Run' function to use for testing.
 func testRun(cmd *exec.Command) error {
-	_, err := cmd.Stdout.Write([]byte("{}"))
+	_, err := cmd.Stdout.Write([]byte(execOutput))
 	if err != nil {
 		return fmt.Errorf("Internal error writing: %s", err)
 	}
 	execString = exec.DebugString(cmd)
+	execTimeout = cmd.Timeout
 	return nil
 }
 
@@ -114,13 +149,76 @@ func TestRun(t *testing.T) {
 	exec.SetRunForTesting(testRun)
 	defer exec.SetRunForTesting(exec.DefaultRun)
 
-	res, err := Run("checkout/", "fiddleroot/", "depot_tools/", "abcdef", true, "")
+	opts := &types.Options{
+		Width:  128,
+		Height: 256,
+	}
+	limits := &types.DefaultLimits
+	res, err := New("fiddleroot/", true).Run("checkout/", "abcdef", "", opts, limits)
 	assert.NoError(t, err)
 	assert.NotNil(t, res)
-	assert.Equal(t, "fiddle_run --fiddle_root fiddleroot/ --git_hash abcdef --local --alsologtostderr", execString)
+	assert.Equal(t, "fiddle_run --fiddle_root fiddleroot/ --git_hash abcdef --timeout 20s --cpu_limit 5 --memory_limit 1000000000 --local --alsologtostderr", execString)
+	assert.Equal(t, COMPILE_TIMEOUT+20*time.Second, execTimeout)
 
-	res, err = Run("checkout/", "fiddleroot/", "depot_tools/", "abcdef", false, "/mnt/pd0/fiddle/tmp/draw0123")
+	res, err = New("fiddleroot/", false).Run("checkout/", "abcdef", "/mnt/pd0/fiddle/tmp/draw0123", opts, limits)
 	assert.NoError(t, err)
 	assert.NotNil(t, res)
-	assert.Equal(t, "sudo systemd-nspawn -D /mnt/pd0/container/ --read-only --private-network --machine draw0123 --overlay fiddleroot/:/mnt/pd0/fiddle/tmp/draw0123:fiddleroot/ --bind-ro /mnt/pd0/fiddle/tmp/draw0123/draw.cpp:checkout/skia/tools/fiddle/draw.cpp xargs --arg-file=/dev/null /mnt/pd0/fiddle/bin/fiddle_run --fiddle_root fiddleroot/ --git_hash abcdef", execString)
+	assert.Equal(t, "sudo systemd-nspawn -D /mnt/pd0/container/ --read-only --private-network --machine draw0123 --overlay fiddleroot/:/mnt/pd0/fiddle/tmp/draw0123:fiddleroot/ --bind-ro /mnt/pd0/fiddle/tmp/draw0123/draw.cpp:checkout/skia/tools/fiddle/draw.cpp xargs --arg-file=/dev/null /mnt/pd0/fiddle/bin/fiddle_run --fiddle_root fiddleroot/ --git_hash abcdef --timeout 20s --cpu_limit 5 --memory_limit 1000000000", execString)
+
+	// Animated fiddles pass the animation options to fiddle_run.
+	opts.Frames = 10
+	opts.Duration = 2.5
+	res, err = New("fiddleroot/", true).Run("checkout/", "abcdef", "", opts, limits)
+	assert.NoError(t, err)
+	assert.NotNil(t, res)
+	assert.Equal(t, "fiddle_run --fiddle_root fiddleroot/ --git_hash abcdef --timeout 20s --cpu_limit 5 --memory_limit 1000000000 --frames 10 --duration 2.5 --local --alsologtostderr", execString)
+	assert.Equal(t, COMPILE_TIMEOUT+200*time.Second, execTimeout)
+}
+
+func TestRunStatus(t *testing.T) {
+	testutils.SmallTest(t)
+	exec.SetRunForTesting(testRun)
+	defer exec.SetRunForTesting(exec.DefaultRun)
+	defer func() {
+		execOutput = "{}"
+	}()
+
+	opts := &types.Options{
+		Width:  128,
+		Height: 256,
+	}
+	limits := &types.DefaultLimits
+	r := New("fiddleroot/", true)
+
+	// The status of the sandboxed fiddle is passed through.
+	execOutput = `{"execute":{"errors":"killed","status":{"exitCode":-1,"signal":"killed","outOfMemory":true,"peakMemoryKB":990000}}}`
+	res, err := r.Run("checkout/", "abcdef", "", opts, limits)
+	assert.NoError(t, err)
+	assert.NotNil(t, res.Execute.Status)
+	assert.True(t, res.Execute.Status.OutOfMemory)
+	assert.Equal(t, int64(990000), res.Execute.Status.PeakMemoryKB)
+	assert.Equal(t, "killed", res.Execute.Status.Signal)
+
+	// Invalid output is reported in the results.
+	execOutput = "Segmentation fault"
+	res, err = r.Run("checkout/", "abcdef", "", opts, limits)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", res.Errors)
+	assert.Equal(t, "Segmentation fault", res.Execute.Errors)
+
+	// fiddle_run timing out is reported in the results.
+	exec.SetRunForTesting(func(cmd *exec.Command) error {
+		return fmt.Errorf("%s %f secs", exec.TIMEOUT_ERROR_PREFIX, cmd.Timeout.Seconds())
+	})
+	res, err = r.Run("checkout/", "abcdef", "", opts, limits)
+	assert.NoError(t, err)
+	assert.True(t, res.Execute.Status.TimedOut)
+	assert.Equal(t, limits.Timeout, res.Execute.Status.Limits.Timeout)
+
+	// Other failures to run fiddle_run are errors.
+	exec.SetRunForTesting(func(cmd *exec.Command) error {
+		return fmt.Errorf("No such file.")
+	})
+	_, err = r.Run("checkout/", "abcdef", "", opts, limits)
+	assert.Error(t, err)
 }
@@ -18,11 +18,11 @@ import (
 type Source struct {
 	// thumbnails maps source image ids to the PNG bytes of the thumbnail.
 	thumbnails map[int][]byte
-	st         *store.Store
+	st         store.Store
 }
 
 // New create a new Source.
-func New(st *store.Store) (*Source, error) {
+func New(st store.Store) (*Source, error) {
 	s := &Source{
 		thumbnails: map[int][]byte{},
 		st:         st,
@@ -0,0 +1,301 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/fiddle/go/types"
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	// METADATA_EXT is the extension of the files that store the metadata of
+	// the file with the same name, i.e. the equivalent of the metadata of a
+	// Google Storage object.
+	METADATA_EXT = ".meta"
+)
+
+// LocalStore implements Store on a local directory, which allows running
+// fiddle without access to Google Storage.
+//
+// The layout of the directory mirrors the layout of the Google Storage bucket:
+//
+//   <dir>/fiddle/<fiddleHash>/draw.cpp
+//   <dir>/fiddle/<fiddleHash>/draw.cpp.meta
+//   <dir>/fiddle/<fiddleHash>/<runId>/cpu.png
+//   ...
+//   <dir>/named/<fiddle name>
+//   <dir>/named/<fiddle name>.meta
+//   <dir>/source/<id>.png
+//
+// Where the .meta files contain the metadata of the file as JSON.
+type LocalStore struct {
+	dir string
+
+	// mutex serializes writes.
+	mutex sync.Mutex
+}
+
+// NewLocal creates a new Store backed by the given local directory.
+func NewLocal(dir string) (Store, error) {
+	for _, sub := range []string{"fiddle", "named", "source"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("Failed to create store directory: %s", err)
+		}
+	}
+	return &LocalStore{
+		dir: dir,
+	}, nil
+}
+
+// writeFile writes the file and its metadata, if any.
+func (s *LocalStore) writeFile(path string, b []byte, metadata map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Failed to create directory for %s: %s", path, err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("Failed to write %s: %s", path, err)
+	}
+	if metadata == nil {
+		return nil
+	}
+	meta, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("Failed to encode metadata for %s: %s", path, err)
+	}
+	if err := ioutil.WriteFile(path+METADATA_EXT, meta, 0644); err != nil {
+		return fmt.Errorf("Failed to write metadata for %s: %s", path, err)
+	}
+	return nil
+}
+
+// readMetadata reads the metadata of the given file.
+func (s *LocalStore) readMetadata(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path + METADATA_EXT)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read metadata for %s: %s", path, err)
+	}
+	ret := map[string]string{}
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil, fmt.Errorf("Failed to decode metadata for %s: %s", path, err)
+	}
+	return ret, nil
+}
+
+// Put implements Store.
+func (s *LocalStore) Put(code string, options types.Options, gitHash string, ts time.Time, results *types.Result) (string, error) {
+	fiddleHash, err := options.ComputeHash(code)
+	if err != nil {
+		return "", fmt.Errorf("Could not compute hash for the code: %s", err)
+	}
+	s.mutex.Lock()
+	err = s.writeFile(filepath.Join(s.dir, "fiddle", fiddleHash, "draw.cpp"), []byte(code), optionsToMetadata(options))
+	s.mutex.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("There was a problem storing the code: %s", err)
+	}
+	// Write media, if any.
+	if results == nil {
+		return fiddleHash, nil
+	}
+	if err := s.PutMedia(fiddleHash, gitHash, ts, results); err != nil {
+		return fiddleHash, err
+	}
+	return fiddleHash, nil
+}
+
+// PutMedia implements Store.
+func (s *LocalStore) PutMedia(fiddleHash string, gitHash string, ts time.Time, results *types.Result) error {
+	runId := runIdFromGitHash(gitHash, ts)
+	files := map[Media]string{
+		CPU: results.Execute.Output.Raster,
+		GPU: results.Execute.Output.Gpu,
+		PDF: results.Execute.Output.Pdf,
+		SKP: results.Execute.Output.Skp,
+	}
+	// Only animated fiddles produce GIFs.
+	if results.Execute.Output.AnimatedRaster != "" {
+		files[ANIM_CPU] = results.Execute.Output.AnimatedRaster
+	}
+	if results.Execute.Output.AnimatedGpu != "" {
+		files[ANIM_GPU] = results.Execute.Output.AnimatedGpu
+	}
+	if results.Execute.Text != "" {
+		files[TXT] = base64.StdEncoding.EncodeToString([]byte(results.Execute.Text))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for media, b64 := range files {
+		if b64 == "" {
+			return fmt.Errorf("An empty file is not a valid %s file.", string(media))
+		}
+		body, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return fmt.Errorf("Media wasn't properly encoded base64: %s", err)
+		}
+		if err := s.writeFile(filepath.Join(s.dir, "fiddle", fiddleHash, runId, mediaProps[media].filename), body, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCode implements Store.
+func (s *LocalStore) GetCode(fiddleHash string) (string, *types.Options, error) {
+	path := filepath.Join(s.dir, "fiddle", fiddleHash, "draw.cpp")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to read source file for %s: %s", fiddleHash, err)
+	}
+	metadata, err := s.readMetadata(path)
+	if err != nil {
+		return "", nil, err
+	}
+	options, err := optionsFromMetadata(metadata)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(b), options, nil
+}
+
+// GetMedia implements Store.
+func (s *LocalStore) GetMedia(fiddleHash string, media Media) ([]byte, string, string, error) {
+	p, ok := mediaProps[media]
+	if !ok {
+		return nil, "", "", fmt.Errorf("Unknown media type: %q", string(media))
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(s.dir, "fiddle", fiddleHash))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("Failed to retrieve list of results for (%s, %s): %s", fiddleHash, string(media), err)
+	}
+	runIds := []string{}
+	for _, info := range infos {
+		if info.IsDir() {
+			runIds = append(runIds, info.Name())
+		}
+	}
+	if len(runIds) == 0 {
+		return nil, "", "", fmt.Errorf("This fiddle has no valid output written (%s, %s)", fiddleHash, string(media))
+	}
+	sort.Strings(runIds)
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, "fiddle", fiddleHash, runIds[len(runIds)-1], p.filename))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("Unable to read the media file (%s, %s): %s", fiddleHash, string(media), err)
+	}
+	return b, p.contentType, p.filename, nil
+}
+
+// DownloadAllSourceImages implements Store.
+func (s *LocalStore) DownloadAllSourceImages(fiddleRoot string) error {
+	if err := os.MkdirAll(filepath.Join(fiddleRoot, "images"), 0755); err != nil {
+		return fmt.Errorf("Failed to create images directory: %s", err)
+	}
+	ids, err := s.ListSourceImages()
+	if err != nil {
+		return err
+	}
+	for _, i := range ids {
+		filename := fmt.Sprintf("%d.png", i)
+		b, err := ioutil.ReadFile(filepath.Join(s.dir, "source", filename))
+		if err != nil {
+			glog.Errorf("Failed to read image %q: %s", filename, err)
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(fiddleRoot, "images", filename), b, 0644); err != nil {
+			glog.Errorf("Failed to copy image %q: %s", filename, err)
+		}
+	}
+	return nil
+}
+
+// GetSourceImage implements Store.
+func (s *LocalStore) GetSourceImage(i int) (image.Image, error) {
+	f, err := os.Open(filepath.Join(s.dir, "source", fmt.Sprintf("%d.png", i)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open image: %s", err)
+	}
+	defer util.Close(f)
+	return png.Decode(f)
+}
+
+// ListSourceImages implements Store.
+func (s *LocalStore) ListSourceImages() ([]int, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(s.dir, "source"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve image list: %s", err)
+	}
+	ret := []int{}
+	for _, info := range infos {
+		matches := sourceFileName.FindAllStringSubmatch(info.Name(), -1)
+		if len(matches) != 1 || len(matches[0]) != 2 {
+			glog.Infof("Filename %s is not a source image.", info.Name())
+			continue
+		}
+		i, err := strconv.Atoi(matches[0][1])
+		if err != nil {
+			glog.Errorf("Failed to parse souce image filename: %s", err)
+			continue
+		}
+		ret = append(ret, i)
+	}
+	sort.Ints(ret)
+	return ret, nil
+}
+
+// ListAllNames implements Store.
+func (s *LocalStore) ListAllNames() ([]Named, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(s.dir, "named"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve name list: %s", err)
+	}
+	ret := []Named{}
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), METADATA_EXT) {
+			continue
+		}
+		metadata, err := s.readMetadata(filepath.Join(s.dir, "named", info.Name()))
+		if err != nil {
+			glog.Errorf("Failed to read name metadata: %s", err)
+			metadata = map[string]string{}
+		}
+		ret = append(ret, Named{
+			Name: info.Name(),
+			User: metadata[USER_METADATA],
+		})
+	}
+	return ret, nil
+}
+
+// GetHashFromName implements Store.
+func (s *LocalStore) GetHashFromName(name string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, "named", name))
+	if err != nil {
+		return "", fmt.Errorf("Failed to read named file %q: %s", name, err)
+	}
+	return string(b), nil
+}
+
+// WriteName implements Store.
+func (s *LocalStore) WriteName(name, hash, user string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	metadata := map[string]string{
+		USER_METADATA: user,
+	}
+	if err := s.writeFile(filepath.Join(s.dir, "named", name), []byte(hash), metadata); err != nil {
+		return fmt.Errorf("Failed to write named file %q: %s", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/fiddle/go/types"
+	"go.skia.org/infra/go/testutils"
+)
+
+func TestLocalStore(t *testing.T) {
+	testutils.MediumTest(t)
+	dir, err := ioutil.TempDir("", "fiddle-store")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, dir)
+
+	st, err := NewLocal(dir)
+	assert.NoError(t, err)
+
+	// Code.
+	options := types.Options{
+		Width:    128,
+		Height:   64,
+		Source:   2,
+		Frames:   10,
+		Duration: 2.5,
+	}
+	b64 := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	results := &types.Result{}
+	results.Execute.Output.Raster = b64("cpu-1")
+	results.Execute.Output.Gpu = b64("gpu-1")
+	results.Execute.Output.Pdf = b64("pdf-1")
+	results.Execute.Output.Skp = b64("skp-1")
+	results.Execute.Output.AnimatedRaster = b64("gif-1")
+	results.Execute.Output.AnimatedGpu = b64("gif-1")
+	results.Execute.Text = "x = 1\n"
+	ts := time.Unix(1000, 0)
+	fiddleHash, err := st.Put("void draw(SkCanvas* canvas) {}", options, "aaaa", ts, results)
+	assert.NoError(t, err)
+	expectedHash, err := options.ComputeHash("void draw(SkCanvas* canvas) {}")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedHash, fiddleHash)
+
+	code, gotOptions, err := st.GetCode(fiddleHash)
+	assert.NoError(t, err)
+	assert.Equal(t, "void draw(SkCanvas* canvas) {}", code)
+	assert.Equal(t, options, *gotOptions)
+
+	_, _, err = st.GetCode("cbb8dee39e9f1576cd97c2d504db8eee")
+	assert.Error(t, err)
+
+	// Media, the most recent run wins.
+	body, contentType, filename, err := st.GetMedia(fiddleHash, CPU)
+	assert.NoError(t, err)
+	assert.Equal(t, "cpu-1", string(body))
+	assert.Equal(t, "image/png", contentType)
+	assert.Equal(t, "cpu.png", filename)
+
+	results.Execute.Output.Raster = b64("cpu-2")
+	assert.NoError(t, st.PutMedia(fiddleHash, "bbbb", ts.Add(time.Hour), results))
+	body, _, _, err = st.GetMedia(fiddleHash, CPU)
+	assert.NoError(t, err)
+	assert.Equal(t, "cpu-2", string(body))
+	body, contentType, _, err = st.GetMedia(fiddleHash, ANIM_GPU)
+	assert.NoError(t, err)
+	assert.Equal(t, "gif-1", string(body))
+	assert.Equal(t, "image/gif", contentType)
+	body, contentType, _, err = st.GetMedia(fiddleHash, TXT)
+	assert.NoError(t, err)
+	assert.Equal(t, "x = 1\n", string(body))
+	assert.Equal(t, "text/plain", contentType)
+
+	results.Execute.Output.Skp = ""
+	assert.Error(t, st.PutMedia(fiddleHash, "cccc", ts, results))
+
+	// Names.
+	assert.NoError(t, st.WriteName("star", fiddleHash, "fred@example.com"))
+	hash, err := st.GetHashFromName("star")
+	assert.NoError(t, err)
+	assert.Equal(t, fiddleHash, hash)
+	_, err = st.GetHashFromName("missing")
+	assert.Error(t, err)
+	names, err := st.ListAllNames()
+	assert.NoError(t, err)
+	assert.Equal(t, []Named{{Name: "star", User: "fred@example.com"}}, names)
+
+	// Source images.
+	buf := &bytes.Buffer{}
+	assert.NoError(t, png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, 4, 4))))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "source", "3.png"), buf.Bytes(), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "source", "lastid.txt"), []byte("3"), 0644))
+	ids, err := st.ListSourceImages()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3}, ids)
+	img, err := st.GetSourceImage(3)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, img.Bounds().Dx())
+
+	fiddleRoot := filepath.Join(dir, "fiddle_root")
+	assert.NoError(t, st.DownloadAllSourceImages(fiddleRoot))
+	copied, err := ioutil.ReadFile(filepath.Join(fiddleRoot, "images", "3.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, buf.Bytes(), copied)
+}
@@ -1,4 +1,5 @@
-// Stores and retrieves fiddles and associated assets in Google Storage.
+// Stores and retrieves fiddles and associated assets in Google Storage or in
+// a local directory.
 package store
 
 import (
@@ -39,6 +40,9 @@ const (
 	WIDTH_METADATA  = "width"
 	HEIGHT_METADATA = "height"
 	SOURCE_METADATA = "source"
+
+	FRAMES_METADATA   = "frames"
+	DURATION_METADATA = "duration"
 )
 
 // Media is the type of outputs we can get from running a fiddle.
@@ -46,11 +50,14 @@ type Media string
 
 // Media constants.
 const (
-	CPU     Media = "CPU"
-	GPU     Media = "GPU"
-	PDF     Media = "PDF"
-	SKP     Media = "SKP"
-	UNKNOWN Media = ""
+	CPU      Media = "CPU"
+	GPU      Media = "GPU"
+	PDF      Media = "PDF"
+	SKP      Media = "SKP"
+	ANIM_CPU Media = "ANIM_CPU"
+	ANIM_GPU Media = "ANIM_GPU"
+	TXT      Media = "TXT"
+	UNKNOWN  Media = ""
 )
 
 // props records the name and content-type for each type of Media and is used in mediaProps.
@@ -65,21 +72,128 @@ var (
 		GPU: props{filename: "gpu.png", contentType: "image/png"},
 		PDF: props{filename: "pdf.pdf", contentType: "application/pdf"},
 		SKP: props{filename: "skp.skp", contentType: "application/octet-stream"},
+
+		ANIM_CPU: props{filename: "cpu.gif", contentType: "image/gif"},
+		ANIM_GPU: props{filename: "gpu.gif", contentType: "image/gif"},
+
+		TXT: props{filename: "text.txt", contentType: "text/plain"},
 	}
 
 	// sourceFileName parses a souce image filename as stored in Google Storage.
 	sourceFileName = regexp.MustCompile("^([0-9]+).png$")
 )
 
-// cacheEntry is used to store PNGs in the Store lru cache.
+// Named is the information about a named fiddle.
+type Named struct {
+	Name string
+	User string
+}
+
+// Store is used to read and write user code, media, names and source images.
+type Store interface {
+	// Put writes the code and media and returns the fiddleHash.
+	//
+	//    code - The user's code.
+	//    options - The options the user chose to run the code under.
+	//    gitHash - The git checkout this was built under.
+	//    ts - The timestamp of the gitHash.
+	//    results - The results from running fiddle_run, if nil then only the
+	//              code is written.
+	Put(code string, options types.Options, gitHash string, ts time.Time, results *types.Result) (string, error)
+
+	// PutMedia writes the media for the given fiddleHash.
+	PutMedia(fiddleHash string, gitHash string, ts time.Time, results *types.Result) error
+
+	// GetCode returns the code and options for the given fiddle hash.
+	GetCode(fiddleHash string) (string, *types.Options, error)
+
+	// GetMedia returns the file, content-type, filename, and error for a given
+	// fiddle hash and type of media. The media of the most recent run is
+	// returned.
+	GetMedia(fiddleHash string, media Media) ([]byte, string, string, error)
+
+	// DownloadAllSourceImages copies all the source images as PNG images
+	// under FIDDLE_ROOT/images/.
+	DownloadAllSourceImages(fiddleRoot string) error
+
+	// GetSourceImage returns a single source image.
+	GetSourceImage(i int) (image.Image, error)
+
+	// ListSourceImages returns the ids of all the source images.
+	ListSourceImages() ([]int, error)
+
+	// ListAllNames returns the list of all named fiddles.
+	ListAllNames() ([]Named, error)
+
+	// GetHashFromName loads the fiddle hash for the given name.
+	GetHashFromName(name string) (string, error)
+
+	// WriteName writes the name file for a named fiddle.
+	WriteName(name, hash, user string) error
+}
+
+// runIdFromGitHash returns the unique identifier of a run, which sorts by
+// the time of the Skia commit.
+func runIdFromGitHash(gitHash string, ts time.Time) string {
+	return fmt.Sprintf("%s:%s", ts.UTC().Format(time.RFC3339), gitHash)
+}
+
+// optionsToMetadata returns the metadata that is stored along with the code.
+func optionsToMetadata(options types.Options) map[string]string {
+	ret := map[string]string{
+		WIDTH_METADATA:  fmt.Sprintf("%d", options.Width),
+		HEIGHT_METADATA: fmt.Sprintf("%d", options.Height),
+		SOURCE_METADATA: fmt.Sprintf("%d", options.Source),
+	}
+	if options.IsAnimated() {
+		ret[FRAMES_METADATA] = fmt.Sprintf("%d", options.Frames)
+		ret[DURATION_METADATA] = fmt.Sprintf("%g", options.Duration)
+	}
+	return ret
+}
+
+// optionsFromMetadata parses the metadata stored along with the code.
+func optionsFromMetadata(metadata map[string]string) (*types.Options, error) {
+	width, err := strconv.Atoi(metadata[WIDTH_METADATA])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse options width: %s", err)
+	}
+	height, err := strconv.Atoi(metadata[HEIGHT_METADATA])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse options height: %s", err)
+	}
+	source, err := strconv.Atoi(metadata[SOURCE_METADATA])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse options source: %s", err)
+	}
+	options := &types.Options{
+		Width:  width,
+		Height: height,
+		Source: source,
+	}
+	// Fiddles stored before animations were added don't have these values.
+	if frames, ok := metadata[FRAMES_METADATA]; ok {
+		if options.Frames, err = strconv.Atoi(frames); err != nil {
+			return nil, fmt.Errorf("Failed to parse options frames: %s", err)
+		}
+	}
+	if duration, ok := metadata[DURATION_METADATA]; ok {
+		if options.Duration, err = strconv.ParseFloat(duration, 64); err != nil {
+			return nil, fmt.Errorf("Failed to parse options duration: %s", err)
+		}
+	}
+	return options, nil
+}
+
+// cacheEntry is used to store PNGs in the GCSStore lru cache.
 type cacheEntry struct {
 	body  []byte
 	runId string
 }
 
-// Store is used to read and write user code and media to and from Google
+// GCSStore implements Store by reading and writing to and from Google
 // Storage.
-type Store struct {
+type GCSStore struct {
 	bucket *storage.BucketHandle
 
 	// cache is an in-memory cache of PNGs, where the keys are <fiddlehash>-<media>.
@@ -94,8 +208,8 @@ func shouldBeCached(media Media) bool {
 	return media == CPU || media == GPU
 }
 
-// New create a new Store.
-func New() (*Store, error) {
+// New create a new Store backed by Google Storage.
+func New() (Store, error) {
 	// TODO(jcgregorio) Decide is this needs to be a backoff client. May not be necessary if we add caching at this layer.
 	client, err := auth.NewDefaultJWTServiceAccountClient(auth.SCOPE_READ_WRITE)
 	if err != nil {
@@ -105,7 +219,7 @@ func New() (*Store, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Problem creating storage client: %s", err)
 	}
-	return &Store{
+	return &GCSStore{
 		bucket: storageClient.Bucket(FIDDLE_STORAGE_BUCKET),
 		cache:  lru.New(LRU_CACHE_SIZE),
 	}, nil
@@ -117,7 +231,7 @@ func New() (*Store, error) {
 //    fiddleHash - The hash of the fiddle.
 //    runId - A unique identifier for the specific run (git checkout of Skia).
 //    b64 - The contents of the media file base64 encoded.
-func (s *Store) writeMediaFile(media Media, fiddleHash, runId, b64 string) error {
+func (s *GCSStore) writeMediaFile(media Media, fiddleHash, runId, b64 string) error {
 	if b64 == "" {
 		return fmt.Errorf("An empty file is not a valid %s file.", string(media))
 	}
@@ -189,12 +303,21 @@ func (s *Store) writeMediaFile(media Media, fiddleHash, runId, b64 string) error
 //   gs://skia-fiddle/fiddle/<fiddleHash>/<runId>/skp.skp
 //   gs://skia-fiddle/fiddle/<fiddleHash>/<runId>/pdf.pdf
 //
+// Animated fiddles also write:
+//
+//   gs://skia-fiddle/fiddle/<fiddleHash>/<runId>/cpu.gif
+//   gs://skia-fiddle/fiddle/<fiddleHash>/<runId>/gpu.gif
+//
+// And fiddles that print text also write:
+//
+//   gs://skia-fiddle/fiddle/<fiddleHash>/<runId>/text.txt
+//
 // Where runId is <git commit timestamp in RFC3339>:<git commit hash>.
 //
 // If results is nil then only the code is written.
 //
 // Returns the fiddleHash.
-func (s *Store) Put(code string, options types.Options, gitHash string, ts time.Time, results *types.Result) (string, error) {
+func (s *GCSStore) Put(code string, options types.Options, gitHash string, ts time.Time, results *types.Result) (string, error) {
 	fiddleHash, err := options.ComputeHash(code)
 	if err != nil {
 		return "", fmt.Errorf("Could not compute hash for the code: %s", err)
@@ -204,11 +327,7 @@ func (s *Store) Put(code string, options types.Options, gitHash string, ts time.
 	w := s.bucket.Object(path).NewWriter(context.Background())
 	defer util.Close(w)
 	w.ObjectAttrs.ContentEncoding = "text/plain"
-	w.ObjectAttrs.Metadata = map[string]string{
-		WIDTH_METADATA:  fmt.Sprintf("%d", options.Width),
-		HEIGHT_METADATA: fmt.Sprintf("%d", options.Height),
-		SOURCE_METADATA: fmt.Sprintf("%d", options.Source),
-	}
+	w.ObjectAttrs.Metadata = optionsToMetadata(options)
 	if n, err := w.Write([]byte(code)); err != nil {
 		return "", fmt.Errorf("There was a problem storing the code. Uploaded %d bytes: %s", n, err)
 	}
@@ -236,14 +355,23 @@ func (s *Store) Put(code string, options types.Options, gitHash string, ts time.
 //   gs://skia-fiddle/fiddle/<fiddleHash>/<runId>/skp.skp
 //   gs://skia-fiddle/fiddle/<fiddleHash>/<runId>/pdf.pdf
 //
+// Animated fiddles also write:
+//
+//   gs://skia-fiddle/fiddle/<fiddleHash>/<runId>/cpu.gif
+//   gs://skia-fiddle/fiddle/<fiddleHash>/<runId>/gpu.gif
+//
+// And fiddles that print text also write:
+//
+//   gs://skia-fiddle/fiddle/<fiddleHash>/<runId>/text.txt
+//
 // Where runId is <git commit timestamp in RFC3339>:<git commit hash>.
 //
 // If results is nil then only the code is written.
 //
 // Returns the fiddleHash.
-func (s *Store) PutMedia(fiddleHash string, gitHash string, ts time.Time, results *types.Result) error {
+func (s *GCSStore) PutMedia(fiddleHash string, gitHash string, ts time.Time, results *types.Result) error {
 	// Write each of the media files.
-	runId := fmt.Sprintf("%s:%s", ts.UTC().Format(time.RFC3339), gitHash)
+	runId := runIdFromGitHash(gitHash, ts)
 	err := s.writeMediaFile(CPU, fiddleHash, runId, results.Execute.Output.Raster)
 	if err != nil {
 		return err
@@ -260,6 +388,25 @@ func (s *Store) PutMedia(fiddleHash string, gitHash string, ts time.Time, result
 	if err != nil {
 		return err
 	}
+	// Only animated fiddles produce GIFs.
+	if results.Execute.Output.AnimatedRaster != "" {
+		err = s.writeMediaFile(ANIM_CPU, fiddleHash, runId, results.Execute.Output.AnimatedRaster)
+		if err != nil {
+			return err
+		}
+	}
+	if results.Execute.Output.AnimatedGpu != "" {
+		err = s.writeMediaFile(ANIM_GPU, fiddleHash, runId, results.Execute.Output.AnimatedGpu)
+		if err != nil {
+			return err
+		}
+	}
+	if results.Execute.Text != "" {
+		err = s.writeMediaFile(TXT, fiddleHash, runId, base64.StdEncoding.EncodeToString([]byte(results.Execute.Text)))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -268,7 +415,7 @@ func (s *Store) PutMedia(fiddleHash string, gitHash string, ts time.Time, result
 //    fiddleHash - The fiddle hash.
 //
 // Returns the code and the options the code was run under.
-func (s *Store) GetCode(fiddleHash string) (string, *types.Options, error) {
+func (s *GCSStore) GetCode(fiddleHash string) (string, *types.Options, error) {
 	o := s.bucket.Object(fmt.Sprintf("fiddle/%s/draw.cpp", fiddleHash))
 	r, err := o.NewReader(context.Background())
 	if err != nil {
@@ -282,22 +429,9 @@ func (s *Store) GetCode(fiddleHash string) (string, *types.Options, error) {
 	if err != nil {
 		return "", nil, fmt.Errorf("Failed to read attributes for %s: %s", fiddleHash, err)
 	}
-	width, err := strconv.Atoi(attr.Metadata[WIDTH_METADATA])
+	options, err := optionsFromMetadata(attr.Metadata)
 	if err != nil {
-		return "", nil, fmt.Errorf("Failed to parse options width: %s", err)
-	}
-	height, err := strconv.Atoi(attr.Metadata[HEIGHT_METADATA])
-	if err != nil {
-		return "", nil, fmt.Errorf("Failed to parse options height: %s", err)
-	}
-	source, err := strconv.Atoi(attr.Metadata[SOURCE_METADATA])
-	if err != nil {
-		return "", nil, fmt.Errorf("Failed to parse options source: %s", err)
-	}
-	options := &types.Options{
-		Width:  width,
-		Height: height,
-		Source: source,
+		return "", nil, err
 	}
 	return string(b), options, nil
 }
@@ -308,7 +442,7 @@ func (s *Store) GetCode(fiddleHash string) (string, *types.Options, error) {
 //    fiddleHash - The hash of the fiddle.
 //
 // Returns the media file contents as a byte slice, the content-type, and the filename of the media.
-func (s *Store) GetMedia(fiddleHash string, media Media) ([]byte, string, string, error) {
+func (s *GCSStore) GetMedia(fiddleHash string, media Media) ([]byte, string, string, error) {
 	key := cacheKey(fiddleHash, media)
 	if c, ok := s.cache.Get(key); ok {
 		if entry, ok := c.(*cacheEntry); ok {
@@ -396,7 +530,7 @@ func downloadSingleSourceImage(ctx context.Context, bucket *storage.BucketHandle
 // and copies them as PNG images under FIDDLE_ROOT/images/.
 //
 //    fiddleRoot - The root directory where fiddle is working. See DESIGN.md.
-func (s *Store) DownloadAllSourceImages(fiddleRoot string) error {
+func (s *GCSStore) DownloadAllSourceImages(fiddleRoot string) error {
 	ctx := context.Background()
 	q := &storage.Query{
 		Prefix: fmt.Sprintf("source/"),
@@ -419,7 +553,7 @@ func (s *Store) DownloadAllSourceImages(fiddleRoot string) error {
 }
 
 // GetSourceImage downloads a single source image from the Google Storage bucket.
-func (s *Store) GetSourceImage(i int) (image.Image, error) {
+func (s *GCSStore) GetSourceImage(i int) (image.Image, error) {
 	ctx := context.Background()
 	r, err := s.bucket.Object(fmt.Sprintf("source/%d.png", i)).NewReader(ctx)
 	if err != nil {
@@ -430,7 +564,7 @@ func (s *Store) GetSourceImage(i int) (image.Image, error) {
 }
 
 // ListSourceImages returns the ids of all the images under gs://skia-fiddles/source/.
-func (s *Store) ListSourceImages() ([]int, error) {
+func (s *GCSStore) ListSourceImages() ([]int, error) {
 	ret := []int{}
 	ctx := context.Background()
 	q := &storage.Query{
@@ -457,14 +591,8 @@ func (s *Store) ListSourceImages() ([]int, error) {
 	return ret, nil
 }
 
-// Named is the information about a named fiddle.
-type Named struct {
-	Name string
-	User string
-}
-
 // ListAllNames returns the list of all named fiddles.
-func (s *Store) ListAllNames() ([]Named, error) {
+func (s *GCSStore) ListAllNames() ([]Named, error) {
 	ret := []Named{}
 	ctx := context.Background()
 	q := &storage.Query{
@@ -485,7 +613,7 @@ func (s *Store) ListAllNames() ([]Named, error) {
 }
 
 // GetHashFromName loads the fiddle hash for the given name.
-func (s *Store) GetHashFromName(name string) (string, error) {
+func (s *GCSStore) GetHashFromName(name string) (string, error) {
 	ctx := context.Background()
 	r, err := s.bucket.Object(fmt.Sprintf("named/%s", name)).NewReader(ctx)
 	if err != nil {
@@ -504,7 +632,7 @@ func (s *Store) GetHashFromName(name string) (string, error) {
 //   name - The name of the fidde.
 //   hash - The fiddle hash.
 //   user - The email of the user that created the name.
-func (s *Store) WriteName(name, hash, user string) error {
+func (s *GCSStore) WriteName(name, hash, user string) error {
 	ctx := context.Background()
 	w := s.bucket.Object(fmt.Sprintf("named/%s", name)).NewWriter(ctx)
 	defer util.Close(w)
@@ -13,4 +13,6 @@ func TestMedia(t *testing.T) {
 	assert.Equal(t, "CPU", string(CPU))
 	assert.Equal(t, "pdf.pdf", mediaProps[PDF].filename)
 	assert.Equal(t, "abcd-GPU", cacheKey("abcd", GPU))
+	assert.Equal(t, "gpu.gif", mediaProps[ANIM_GPU].filename)
+	assert.Equal(t, "image/gif", mediaProps[ANIM_CPU].contentType)
 }
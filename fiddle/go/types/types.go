@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"strings"
+	"syscall"
+	"time"
 
 	"go.skia.org/infra/fiddle/go/linenumbers"
 )
@@ -26,17 +28,145 @@ type Compile struct {
 type Execute struct {
 	Errors string `json:"errors"`
 	Output Output `json:"output"`
+
+	// Status describes how running the compiled fiddle ended, it is nil if
+	// the fiddle was never run, e.g. if the compile failed.
+	Status *Status `json:"status,omitempty"`
+
+	// Text is the text the fiddle printed, e.g. via SkDebugf, truncated to
+	// MAX_TEXT_OUTPUT bytes.
+	Text string `json:"text,omitempty"`
+}
+
+// TruncateText truncates the text output of a fiddle to MAX_TEXT_OUTPUT
+// bytes.
+func TruncateText(s string) string {
+	if len(s) <= MAX_TEXT_OUTPUT {
+		return s
+	}
+	return s[:MAX_TEXT_OUTPUT] + TRUNCATED_TEXT
+}
+
+// Limits are the resource limits the compiled fiddle is run under.
+type Limits struct {
+	// Timeout is the wall-clock limit for running a single frame.
+	Timeout time.Duration `json:"timeout"`
+
+	// CPUSeconds is the limit of CPU time for running a single frame.
+	CPUSeconds int `json:"cpuSeconds"`
+
+	// MemoryBytes is the limit of the address space of the running fiddle.
+	MemoryBytes int64 `json:"memoryBytes"`
+}
+
+// DefaultLimits are the limits that fiddles are run under unless configured
+// otherwise.
+var DefaultLimits = Limits{
+	Timeout:     20 * time.Second,
+	CPUSeconds:  5,
+	MemoryBytes: 1000000000,
+}
+
+// OUT_OF_MEMORY_REPORT is reported by fiddle_secwrap when the fiddle fails to
+// map memory because of the memory limit.
+const OUT_OF_MEMORY_REPORT = "out of memory"
+
+// Status describes how running the compiled fiddle in the sandbox ended.
+type Status struct {
+	// ExitCode is the exit code of the fiddle, -1 if it was killed by a
+	// signal.
+	ExitCode int `json:"exitCode"`
+
+	// Signal is the name of the signal that killed the fiddle, if any.
+	Signal string `json:"signal,omitempty"`
+
+	// TimedOut is true if the fiddle exceeded the wall-clock or CPU limit.
+	TimedOut bool `json:"timedOut,omitempty"`
+
+	// OutOfMemory is true if the fiddle exceeded the memory limit.
+	OutOfMemory bool `json:"outOfMemory,omitempty"`
+
+	// PeakMemoryKB is the maximum resident set size of the fiddle. It's only
+	// informational, since the memory limit applies to the address space,
+	// which allocations exhaust long before the resident set gets near it.
+	PeakMemoryKB int64 `json:"peakMemoryKB"`
+
+	// Limits are the limits the fiddle was run under.
+	Limits Limits `json:"limits"`
+}
+
+// Failed returns true if the fiddle did not exit cleanly.
+func (s *Status) Failed() bool {
+	return s.TimedOut || s.OutOfMemory || s.Signal != "" || s.ExitCode != 0
+}
+
+// DetectLimits marks a failed run as timed out or out of memory. The CPU
+// limit is enforced by SIGXCPU. Exceeding the memory limit makes allocations
+// fail, which fiddle_secwrap sees and reports out of band in 'report', so
+// that neither aborts for other reasons nor text printed by the fiddle are
+// mistaken for it.
+func (s *Status) DetectLimits(report string) {
+	if !s.Failed() {
+		return
+	}
+	if s.Signal == syscall.SIGXCPU.String() {
+		s.TimedOut = true
+	}
+	if strings.Contains(report, OUT_OF_MEMORY_REPORT) {
+		s.OutOfMemory = true
+	}
+}
+
+// Message returns a human readable description of why the run failed, or
+// the empty string if it didn't.
+func (s *Status) Message() string {
+	switch {
+	case s.TimedOut:
+		return fmt.Sprintf("The fiddle exceeded the time limit of %s, or %d seconds of CPU time.", s.Limits.Timeout, s.Limits.CPUSeconds)
+	case s.OutOfMemory:
+		return fmt.Sprintf("The fiddle ran out of memory, the limit is %d MB.", s.Limits.MemoryBytes/1000000)
+	case s.Signal != "":
+		return fmt.Sprintf("The fiddle was killed by signal: %s.", s.Signal)
+	case s.ExitCode != 0:
+		return fmt.Sprintf("The fiddle exited with code %d.", s.ExitCode)
+	}
+	return ""
 }
 
 // Output contains the base64 encoded files for each
 // of the output types.
+//
+// For animated fiddles Raster, Gpu, Pdf and Skp contain the output of the
+// first frame, and AnimatedRaster and AnimatedGpu contain all the frames
+// encoded as an animated GIF.
 type Output struct {
-	Raster string `json:"Raster"`
-	Gpu    string `json:"Gpu"`
-	Pdf    string `json:"Pdf"`
-	Skp    string `json:"Skp"`
+	Raster         string `json:"Raster"`
+	Gpu            string `json:"Gpu"`
+	Pdf            string `json:"Pdf"`
+	Skp            string `json:"Skp"`
+	AnimatedRaster string `json:"AnimatedRaster,omitempty"`
+	AnimatedGpu    string `json:"AnimatedGpu,omitempty"`
 }
 
+const (
+	// MAX_FRAMES is the maximum number of frames of an animated fiddle.
+	MAX_FRAMES = 60
+
+	// MAX_DURATION is the maximum duration of an animated fiddle in seconds.
+	MAX_DURATION = 60.0
+
+	// MAX_TEXT_OUTPUT is the maximum number of bytes of text output that is
+	// kept from running a fiddle.
+	MAX_TEXT_OUTPUT = 64 * 1024
+
+	// TRUNCATED_TEXT is appended to text output that was truncated.
+	TRUNCATED_TEXT = "\n[Output truncated]\n"
+
+	// MAX_JSON_OUTPUT is the maximum number of bytes of JSON output, i.e. the
+	// base64 encoded media, that is read from running a fiddle.
+	MAX_JSON_OUTPUT = 100 * 1024 * 1024
+)
+
 // Options are the users options they can select when running a fiddle that
 // will cause it to produce different output.
 //
@@ -45,6 +175,32 @@ type Options struct {
 	Width  int `json:"width"`
 	Height int `json:"height"`
 	Source int `json:"source"`
+
+	// Frames is the number of frames of an animated fiddle. The fiddle is
+	// only animated if Frames is larger than 1.
+	Frames int `json:"frames,omitempty"`
+
+	// Duration is the duration of an animated fiddle in seconds.
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// IsAnimated returns true if the options describe an animated fiddle.
+func (o *Options) IsAnimated() bool {
+	return o.Frames > 1
+}
+
+// Validate returns an error if the animation options are out of range.
+func (o *Options) Validate() error {
+	if !o.IsAnimated() {
+		return nil
+	}
+	if o.Frames > MAX_FRAMES {
+		return fmt.Errorf("An animation can have at most %d frames.", MAX_FRAMES)
+	}
+	if o.Duration <= 0 || o.Duration > MAX_DURATION {
+		return fmt.Errorf("The duration of an animation must be larger than 0 and at most %g seconds.", MAX_DURATION)
+	}
+	return nil
 }
 
 // ComputeHash calculates the fiddleHash for the given code and options.
@@ -54,13 +210,17 @@ type Options struct {
 //
 // The hash computation is a bit convoluted because it needs to be
 // backward compatible with the original version of fiddle so URLs
-// don't break.
+// don't break. For the same reason the animation options only contribute
+// to the hash of animated fiddles.
 func (o *Options) ComputeHash(code string) (string, error) {
 	lines := strings.Split(linenumbers.LineNumbers(code), "\n")
 	out := []string{
 		"DECLARE_bool(portableFonts);",
 		fmt.Sprintf("// WxH: %d, %d", o.Width, o.Height),
 	}
+	if o.IsAnimated() {
+		out = append(out, fmt.Sprintf("// Frames: %d, Duration: %g", o.Frames, o.Duration))
+	}
 	for _, line := range lines {
 		if strings.Contains(line, "%:") {
 			return "", fmt.Errorf("Unable to compile source.")
@@ -1,6 +1,8 @@
 package types
 
 import (
+	"strings"
+	"syscall"
 	"testing"
 
 	"go.skia.org/infra/go/testutils"
@@ -27,4 +29,106 @@ func TestOptions(t *testing.T) {
 	hash, err := o.ComputeHash(code)
 	assert.NoError(t, err)
 	assert.Equal(t, "cbb8dee39e9f1576cd97c2d504db8eee", hash)
+
+	// A single frame is not an animation and doesn't change the hash.
+	o.Frames = 1
+	o.Duration = 2
+	hash, err = o.ComputeHash(code)
+	assert.NoError(t, err)
+	assert.Equal(t, "cbb8dee39e9f1576cd97c2d504db8eee", hash)
+
+	// Animations have their own hash.
+	o.Frames = 10
+	animHash, err := o.ComputeHash(code)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, animHash)
+	o.Duration = 3
+	animHash2, err := o.ComputeHash(code)
+	assert.NoError(t, err)
+	assert.NotEqual(t, animHash, animHash2)
+}
+
+func TestValidate(t *testing.T) {
+	testutils.SmallTest(t)
+	o := Options{Width: 256, Height: 256}
+	assert.NoError(t, o.Validate())
+	o.Frames = 10
+	assert.Error(t, o.Validate())
+	o.Duration = 2.5
+	assert.NoError(t, o.Validate())
+	o.Frames = MAX_FRAMES + 1
+	assert.Error(t, o.Validate())
+	o.Frames = MAX_FRAMES
+	o.Duration = MAX_DURATION + 1
+	assert.Error(t, o.Validate())
+}
+
+func TestStatus(t *testing.T) {
+	testutils.SmallTest(t)
+	s := &Status{
+		Limits:       DefaultLimits,
+		PeakMemoryKB: 1024,
+	}
+	s.DetectLimits(OUT_OF_MEMORY_REPORT)
+	assert.False(t, s.Failed())
+	assert.False(t, s.OutOfMemory)
+	assert.Equal(t, "", s.Message())
+
+	s.ExitCode = 2
+	assert.True(t, s.Failed())
+	assert.Equal(t, "The fiddle exited with code 2.", s.Message())
+
+	s = &Status{
+		ExitCode: -1,
+		Signal:   "segmentation fault",
+		Limits:   DefaultLimits,
+	}
+	s.DetectLimits("")
+	assert.False(t, s.TimedOut)
+	assert.False(t, s.OutOfMemory)
+	assert.Equal(t, "The fiddle was killed by signal: segmentation fault.", s.Message())
+
+	s.DetectLimits(OUT_OF_MEMORY_REPORT + "\n")
+	assert.True(t, s.OutOfMemory)
+	assert.Equal(t, "The fiddle ran out of memory, the limit is 1000 MB.", s.Message())
+
+	// The resident set doesn't indicate running out of address space.
+	s = &Status{
+		ExitCode:     -1,
+		Signal:       "killed",
+		Limits:       DefaultLimits,
+		PeakMemoryKB: 950000,
+	}
+	s.DetectLimits("")
+	assert.False(t, s.OutOfMemory)
+
+	// Aborting, e.g. on a failed SkASSERT, isn't running out of memory.
+	s = &Status{
+		ExitCode:     -1,
+		Signal:       syscall.SIGABRT.String(),
+		Limits:       DefaultLimits,
+		PeakMemoryKB: 2048,
+	}
+	s.DetectLimits("")
+	assert.False(t, s.OutOfMemory)
+	assert.Equal(t, "The fiddle was killed by signal: aborted.", s.Message())
+
+	s = &Status{
+		ExitCode: -1,
+		Signal:   "CPU time limit exceeded",
+		Limits:   DefaultLimits,
+	}
+	s.DetectLimits("")
+	assert.True(t, s.TimedOut)
+	assert.Equal(t, "The fiddle exceeded the time limit of 20s, or 5 seconds of CPU time.", s.Message())
+}
+
+func TestTruncateText(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.Equal(t, "", TruncateText(""))
+	assert.Equal(t, "x = 1\n", TruncateText("x = 1\n"))
+	long := strings.Repeat("a", MAX_TEXT_OUTPUT+10)
+	truncated := TruncateText(long)
+	assert.Equal(t, MAX_TEXT_OUTPUT+len(TRUNCATED_TEXT), len(truncated))
+	assert.True(t, strings.HasSuffix(truncated, TRUNCATED_TEXT))
 }
@@ -32,7 +32,7 @@ type Fiddle struct {
 }
 
 // migrateCode copies all existing fiddles from the MySQL database to Google Storage.
-func migrateCode(db *sql.DB, st *store.Store, ts time.Time, hash string) error {
+func migrateCode(db *sql.DB, st store.Store, ts time.Time, hash string) error {
 	fiddles := []*Fiddle{}
 	code := ""
 	count := 0
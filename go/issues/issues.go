@@ -40,7 +40,14 @@ type IssueResponse struct {
 }
 
 type CommentRequest struct {
-	Content string `json:"content"`
+	Content string          `json:"content"`
+	Updates *CommentUpdates `json:"updates,omitempty"`
+}
+
+// CommentUpdates are changes made to an issue along with a comment, eg. setting
+// the Status to "Fixed" closes the issue.
+type CommentUpdates struct {
+	Status string `json:"status,omitempty"`
 }
 
 type MonorailPerson struct {
@@ -54,6 +61,7 @@ type IssueRequest struct {
 	Owner       MonorailPerson   `json:"owner"`
 	CC          []MonorailPerson `json:"cc"`
 	Labels      []string         `json:"labels"`
+	Components  []string         `json:"components,omitempty"`
 	Summary     string           `json:"summary"`
 	Description string           `json:"description"`
 }
@@ -144,3 +144,32 @@ func TestErrHandling(t *testing.T) {
 	assert.Error(t, errThree)
 	assert.NotEqual(t, err, errThree)
 }
+
+func TestRemove(t *testing.T) {
+	testutils.SmallTest(t)
+	nCalls := 0
+	var mutex sync.Mutex
+	worker := func(priority int64, id string) (interface{}, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		nCalls++
+		return id, nil
+	}
+
+	q := New(worker, 100, runtime.NumCPU())
+	_, err := q.Get(1, "id-1")
+	assert.NoError(t, err)
+	_, err = q.Get(1, "id-2")
+	assert.NoError(t, err)
+	assert.True(t, q.Contains("id-1"))
+
+	q.Remove([]string{"id-1"})
+	assert.False(t, q.Contains("id-1"))
+	assert.True(t, q.Contains("id-2"))
+
+	// Retrieving the removed item calls the worker function again.
+	_, err = q.Get(1, "id-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, nCalls)
+	q.(*MemReadThroughCache).shutdown()
+}
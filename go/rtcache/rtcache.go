@@ -21,6 +21,10 @@ type ReadThroughCache interface {
 
 	// Contains returns true if the identfied item is currently cached.
 	Contains(id string) bool
+
+	// Remove removes the identified items from the cache. Subsequent calls
+	// to Get will call the worker function again.
+	Remove(ids []string)
 }
 
 // WorkerFn defines the function that is called when an item is not in the
@@ -0,0 +1,128 @@
+// baseline contains functions to create and retrieve baselines, i.e. the
+// positive and negative digests of each test. Test harnesses can use a
+// baseline to skip uploading known-good digests and to fail fast on known-bad
+// digests without contacting the Gold server for each image.
+package baseline
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/types"
+)
+
+// Baseline is the compact representation of the positive and negative digests
+// of each test. The digest lists are sorted so they can be searched efficiently.
+type Baseline struct {
+	// Commit is the hash of the commit the baseline was created for. It is
+	// empty if the baseline reflects all current expectations.
+	Commit string `json:"commit"`
+
+	// Positive maps test names to the sorted list of positive digests.
+	Positive map[string][]string `json:"positive"`
+
+	// Negative maps test names to the sorted list of negative digests.
+	Negative map[string][]string `json:"negative"`
+}
+
+// Classification returns the label of the given test/digest pair according
+// to the baseline. Digests that are not part of the baseline are untriaged.
+func (b *Baseline) Classification(test, digest string) types.Label {
+	if contains(b.Positive[test], digest) {
+		return types.POSITIVE
+	}
+	if contains(b.Negative[test], digest) {
+		return types.NEGATIVE
+	}
+	return types.UNTRIAGED
+}
+
+// ETag returns a strong entity tag for the baseline. It only changes if the
+// content of the baseline changes.
+func (b *Baseline) ETag() (string, error) {
+	// The JSON encoding is deterministic since map keys are sorted by the
+	// encoder and digest lists are sorted.
+	jsonBytes, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("\"%x\"", md5.Sum(jsonBytes)), nil
+}
+
+// GetBaseline returns the baseline defined by the given expectations.
+//
+// If commitHash is empty the baseline contains all positive and negative
+// digests in exp. Otherwise commitHash has to be a commit in the given tile
+// and the baseline only contains the digests that appear in the tile at or
+// before that commit.
+func GetBaseline(exp *expstorage.Expectations, tile *tiling.Tile, commitHash string) (*Baseline, error) {
+	ret := &Baseline{
+		Commit:   commitHash,
+		Positive: map[string][]string{},
+		Negative: map[string][]string{},
+	}
+
+	// seen contains the digests that appear in the tile up to commitHash.
+	var seen map[string]map[string]bool = nil
+	if commitHash != "" {
+		commitIdx := -1
+		for idx, commit := range tile.Commits[:tile.LastCommitIndex()+1] {
+			if commit.Hash == commitHash {
+				commitIdx = idx
+				break
+			}
+		}
+		if commitIdx == -1 {
+			return nil, fmt.Errorf("Commit %s is not in the current tile.", commitHash)
+		}
+
+		seen = map[string]map[string]bool{}
+		for _, trace := range tile.Traces {
+			gTrace := trace.(*types.GoldenTrace)
+			testName := gTrace.Params()[types.PRIMARY_KEY_FIELD]
+			if _, ok := seen[testName]; !ok {
+				seen[testName] = map[string]bool{}
+			}
+			for _, digest := range gTrace.Values[:commitIdx+1] {
+				if digest != types.MISSING_DIGEST {
+					seen[testName][digest] = true
+				}
+			}
+		}
+	}
+
+	for testName, digests := range exp.Tests {
+		if (seen != nil) && (seen[testName] == nil) {
+			continue
+		}
+		for digest, label := range digests {
+			if (seen != nil) && !seen[testName][digest] {
+				continue
+			}
+			switch label {
+			case types.POSITIVE:
+				ret.Positive[testName] = append(ret.Positive[testName], digest)
+			case types.NEGATIVE:
+				ret.Negative[testName] = append(ret.Negative[testName], digest)
+			}
+		}
+	}
+
+	for _, digests := range ret.Positive {
+		sort.Strings(digests)
+	}
+	for _, digests := range ret.Negative {
+		sort.Strings(digests)
+	}
+	return ret, nil
+}
+
+// contains returns true if the sorted slice of digests contains digest.
+func contains(digests []string, digest string) bool {
+	idx := sort.SearchStrings(digests, digest)
+	return (idx < len(digests)) && (digests[idx] == digest)
+}
@@ -0,0 +1,135 @@
+package baseline
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"go.skia.org/infra/go/httputils"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/types"
+)
+
+func TestGetBaseline(t *testing.T) {
+	testutils.SmallTest(t)
+
+	tile, exp := testTileAndExpectations()
+
+	// The baseline at HEAD contains all expectations.
+	b, err := GetBaseline(exp, tile, "")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"foo": []string{"aaa", "ccc"}, "bar": []string{"eee"}}, b.Positive)
+	assert.Equal(t, map[string][]string{"foo": []string{"bbb"}, "bar": []string{"fff"}}, b.Negative)
+	assert.Equal(t, types.POSITIVE, b.Classification("foo", "ccc"))
+	assert.Equal(t, types.NEGATIVE, b.Classification("foo", "bbb"))
+	assert.Equal(t, types.UNTRIAGED, b.Classification("foo", "ddd"))
+	assert.Equal(t, types.UNTRIAGED, b.Classification("unknown", "aaa"))
+
+	// At the second commit only some of the digests have been seen.
+	b, err = GetBaseline(exp, tile, "commit-1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"foo": []string{"aaa"}, "bar": []string{"eee"}}, b.Positive)
+	assert.Equal(t, map[string][]string{"foo": []string{"bbb"}}, b.Negative)
+
+	_, err = GetBaseline(exp, tile, "unknown-commit")
+	assert.Error(t, err)
+
+	// The ETag only depends on the content.
+	b1, err := GetBaseline(exp, tile, "")
+	assert.NoError(t, err)
+	b2, err := GetBaseline(exp, tile, "")
+	assert.NoError(t, err)
+	etag1, err := b1.ETag()
+	assert.NoError(t, err)
+	etag2, err := b2.ETag()
+	assert.NoError(t, err)
+	assert.Equal(t, etag1, etag2)
+	etag3, err := b.ETag()
+	assert.NoError(t, err)
+	assert.NotEqual(t, etag1, etag3)
+}
+
+func TestClient(t *testing.T) {
+	testutils.MediumTest(t)
+
+	tile, exp := testTileAndExpectations()
+	nRequests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nRequests++
+		ServeBaseline(w, r, exp, tile)
+	}))
+	defer ts.Close()
+
+	client := NewClient(ts.URL+"/", httputils.NewTimeoutClient())
+	b, err := client.Get("commit-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "commit-1", b.Commit)
+	assert.Equal(t, types.POSITIVE, b.Classification("foo", "aaa"))
+	assert.Equal(t, types.UNTRIAGED, b.Classification("foo", "ccc"))
+
+	// The second request is answered with "Not Modified" and served from the
+	// cache.
+	cached, err := client.Get("commit-1")
+	assert.NoError(t, err)
+	assert.True(t, b == cached)
+	assert.Equal(t, 2, nRequests)
+
+	// A change in expectations results in a new baseline.
+	exp.AddDigests(map[string]types.TestClassification{"foo": {"ccc": types.NEGATIVE}})
+	b, err = client.Get("")
+	assert.NoError(t, err)
+	assert.Equal(t, types.NEGATIVE, b.Classification("foo", "ccc"))
+
+	_, err = client.Get("unknown-commit")
+	assert.Error(t, err)
+
+	// Baselines for issues aren't supported.
+	resp, err := http.Get(ts.URL + BASELINE_URL_PATH + "?issue=12345")
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+// testTileAndExpectations returns a tile with three commits and matching
+// expectations.
+func testTileAndExpectations() (*tiling.Tile, *expstorage.Expectations) {
+	tile := tiling.NewTile()
+	for i, hash := range []string{"commit-0", "commit-1", "commit-2"} {
+		tile.Commits[i] = &tiling.Commit{
+			Hash:       hash,
+			CommitTime: int64(1000 + i),
+		}
+	}
+
+	trace1 := types.NewGoldenTrace()
+	trace1.Values[0] = "aaa"
+	trace1.Values[1] = "bbb"
+	trace1.Values[2] = "ccc"
+	trace1.Params_[types.PRIMARY_KEY_FIELD] = "foo"
+	tile.Traces["foo:x86"] = trace1
+
+	trace2 := types.NewGoldenTrace()
+	trace2.Values[1] = "eee"
+	trace2.Values[2] = "fff"
+	trace2.Params_[types.PRIMARY_KEY_FIELD] = "bar"
+	tile.Traces["bar:x86"] = trace2
+
+	exp := expstorage.NewExpectations()
+	exp.AddDigests(map[string]types.TestClassification{
+		"foo": {
+			"aaa": types.POSITIVE,
+			"bbb": types.NEGATIVE,
+			"ccc": types.POSITIVE,
+			"ddd": types.UNTRIAGED,
+		},
+		"bar": {
+			"eee": types.POSITIVE,
+			"fff": types.NEGATIVE,
+		},
+	})
+	return tile, exp
+}
@@ -0,0 +1,97 @@
+package baseline
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	// BASELINE_URL_PATH is the path of the endpoint that serves baselines.
+	BASELINE_URL_PATH = "/json/baseline"
+)
+
+// Client retrieves baselines from a Gold server. It caches the retrieved
+// baselines and uses their ETags to avoid downloading unchanged baselines
+// again. It is safe to use from multiple Go routines.
+type Client struct {
+	serverURL  string
+	httpClient *http.Client
+	cache      map[string]*cacheEntry
+	mutex      sync.Mutex
+}
+
+// cacheEntry is a baseline cached by Client along with its ETag.
+type cacheEntry struct {
+	baseline *Baseline
+	etag     string
+}
+
+// NewClient returns a new client for the Gold server at serverURL,
+// e.g. "https://gold.skia.org".
+func NewClient(serverURL string, httpClient *http.Client) *Client {
+	return &Client{
+		serverURL:  strings.TrimRight(serverURL, "/"),
+		httpClient: httpClient,
+		cache:      map[string]*cacheEntry{},
+	}
+}
+
+// Get returns the baseline at the given commit. If commitHash is empty the
+// baseline reflects the current expectations.
+func (c *Client) Get(commitHash string) (*Baseline, error) {
+	params := url.Values{}
+	if commitHash != "" {
+		params.Set("commit", commitHash)
+	}
+	reqURL := c.serverURL + BASELINE_URL_PATH
+	if len(params) > 0 {
+		reqURL += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	cached := c.cache[reqURL]
+	c.mutex.Unlock()
+	if cached != nil {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve baseline from %s: %s", reqURL, err)
+	}
+	defer util.Close(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if cached == nil {
+			return nil, fmt.Errorf("Received unexpected 'Not Modified' response from %s", reqURL)
+		}
+		return cached.baseline, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("Retrieving baseline from %s failed with status: %s", reqURL, resp.Status)
+	}
+
+	ret := &Baseline{}
+	if err := json.NewDecoder(resp.Body).Decode(ret); err != nil {
+		return nil, fmt.Errorf("Unable to decode baseline: %s", err)
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		c.mutex.Lock()
+		c.cache[reqURL] = &cacheEntry{baseline: ret, etag: etag}
+		c.mutex.Unlock()
+	}
+	return ret, nil
+}
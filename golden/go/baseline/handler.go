@@ -0,0 +1,47 @@
+package baseline
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.skia.org/infra/go/httputils"
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/golden/go/expstorage"
+)
+
+// ServeBaseline writes the Baseline for the given expectations and tile in
+// response to a request for BASELINE_URL_PATH. The request parameter is
+// "commit", see GetBaseline. Baselines for CLs, i.e. with the expectations of
+// an issue applied, aren't supported yet, so requests with an "issue"
+// parameter fail. The response contains an ETag and honors the If-None-Match
+// header.
+func ServeBaseline(w http.ResponseWriter, r *http.Request, exp *expstorage.Expectations, tile *tiling.Tile) {
+	if issue := r.FormValue("issue"); issue != "" {
+		httputils.ReportError(w, r, fmt.Errorf("Baseline requested for issue %s.", issue), "Baselines for issues are not supported.")
+		return
+	}
+	b, err := GetBaseline(exp, tile, r.FormValue("commit"))
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to calculate baseline.")
+		return
+	}
+
+	etag, err := b.ETag()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to calculate ETag of baseline.")
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "max-age=60")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if err := json.NewEncoder(w).Encode(b); err != nil {
+		httputils.ReportError(w, r, err, "Failed to encode JSON response.")
+	}
+}
@@ -52,6 +52,36 @@ func (w WeightedBlameSlice) Len() int           { return len(w) }
 func (w WeightedBlameSlice) Less(i, j int) bool { return w[i].Prob < w[j].Prob }
 func (w WeightedBlameSlice) Swap(i, j int)      { w[i], w[j] = w[j], w[i] }
 
+// CommitBlame describes a test/digest pair that is blamed on a commit.
+type CommitBlame struct {
+	Test   string `json:"test"`
+	Digest string `json:"digest"`
+
+	// Weight is the likelihood that the commit caused the digest, i.e.
+	// one divided by the number of commits on the blamelist of the digest.
+	Weight float64 `json:"weight"`
+
+	// Old indicates that the digest has been seen prior to the current
+	// tile and the blame might be unreliable.
+	Old bool `json:"old"`
+}
+
+// CommitBlameSlice is a utility type for sorting CommitBlames by descending
+// weight, test name and digest.
+type CommitBlameSlice []*CommitBlame
+
+func (c CommitBlameSlice) Len() int      { return len(c) }
+func (c CommitBlameSlice) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c CommitBlameSlice) Less(i, j int) bool {
+	if c[i].Weight != c[j].Weight {
+		return c[i].Weight > c[j].Weight
+	}
+	if c[i].Test != c[j].Test {
+		return c[i].Test < c[j].Test
+	}
+	return c[i].Digest < c[j].Digest
+}
+
 // New returns a new Blamer instance and error. The error is not
 // nil if the first run of calculating the blame lists failed.
 func New(storages *storage.Storage) *Blamer {
@@ -107,6 +137,43 @@ func (b *Blamer) GetBlame(testName string, digest string, commits []*tiling.Comm
 	}
 }
 
+// GetBlamesForCommit returns all test/digest pairs whose blamelist contains
+// the commit with the given hash, sorted by descending weight. The returned
+// commit is nil if the commit is not in the current tile.
+func (b *Blamer) GetBlamesForCommit(commitHash string) ([]*CommitBlame, *tiling.Commit) {
+	blameLists, commits := b.GetAllBlameLists()
+	targetIdx := -1
+	for idx, commit := range commits {
+		if commit.Hash == commitHash {
+			targetIdx = idx
+			break
+		}
+	}
+	if targetIdx == -1 {
+		return nil, nil
+	}
+
+	ret := []*CommitBlame{}
+	for testName, digests := range blameLists {
+		for digest, blameDistribution := range digests {
+			commitIndices, maxCount := b.getBlame(blameDistribution, commits, commits)
+			for _, commitIdx := range commitIndices {
+				if commitIdx == targetIdx {
+					ret = append(ret, &CommitBlame{
+						Test:   testName,
+						Digest: digest,
+						Weight: 1.0 / float64(len(commitIndices)),
+						Old:    (maxCount != 0) && blameDistribution.Old,
+					})
+					break
+				}
+			}
+		}
+	}
+	sort.Sort(CommitBlameSlice(ret))
+	return ret, commits[targetIdx]
+}
+
 func (b *Blamer) getBlame(blameDistribution *BlameDistribution, blameCommits, commits []*tiling.Commit) ([]int, int) {
 	if (blameDistribution == nil) || (len(blameDistribution.Freq) == 0) {
 		return []int{}, 0
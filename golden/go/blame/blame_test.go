@@ -113,6 +113,24 @@ func TestBlamerWithSyntheticData(t *testing.T) {
 	assert.Equal(t, &BlameDistribution{Freq: []int{0}}, blamer.GetBlame("bar", DI_5, commits))
 	assert.Equal(t, &BlameDistribution{Freq: []int{0}}, blamer.GetBlame("bar", DI_6, commits))
 
+	// Look up the digests blamed on individual commits.
+	commitBlames, commit := blamer.GetBlamesForCommit("h4")
+	assert.Equal(t, "h4", commit.Hash)
+	assert.Equal(t, []*CommitBlame{
+		&CommitBlame{Test: "baz", Digest: DI_8, Weight: 1},
+		&CommitBlame{Test: "foo", Digest: DI_2, Weight: 1},
+	}, commitBlames)
+	commitBlames, _ = blamer.GetBlamesForCommit("h2")
+	assert.Equal(t, []*CommitBlame{
+		&CommitBlame{Test: "bar", Digest: DI_4, Weight: 1},
+		&CommitBlame{Test: "foo", Digest: DI_1, Weight: 1},
+	}, commitBlames)
+	commitBlames, _ = blamer.GetBlamesForCommit("h5")
+	assert.Equal(t, []*CommitBlame{}, commitBlames)
+	commitBlames, commit = blamer.GetBlamesForCommit("unknown")
+	assert.Nil(t, commitBlames)
+	assert.Nil(t, commit)
+
 	// Classify some digests and re-calculate.
 	changes := map[string]types.TestClassification{
 		"foo": map[string]types.Label{DI_1: types.POSITIVE, DI_2: types.NEGATIVE},
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -169,16 +170,40 @@ func (d *MemDiffStore) Get(priority int64, mainDigest string, rightDigests []str
 	return diffMap, nil
 }
 
-// TODO(stephana): Implement UnavailableDigests and PurgeDigests when/if we
-// re-add the endpoints to deal with image errors.
+// TODO(stephana): Implement UnavailableDigests when/if we re-add the
+// endpoints to deal with image errors.
 
 // UnavailableDigests implements the DiffStore interface.
 func (m *MemDiffStore) UnavailableDigests() map[string]*diff.DigestFailure {
 	return nil
 }
 
-// PurgeDigests implements the DiffStore interface.
+// PurgeDigests implements the DiffStore interface. It removes the images of
+// the given digests, all diff metrics that involve them and the
+// corresponding diff images.
 func (m *MemDiffStore) PurgeDigests(digests []string, purgeGS bool) error {
+	if len(digests) == 0 {
+		return nil
+	}
+
+	if err := m.imgLoader.Remove(digests, purgeGS); err != nil {
+		return err
+	}
+
+	diffIDs, err := m.removeDiffMetrics(util.NewStringSet(digests))
+	if err != nil {
+		return err
+	}
+	m.diffMetricsCache.Remove(diffIDs)
+
+	for _, id := range diffIDs {
+		leftDigest, rightDigest := splitDigests(id)
+		diffPath := fileutil.TwoLevelRadixPath(m.localDiffDir, getDiffImgFileName(leftDigest, rightDigest))
+		if err := os.Remove(diffPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Unable to remove diff image %s: %s", diffPath, err)
+		}
+	}
+	glog.Infof("Purged %d digests and %d diffs.", len(digests), len(diffIDs))
 	return nil
 }
 
@@ -335,6 +360,43 @@ func (d *MemDiffStore) saveDiffMetric(id string, dr *diff.DiffMetrics) error {
 	return err
 }
 
+// removeDiffMetrics removes all diff metrics from disk that involve one of
+// the given digests. It returns the ids of the removed diff metrics.
+func (d *MemDiffStore) removeDiffMetrics(digests util.StringSet) ([]string, error) {
+	diffIDs := []string{}
+	updateFn := func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(METRICS_BUCKET))
+		if bucket == nil {
+			return nil
+		}
+
+		// Collect the keys first since the bucket must not be modified
+		// while iterating over it.
+		err := bucket.ForEach(func(k, v []byte) error {
+			leftDigest, rightDigest := splitDigests(string(k))
+			if digests[leftDigest] || digests[rightDigest] {
+				diffIDs = append(diffIDs, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range diffIDs {
+			if err := bucket.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := d.metricsDB.Update(updateFn); err != nil {
+		return nil, err
+	}
+	return diffIDs, nil
+}
+
 func getDiffBasename(d1, d2 string) string {
 	if d1 < d2 {
 		return fmt.Sprintf("%s-%s", d1, d2)
@@ -101,6 +101,25 @@ func TestDiffStore(t *testing.T) {
 	ti.Stop()
 	memDiffStore.sync()
 	testDiffs(t, baseDir, memDiffStore, digests, digests, foundDiffs)
+
+	// Purge one digest and make sure its image and all its diffs are gone.
+	purged, kept := digests[0], digests[1:]
+	assert.NoError(t, diffStore.PurgeDigests([]string{purged}, false))
+	assert.False(t, memDiffStore.imgLoader.IsOnDisk(purged))
+	for _, d := range kept {
+		assert.True(t, memDiffStore.imgLoader.IsOnDisk(d))
+
+		dm, err := memDiffStore.loadDiffMetric(combineDigests(purged, d))
+		assert.NoError(t, err)
+		assert.Nil(t, dm)
+		diffPath := fileutil.TwoLevelRadixPath(memDiffStore.localDiffDir, getDiffImgFileName(purged, d))
+		assert.False(t, fileutil.FileExists(diffPath))
+	}
+	delete(foundDiffs, purged)
+	for _, found := range foundDiffs {
+		delete(found, purged)
+	}
+	testDiffs(t, baseDir, memDiffStore, kept, kept, foundDiffs)
 }
 
 func testDiffs(t *testing.T, baseDir string, diffStore *MemDiffStore, leftDigests, rightDigests []string, result map[string]map[string]*diff.DiffMetrics) {
@@ -8,6 +8,7 @@ import (
 	"image"
 	"io"
 	"net/http"
+	"os"
 	"path/filepath"
 	"sync"
 
@@ -139,6 +140,33 @@ func (il *ImageLoader) IsOnDisk(digest string) bool {
 	return fileutil.FileExists(fileutil.TwoLevelRadixPath(il.localImgDir, getDigestImageFileName(digest)))
 }
 
+// Remove removes the images of the given digests from the in-memory cache and
+// from disk. If purgeGS is true the images are also deleted in all
+// configured GS buckets.
+func (il *ImageLoader) Remove(digests []string, purgeGS bool) error {
+	il.imageCache.Remove(digests)
+
+	ctx := context.Background()
+	for _, digest := range digests {
+		imageFileName := getDigestImageFileName(digest)
+		imagePath := fileutil.TwoLevelRadixPath(il.localImgDir, imageFileName)
+		if err := os.Remove(imagePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Unable to remove image %s: %s", imagePath, err)
+		}
+
+		if purgeGS {
+			objLocation := filepath.Join(il.gsImageBaseDir, imageFileName)
+			for _, bucketName := range il.gsBucketNames {
+				err := il.storageClient.Bucket(bucketName).Object(objLocation).Delete(ctx)
+				if err != nil && err != storage.ErrObjectNotExist {
+					return fmt.Errorf("Unable to delete %s/%s: %s", bucketName, objLocation, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // imageLoadWorker implements the rtcache.ReadThroughFunc signature.
 // It loads an image file either from disk or from Google storage.
 func (il *ImageLoader) imageLoadWorker(priority int64, digest string) (interface{}, error) {
@@ -0,0 +1,196 @@
+// Package digestgc removes the images and diff metrics of digests that are
+// no longer referenced. It relies on the first/last seen timestamps that the
+// history package records in the DigestStore as new tiles are ingested.
+package digestgc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skia-dev/glog"
+
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/timer"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/digeststore"
+	"go.skia.org/infra/golden/go/storage"
+	"go.skia.org/infra/golden/go/types"
+)
+
+// Candidate is a digest that is eligible for garbage collection.
+type Candidate struct {
+	// Digest is the digest that is going to be removed.
+	Digest string `json:"digest"`
+
+	// Tests contains the tests that produced the digest.
+	Tests []string `json:"tests"`
+
+	// LastSeen is the commit time in seconds of the last time the digest
+	// appeared in any trace.
+	LastSeen int64 `json:"lastSeen"`
+}
+
+// Report summarizes a run of the garbage collector.
+type Report struct {
+	// DryRun is true if nothing was actually removed.
+	DryRun bool `json:"dryRun"`
+
+	// PurgeGS is true if images were (or would be) removed from GS.
+	PurgeGS bool `json:"purgeGS"`
+
+	// Timestamp is the time of the run in seconds.
+	Timestamp int64 `json:"timestamp"`
+
+	// Cutoff is the time in seconds before which a digest must have been
+	// seen last to be considered for removal.
+	Cutoff int64 `json:"cutoff"`
+
+	// Candidates are the digests that were (or would be) removed, sorted
+	// by the time they were last seen.
+	Candidates []*Candidate `json:"candidates"`
+}
+
+// GarbageCollector removes digests that have not appeared in any trace for
+// a given time and are not positive for any test.
+type GarbageCollector struct {
+	storages *storage.Storage
+	maxAge   time.Duration
+	purgeGS  bool
+
+	// mutex serializes runs of the garbage collector.
+	mutex sync.Mutex
+}
+
+// New returns a new instance of GarbageCollector. Digests that have not been
+// seen for maxAge are removed. If purgeGS is true their images are also
+// removed from Google storage.
+func New(storages *storage.Storage, maxAge time.Duration, purgeGS bool) *GarbageCollector {
+	return &GarbageCollector{
+		storages: storages,
+		maxAge:   maxAge,
+		purgeGS:  purgeGS,
+	}
+}
+
+// Start runs the garbage collector in the background in the given interval.
+func (g *GarbageCollector) Start(interval time.Duration) {
+	nPurged := metrics2.GetInt64Metric("gold.digestgc.purged-digests", nil)
+	liveness := metrics2.NewLiveness("gold.digestgc")
+	go func() {
+		for _ = range time.Tick(interval) {
+			report, err := g.Run(false)
+			if err != nil {
+				glog.Errorf("Error garbage collecting digests: %s", err)
+				continue
+			}
+			nPurged.Update(int64(len(report.Candidates)))
+			liveness.Reset()
+		}
+	}()
+}
+
+// Run finds all digests that are eligible for garbage collection. Unless
+// dryRun is true it purges them from the DiffStore and removes them from the
+// DigestStore. The returned report lists the digests that were found.
+func (g *GarbageCollector) Run(dryRun bool) (*Report, error) {
+	defer timer.New("digest gc").Stop()
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	report := &Report{
+		DryRun:     dryRun,
+		PurgeGS:    g.purgeGS,
+		Timestamp:  now.Unix(),
+		Cutoff:     now.Add(-g.maxAge).Unix(),
+		Candidates: []*Candidate{},
+	}
+
+	// Never remove digests that are in the current tile, including the
+	// ignored traces.
+	tilePair, err := g.storages.GetLastTileTrimmed()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve tile: %s", err)
+	}
+	keep := util.StringSet{}
+	for _, trace := range tilePair.TileWithIgnores.Traces {
+		keep.AddLists(trace.(*types.GoldenTrace).Values)
+	}
+
+	// Images are shared across tests, so a digest that is positive for any
+	// test has to be kept.
+	exp, err := g.storages.ExpectationsStore.Get()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve expectations: %s", err)
+	}
+	for _, digests := range exp.Tests {
+		for digest, label := range digests {
+			if label == types.POSITIVE {
+				keep[digest] = true
+			}
+		}
+	}
+
+	candidates := map[string]*Candidate{}
+	digestInfos := map[string][]*digeststore.DigestInfo{}
+	err = g.storages.DigestStore.Iterate(func(digestInfo *digeststore.DigestInfo) error {
+		if keep[digestInfo.Digest] {
+			return nil
+		}
+		c, ok := candidates[digestInfo.Digest]
+		if !ok {
+			c = &Candidate{Digest: digestInfo.Digest}
+			candidates[digestInfo.Digest] = c
+		}
+		c.Tests = append(c.Tests, digestInfo.TestName)
+		c.LastSeen = util.MaxInt64(c.LastSeen, digestInfo.Last)
+		digestInfos[digestInfo.Digest] = append(digestInfos[digestInfo.Digest], digestInfo)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to iterate over digests: %s", err)
+	}
+
+	digests := []string{}
+	removeInfos := []*digeststore.DigestInfo{}
+	for digest, c := range candidates {
+		if c.LastSeen < report.Cutoff {
+			sort.Strings(c.Tests)
+			report.Candidates = append(report.Candidates, c)
+			digests = append(digests, digest)
+			removeInfos = append(removeInfos, digestInfos[digest]...)
+		}
+	}
+	sort.Sort(candidateSlice(report.Candidates))
+
+	if dryRun || (len(digests) == 0) {
+		return report, nil
+	}
+
+	if err := g.storages.DiffStore.PurgeDigests(digests, g.purgeGS); err != nil {
+		return nil, fmt.Errorf("Unable to purge digests: %s", err)
+	}
+
+	// Only forget about the digests once their images are gone. Otherwise
+	// a failed purge would leave images behind that we never revisit.
+	if err := g.storages.DigestStore.Delete(removeInfos); err != nil {
+		return nil, fmt.Errorf("Unable to remove digests from digest store: %s", err)
+	}
+	glog.Infof("Garbage collected %d digests.", len(digests))
+	return report, nil
+}
+
+// candidateSlice is a utility type for sorting candidates by the time they
+// were last seen.
+type candidateSlice []*Candidate
+
+func (c candidateSlice) Len() int      { return len(c) }
+func (c candidateSlice) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c candidateSlice) Less(i, j int) bool {
+	if c[i].LastSeen == c[j].LastSeen {
+		return c[i].Digest < c[j].Digest
+	}
+	return c[i].LastSeen < c[j].LastSeen
+}
@@ -0,0 +1,124 @@
+package digestgc
+
+import (
+	"io/ioutil"
+	"sort"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"go.skia.org/infra/go/eventbus"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/golden/go/digeststore"
+	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/mocks"
+	"go.skia.org/infra/golden/go/storage"
+	"go.skia.org/infra/golden/go/types"
+)
+
+func TestGarbageCollector(t *testing.T) {
+	testutils.MediumTest(t)
+
+	storageDir, err := ioutil.TempDir("", "digestgc")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, storageDir)
+
+	digestStore, err := digeststore.New(storageDir)
+	assert.NoError(t, err)
+
+	// The current tile only contains digest "aaa".
+	tile := tiling.NewTile()
+	tile.Commits[0] = &tiling.Commit{Hash: "commit-0", CommitTime: time.Now().Unix()}
+	trace := types.NewGoldenTrace()
+	trace.Values[0] = "aaa"
+	trace.Params_[types.PRIMARY_KEY_FIELD] = "foo"
+	tile.Traces["foo:x86"] = trace
+
+	eventBus := eventbus.New(nil)
+	diffStore := &purgeRecorder{}
+	storages := &storage.Storage{
+		DiffStore:         diffStore,
+		ExpectationsStore: expstorage.NewMemExpectationsStore(eventBus),
+		MasterTileBuilder: mocks.NewMockTileBuilderFromTile(t, tile),
+		DigestStore:       digestStore,
+		EventBus:          eventBus,
+	}
+
+	old := time.Now().Add(-30 * 24 * time.Hour).Unix()
+	recent := time.Now().Add(-time.Hour).Unix()
+	assert.NoError(t, digestStore.Update([]*digeststore.DigestInfo{
+		// In the current tile.
+		{TestName: "foo", Digest: "aaa", First: old, Last: old},
+		// Old and untriaged for two tests.
+		{TestName: "foo", Digest: "bbb", First: old, Last: old},
+		{TestName: "bar", Digest: "bbb", First: old, Last: old},
+		// Old, but positive for another test.
+		{TestName: "foo", Digest: "ccc", First: old, Last: old},
+		// Seen recently.
+		{TestName: "foo", Digest: "ddd", First: old, Last: recent},
+		// Old and negative.
+		{TestName: "bar", Digest: "eee", First: old, Last: old - 10},
+	}))
+	assert.NoError(t, storages.ExpectationsStore.AddChange(map[string]types.TestClassification{
+		"bar": {"ccc": types.POSITIVE, "eee": types.NEGATIVE},
+	}, "user@example.com"))
+
+	gc := New(storages, 7*24*time.Hour, true)
+
+	// A dry run reports the candidates without removing them.
+	report, err := gc.Run(true)
+	assert.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []string{"eee", "bbb"}, candidateDigests(report))
+	assert.Equal(t, []string{"bar", "foo"}, report.Candidates[1].Tests)
+	assert.Equal(t, old, report.Candidates[1].LastSeen)
+	assert.Equal(t, 0, len(diffStore.purged))
+	_, ok, err := digestStore.Get("bar", "bbb")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// A real run purges the images and forgets the digests.
+	report, err = gc.Run(false)
+	assert.NoError(t, err)
+	assert.False(t, report.DryRun)
+	assert.Equal(t, []string{"eee", "bbb"}, candidateDigests(report))
+	sort.Strings(diffStore.purged)
+	assert.Equal(t, []string{"bbb", "eee"}, diffStore.purged)
+	assert.True(t, diffStore.purgeGS)
+	for _, testName := range []string{"foo", "bar"} {
+		_, ok, err = digestStore.Get(testName, "bbb")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	}
+	_, ok, err = digestStore.Get("foo", "ccc")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Nothing is left to collect.
+	report, err = gc.Run(true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(report.Candidates))
+}
+
+func candidateDigests(report *Report) []string {
+	ret := make([]string, 0, len(report.Candidates))
+	for _, c := range report.Candidates {
+		ret = append(ret, c.Digest)
+	}
+	return ret
+}
+
+// purgeRecorder is a DiffStore that records the purged digests.
+type purgeRecorder struct {
+	mocks.MockDiffStore
+	purged  []string
+	purgeGS bool
+}
+
+func (p *purgeRecorder) PurgeDigests(digests []string, purgeGS bool) error {
+	p.purged = append(p.purged, digests...)
+	p.purgeGS = purgeGS
+	return nil
+}
@@ -57,6 +57,16 @@ type DigestStore interface {
 	// Update updates the stored information about the testname/digest
 	// pairs identified in the list of DigestInfos.
 	Update(digetInfos []*DigestInfo) error
+
+	// Iterate calls fn for every stored DigestInfo. If fn returns an error
+	// the iteration stops and the error is returned. fn must not call
+	// any other method of the DigestStore.
+	Iterate(fn func(digestInfo *DigestInfo) error) error
+
+	// Delete removes the information about the testname/digest pairs
+	// identified in the list of DigestInfos. A pair that was seen after the
+	// Last timestamp of its DigestInfo, i.e. since it was retrieved, is kept.
+	Delete(digestInfos []*DigestInfo) error
 }
 
 type BoltDigestStore struct {
@@ -137,3 +147,50 @@ func (b BoltDigestStore) Update(digestInfos []*DigestInfo) error {
 		return nil
 	})
 }
+
+// Iterate implements the DigestStore interface. It runs in a single read
+// transaction, so fn sees a consistent snapshot of the store.
+func (b BoltDigestStore) Iterate(fn func(digestInfo *DigestInfo) error) error {
+	return b.digestDB.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(testName []byte, bucket *bolt.Bucket) error {
+			return bucket.ForEach(func(digest, jsonBytes []byte) error {
+				digestInfo := &DigestInfo{}
+				if err := json.Unmarshal(jsonBytes, digestInfo); err != nil {
+					return err
+				}
+				return fn(digestInfo)
+			})
+		})
+	})
+}
+
+// Delete implements the DigestStore interface. The Last timestamps are
+// checked in the same transaction as the deletion, so a digest seen by a
+// concurrent Update keeps its record.
+func (b BoltDigestStore) Delete(digestInfos []*DigestInfo) error {
+	return b.digestDB.Update(func(tx *bolt.Tx) error {
+		for _, digestInfo := range digestInfos {
+			bucket := tx.Bucket([]byte(digestInfo.TestName))
+			if bucket == nil {
+				continue
+			}
+
+			jsonBytes := bucket.Get([]byte(digestInfo.Digest))
+			if jsonBytes == nil {
+				continue
+			}
+			stored := &DigestInfo{}
+			if err := json.Unmarshal(jsonBytes, stored); err != nil {
+				return err
+			}
+			if stored.Last > digestInfo.Last {
+				continue
+			}
+
+			if err := bucket.Delete([]byte(digestInfo.Digest)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -54,4 +54,37 @@ func testDigestStore(t assert.TestingT, digestStore DigestStore) {
 
 	assert.Equal(t, timestamp_1, di.First)
 	assert.Equal(t, timestamp_2, di.Last)
+
+	// Add a digest of a second test and iterate over all digests.
+	testName_2, digest_2 := "sampleTest_2", "sampleDigest_2"
+	digestInfos = []*DigestInfo{
+		&DigestInfo{TestName: testName_2, Digest: digest_2, First: timestamp_1, Last: timestamp_1},
+	}
+	assert.NoError(t, digestStore.Update(digestInfos))
+
+	found := map[string]*DigestInfo{}
+	assert.NoError(t, digestStore.Iterate(func(digestInfo *DigestInfo) error {
+		found[digestInfo.TestName+":"+digestInfo.Digest] = digestInfo
+		return nil
+	}))
+	assert.Equal(t, 2, len(found))
+	assert.Equal(t, timestamp_2, found[testName_1+":"+digest_1].Last)
+	assert.Equal(t, timestamp_1, found[testName_2+":"+digest_2].Last)
+
+	// A digest that was seen since it was retrieved isn't deleted.
+	stale := *found[testName_1+":"+digest_1]
+	stale.Last = timestamp_1
+	assert.NoError(t, digestStore.Delete([]*DigestInfo{&stale}))
+	_, ok, err = digestStore.Get(testName_1, digest_1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Delete the first digest.
+	assert.NoError(t, digestStore.Delete([]*DigestInfo{found[testName_1+":"+digest_1]}))
+	_, ok, err = digestStore.Get(testName_1, digest_1)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = digestStore.Get(testName_2, digest_2)
+	assert.NoError(t, err)
+	assert.True(t, ok)
 }
@@ -0,0 +1,137 @@
+// flaky computes how unstable individual traces are over a tile. A trace
+// that keeps alternating between several digests from commit to commit is
+// considered flaky.
+package flaky
+
+import (
+	"sort"
+
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/go/timer"
+	"go.skia.org/infra/golden/go/types"
+)
+
+const (
+	// DEFAULT_MIN_DIGESTS is the default minimum number of distinct digests
+	// a trace needs to have to be considered flaky.
+	DEFAULT_MIN_DIGESTS = 3
+)
+
+// TraceFlakiness captures the flakiness score of a single trace.
+type TraceFlakiness struct {
+	// TraceID is the id of the trace in the tile.
+	TraceID string `json:"traceID"`
+
+	// Test is the name of the test this trace belongs to.
+	Test string `json:"test"`
+
+	// NDigests is the number of distinct digests in the trace.
+	NDigests int `json:"nDigests"`
+
+	// NTransitions is the number of times the digest changed between two
+	// consecutive non-missing values of the trace.
+	NTransitions int `json:"nTransitions"`
+
+	// NValues is the number of non-missing values in the trace.
+	NValues int `json:"nValues"`
+}
+
+// Score returns the fraction of consecutive values that differ, i.e. a value
+// in the range [0, 1] where 1 means that the digest changed at every commit.
+func (t *TraceFlakiness) Score() float64 {
+	if t.NValues <= 1 {
+		return 0
+	}
+	return float64(t.NTransitions) / float64(t.NValues-1)
+}
+
+// IsFlaky returns true if the trace has at least minDigests distinct digests.
+func (t *TraceFlakiness) IsFlaky(minDigests int) bool {
+	return t.NDigests >= minDigests
+}
+
+// FlakyTraces holds the flakiness of all traces in a tile.
+// It is not thread safe. The client of this package needs to make sure there
+// are no conflicts.
+type FlakyTraces struct {
+	byTrace map[string]*TraceFlakiness
+}
+
+// New creates a new instance of FlakyTraces.
+func New() *FlakyTraces {
+	return &FlakyTraces{
+		byTrace: map[string]*TraceFlakiness{},
+	}
+}
+
+// Calculate computes the flakiness of all traces in the given tile.
+func (f *FlakyTraces) Calculate(tile *tiling.Tile) {
+	f.byTrace = calcFlakiness(tile)
+}
+
+// Get returns the flakiness of the given trace or nil if the trace is unknown.
+func (f *FlakyTraces) Get(traceID string) *TraceFlakiness {
+	return f.byTrace[traceID]
+}
+
+// List returns all traces with at least minDigests distinct digests, sorted
+// so that the most flaky traces come first.
+func (f *FlakyTraces) List(minDigests int) []*TraceFlakiness {
+	ret := make([]*TraceFlakiness, 0, len(f.byTrace))
+	for _, tf := range f.byTrace {
+		if tf.IsFlaky(minDigests) {
+			ret = append(ret, tf)
+		}
+	}
+	sort.Sort(TraceFlakinessSlice(ret))
+	return ret
+}
+
+// calcFlakiness does the actual work of Calculate.
+func calcFlakiness(tile *tiling.Tile) map[string]*TraceFlakiness {
+	defer timer.New("flaky").Stop()
+	ret := make(map[string]*TraceFlakiness, len(tile.Traces))
+	for id, tr := range tile.Traces {
+		gTrace := tr.(*types.GoldenTrace)
+		digests := map[string]bool{}
+		nTransitions := 0
+		nValues := 0
+		lastDigest := ""
+		for _, digest := range gTrace.Values {
+			if digest == types.MISSING_DIGEST {
+				continue
+			}
+			nValues++
+			digests[digest] = true
+			if (lastDigest != "") && (lastDigest != digest) {
+				nTransitions++
+			}
+			lastDigest = digest
+		}
+
+		ret[id] = &TraceFlakiness{
+			TraceID:      id,
+			Test:         gTrace.Params()[types.PRIMARY_KEY_FIELD],
+			NDigests:     len(digests),
+			NTransitions: nTransitions,
+			NValues:      nValues,
+		}
+	}
+	return ret
+}
+
+// TraceFlakinessSlice is a utility type to sort TraceFlakiness instances,
+// by number of distinct digests and then by number of transitions.
+type TraceFlakinessSlice []*TraceFlakiness
+
+func (t TraceFlakinessSlice) Len() int { return len(t) }
+func (t TraceFlakinessSlice) Less(i, j int) bool {
+	if t[i].NDigests != t[j].NDigests {
+		return t[i].NDigests > t[j].NDigests
+	}
+	if t[i].NTransitions != t[j].NTransitions {
+		return t[i].NTransitions > t[j].NTransitions
+	}
+	return t[i].TraceID < t[j].TraceID
+}
+func (t TraceFlakinessSlice) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
@@ -0,0 +1,59 @@
+package flaky
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/golden/go/types"
+)
+
+func TestFlakyTraces(t *testing.T) {
+	testutils.SmallTest(t)
+
+	tile := tiling.NewTile()
+	addTrace(tile, "foo:stable", "foo", "aaa", "aaa", "", "aaa")
+	addTrace(tile, "foo:changed", "foo", "aaa", "bbb", "bbb", "bbb")
+	addTrace(tile, "bar:flaky", "bar", "aaa", "bbb", "", "ccc", "aaa", "bbb")
+	addTrace(tile, "bar:empty", "bar")
+
+	flakyTraces := New()
+	flakyTraces.Calculate(tile)
+
+	stable := flakyTraces.Get("foo:stable")
+	assert.Equal(t, 1, stable.NDigests)
+	assert.Equal(t, 0, stable.NTransitions)
+	assert.Equal(t, 3, stable.NValues)
+	assert.Equal(t, 0.0, stable.Score())
+
+	changed := flakyTraces.Get("foo:changed")
+	assert.Equal(t, 2, changed.NDigests)
+	assert.Equal(t, 1, changed.NTransitions)
+
+	flaky := flakyTraces.Get("bar:flaky")
+	assert.Equal(t, "bar", flaky.Test)
+	assert.Equal(t, 3, flaky.NDigests)
+	assert.Equal(t, 4, flaky.NTransitions)
+	assert.Equal(t, 5, flaky.NValues)
+	assert.Equal(t, 1.0, flaky.Score())
+
+	empty := flakyTraces.Get("bar:empty")
+	assert.Equal(t, 0, empty.NDigests)
+	assert.Equal(t, 0.0, empty.Score())
+	assert.Nil(t, flakyTraces.Get("unknown"))
+
+	found := flakyTraces.List(DEFAULT_MIN_DIGESTS)
+	assert.Equal(t, []*TraceFlakiness{flaky}, found)
+
+	found = flakyTraces.List(2)
+	assert.Equal(t, []*TraceFlakiness{flaky, changed}, found)
+}
+
+func addTrace(tile *tiling.Tile, traceID, testName string, digests ...string) {
+	trace := types.NewGoldenTrace()
+	copy(trace.Values, digests)
+	trace.Params_[types.PRIMARY_KEY_FIELD] = testName
+	tile.Traces[traceID] = trace
+}
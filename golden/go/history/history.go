@@ -104,7 +104,7 @@ func (h *historian) updateDigestInfo(tile *tiling.Tile) error {
 
 func (h *historian) backFillDigestInfo(nDaysToBackfill int) {
 	go func() {
-		startTS := time.Now().Add(time.Hour * 24 * time.Duration(nDaysToBackfill))
+		startTS := time.Now().Add(-time.Hour * 24 * time.Duration(nDaysToBackfill))
 		endTS := time.Now()
 		tile, err := h.storages.GetTileFromTimeRange(startTS, endTS)
 		if err != nil {
@@ -13,6 +13,7 @@ import (
 	"go.skia.org/infra/go/timer"
 	"go.skia.org/infra/golden/go/blame"
 	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/flaky"
 	"go.skia.org/infra/golden/go/paramsets"
 	"go.skia.org/infra/golden/go/pdag"
 	"go.skia.org/infra/golden/go/storage"
@@ -39,6 +40,7 @@ type SearchIndex struct {
 	paramsetSummary *paramsets.ParamSummary
 	blamer          *blame.Blamer
 	warmer          *warmer.Warmer
+	flakyTraces     *flaky.FlakyTraces
 
 	// Used by the pdag pipeline.
 	testNames []string
@@ -55,6 +57,7 @@ func newSearchIndex(storages *storage.Storage, tilePair *types.TilePair) *Search
 		paramsetSummary: paramsets.New(),
 		blamer:          blame.New(storages),
 		warmer:          warmer.New(storages),
+		flakyTraces:     flaky.New(),
 	}
 }
 
@@ -104,6 +107,21 @@ func (idx *SearchIndex) GetBlame(test, digest string, commits []*tiling.Commit)
 	return idx.blamer.GetBlame(test, digest, commits)
 }
 
+// Proxy to blame.Blamer.GetBlamesForCommit.
+func (idx *SearchIndex) GetBlamesForCommit(commitHash string) ([]*blame.CommitBlame, *tiling.Commit) {
+	return idx.blamer.GetBlamesForCommit(commitHash)
+}
+
+// Proxy to flaky.FlakyTraces.List.
+func (idx *SearchIndex) FlakyTraces(minDigests int) []*flaky.TraceFlakiness {
+	return idx.flakyTraces.List(minDigests)
+}
+
+// Proxy to flaky.FlakyTraces.Get.
+func (idx *SearchIndex) GetFlakiness(traceID string) *flaky.TraceFlakiness {
+	return idx.flakyTraces.Get(traceID)
+}
+
 // Indexer is the type that drive continously indexing as the underlying
 // data change. It uses a DAG that encodes the dependencies of the
 // different components of an index and creates a processing pipeline on top
@@ -132,6 +150,9 @@ func New(storages *storage.Storage, interval time.Duration) (*Indexer, error) {
 	blamerNode := root.Child(calcBlame)
 	tallyNode := root.Child(calcTallies)
 
+	// The flakiness of traces only depends on the tile.
+	flakyNode := root.Child(calcFlaky)
+
 	// parameters depend on tallies.
 	tallyNode.Child(calcParamsets)
 
@@ -142,7 +163,7 @@ func New(storages *storage.Storage, interval time.Duration) (*Indexer, error) {
 	pdag.NewNode(runWarmer, summaryNode, tallyNode)
 
 	// Set the result on the Indexer instance.
-	pdag.NewNode(ret.setIndex, summaryNode)
+	pdag.NewNode(ret.setIndex, summaryNode, flakyNode)
 
 	ret.pipeline = root
 	ret.blamerNode = blamerNode
@@ -233,6 +254,7 @@ func (ixr *Indexer) indexTests(testNames []string) {
 		paramsetSummary: lastIdx.paramsetSummary,
 		blamer:          blame.New(ixr.storages),
 		warmer:          warmer.New(ixr.storages),
+		flakyTraces:     lastIdx.flakyTraces,
 		testNames:       testNames,
 	}
 
@@ -260,6 +282,13 @@ func calcTallies(state interface{}) error {
 	return nil
 }
 
+// calcFlaky is the pipeline function to calculate the flakiness of traces.
+func calcFlaky(state interface{}) error {
+	idx := state.(*SearchIndex)
+	idx.flakyTraces.Calculate(idx.tilePair.TileWithIgnores)
+	return nil
+}
+
 // calcSummaries is the pipeline function to calculate the summaries.
 func calcSummaries(state interface{}) error {
 	idx := state.(*SearchIndex)
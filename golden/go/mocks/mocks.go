@@ -87,6 +87,14 @@ func (m *MockDigestStore) Update([]*digeststore.DigestInfo) error {
 	return nil
 }
 
+func (m *MockDigestStore) Iterate(func(*digeststore.DigestInfo) error) error {
+	return nil
+}
+
+func (m *MockDigestStore) Delete([]*digeststore.DigestInfo) error {
+	return nil
+}
+
 type MockTileBuilder struct {
 	t    assert.TestingT
 	tile *tiling.Tile
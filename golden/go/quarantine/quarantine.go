@@ -0,0 +1,272 @@
+// quarantine keeps track of traces that have been quarantined by a user.
+// The untriaged digests of quarantined traces are not counted towards the
+// overall status of a corpus.
+package quarantine
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.skia.org/infra/go/eventbus"
+	"go.skia.org/infra/go/fileutil"
+)
+
+const (
+	// Event emitted when the set of quarantined traces changes.
+	// Callback argument: []string with the ids of the affected traces.
+	EV_QUARANTINE_CHANGED = "quarantine:changed"
+
+	// QUARANTINE_DB_NAME is the name of the boltdb file in the storage directory.
+	QUARANTINE_DB_NAME = "quarantine.boltdb"
+)
+
+var (
+	// QUARANTINE_BUCKET is the name of the boltdb bucket that stores the records.
+	QUARANTINE_BUCKET = []byte("quarantine")
+)
+
+// QuarantinedTrace records that a trace has been quarantined.
+type QuarantinedTrace struct {
+	// TraceID is the id of the quarantined trace.
+	TraceID string `json:"traceID"`
+
+	// Test is the name of the test the trace belongs to.
+	Test string `json:"test"`
+
+	// UpdatedBy is the user that quarantined the trace.
+	UpdatedBy string `json:"updatedBy"`
+
+	// Created is the time when the trace was quarantined in ms since the epoch.
+	Created int64 `json:"created"`
+
+	// Note is free form text explaining why the trace was quarantined.
+	Note string `json:"note"`
+}
+
+// NewQuarantinedTrace creates a new QuarantinedTrace with the current time.
+func NewQuarantinedTrace(traceID, test, user, note string) *QuarantinedTrace {
+	return &QuarantinedTrace{
+		TraceID:   traceID,
+		Test:      test,
+		UpdatedBy: user,
+		Created:   time.Now().Unix() * 1000,
+		Note:      note,
+	}
+}
+
+// QuarantineStore stores the set of quarantined traces.
+type QuarantineStore interface {
+	// Add quarantines the given traces. Existing records are overwritten.
+	Add(traces []*QuarantinedTrace) error
+
+	// Delete removes the given trace ids from quarantine.
+	Delete(traceIDs []string) error
+
+	// List returns all quarantined traces sorted by trace id.
+	List() ([]*QuarantinedTrace, error)
+
+	// Get returns the quarantined traces keyed by trace id.
+	Get() (map[string]*QuarantinedTrace, error)
+}
+
+// boltQuarantineStore implements the QuarantineStore interface on top of boltdb.
+type boltQuarantineStore struct {
+	db       *bolt.DB
+	eventBus *eventbus.EventBus
+}
+
+// New returns a new instance of QuarantineStore that is stored in the given
+// directory. If eventBus is not nil EV_QUARANTINE_CHANGED is published
+// whenever the set of quarantined traces changes.
+func New(baseDir string, eventBus *eventbus.EventBus) (QuarantineStore, error) {
+	baseDir, err := fileutil.EnsureDirExists(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path.Join(baseDir, QUARANTINE_DB_NAME), 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(QUARANTINE_BUCKET)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltQuarantineStore{
+		db:       db,
+		eventBus: eventBus,
+	}, nil
+}
+
+// Add, see QuarantineStore interface.
+func (b *boltQuarantineStore) Add(traces []*QuarantinedTrace) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(QUARANTINE_BUCKET)
+		for _, qt := range traces {
+			jsonBytes, err := json.Marshal(qt)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(qt.TraceID), jsonBytes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	traceIDs := make([]string, 0, len(traces))
+	for _, qt := range traces {
+		traceIDs = append(traceIDs, qt.TraceID)
+	}
+	publishChange(b.eventBus, traceIDs)
+	return nil
+}
+
+// Delete, see QuarantineStore interface.
+func (b *boltQuarantineStore) Delete(traceIDs []string) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(QUARANTINE_BUCKET)
+		for _, traceID := range traceIDs {
+			if err := bucket.Delete([]byte(traceID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	publishChange(b.eventBus, traceIDs)
+	return nil
+}
+
+// List, see QuarantineStore interface.
+func (b *boltQuarantineStore) List() ([]*QuarantinedTrace, error) {
+	var ret []*QuarantinedTrace
+	viewFn := func(tx *bolt.Tx) error {
+		found := []*QuarantinedTrace{}
+		// Keys in boltdb are sorted so we don't need to sort the result.
+		err := tx.Bucket(QUARANTINE_BUCKET).ForEach(func(k, v []byte) error {
+			qt := &QuarantinedTrace{}
+			if err := json.Unmarshal(v, qt); err != nil {
+				return err
+			}
+			found = append(found, qt)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		ret = found
+		return nil
+	}
+	return ret, b.db.View(viewFn)
+}
+
+// Get, see QuarantineStore interface.
+func (b *boltQuarantineStore) Get() (map[string]*QuarantinedTrace, error) {
+	traces, err := b.List()
+	if err != nil {
+		return nil, err
+	}
+	return toMap(traces), nil
+}
+
+// MemQuarantineStore is an in-memory implementation of QuarantineStore.
+type MemQuarantineStore struct {
+	traces   map[string]*QuarantinedTrace
+	eventBus *eventbus.EventBus
+	mutex    sync.Mutex
+}
+
+// NewMemQuarantineStore returns an in-memory QuarantineStore that is
+// intended for testing.
+func NewMemQuarantineStore(eventBus *eventbus.EventBus) QuarantineStore {
+	return &MemQuarantineStore{
+		traces:   map[string]*QuarantinedTrace{},
+		eventBus: eventBus,
+	}
+}
+
+// Add, see QuarantineStore interface.
+func (m *MemQuarantineStore) Add(traces []*QuarantinedTrace) error {
+	m.mutex.Lock()
+	traceIDs := make([]string, 0, len(traces))
+	for _, qt := range traces {
+		m.traces[qt.TraceID] = qt
+		traceIDs = append(traceIDs, qt.TraceID)
+	}
+	m.mutex.Unlock()
+
+	publishChange(m.eventBus, traceIDs)
+	return nil
+}
+
+// Delete, see QuarantineStore interface.
+func (m *MemQuarantineStore) Delete(traceIDs []string) error {
+	m.mutex.Lock()
+	for _, traceID := range traceIDs {
+		delete(m.traces, traceID)
+	}
+	m.mutex.Unlock()
+
+	publishChange(m.eventBus, traceIDs)
+	return nil
+}
+
+// List, see QuarantineStore interface.
+func (m *MemQuarantineStore) List() ([]*QuarantinedTrace, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ret := make([]*QuarantinedTrace, 0, len(m.traces))
+	for _, qt := range m.traces {
+		ret = append(ret, qt)
+	}
+	sort.Sort(QuarantinedTraceSlice(ret))
+	return ret, nil
+}
+
+// Get, see QuarantineStore interface.
+func (m *MemQuarantineStore) Get() (map[string]*QuarantinedTrace, error) {
+	traces, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	return toMap(traces), nil
+}
+
+// QuarantinedTraceSlice is a utility type to sort QuarantinedTrace's by trace id.
+type QuarantinedTraceSlice []*QuarantinedTrace
+
+func (q QuarantinedTraceSlice) Len() int           { return len(q) }
+func (q QuarantinedTraceSlice) Less(i, j int) bool { return q[i].TraceID < q[j].TraceID }
+func (q QuarantinedTraceSlice) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+// toMap indexes the given traces by trace id.
+func toMap(traces []*QuarantinedTrace) map[string]*QuarantinedTrace {
+	ret := make(map[string]*QuarantinedTrace, len(traces))
+	for _, qt := range traces {
+		ret[qt.TraceID] = qt
+	}
+	return ret
+}
+
+// publishChange sends the EV_QUARANTINE_CHANGED event if there is an event bus.
+func publishChange(eventBus *eventbus.EventBus, traceIDs []string) {
+	if (eventBus != nil) && (len(traceIDs) > 0) {
+		eventBus.Publish(EV_QUARANTINE_CHANGED, traceIDs)
+	}
+}
@@ -0,0 +1,68 @@
+package quarantine
+
+import (
+	"sync"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"go.skia.org/infra/go/eventbus"
+	"go.skia.org/infra/go/testutils"
+)
+
+const (
+	TEST_DATA_DIR = "./testdata"
+)
+
+func TestBoltQuarantineStore(t *testing.T) {
+	testutils.MediumTest(t)
+
+	eventBus := eventbus.New(nil)
+	store, err := New(TEST_DATA_DIR, eventBus)
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, TEST_DATA_DIR)
+
+	testQuarantineStore(t, store, eventBus)
+}
+
+func TestMemQuarantineStore(t *testing.T) {
+	testutils.SmallTest(t)
+
+	eventBus := eventbus.New(nil)
+	testQuarantineStore(t, NewMemQuarantineStore(eventBus), eventBus)
+}
+
+func testQuarantineStore(t *testing.T, store QuarantineStore, eventBus *eventbus.EventBus) {
+	var mutex sync.Mutex
+	changed := []string{}
+	eventBus.SubscribeAsync(EV_QUARANTINE_CHANGED, func(e interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		changed = append(changed, e.([]string)...)
+	})
+
+	found, err := store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(found))
+
+	qt1 := NewQuarantinedTrace("trace-2", "test-1", "jdoe@example.com", "Flaky on Win.")
+	qt2 := NewQuarantinedTrace("trace-1", "test-1", "jdoe@example.com", "")
+	qt3 := NewQuarantinedTrace("trace-3", "test-2", "jdoe@example.com", "")
+	assert.NoError(t, store.Add([]*QuarantinedTrace{qt1, qt2, qt3}))
+	eventBus.Wait(EV_QUARANTINE_CHANGED)
+
+	found, err = store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []*QuarantinedTrace{qt2, qt1, qt3}, found)
+
+	assert.NoError(t, store.Delete([]string{"trace-1", "trace-3"}))
+	eventBus.Wait(EV_QUARANTINE_CHANGED)
+
+	foundMap, err := store.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*QuarantinedTrace{"trace-2": qt1}, foundMap)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"trace-2", "trace-1", "trace-3", "trace-1", "trace-3"}, changed)
+}
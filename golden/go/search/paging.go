@@ -0,0 +1,166 @@
+package search
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	// SORT_FIELD_TRACE_COUNT indicates that search results should be sorted
+	// by the number of traces a digest appears in.
+	SORT_FIELD_TRACE_COUNT = "traces"
+
+	// SORT_FIELD_FIRST_SEEN indicates that search results should be sorted
+	// by the time a digest was first seen.
+	SORT_FIELD_FIRST_SEEN = "first"
+
+	// MAX_PAGE_SIZE is the maximum number of results returned by a search.
+	// Smaller limits are respected, all other limits are clamped to it.
+	MAX_PAGE_SIZE = 1000
+)
+
+var (
+	// searchSortFields are the valid options for the sort field of a search.
+	searchSortFields = []string{SORT_FIELD_DIFF, SORT_FIELD_TRACE_COUNT, SORT_FIELD_FIRST_SEEN}
+)
+
+// validateSort checks the sort field and direction of the query and fills in
+// the defaults if they are empty.
+func (q *Query) validateSort() error {
+	if q.Sort == "" {
+		q.Sort = SORT_FIELD_DIFF
+	}
+	if q.SortDir == "" {
+		q.SortDir = SORT_DESC
+	}
+
+	if !util.In(q.Sort, searchSortFields) {
+		return fmt.Errorf("Field '%s' is not a valid sort field. Must be one of: %v", q.Sort, searchSortFields)
+	}
+
+	if !util.In(q.SortDir, sortDirections) {
+		return fmt.Errorf("Field '%s' is not a valid sort direction. Must be one of: %v", q.SortDir, sortDirections)
+	}
+	return nil
+}
+
+// sortIntermediates sorts the given intermediates by the given field and
+// direction. Ties are broken by test name and digest so the order is stable
+// across requests, which is necessary for pagination.
+//
+// Calculating the diffs of all intermediates is too expensive, so when
+// sorting by diff the intermediates are only sorted by test name and digest
+// here, and each page is sorted by diff via sortPage.
+func sortIntermediates(inter []*intermediate, field, dir string, builder *digestBuilder) {
+	var lessFn func(a, b *intermediate) bool
+	switch field {
+	case SORT_FIELD_DIFF:
+		lessFn = func(a, b *intermediate) bool { return false }
+	case SORT_FIELD_TRACE_COUNT:
+		lessFn = func(a, b *intermediate) bool { return len(a.Traces) < len(b.Traces) }
+	case SORT_FIELD_FIRST_SEEN:
+		for _, i := range inter {
+			builder.getFirstSeen(i)
+		}
+		lessFn = func(a, b *intermediate) bool { return *a.firstSeen < *b.firstSeen }
+	}
+
+	sort.Sort(&intermediateSlice{
+		data:   inter,
+		lessFn: lessFn,
+		desc:   dir == SORT_DESC,
+	})
+}
+
+// sortPage sorts a page returned by pageIntermediates by diff if that is the
+// given field, calculating the diffs of only the intermediates of the page.
+// See sortIntermediates.
+func sortPage(page []*intermediate, field, dir string, builder *digestBuilder) {
+	if field != SORT_FIELD_DIFF {
+		return
+	}
+	for _, i := range page {
+		builder.getDiff(i)
+	}
+	sort.Sort(&intermediateSlice{
+		data:   page,
+		lessFn: func(a, b *intermediate) bool { return a.diff.Diff < b.diff.Diff },
+		desc:   dir == SORT_DESC,
+	})
+}
+
+// intermediateSlice is a utility type for sorting intermediates.
+type intermediateSlice struct {
+	data   []*intermediate
+	lessFn func(a, b *intermediate) bool
+	desc   bool
+}
+
+func (s *intermediateSlice) Len() int      { return len(s.data) }
+func (s *intermediateSlice) Swap(i, j int) { s.data[i], s.data[j] = s.data[j], s.data[i] }
+func (s *intermediateSlice) Less(i, j int) bool {
+	a, b := s.data[i], s.data[j]
+	if s.desc {
+		a, b = b, a
+	}
+	if s.lessFn(a, b) {
+		return true
+	}
+	if s.lessFn(b, a) {
+		return false
+	}
+	return s.data[i].key() < s.data[j].key()
+}
+
+// pageIntermediates returns the page of the sorted intermediates that starts
+// after the given cursor or, if cursor is empty, at offset. The page contains
+// at most limit entries, or MAX_PAGE_SIZE if limit is <= 0 or larger than
+// that. It also returns the offset of the page and the cursor of the next
+// page, which is empty if there are no further results.
+func pageIntermediates(inter []*intermediate, offset, limit int, cursor string) ([]*intermediate, int, string, error) {
+	if cursor != "" {
+		key, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		offset = -1
+		for idx, i := range inter {
+			if i.key() == key {
+				offset = idx + 1
+				break
+			}
+		}
+		if offset == -1 {
+			return nil, 0, "", fmt.Errorf("Cursor is no longer valid. Please restart the search.")
+		}
+	}
+
+	if (limit <= 0) || (limit > MAX_PAGE_SIZE) {
+		limit = MAX_PAGE_SIZE
+	}
+	start := util.MinInt(util.MaxInt(offset, 0), len(inter))
+	end := util.MinInt(start+limit, len(inter))
+
+	nextCursor := ""
+	if (end < len(inter)) && (end > start) {
+		nextCursor = encodeCursor(inter[end-1].key())
+	}
+	return inter[start:end], start, nextCursor, nil
+}
+
+// encodeCursor returns an opaque cursor for the given intermediate key.
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeCursor returns the intermediate key encoded in the given cursor.
+func decodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("Invalid cursor %q: %s", cursor, err)
+	}
+	return string(key), nil
+}
@@ -0,0 +1,153 @@
+package search
+
+import (
+	"fmt"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/golden/go/digeststore"
+	"go.skia.org/infra/golden/go/storage"
+)
+
+func TestSortAndPageIntermediates(t *testing.T) {
+	testutils.SmallTest(t)
+
+	firstSeen := map[string]int64{}
+	inter := []*intermediate{}
+	for i := 0; i < 10; i++ {
+		digest := fmt.Sprintf("digest-%d", i)
+		traces := map[string]tiling.Trace{}
+		for j := 0; j < i%4; j++ {
+			traces[fmt.Sprintf("trace-%d", j)] = nil
+		}
+		inter = append(inter, &intermediate{
+			Test:   "test",
+			Digest: digest,
+			Traces: traces,
+		})
+		firstSeen[digest] = int64(100 - i)
+	}
+
+	builder := &digestBuilder{
+		storages: &storage.Storage{
+			DigestStore: &firstSeenDigestStore{firstSeen: firstSeen},
+		},
+	}
+
+	// Sort by trace count, ties are broken by key.
+	sortIntermediates(inter, SORT_FIELD_TRACE_COUNT, SORT_DESC, builder)
+	assert.Equal(t, []string{
+		"digest-3", "digest-7", "digest-2", "digest-6", "digest-1",
+		"digest-5", "digest-9", "digest-0", "digest-4", "digest-8",
+	}, digestsOf(inter))
+
+	sortIntermediates(inter, SORT_FIELD_FIRST_SEEN, SORT_ASC, builder)
+	assert.Equal(t, []string{
+		"digest-9", "digest-8", "digest-7", "digest-6", "digest-5",
+		"digest-4", "digest-3", "digest-2", "digest-1", "digest-0",
+	}, digestsOf(inter))
+
+	// Page through the results via offset.
+	page, offset, cursor, err := pageIntermediates(inter, 8, 4, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 8, offset)
+	assert.Equal(t, "", cursor)
+	assert.Equal(t, []string{"digest-1", "digest-0"}, digestsOf(page))
+
+	// Page through the results via cursor.
+	allDigests := []string{}
+	cursor = ""
+	for {
+		page, _, cursor, err = pageIntermediates(inter, 0, 3, cursor)
+		assert.NoError(t, err)
+		allDigests = append(allDigests, digestsOf(page)...)
+		if cursor == "" {
+			break
+		}
+	}
+	assert.Equal(t, digestsOf(inter), allDigests)
+
+	// No limit returns everything up to MAX_PAGE_SIZE.
+	page, _, cursor, err = pageIntermediates(inter, 0, 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "", cursor)
+	assert.Equal(t, len(inter), len(page))
+	many := make([]*intermediate, 0, MAX_PAGE_SIZE+1)
+	for i := 0; i <= MAX_PAGE_SIZE; i++ {
+		many = append(many, &intermediate{Test: "test", Digest: fmt.Sprintf("digest-%04d", i)})
+	}
+	for _, limit := range []int{0, -1, MAX_PAGE_SIZE + 1} {
+		page, _, cursor, err = pageIntermediates(many, 0, limit, "")
+		assert.NoError(t, err)
+		assert.Equal(t, MAX_PAGE_SIZE, len(page))
+		assert.NotEqual(t, "", cursor)
+	}
+
+	// Offsets past the end return an empty page.
+	page, _, _, err = pageIntermediates(inter, 20, 5, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(page))
+
+	// Unknown cursors are an error.
+	_, _, _, err = pageIntermediates(inter, 0, 3, encodeCursor("test:unknown"))
+	assert.Error(t, err)
+
+	// Sorting by diff only sorts by key, and calculates the diffs for the
+	// page.
+	sortIntermediates(inter, SORT_FIELD_DIFF, SORT_DESC, builder)
+	assert.Equal(t, []string{
+		"digest-0", "digest-1", "digest-2", "digest-3", "digest-4",
+		"digest-5", "digest-6", "digest-7", "digest-8", "digest-9",
+	}, digestsOf(inter))
+	for i, in := range inter {
+		in.diff = &Diff{Diff: float32(i % 3)}
+	}
+	page, _, cursor, err = pageIntermediates(inter, 0, 4, "")
+	assert.NoError(t, err)
+	sortPage(page, SORT_FIELD_DIFF, SORT_DESC, builder)
+	assert.Equal(t, []string{"digest-2", "digest-1", "digest-0", "digest-3"}, digestsOf(page))
+	// The cursor still refers to the order by key.
+	page, _, _, err = pageIntermediates(inter, 0, 4, cursor)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"digest-4", "digest-5", "digest-6", "digest-7"}, digestsOf(page))
+
+	q := &Query{}
+	assert.NoError(t, q.validateSort())
+	assert.Equal(t, SORT_FIELD_DIFF, q.Sort)
+	assert.Equal(t, SORT_DESC, q.SortDir)
+	q = &Query{Sort: "bogus"}
+	assert.Error(t, q.validateSort())
+}
+
+func digestsOf(inter []*intermediate) []string {
+	ret := make([]string, 0, len(inter))
+	for _, i := range inter {
+		ret = append(ret, i.Digest)
+	}
+	return ret
+}
+
+// firstSeenDigestStore is a DigestStore that returns fixed first-seen values.
+type firstSeenDigestStore struct {
+	firstSeen map[string]int64
+}
+
+func (f *firstSeenDigestStore) Get(testName, digest string) (*digeststore.DigestInfo, bool, error) {
+	first, ok := f.firstSeen[digest]
+	return &digeststore.DigestInfo{TestName: testName, Digest: digest, First: first}, ok, nil
+}
+
+func (f *firstSeenDigestStore) Update([]*digeststore.DigestInfo) error {
+	return nil
+}
+
+func (f *firstSeenDigestStore) Iterate(func(*digeststore.DigestInfo) error) error {
+	return nil
+}
+
+func (f *firstSeenDigestStore) Delete([]*digeststore.DigestInfo) error {
+	return nil
+}
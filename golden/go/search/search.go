@@ -114,8 +114,12 @@ type Query struct {
 	PatchsetsStr   string      `json:"patchsets"` // Comma-separated list of patchsets.
 	Patchsets      []string    `json:"-"`
 	CommitRange    CommitRange `json:"-"`
-	Limit          int         `json:"limit"`
-	IncludeMaster  bool        `json:"master"` // Include digests also contained in master when searching Rietveld issues.
+	Limit          int         `json:"limit"`   // Page size. Values <= 0 or above MAX_PAGE_SIZE return MAX_PAGE_SIZE results.
+	Offset         int         `json:"offset"`  // Offset of the first result to return.
+	Cursor         string      `json:"cursor"`  // Continue after this cursor. Takes precedence over Offset.
+	Sort           string      `json:"sort"`    // One of the SORT_FIELD_* constants. Defaults to SORT_FIELD_DIFF.
+	SortDir        string      `json:"sortDir"` // SORT_ASC or SORT_DESC. Defaults to SORT_DESC.
+	IncludeMaster  bool        `json:"master"`  // Include digests also contained in master when searching Rietveld issues.
 }
 
 // SearchResponse is the standard search response. Depending on the query some fields
@@ -123,6 +127,8 @@ type Query struct {
 type SearchResponse struct {
 	Digests       []*Digest
 	Total         int
+	Offset        int
+	NextCursor    string // Empty if there are no more results.
 	Commits       []*tiling.Commit
 	IssueResponse *IssueResponse
 }
@@ -146,17 +152,32 @@ func (q *Query) excludeClassification(cl types.Label) bool {
 //
 // To avoid filtering through the tile more than once we first take a pass
 // through the tile and collect all info for the current Query, then we
-// transform each intermediate into a Digest.
+// transform each intermediate into a Digest. Since building a Digest is
+// expensive this only happens for the intermediates that are part of the
+// requested page of results.
 type intermediate struct {
 	Test   string
 	Digest string
 	Traces map[string]tiling.Trace
+
+	// ParamSet is only set for the results of an issue search. Otherwise the
+	// paramset is retrieved from the index.
+	ParamSet map[string][]string
+
+	// diff and firstSeen are calculated lazily. See digestBuilder.
+	diff      *Diff
+	firstSeen *int64
 }
 
 func (i *intermediate) addTrace(id string, tr tiling.Trace, digests []string) {
 	i.Traces[id] = tr
 }
 
+// key returns the unique key of this intermediate within the search results.
+func (i *intermediate) key() string {
+	return i.Test + ":" + i.Digest
+}
+
 func newIntermediate(test, digest, id string, tr tiling.Trace, digests []string) *intermediate {
 	ret := &intermediate{
 		Test:   test,
@@ -176,7 +197,37 @@ func (p DigestSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
 // Search returns a slice of Digests that match the input query, and the total number of Digests
 // that matched the query. It also returns a slice of Commits that were used in the calculations.
+// The results are sorted as defined by q.Sort and q.SortDir and only the page defined
+// by q.Offset (or q.Cursor) and q.Limit is returned. When sorting by diff only the
+// results within the page are sorted by diff, see sortIntermediates.
 func Search(q *Query, storages *storage.Storage, idx *indexer.SearchIndex) (*SearchResponse, error) {
+	ret := []*Digest{}
+	searchResp, err := search(q, storages, idx, func(digest *Digest) error {
+		ret = append(ret, digest)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	searchResp.Digests = ret
+	return searchResp, nil
+}
+
+// SearchStream works like Search, but instead of collecting the Digests of the
+// result it calls emitFn for each of them in sort order. This allows to process
+// large results without keeping all Digests in memory. The Digests field of
+// the returned SearchResponse is always nil. If emitFn returns an error the
+// search is aborted and the error is returned.
+func SearchStream(q *Query, storages *storage.Storage, idx *indexer.SearchIndex, emitFn func(*Digest) error) (*SearchResponse, error) {
+	return search(q, storages, idx, emitFn)
+}
+
+// search implements Search and SearchStream.
+func search(q *Query, storages *storage.Storage, idx *indexer.SearchIndex, emitFn func(*Digest) error) (*SearchResponse, error) {
+	if err := q.validateSort(); err != nil {
+		return nil, err
+	}
+
 	tile := idx.GetTile(q.IncludeIgnores)
 
 	e, err := storages.ExpectationsStore.Get()
@@ -184,33 +235,107 @@ func Search(q *Query, storages *storage.Storage, idx *indexer.SearchIndex) (*Sea
 		return nil, fmt.Errorf("Couldn't get expectations: %s", err)
 	}
 
-	var ret []*Digest
+	builder := &digestBuilder{
+		exp:            e,
+		idx:            idx,
+		storages:       storages,
+		includeIgnores: q.IncludeIgnores,
+	}
+
+	var inter []*intermediate
 	var issueResponse *IssueResponse = nil
 	var commits []*tiling.Commit = nil
 	if q.Issue != "" {
-		ret, issueResponse, err = searchByIssue(q.Issue, q, e, q.Query, storages, idx)
+		inter, issueResponse, err = searchByIssue(q.Issue, q, e, q.Query, storages, idx)
 	} else {
-		ret, commits, err = searchTile(q, e, q.Query, storages, tile, idx)
+		builder.tile = tile
+		inter, commits, err = searchTile(q, e, q.Query, storages, tile, idx)
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	sort.Sort(DigestSlice(ret))
-	fullLength := len(ret)
-	if fullLength > q.Limit {
-		ret = ret[0:q.Limit]
+	sortIntermediates(inter, q.Sort, q.SortDir, builder)
+	page, offset, nextCursor, err := pageIntermediates(inter, q.Offset, q.Limit, q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	sortPage(page, q.Sort, q.SortDir, builder)
+
+	for _, i := range page {
+		if err := emitFn(builder.build(i)); err != nil {
+			return nil, err
+		}
 	}
 
 	return &SearchResponse{
-		Digests:       ret,
-		Total:         fullLength,
+		Total:         len(inter),
+		Offset:        offset,
+		NextCursor:    nextCursor,
 		Commits:       commits,
 		IssueResponse: issueResponse,
 	}, nil
 }
 
+// digestBuilder turns intermediates into Digests. It also lazily calculates
+// the values of an intermediate that are needed for sorting.
+type digestBuilder struct {
+	exp            *expstorage.Expectations
+	tile           *tiling.Tile // nil for issue searches.
+	idx            *indexer.SearchIndex
+	storages       *storage.Storage
+	includeIgnores bool
+}
+
+// getDiff returns the Diff of the given intermediate and caches it.
+func (b *digestBuilder) getDiff(i *intermediate) *Diff {
+	if i.diff == nil {
+		i.diff = buildDiff(i.Test, i.Digest, b.exp, b.tile, b.idx.TalliesByTest(), b.storages.DiffStore, b.idx, b.includeIgnores)
+	}
+	return i.diff
+}
+
+// getFirstSeen returns the time the digest of the given intermediate was
+// first seen or 0 if that is unknown.
+func (b *digestBuilder) getFirstSeen(i *intermediate) int64 {
+	if i.firstSeen == nil {
+		var firstSeen int64 = 0
+		if b.storages.DigestStore != nil {
+			digestInfo, ok, err := b.storages.DigestStore.Get(i.Test, i.Digest)
+			if err != nil {
+				glog.Errorf("Unable to retrieve digest info for %s: %s", i.key(), err)
+			} else if ok {
+				firstSeen = digestInfo.First
+			}
+		}
+		i.firstSeen = &firstSeen
+	}
+	return *i.firstSeen
+}
+
+// build returns the Digest for the given intermediate.
+func (b *digestBuilder) build(i *intermediate) *Digest {
+	if b.tile == nil {
+		return &Digest{
+			Test:     i.Test,
+			Digest:   i.Digest,
+			Status:   b.exp.Classification(i.Test, i.Digest).String(),
+			ParamSet: i.ParamSet,
+			Traces:   &Traces{},
+			Diff:     b.getDiff(i),
+		}
+	}
+	return &Digest{
+		Test:     i.Test,
+		Digest:   i.Digest,
+		Status:   b.exp.Classification(i.Test, i.Digest).String(),
+		ParamSet: b.idx.GetParamsetSummary(i.Test, i.Digest, b.includeIgnores),
+		Traces:   buildTraces(i.Test, i.Digest, i.Traces, b.exp, b.tile, b.idx.TalliesByTrace()),
+		Diff:     b.getDiff(i),
+	}
+}
+
 // issueIntermediate is a utility struct for searchByIssue.
 type issueIntermediate struct {
 	test     string
@@ -236,7 +361,7 @@ func (i *issueIntermediate) add(params map[string]string) {
 	util.AddParamsToParamSet(i.paramSet, params)
 }
 
-func searchByIssue(issueID string, q *Query, exp *expstorage.Expectations, parsedQuery url.Values, storages *storage.Storage, idx *indexer.SearchIndex) ([]*Digest, *IssueResponse, error) {
+func searchByIssue(issueID string, q *Query, exp *expstorage.Expectations, parsedQuery url.Values, storages *storage.Storage, idx *indexer.SearchIndex) ([]*intermediate, *IssueResponse, error) {
 	issue, tile, err := storages.TrybotResults.GetIssue(issueID, q.Patchsets)
 	if err != nil {
 		return nil, nil, err
@@ -263,7 +388,7 @@ func searchByIssue(issueID string, q *Query, exp *expstorage.Expectations, parse
 
 	pidMap := util.NewStringSet(issue.TargetPatchsets)
 	talliesByTest := idx.TalliesByTest()
-	digestMap := map[string]*Digest{}
+	digestMap := map[string]*intermediate{}
 
 	for idx, cid := range issue.CommitIDs {
 		_, pid := goldingestion.ExtractIssueInfo(cid.CommitID, storages.RietveldAPI, storages.GerritAPI)
@@ -305,23 +430,19 @@ func searchByIssue(issueID string, q *Query, exp *expstorage.Expectations, parse
 				}
 
 				if cl := exp.Classification(testName, digest); !q.excludeClassification(cl) {
-					digestMap[key] = &Digest{
+					digestMap[key] = &intermediate{
 						Test:     testName,
 						Digest:   digest,
 						ParamSet: util.AddParamsToParamSet(make(map[string][]string, len(params)), params),
-						Status:   cl.String(),
 					}
 				}
 			}
 		}
 	}
 
-	ret := make([]*Digest, 0, len(digestMap))
-	allDigests := make([]string, len(digestMap))
-	emptyTraces := &Traces{}
+	ret := make([]*intermediate, 0, len(digestMap))
+	allDigests := make([]string, 0, len(digestMap))
 	for _, digestEntry := range digestMap {
-		digestEntry.Diff = buildDiff(digestEntry.Test, digestEntry.Digest, exp, nil, talliesByTest, storages.DiffStore, idx, q.IncludeIgnores)
-		digestEntry.Traces = emptyTraces
 		ret = append(ret, digestEntry)
 		allDigests = append(allDigests, digestEntry.Digest)
 	}
@@ -338,7 +459,7 @@ func searchByIssue(issueID string, q *Query, exp *expstorage.Expectations, parse
 }
 
 // searchTile queries across a tile.
-func searchTile(q *Query, e *expstorage.Expectations, parsedQuery url.Values, storages *storage.Storage, tile *tiling.Tile, idx *indexer.SearchIndex) ([]*Digest, []*tiling.Commit, error) {
+func searchTile(q *Query, e *expstorage.Expectations, parsedQuery url.Values, storages *storage.Storage, tile *tiling.Tile, idx *indexer.SearchIndex) ([]*intermediate, []*tiling.Commit, error) {
 	// TODO Use CommitRange to create a trimmed tile.
 
 	traceTally := idx.TalliesByTrace()
@@ -382,28 +503,14 @@ func searchTile(q *Query, e *expstorage.Expectations, parsedQuery url.Values, st
 			}
 		}
 	}
-	// Now loop over all the intermediates and build a Digest for each one.
-	ret := make([]*Digest, 0, len(inter))
-	for key, i := range inter {
-		parts := strings.Split(key, ":")
-		ret = append(ret, digestFromIntermediate(parts[0], parts[1], i, e, tile, idx, storages.DiffStore, q.IncludeIgnores))
+	// The intermediates are turned into Digests once they have been sorted and paged.
+	ret := make([]*intermediate, 0, len(inter))
+	for _, i := range inter {
+		ret = append(ret, i)
 	}
 	return ret, tile.Commits, nil
 }
 
-func digestFromIntermediate(test, digest string, inter *intermediate, e *expstorage.Expectations, tile *tiling.Tile, idx *indexer.SearchIndex, diffStore diff.DiffStore, includeIgnores bool) *Digest {
-	traceTally := idx.TalliesByTrace()
-	ret := &Digest{
-		Test:     test,
-		Digest:   digest,
-		Status:   e.Classification(test, digest).String(),
-		ParamSet: idx.GetParamsetSummary(test, digest, includeIgnores),
-		Traces:   buildTraces(test, digest, inter.Traces, e, tile, traceTally),
-		Diff:     buildDiff(test, digest, e, tile, idx.TalliesByTest(), diffStore, idx, includeIgnores),
-	}
-	return ret
-}
-
 // buildDiff creates a Diff for the given intermediate.
 func buildDiff(test, digest string, e *expstorage.Expectations, tile *tiling.Tile, testTally map[string]tally.Tally, diffStore diff.DiffStore, idx *indexer.SearchIndex, includeIgnores bool) *Diff {
 	ret := &Diff{
@@ -19,11 +19,15 @@ import (
 	"go.skia.org/infra/go/tiling"
 	"go.skia.org/infra/go/timer"
 	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/baseline"
 	"go.skia.org/infra/golden/go/blame"
 	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/digesttools"
 	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/flaky"
 	"go.skia.org/infra/golden/go/ignore"
 	"go.skia.org/infra/golden/go/indexer"
+	"go.skia.org/infra/golden/go/quarantine"
 	"go.skia.org/infra/golden/go/search"
 	"go.skia.org/infra/golden/go/summary"
 	"go.skia.org/infra/golden/go/trybot"
@@ -36,6 +40,10 @@ const (
 
 	// MAX_PAGE_SIZE is the maximum page size used for pagination.
 	MAX_PAGE_SIZE = 100
+
+	// STREAM_FLUSH_INTERVAL is the number of digests after which a streaming
+	// search response is flushed to the client.
+	STREAM_FLUSH_INTERVAL = 50
 )
 
 // TODO(stephana): once the byBlameHandler is removed, refactor this to
@@ -209,6 +217,82 @@ type TestRollup struct {
 	SampleDigest string `json:"sample_digest"`
 }
 
+// CommitBlameEntry is a test/digest pair that is blamed on a commit along
+// with its triage status and the closest positive digest.
+type CommitBlameEntry struct {
+	*blame.CommitBlame
+	Status string `json:"status"`
+
+	// ClosestPos is nil if there is no positive digest for the test.
+	ClosestPos *digesttools.Closest `json:"closestPos"`
+}
+
+// CommitBlameResponse is the response of jsonCommitBlameHandler.
+type CommitBlameResponse struct {
+	Commit  *tiling.Commit      `json:"commit"`
+	Entries []*CommitBlameEntry `json:"entries"`
+}
+
+// jsonCommitBlameHandler returns all test/digest pairs whose blamelist
+// contains the given commit, sorted by descending blame weight.
+//
+// It takes these parameters:
+//
+//	commit - Hash of the commit. Required.
+//	offset - Offset for pagination.
+//	size   - Page size for pagination.
+func jsonCommitBlameHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	offset, size, err := httputils.PaginationParams(q, 0, DEFAULT_PAGE_SIZE, MAX_PAGE_SIZE)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Invalid pagination parameters.")
+		return
+	}
+
+	commitHash := q.Get("commit")
+	if commitHash == "" {
+		httputils.ReportError(w, r, fmt.Errorf("No commit provided."), "A commit hash is required.")
+		return
+	}
+
+	idx := ixr.GetIndex()
+	commitBlames, commit := idx.GetBlamesForCommit(commitHash)
+	if commit == nil {
+		httputils.ReportError(w, r, fmt.Errorf("Unknown commit %s", commitHash), "Commit is not in the current tile.")
+		return
+	}
+
+	exp, err := storages.ExpectationsStore.Get()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to retrieve expectations.")
+		return
+	}
+
+	// Finding the closest positive digest requires diffs, so we only do it
+	// for the requested page.
+	talliesByTest := idx.TalliesByTest()
+	start := util.MinInt(offset, len(commitBlames))
+	end := util.MinInt(start+size, len(commitBlames))
+	entries := make([]*CommitBlameEntry, 0, end-start)
+	for _, cb := range commitBlames[start:end] {
+		entry := &CommitBlameEntry{
+			CommitBlame: cb,
+			Status:      exp.Classification(cb.Test, cb.Digest).String(),
+		}
+		if closest := digesttools.ClosestDigest(cb.Test, cb.Digest, exp, talliesByTest[cb.Test], storages.DiffStore, types.POSITIVE); closest.Digest != "" {
+			entry.ClosestPos = closest
+		}
+		entries = append(entries, entry)
+	}
+
+	pagination := &httputils.ResponsePagination{
+		Offset: offset,
+		Size:   size,
+		Total:  len(commitBlames),
+	}
+	sendResponse(w, &CommitBlameResponse{Commit: commit, Entries: entries}, http.StatusOK, pagination)
+}
+
 // jsonSearchHandler is the endpoint for all searches.
 func jsonSearchHandler(w http.ResponseWriter, r *http.Request) {
 	query := search.Query{Limit: 50}
@@ -223,18 +307,82 @@ func jsonSearchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	sendJsonResponse(w, &SearchResult{
-		Digests: searchResponse.Digests,
-		Commits: searchResponse.Commits,
-		Issue:   adaptIssueResponse(searchResponse.IssueResponse),
+		Digests:    searchResponse.Digests,
+		Commits:    searchResponse.Commits,
+		Issue:      adaptIssueResponse(searchResponse.IssueResponse),
+		NumMatches: searchResponse.Total,
+		Offset:     searchResponse.Offset,
+		NextCursor: searchResponse.NextCursor,
 	})
 }
 
+// SearchStreamEnd is the last line written by jsonSearchStreamHandler. It is
+// always present, so a stream without it was truncated. If Error is not empty
+// the search failed after some digests were written.
+type SearchStreamEnd struct {
+	Done       bool   `json:"done"`
+	Error      string `json:"error,omitempty"`
+	NumMatches int    `json:"numMatches"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"nextCursor"`
+}
+
+// jsonSearchStreamHandler is the streaming variant of jsonSearchHandler.
+// It accepts the same parameters, but writes one JSON encoded search.Digest
+// per line (NDJSON) as the results become available, followed by a
+// SearchStreamEnd. At most search.MAX_PAGE_SIZE digests are returned, pass
+// the nextCursor of the SearchStreamEnd as 'cursor' to continue. Use the
+// 'sort' and 'sortDir' parameters to control the order of the results.
+func jsonSearchStreamHandler(w http.ResponseWriter, r *http.Request) {
+	query := search.Query{}
+	if err := parseQuery(r, &query); err != nil {
+		httputils.ReportError(w, r, err, "Search for digests failed.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	nWritten := 0
+	searchResponse, err := search.SearchStream(&query, storages, ixr.GetIndex(), func(digest *search.Digest) error {
+		if err := enc.Encode(digest); err != nil {
+			return err
+		}
+		nWritten++
+		if (flusher != nil) && (nWritten%STREAM_FLUSH_INTERVAL == 0) {
+			flusher.Flush()
+		}
+		return nil
+	})
+
+	// Once we have written a result we can't report errors via HTTP status.
+	end := &SearchStreamEnd{Done: true}
+	if err != nil {
+		if nWritten == 0 {
+			httputils.ReportError(w, r, err, "Search for digests failed.")
+			return
+		}
+		glog.Errorf("Streaming search results failed after %d digests: %s", nWritten, err)
+		end.Error = "Search for digests failed."
+	} else {
+		end.NumMatches = searchResponse.Total
+		end.Offset = searchResponse.Offset
+		end.NextCursor = searchResponse.NextCursor
+	}
+	if err := enc.Encode(end); err != nil {
+		glog.Errorf("Failed to write end of search stream: %s", err)
+	}
+}
+
 // SearchResult encapsulates the results of a search request.
 type SearchResult struct {
 	Digests    []*search.Digest   `json:"digests"`
 	Commits    []*tiling.Commit   `json:"commits"`
 	Issue      *IssueSearchResult `json:"issue"`
 	NumMatches int
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"nextCursor"`
 }
 
 // TODO (stephana): Replace search.IssueResponse with IssueSearchResult
@@ -786,7 +934,6 @@ func (p SummarySlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
 // TODO(stephana): Remove queryFromRequest in favor of parseQuery as the generic
 // way to parse input parameters for search-like endpoints.
-// Remove the "Limit" field and replace with pagination.
 
 // parseQuery parses the request parameters,
 func parseQuery(r *http.Request, query *search.Query) error {
@@ -799,6 +946,15 @@ func parseQuery(r *http.Request, query *search.Query) error {
 		query.Limit = limit
 	}
 
+	// Get the offset
+	if o := r.FormValue("offset"); o != "" {
+		offset, err := strconv.Atoi(o)
+		if err != nil {
+			return fmt.Errorf("Unable to parse an offset of: %s", o)
+		}
+		query.Offset = offset
+	}
+
 	// Parse the query
 	var err error
 	query.Query = url.Values{}
@@ -823,6 +979,9 @@ func parseQuery(r *http.Request, query *search.Query) error {
 	query.IncludeIgnores = r.FormValue("include") == "true"
 	query.Issue = r.FormValue("issue")
 	query.IncludeMaster = r.FormValue("master") == "true"
+	query.Cursor = r.FormValue("cursor")
+	query.Sort = r.FormValue("sort")
+	query.SortDir = r.FormValue("sortDir")
 
 	return nil
 }
@@ -873,6 +1032,35 @@ func jsonClearFailureHandler(w http.ResponseWriter, r *http.Request) {
 	jsonListFailureHandler(w, r)
 }
 
+// jsonDigestGCHandler returns a dry-run report of the digest garbage
+// collector, i.e. the digests that would be removed by the next run.
+func jsonDigestGCHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := digestGC.Run(true)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to calculate garbage collection report.")
+		return
+	}
+	sendJsonResponse(w, report)
+}
+
+// jsonDigestGCRunHandler runs the digest garbage collector and returns the
+// list of digests that were removed. Only admins may run it.
+func jsonDigestGCRunHandler(w http.ResponseWriter, r *http.Request) {
+	if !login.IsAdmin(r) {
+		httputils.ReportError(w, r, fmt.Errorf("Not an admin."), "You must be logged in as an admin to garbage collect digests.")
+		return
+	}
+	user := login.LoggedInAs(r)
+
+	report, err := digestGC.Run(false)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to garbage collect digests.")
+		return
+	}
+	glog.Infof("User %s garbage collected %d digests.", user, len(report.Candidates))
+	sendJsonResponse(w, report)
+}
+
 // jsonTriageLogHandler returns the entries in the triagelog paginated
 // in reverse chronological order.
 func jsonTriageLogHandler(w http.ResponseWriter, r *http.Request) {
@@ -1034,3 +1222,166 @@ func jsonCompareTestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	sendJsonResponse(w, compareResult)
 }
+
+// FlakyTraceEntry is the entry returned by jsonFlakyHandler for each trace.
+type FlakyTraceEntry struct {
+	*flaky.TraceFlakiness
+	Score       float64           `json:"score"`
+	Params      map[string]string `json:"params"`
+	Quarantined bool              `json:"quarantined"`
+}
+
+// jsonFlakyHandler returns the traces in the current tile that are considered
+// flaky, sorted so the most flaky traces come first.
+//
+// It takes these parameters:
+//
+//	min_digests - Minimum number of distinct digests of a trace to be included.
+//	offset      - Offset for pagination.
+//	size        - Page size for pagination.
+func jsonFlakyHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	offset, size, err := httputils.PaginationParams(q, 0, DEFAULT_PAGE_SIZE, MAX_PAGE_SIZE)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Invalid pagination parameters.")
+		return
+	}
+
+	minDigests := flaky.DEFAULT_MIN_DIGESTS
+	if md := q.Get("min_digests"); md != "" {
+		if minDigests, err = strconv.Atoi(md); err != nil {
+			httputils.ReportError(w, r, err, "Invalid value for min_digests.")
+			return
+		}
+	}
+
+	quarantined, err := storages.GetQuarantined()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to retrieve quarantined traces.")
+		return
+	}
+
+	idx := ixr.GetIndex()
+	tile := idx.GetTile(true)
+	allFlaky := idx.FlakyTraces(minDigests)
+	start := util.MinInt(offset, len(allFlaky))
+	end := util.MinInt(start+size, len(allFlaky))
+	ret := make([]*FlakyTraceEntry, 0, end-start)
+	for _, tf := range allFlaky[start:end] {
+		_, isQuarantined := quarantined[tf.TraceID]
+		entry := &FlakyTraceEntry{
+			TraceFlakiness: tf,
+			Score:          tf.Score(),
+			Quarantined:    isQuarantined,
+		}
+		if tr, ok := tile.Traces[tf.TraceID]; ok {
+			entry.Params = tr.Params()
+		}
+		ret = append(ret, entry)
+	}
+
+	pagination := &httputils.ResponsePagination{
+		Offset: offset,
+		Size:   size,
+		Total:  len(allFlaky),
+	}
+	sendResponse(w, ret, http.StatusOK, pagination)
+}
+
+// QuarantineRequest is the JSON posted to jsonQuarantineAddHandler.
+type QuarantineRequest struct {
+	TraceIDs []string `json:"traceIDs"`
+	Note     string   `json:"note"`
+}
+
+// jsonQuarantineHandler returns the list of currently quarantined traces.
+func jsonQuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	traces, err := storages.QuarantineStore.List()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to retrieve quarantined traces.")
+		return
+	}
+	sendJsonResponse(w, traces)
+}
+
+// jsonQuarantineAddHandler quarantines the traces in the POST'd
+// QuarantineRequest. The untriaged digests of quarantined traces are not
+// counted towards the status.
+func jsonQuarantineAddHandler(w http.ResponseWriter, r *http.Request) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to quarantine traces.")
+		return
+	}
+
+	req := &QuarantineRequest{}
+	if err := parseJson(r, req); err != nil {
+		httputils.ReportError(w, r, err, "Failed to parse submitted data.")
+		return
+	}
+	if len(req.TraceIDs) == 0 {
+		httputils.ReportError(w, r, fmt.Errorf("No trace ids provided."), "No trace ids provided.")
+		return
+	}
+
+	tile := ixr.GetIndex().GetTile(true)
+	traces := make([]*quarantine.QuarantinedTrace, 0, len(req.TraceIDs))
+	for _, traceID := range req.TraceIDs {
+		tr, ok := tile.Traces[traceID]
+		if !ok {
+			httputils.ReportError(w, r, fmt.Errorf("Unknown trace id: %q", traceID), "Unknown trace id.")
+			return
+		}
+		traces = append(traces, quarantine.NewQuarantinedTrace(traceID, tr.Params()[types.PRIMARY_KEY_FIELD], user, req.Note))
+	}
+
+	if err := storages.QuarantineStore.Add(traces); err != nil {
+		httputils.ReportError(w, r, err, "Unable to quarantine traces.")
+		return
+	}
+	jsonQuarantineHandler(w, r)
+}
+
+// jsonQuarantineDeleteHandler removes the POST'd list of trace ids from
+// quarantine.
+func jsonQuarantineDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to remove traces from quarantine.")
+		return
+	}
+
+	traceIDs := []string{}
+	if err := parseJson(r, &traceIDs); err != nil {
+		httputils.ReportError(w, r, err, "Unable to decode trace id list.")
+		return
+	}
+
+	if err := storages.QuarantineStore.Delete(traceIDs); err != nil {
+		httputils.ReportError(w, r, err, "Unable to remove traces from quarantine.")
+		return
+	}
+	jsonQuarantineHandler(w, r)
+}
+
+// jsonBaselineHandler returns the baseline, i.e. the positive and negative
+// digests of each test, as a baseline.Baseline. Test harnesses use it to avoid
+// uploading known digests. See the baseline package for a client.
+//
+// It takes these parameters:
+//  commit - Optional hash of a commit in the current tile. If provided only
+//           digests that appeared at or before that commit are included.
+//
+// Baselines for CLs, i.e. with an 'issue' parameter, are not supported yet.
+//
+// The response contains an ETag and honors the If-None-Match header.
+func jsonBaselineHandler(w http.ResponseWriter, r *http.Request) {
+	exp, err := storages.ExpectationsStore.Get()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to retrieve expectations.")
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	baseline.ServeBaseline(w, r, exp, ixr.GetIndex().GetTile(true))
+}
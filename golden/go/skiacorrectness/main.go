@@ -30,14 +30,17 @@ import (
 	"go.skia.org/infra/go/timer"
 	tracedb "go.skia.org/infra/go/trace/db"
 	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/baseline"
 	"go.skia.org/infra/golden/go/db"
 	"go.skia.org/infra/golden/go/diffstore"
+	"go.skia.org/infra/golden/go/digestgc"
 	"go.skia.org/infra/golden/go/digeststore"
 	"go.skia.org/infra/golden/go/expstorage"
 	"go.skia.org/infra/golden/go/goldingestion"
 	"go.skia.org/infra/golden/go/history"
 	"go.skia.org/infra/golden/go/ignore"
 	"go.skia.org/infra/golden/go/indexer"
+	"go.skia.org/infra/golden/go/quarantine"
 	"go.skia.org/infra/golden/go/status"
 	"go.skia.org/infra/golden/go/storage"
 	"go.skia.org/infra/golden/go/trybot"
@@ -50,6 +53,9 @@ var (
 	authWhiteList      = flag.String("auth_whitelist", login.DEFAULT_DOMAIN_WHITELIST, "White space separated list of domains and email addresses that are allowed to login.")
 	cacheSize          = flag.Int("cache_size", 1, "Approximate cachesize used to cache images and diff metrics in GiB. This is just a way to limit caching. 0 means no caching at all. Use default for testing.")
 	cpuProfile         = flag.Duration("cpu_profile", 0, "Duration for which to profile the CPU usage. After this duration the program writes the CPU profile and exits.")
+	digestGCAge        = flag.Duration("digest_gc_age", 30*24*time.Hour, "Digests that are not positive and have not appeared in any trace for this long are garbage collected.")
+	digestGCInterval   = flag.Duration("digest_gc_interval", 0, "Interval at which unreferenced digests are garbage collected. 0 disables the garbage collection.")
+	digestGCPurgeGS    = flag.Bool("digest_gc_purge_gs", false, "Also remove the images of garbage collected digests from Google storage.")
 	doOauth            = flag.Bool("oauth", true, "Run through the OAuth 2.0 flow on startup, otherwise use a GCE service account.")
 	forceLogin         = flag.Bool("force_login", false, "Force the user to be authenticated for all requests.")
 	gsBucketNames      = flag.String("gs_buckets", "skia-infra-gm,chromium-skia-gm", "Comma-separated list of google storage bucket that hold uploaded images.")
@@ -187,18 +193,23 @@ func main() {
 		glog.Fatal("Wrong DB version. Please updated to latest version.")
 	}
 
+	evt := eventbus.New(nil)
+
 	digestStore, err := digeststore.New(*storageDir)
 	if err != nil {
 		glog.Fatal(err)
 	}
 
+	quarantineStore, err := quarantine.New(filepath.Join(*storageDir, "quarantine"), evt)
+	if err != nil {
+		glog.Fatalf("Unable to open quarantine store: %s", err)
+	}
+
 	git, err := gitinfo.CloneOrUpdate(*gitRepoURL, *gitRepoDir, false)
 	if err != nil {
 		glog.Fatal(err)
 	}
 
-	evt := eventbus.New(nil)
-
 	rietveldAPI := rietveld.New(rietveld.RIETVELD_SKIA_URL, httputils.NewTimeoutClient())
 	gerritAPI, err := gerrit.NewGerrit(*gerritURL, "", httputils.NewTimeoutClient())
 	if err != nil {
@@ -227,6 +238,7 @@ func main() {
 		MasterTileBuilder: masterTileBuilder,
 		BranchTileBuilder: branchTileBuilder,
 		DigestStore:       digestStore,
+		QuarantineStore:   quarantineStore,
 		NCommits:          *nCommits,
 		EventBus:          evt,
 		TrybotResults:     trybot.NewTrybotResults(branchTileBuilder, rietveldAPI, gerritAPI, ingestionStore),
@@ -245,6 +257,11 @@ func main() {
 		glog.Fatalf("Failed to start monitoring for expired ignore rules: %s", err)
 	}
 
+	digestGC = digestgc.New(storages, *digestGCAge, *digestGCPurgeGS)
+	if *digestGCInterval > 0 {
+		digestGC.Start(*digestGCInterval)
+	}
+
 	// Rebuild the index every two minutes.
 	ixr, err = indexer.New(storages, 2*time.Minute)
 	if err != nil {
@@ -278,21 +295,30 @@ func main() {
 
 	// /_/hashes is used by the bots to find hashes it does not need to upload.
 	router.HandleFunc("/_/hashes", textAllHashesHandler).Methods("GET")
+
+	// The baseline is used by test harnesses to skip uploading known digests.
+	router.HandleFunc(baseline.BASELINE_URL_PATH, jsonBaselineHandler).Methods("GET")
 	router.HandleFunc("/json/version", skiaversion.JsonHandler)
 	router.HandleFunc("/loginstatus/", login.StatusHandler)
 	router.HandleFunc("/logout/", login.LogoutHandler)
 
 	// json handlers only used by the new UI.
 	router.HandleFunc("/json/byblame", jsonByBlameHandler).Methods("GET")
+	router.HandleFunc("/json/commitblame", jsonCommitBlameHandler).Methods("GET")
 	router.HandleFunc("/json/list", jsonListTestsHandler).Methods("GET")
 	router.HandleFunc("/json/paramset", jsonParamsHandler).Methods("GET")
 	router.HandleFunc("/json/search", jsonSearchHandler).Methods("GET")
+	router.HandleFunc("/json/search/stream", jsonSearchStreamHandler).Methods("GET")
 	router.HandleFunc("/json/diff", jsonDiffHandler).Methods("GET")
 	router.HandleFunc("/json/details", jsonDetailsHandler).Methods("GET")
 	router.HandleFunc("/json/ignores", jsonIgnoresHandler).Methods("GET")
 	router.HandleFunc("/json/ignores/add/", jsonIgnoresAddHandler).Methods("POST")
 	router.HandleFunc("/json/ignores/del/{id}", jsonIgnoresDeleteHandler).Methods("POST")
 	router.HandleFunc("/json/ignores/save/{id}", jsonIgnoresUpdateHandler).Methods("POST")
+	router.HandleFunc("/json/flaky", jsonFlakyHandler).Methods("GET")
+	router.HandleFunc("/json/quarantine", jsonQuarantineHandler).Methods("GET")
+	router.HandleFunc("/json/quarantine/add", jsonQuarantineAddHandler).Methods("POST")
+	router.HandleFunc("/json/quarantine/del", jsonQuarantineDeleteHandler).Methods("POST")
 	router.HandleFunc("/json/triage", jsonTriageHandler).Methods("POST")
 	router.HandleFunc("/json/clusterdiff", jsonClusterDiffHandler).Methods("GET")
 	router.HandleFunc("/json/cmp/{test}", jsonCompareTestHandler).Methods("POST")
@@ -301,6 +327,8 @@ func main() {
 	router.HandleFunc("/json/trybot", jsonListTrybotsHandler).Methods("GET")
 	router.HandleFunc("/json/failure", jsonListFailureHandler).Methods("GET")
 	router.HandleFunc("/json/failure/clear", jsonClearFailureHandler).Methods("POST")
+	router.HandleFunc("/json/digestgc", jsonDigestGCHandler).Methods("GET")
+	router.HandleFunc("/json/digestgc/run", jsonDigestGCRunHandler).Methods("POST")
 
 	// For everything else serve the same markup.
 	indexFile := *resourcesDir + "/index.html"
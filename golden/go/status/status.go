@@ -11,6 +11,7 @@ import (
 	"go.skia.org/infra/go/timer"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/quarantine"
 	"go.skia.org/infra/golden/go/storage"
 	"go.skia.org/infra/golden/go/types"
 )
@@ -62,6 +63,10 @@ type GUICorpusStatus struct {
 
 	// Number of negative digests in HEAD.
 	NegativeCount int `json:"negativeCount"`
+
+	// Number of untriaged digests in HEAD of quarantined traces. These are
+	// not counted towards UntriagedCount and don't affect OK.
+	QuarantinedCount int `json:"quarantinedCount"`
 }
 
 type CorpusStatusSorter []*GUICorpusStatus
@@ -102,6 +107,12 @@ func (s *StatusWatcher) calcAndWatchStatus() error {
 		expChanges <- e.([]string)
 	})
 
+	// Changes to the quarantined traces affect the status like changes
+	// to the expectations.
+	s.storages.EventBus.SubscribeAsync(quarantine.EV_QUARANTINE_CHANGED, func(e interface{}) {
+		expChanges <- e.([]string)
+	})
+
 	tileStream := s.storages.GetTileStreamNow(2 * time.Minute)
 
 	lastTilePair := <-tileStream
@@ -151,12 +162,20 @@ func (s *StatusWatcher) calcStatus(tile *tiling.Tile) error {
 		return err
 	}
 
+	quarantined, err := s.storages.GetQuarantined()
+	if err != nil {
+		return err
+	}
+
 	// Gathers unique labels by corpus and label.
 	byCorpus := map[string]map[types.Label]map[string]bool{}
 
+	// Gathers the untriaged digests of quarantined traces by corpus.
+	quarantinedByCorpus := map[string]map[string]bool{}
+
 	// Iterate over the current traces
 	tileLen := tile.LastCommitIndex() + 1
-	for _, trace := range tile.Traces {
+	for traceID, trace := range tile.Traces {
 		gTrace := trace.(*types.GoldenTrace)
 
 		idx := tileLen - 1
@@ -179,6 +198,7 @@ func (s *StatusWatcher) calcStatus(tile *tiling.Tile) error {
 				types.NEGATIVE:  map[string]bool{},
 				types.UNTRIAGED: map[string]bool{},
 			}
+			quarantinedByCorpus[corpus] = map[string]bool{}
 
 			if _, ok := corpusGauges[corpus]; !ok {
 				corpusGauges[corpus] = map[types.Label]*metrics2.Int64Metric{
@@ -194,6 +214,13 @@ func (s *StatusWatcher) calcStatus(tile *tiling.Tile) error {
 		testName := gTrace.Params()[types.PRIMARY_KEY_FIELD]
 		status := expectations.Classification(testName, digest)
 
+		// Untriaged digests of quarantined traces don't affect the status.
+		if _, ok := quarantined[traceID]; ok && (status == types.UNTRIAGED) {
+			quarantinedByCorpus[corpus][digest] = true
+			minCommitId[corpus] = util.MinInt(idx, minCommitId[corpus])
+			continue
+		}
+
 		digestInfo, err := s.storages.GetOrUpdateDigestInfo(testName, digest, tile.Commits[idx])
 		if err != nil {
 			return err
@@ -217,11 +244,12 @@ func (s *StatusWatcher) calcStatus(tile *tiling.Tile) error {
 		positiveCount := len(byCorpus[corpus][types.POSITIVE])
 		negativeCount := len(byCorpus[corpus][types.NEGATIVE])
 		corpStatus = append(corpStatus, &GUICorpusStatus{
-			Name:           corpus,
-			OK:             okByCorpus[corpus],
-			MinCommitHash:  commits[minCommitId[corpus]].Hash,
-			UntriagedCount: untriagedCount,
-			NegativeCount:  negativeCount,
+			Name:             corpus,
+			OK:               okByCorpus[corpus],
+			MinCommitHash:    commits[minCommitId[corpus]].Hash,
+			UntriagedCount:   untriagedCount,
+			NegativeCount:    negativeCount,
+			QuarantinedCount: len(quarantinedByCorpus[corpus]),
 		})
 		allUntriagedCount += untriagedCount
 		allNegativeCount += negativeCount
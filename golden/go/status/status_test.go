@@ -132,3 +132,11 @@ func (m *MockDigestStore) Get(testName, digest string) (*digeststore.DigestInfo,
 func (m *MockDigestStore) Update([]*digeststore.DigestInfo) error {
 	return nil
 }
+
+func (m *MockDigestStore) Iterate(func(*digeststore.DigestInfo) error) error {
+	return nil
+}
+
+func (m *MockDigestStore) Delete([]*digeststore.DigestInfo) error {
+	return nil
+}
@@ -15,6 +15,7 @@ import (
 	"go.skia.org/infra/golden/go/digeststore"
 	"go.skia.org/infra/golden/go/expstorage"
 	"go.skia.org/infra/golden/go/ignore"
+	"go.skia.org/infra/golden/go/quarantine"
 	"go.skia.org/infra/golden/go/trybot"
 	"go.skia.org/infra/golden/go/types"
 )
@@ -28,6 +29,7 @@ type Storage struct {
 	MasterTileBuilder tracedb.MasterTileBuilder
 	BranchTileBuilder tracedb.BranchTileBuilder
 	DigestStore       digeststore.DigestStore
+	QuarantineStore   quarantine.QuarantineStore
 	EventBus          *eventbus.EventBus
 	TrybotResults     *trybot.TrybotResults
 	RietveldAPI       *rietveld.Rietveld
@@ -188,6 +190,15 @@ func (s *Storage) GetOrUpdateDigestInfo(testName, digest string, commit *tiling.
 	return digestInfo, nil
 }
 
+// GetQuarantined returns the currently quarantined traces keyed by trace id.
+// If no QuarantineStore is configured an empty map is returned.
+func (s *Storage) GetQuarantined() (map[string]*quarantine.QuarantinedTrace, error) {
+	if s.QuarantineStore == nil {
+		return map[string]*quarantine.QuarantinedTrace{}, nil
+	}
+	return s.QuarantineStore.Get()
+}
+
 // GetTileFromTimeRange returns a tile that contains the commits in the given time range.
 func (s *Storage) GetTileFromTimeRange(begin, end time.Time) (*tiling.Tile, error) {
 	commitIDs, err := s.BranchTileBuilder.ListLong(begin, end, "master")
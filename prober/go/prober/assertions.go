@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Assertion is a test of an HTTP response which is declared in the config.
+//
+// An Assertion tests either a response header, the whole body, or a value
+// selected from a JSON body by JSONPath. With only a JSONPath, the Assertion
+// tests that the body is JSON and the value exists, e.g. "$" tests that the
+// body is valid JSON.
+type Assertion struct {
+	// Header is the name of the response header to test. If empty, the body
+	// is tested.
+	Header string `json:"header"`
+
+	// JSONPath selects a value from the body, which must be JSON. Only names
+	// and array indices are supported, e.g. "$.data[0].name".
+	JSONPath string `json:"jsonpath"`
+
+	// Optional means the Assertion passes if JSONPath selects nothing.
+	Optional bool `json:"optional"`
+
+	// Regex must match the header, the body, or the selected value. Values
+	// which aren't strings are matched in their JSON encoding.
+	Regex string `json:"regex"`
+
+	// Equals, if set, must equal the selected value.
+	Equals interface{} `json:"equals"`
+
+	// MinLength and MaxLength, if set, bound the length of the selected
+	// array, object or string.
+	MinLength *int `json:"minLength"`
+	MaxLength *int `json:"maxLength"`
+
+	// SameLengthAs is a JSONPath selecting a value which must have the same
+	// length as the selected value.
+	SameLengthAs string `json:"sameLengthAs"`
+
+	re             *regexp.Regexp
+	path           []interface{}
+	sameLengthPath []interface{}
+}
+
+// init parses and validates the Assertion.
+func (a *Assertion) init() error {
+	var err error
+	if a.Regex != "" {
+		if a.re, err = regexp.Compile(a.Regex); err != nil {
+			return fmt.Errorf("Invalid regex %q: %s", a.Regex, err)
+		}
+	}
+	usesJSON := a.Equals != nil || a.MinLength != nil || a.MaxLength != nil || a.SameLengthAs != ""
+	if a.JSONPath == "" {
+		if usesJSON || a.Optional {
+			return fmt.Errorf("Assertion requires a jsonpath: %#v", a)
+		}
+		if a.re == nil {
+			return fmt.Errorf("Assertion must have a jsonpath or a regex.")
+		}
+		return nil
+	}
+	if a.Header != "" {
+		return fmt.Errorf("Assertion can't have both a header and a jsonpath.")
+	}
+	if a.path, err = parseJSONPath(a.JSONPath); err != nil {
+		return err
+	}
+	if a.SameLengthAs != "" {
+		if a.sameLengthPath, err = parseJSONPath(a.SameLengthAs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseJSONPath parses a JSONPath into a list of the object keys (strings)
+// and array indices (ints) to select.
+func parseJSONPath(path string) ([]interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("Invalid jsonpath %q: must begin with '$'", path)
+	}
+	ret := []interface{}{}
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			name := rest[1:end]
+			if name == "" {
+				return nil, fmt.Errorf("Invalid jsonpath %q: empty name", path)
+			}
+			ret = append(ret, name)
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("Invalid jsonpath %q: missing ']'", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("Invalid jsonpath %q: bad index %q", path, rest[1:end])
+			}
+			ret = append(ret, index)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("Invalid jsonpath %q: unexpected %q", path, rest[0])
+		}
+	}
+	return ret, nil
+}
+
+// selectPath returns the value selected by the parsed path from the decoded
+// JSON, and false if the path selects nothing.
+func selectPath(doc interface{}, path []interface{}) (interface{}, bool) {
+	for _, p := range path {
+		switch p := p.(type) {
+		case string:
+			obj, ok := doc.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if doc, ok = obj[p]; !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := doc.([]interface{})
+			if !ok || p >= len(arr) {
+				return nil, false
+			}
+			doc = arr[p]
+		}
+	}
+	return doc, true
+}
+
+// length returns the length of the decoded JSON array, object or string. A
+// null has length zero.
+func length(v interface{}) (int, error) {
+	switch v := v.(type) {
+	case nil:
+		return 0, nil
+	case []interface{}:
+		return len(v), nil
+	case map[string]interface{}:
+		return len(v), nil
+	case string:
+		return len(v), nil
+	}
+	return 0, fmt.Errorf("%v has no length", v)
+}
+
+// test returns a non-nil error if the response doesn't pass the Assertion.
+// doc is the decoded JSON body, or nil if the body isn't valid JSON.
+func (a *Assertion) test(body []byte, doc interface{}, headers http.Header) error {
+	if a.path == nil {
+		s := string(body)
+		if a.Header != "" {
+			s = headers.Get(a.Header)
+		}
+		if !a.re.MatchString(s) {
+			return fmt.Errorf("%q does not match %q", s, a.Regex)
+		}
+		return nil
+	}
+
+	if doc == nil {
+		return fmt.Errorf("Body is not valid JSON.")
+	}
+	v, ok := selectPath(doc, a.path)
+	if !ok {
+		if a.Optional {
+			return nil
+		}
+		return fmt.Errorf("%s not found", a.JSONPath)
+	}
+	if a.re != nil {
+		s, ok := v.(string)
+		if !ok {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("Failed to encode %s: %s", a.JSONPath, err)
+			}
+			s = string(b)
+		}
+		if !a.re.MatchString(s) {
+			return fmt.Errorf("%s: %q does not match %q", a.JSONPath, s, a.Regex)
+		}
+	}
+	if a.Equals != nil && !reflect.DeepEqual(a.Equals, v) {
+		return fmt.Errorf("%s: got %v, want %v", a.JSONPath, v, a.Equals)
+	}
+	if a.MinLength != nil || a.MaxLength != nil || a.sameLengthPath != nil {
+		n, err := length(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", a.JSONPath, err)
+		}
+		if a.MinLength != nil && n < *a.MinLength {
+			return fmt.Errorf("%s: length %d is less than %d", a.JSONPath, n, *a.MinLength)
+		}
+		if a.MaxLength != nil && n > *a.MaxLength {
+			return fmt.Errorf("%s: length %d is more than %d", a.JSONPath, n, *a.MaxLength)
+		}
+		if a.sameLengthPath != nil {
+			other, ok := selectPath(doc, a.sameLengthPath)
+			if !ok {
+				return fmt.Errorf("%s not found", a.SameLengthAs)
+			}
+			m, err := length(other)
+			if err != nil {
+				return fmt.Errorf("%s: %s", a.SameLengthAs, err)
+			}
+			if n != m {
+				return fmt.Errorf("%s has length %d but %s has length %d", a.JSONPath, n, a.SameLengthAs, m)
+			}
+		}
+	}
+	return nil
+}
+
+// testAll returns a non-nil error if the response doesn't pass all of the
+// Assertions. The body is only decoded if an Assertion has a JSONPath.
+func testAll(assertions []*Assertion, body []byte, headers http.Header) error {
+	var doc interface{}
+	for _, a := range assertions {
+		if a.path != nil {
+			if err := json.Unmarshal(body, &doc); err != nil {
+				doc = nil
+			}
+			break
+		}
+	}
+	for _, a := range assertions {
+		if err := a.test(body, doc, headers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
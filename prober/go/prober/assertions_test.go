@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.skia.org/infra/go/testutils"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	testutils.SmallTest(t)
+
+	p, err := parseJSONPath("$")
+	assert.NoError(t, err)
+	expect.Equal(t, []interface{}{}, p)
+
+	p, err = parseJSONPath("$.data[2].name")
+	assert.NoError(t, err)
+	expect.Equal(t, []interface{}{"data", 2, "name"}, p)
+
+	p, err = parseJSONPath("$[0][1]")
+	assert.NoError(t, err)
+	expect.Equal(t, []interface{}{0, 1}, p)
+
+	for _, bad := range []string{"", "data", "$.", "$..a", "$[", "$[x]", "$[-1]", "$a"} {
+		_, err := parseJSONPath(bad)
+		expect.Error(t, err, bad)
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestAssertions(t *testing.T) {
+	testutils.SmallTest(t)
+
+	body := []byte(`{"data": [{"name": "a"}, {"name": "b"}], "permissions": [true, false], "count": 2, "errors": null}`)
+	headers := http.Header{}
+	headers.Set("Content-Length", "12")
+
+	testCases := []struct {
+		assertion *Assertion
+		pass      bool
+	}{
+		{&Assertion{JSONPath: "$"}, true},
+		{&Assertion{JSONPath: "$.data"}, true},
+		{&Assertion{JSONPath: "$.missing"}, false},
+		{&Assertion{JSONPath: "$.missing", Optional: true}, true},
+		{&Assertion{JSONPath: "$.data[1].name", Equals: "b"}, true},
+		{&Assertion{JSONPath: "$.data[2].name", Equals: "b"}, false},
+		{&Assertion{JSONPath: "$.count", Equals: float64(2)}, true},
+		{&Assertion{JSONPath: "$.count", Regex: "^2$"}, true},
+		{&Assertion{JSONPath: "$.data[0]", Regex: `"name":"a"`}, true},
+		{&Assertion{JSONPath: "$.data", MinLength: intPtr(2)}, true},
+		{&Assertion{JSONPath: "$.data", MinLength: intPtr(3)}, false},
+		{&Assertion{JSONPath: "$.data", MaxLength: intPtr(1)}, false},
+		{&Assertion{JSONPath: "$.errors", MaxLength: intPtr(0)}, true},
+		{&Assertion{JSONPath: "$.count", MaxLength: intPtr(0)}, false},
+		{&Assertion{JSONPath: "$.data", SameLengthAs: "$.permissions"}, true},
+		{&Assertion{JSONPath: "$.data", SameLengthAs: "$.data[0]"}, false},
+		{&Assertion{Regex: "permissions"}, true},
+		{&Assertion{Header: "Content-Length", Regex: "^([1-9][0-9]*)?$"}, true},
+		{&Assertion{Header: "Content-Type", Regex: "json"}, false},
+	}
+	for _, tc := range testCases {
+		assert.NoError(t, tc.assertion.init())
+		err := testAll([]*Assertion{tc.assertion}, body, headers)
+		if tc.pass {
+			expect.NoError(t, err, "%#v", tc.assertion)
+		} else {
+			expect.Error(t, err, "%#v", tc.assertion)
+		}
+	}
+
+	// A body which isn't JSON fails any JSONPath.
+	a := &Assertion{JSONPath: "$"}
+	assert.NoError(t, a.init())
+	expect.Error(t, testAll([]*Assertion{a}, []byte("<html>"), headers))
+
+	// Invalid assertions.
+	for _, bad := range []*Assertion{
+		{},
+		{Regex: "("},
+		{MinLength: intPtr(1)},
+		{Optional: true, Regex: "a"},
+		{Header: "Content-Type", JSONPath: "$"},
+		{JSONPath: "$", SameLengthAs: "data"},
+	} {
+		expect.Error(t, bad.init(), "%#v", bad)
+	}
+}
+
+func TestProbeInit(t *testing.T) {
+	testutils.SmallTest(t)
+
+	p := &Probe{Step: Step{URL: "https://skia.org", Expected: []int{200}}}
+	assert.NoError(t, p.init())
+	expect.Equal(t, PROBE_HTTP, p.Type)
+	expect.Equal(t, "GET", p.Method)
+
+	p = &Probe{Type: PROBE_TLS, Addr: "skia.org:443"}
+	assert.NoError(t, p.init())
+	expect.Equal(t, DEFAULT_CERT_EXPIRY_WARNING, p.certExpiryWarning)
+
+	for _, bad := range []*Probe{
+		{Step: Step{URL: "https://skia.org"}},
+		{Step: Step{URL: "https://skia.org", Method: "PATCH", Expected: []int{200}}},
+		{Step: Step{URL: "https://skia.org", Expected: []int{200}}, ResponseTestName: "validJSON"},
+		{Type: PROBE_STEPS},
+		{Type: PROBE_STEPS, Steps: []*Step{{URL: "https://skia.org"}}},
+		{Type: PROBE_TLS, Addr: "skia.org:443", CertExpiryWarning: "2 weeks"},
+		{Type: PROBE_TCP},
+		{Type: PROBE_DNS},
+		{Type: PROBE_GRPC},
+		{Type: "ftp", Addr: "skia.org:21"},
+	} {
+		expect.Error(t, bad.init(), "%#v", bad)
+	}
+}
+
+func TestReadConfigFiles(t *testing.T) {
+	testutils.SmallTest(t)
+
+	probes, err := readConfigFiles("../../probers.json")
+	assert.NoError(t, err)
+	expect.NotEmpty(t, probes)
+}
+
+func TestRunSteps(t *testing.T) {
+	testutils.SmallTest(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1234"})
+			w.WriteHeader(http.StatusOK)
+		case "/video":
+			w.Header().Set("Content-Type", "video/webm")
+			_, _ = w.Write([]byte("not really a video"))
+		case "/data":
+			if c, err := r.Cookie("session"); err != nil || c.Value != "1234" {
+				http.Error(w, "Not logged in", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"data": [1, 2, 3]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	login := &Step{URL: ts.URL + "/login", Expected: []int{200}}
+	data := &Step{
+		URL:        ts.URL + "/data",
+		Expected:   []int{200},
+		Assertions: []*Assertion{{JSONPath: "$.data", MinLength: intPtr(3)}},
+	}
+	assert.NoError(t, login.init())
+	assert.NoError(t, data.init())
+
+	expect.NoError(t, runSteps([]*Step{login, data}, http.DefaultClient))
+	// Cookies aren't kept between runs.
+	expect.Error(t, runSteps([]*Step{data}, http.DefaultClient))
+
+	data.Assertions[0].MinLength = intPtr(4)
+	expect.Error(t, runSteps([]*Step{login, data}, http.DefaultClient))
+
+	// The body isn't read if only headers are tested.
+	video := &Step{
+		URL:        ts.URL + "/video",
+		Expected:   []int{200},
+		Assertions: []*Assertion{{Header: "Content-Type", Regex: "^video/"}},
+	}
+	assert.NoError(t, video.init())
+	expect.False(t, video.testsBody())
+	expect.True(t, data.testsBody())
+	expect.NoError(t, video.run(http.DefaultClient))
+	video.Assertions[0].Regex = "^image/"
+	assert.NoError(t, video.init())
+	expect.Error(t, video.run(http.DefaultClient))
+}
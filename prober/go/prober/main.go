@@ -1,5 +1,7 @@
 // Prober is an HTTP prober that periodically sends out HTTP requests to specified
-// endpoints and reports if the returned results match the expectations. The results
+// endpoints and reports if the returned results match the expectations. It can
+// also probe TLS certificate expiry, TCP connections, DNS resolution and gRPC
+// health checks, and run multi-step HTTP probes. The results
 // of the probe, including latency, are recored in InfluxDB using the Carbon protocol.
 // See probers.json as an example of the config file format.
 package main
@@ -8,7 +10,6 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -27,27 +28,14 @@ import (
 )
 
 var (
-	config   = flag.String("config", "probers.json", "Comma separated names of prober config files.")
-	runEvery = flag.Duration("run_every", 1*time.Minute, "How often to run the probes.")
-	testing  = flag.Bool("testing", false, "Set to true for local testing.")
+	config    = flag.String("config", "probers.json", "Comma separated names of prober config files.")
+	runEvery  = flag.Duration("run_every", 1*time.Minute, "How often to run the probes.")
+	isTesting = flag.Bool("testing", false, "Set to true for local testing.")
 
 	influxHost     = flag.String("influxdb_host", influxdb.DEFAULT_HOST, "The InfluxDB hostname.")
 	influxUser     = flag.String("influxdb_name", influxdb.DEFAULT_USER, "The InfluxDB username.")
 	influxPassword = flag.String("influxdb_password", influxdb.DEFAULT_PASSWORD, "The InfluxDB password.")
 	influxDatabase = flag.String("influxdb_database", influxdb.DEFAULT_DATABASE, "The InfluxDB database.")
-
-	// responseTesters is a mapping of names to functions that test response bodies.
-	responseTesters = map[string]ResponseTester{
-		"ctfeCLDataJSON":              ctfeCLDataJSON,
-		"ctfeBenchmarksPlatformsJSON": ctfeBenchmarksPlatformsJSON,
-		"ctfeGetTasksJSON":            ctfeGetTasksJSON,
-		"ctfeGetTasksNonEmptyJSON":    ctfeGetTasksNonEmptyJSON,
-		"ctfeRevDataJSON":             ctfeRevDataJSON,
-		"nonZeroContenLength":         nonZeroContenLength,
-		"skfiddleJSONBad":             skfiddleJSONBad,
-		"skfiddleJSONGood":            skfiddleJSONGood,
-		"validJSON":                   validJSON,
-	}
 )
 
 const (
@@ -56,32 +44,111 @@ const (
 	ISSUE_TRACKER_PERIOD = 15 * time.Minute
 )
 
-// ResponseTester tests the response from a probe and returns true if it passes all tests.
-type ResponseTester func(io.Reader, http.Header) bool
-
 // Probe is a single endpoint we are probing.
 type Probe struct {
-	// URL is the HTTP URL to probe.
-	URL string `json:"url"`
+	// Type is the kind of probe, one of the PROBE_* constants. Defaults to
+	// "http".
+	Type string `json:"type"`
 
-	// Method is the HTTP method to use when probing.
-	Method string `json:"method"`
+	// Step is the request made by http probes.
+	Step
 
-	// Expected is the list of expected HTTP status code, i.e. [200, 201]
-	Expected []int `json:"expected"`
+	// ResponseTestName is no longer supported, use Assertions instead.
+	ResponseTestName string `json:"responsetest"`
 
-	// Body is the body of the request to send if the method is POST.
-	Body string `json:"body"`
+	// Steps are the requests made in order by steps probes, e.g. to log in
+	// and then fetch a page. Cookies are kept between the steps.
+	Steps []*Step `json:"steps"`
 
-	// The mimetype of the Body.
-	MimeType string `json:"mimetype"`
+	// Addr is the host:port to connect to for tls, tcp and grpc probes, or
+	// the hostname to resolve for dns probes.
+	Addr string `json:"addr"`
 
-	// The body testing function we should use.
-	ResponseTestName string `json:"responsetest"`
+	// CertExpiryWarning is how long before a certificate expires that a tls
+	// probe fails, e.g. "336h". Defaults to DEFAULT_CERT_EXPIRY_WARNING.
+	CertExpiryWarning string `json:"certExpiryWarning"`
+
+	// ExpectedAddrs are addresses which a dns probe must resolve to.
+	ExpectedAddrs []string `json:"expectedAddrs"`
+
+	// Service is the name of the service whose health a grpc probe checks.
+	// If empty, the health of the whole server is checked.
+	Service string `json:"service"`
+
+	// TLS is true if a grpc probe should connect using TLS.
+	TLS bool `json:"tls"`
+
+	certExpiryWarning time.Duration
+	failure           *metrics2.Int64Metric
+	latency           *metrics2.Int64Metric // Latency in ms.
+	certExpiry        *metrics2.Int64Metric // Hours until expiry, for tls probes.
+}
+
+// init validates the Probe.
+func (p *Probe) init() error {
+	if p.ResponseTestName != "" {
+		return fmt.Errorf("responsetest is no longer supported, use assertions instead.")
+	}
+	if p.Type == "" {
+		p.Type = PROBE_HTTP
+	}
+	switch p.Type {
+	case PROBE_HTTP:
+		return p.Step.init()
+	case PROBE_STEPS:
+		if len(p.Steps) == 0 {
+			return fmt.Errorf("A steps probe requires steps.")
+		}
+		for i, s := range p.Steps {
+			if err := s.init(); err != nil {
+				return fmt.Errorf("Step %d: %s", i+1, err)
+			}
+		}
+	case PROBE_TLS:
+		p.certExpiryWarning = DEFAULT_CERT_EXPIRY_WARNING
+		if p.CertExpiryWarning != "" {
+			d, err := time.ParseDuration(p.CertExpiryWarning)
+			if err != nil {
+				return fmt.Errorf("Invalid certExpiryWarning %q: %s", p.CertExpiryWarning, err)
+			}
+			p.certExpiryWarning = d
+		}
+		fallthrough
+	case PROBE_TCP, PROBE_DNS, PROBE_GRPC:
+		if p.Addr == "" {
+			return fmt.Errorf("A %s probe requires an addr.", p.Type)
+		}
+	default:
+		return fmt.Errorf("Unknown probe type: %s", p.Type)
+	}
+	return nil
+}
 
-	responseTest ResponseTester
-	failure      *metrics2.Int64Metric
-	latency      *metrics2.Int64Metric // Latency in ms.
+// run runs the probe and returns a non-nil error if it fails.
+func (p *Probe) run(c *http.Client) error {
+	switch p.Type {
+	case PROBE_HTTP:
+		return p.Step.run(c)
+	case PROBE_STEPS:
+		return runSteps(p.Steps, c)
+	case PROBE_TLS:
+		ttl, err := probeTLS(p.Addr)
+		if err != nil {
+			return err
+		}
+		p.certExpiry.Update(int64(ttl / time.Hour))
+		if ttl < p.certExpiryWarning {
+			return fmt.Errorf("Certificate for %s expires in %s", p.Addr, ttl)
+		}
+		return nil
+	case PROBE_TCP:
+		return probeTCP(p.Addr)
+	case PROBE_DNS:
+		return probeDNS(p.Addr, p.ExpectedAddrs)
+	case PROBE_GRPC:
+		return probeGRPC(p.Addr, p.Service, p.TLS)
+	}
+	return fmt.Errorf("Unknown probe type: %s", p.Type)
 }
 
 // Probes is all the probes that are to be run.
@@ -99,10 +166,10 @@ func readConfigFiles(filenames string) (Probes, error) {
 		if err := d.Decode(p); err != nil {
 			return nil, fmt.Errorf("Failed to decode JSON in config file: %s", err)
 		}
+		util.Close(file)
 		for k, v := range *p {
-			if f, ok := responseTesters[v.ResponseTestName]; ok {
-				v.responseTest = f
-				glog.Infof("Found a request test for %s", k)
+			if err := v.init(); err != nil {
+				return nil, fmt.Errorf("Invalid probe %s in %s: %s", k, filename, err)
 			}
 			allProbes[k] = v
 		}
@@ -125,126 +192,6 @@ func dialTimeout(network, addr string) (net.Conn, error) {
 	return net.DialTimeout(network, addr, DIAL_TIMEOUT)
 }
 
-// nonZeroContenLength tests whether the Content-Length value is non-zero.
-func nonZeroContenLength(r io.Reader, headers http.Header) bool {
-	return headers.Get("Content-Length") != "0"
-}
-
-// validJSON tests whether the response contains valid JSON.
-func validJSON(r io.Reader, headers http.Header) bool {
-	var i interface{}
-	return json.NewDecoder(r).Decode(&i) == nil
-}
-
-type skfiddleResp struct {
-	CompileErrors []interface{} `json:"compile_errors"`
-}
-
-// skfiddleJSONGood tests that the compile completed w/o error.
-func skfiddleJSONGood(r io.Reader, headers http.Header) bool {
-	dec := json.NewDecoder(r)
-	s := skfiddleResp{
-		CompileErrors: []interface{}{},
-	}
-	if err := dec.Decode(&s); err != nil {
-		glog.Warningf("Failed to decode skfiddle JSON: %#v %s", s, err)
-		return false
-	}
-	glog.Infof("%#v", s)
-	return len(s.CompileErrors) == 0
-}
-
-// skfiddleJSONBad tests that the compile completed w/error.
-func skfiddleJSONBad(r io.Reader, headers http.Header) bool {
-	dec := json.NewDecoder(r)
-	s := skfiddleResp{
-		CompileErrors: []interface{}{},
-	}
-	if err := dec.Decode(&s); err != nil {
-		glog.Warningf("Failed to decode skfiddle JSON: %#v %s", s, err)
-		return false
-	}
-	glog.Infof("%#v", s)
-	return len(s.CompileErrors) != 0
-}
-
-// decodeJSONObject reads a JSON object from r and returns the resulting object. Returns nil if the
-// JSON is invalid or can't be decoded to a map[string]interface{}.
-func decodeJSONObject(r io.Reader) map[string]interface{} {
-	var obj map[string]interface{}
-	if json.NewDecoder(r).Decode(&obj) != nil {
-		return nil
-	}
-	return obj
-}
-
-// hasKeys tests that the given decoded JSON object has at least the provided keys. If obj is nil,
-// returns false.
-func hasKeys(obj map[string]interface{}, keys []string) bool {
-	if obj == nil {
-		return false
-	}
-	for _, key := range keys {
-		if _, ok := obj[key]; !ok {
-			return false
-		}
-	}
-	return true
-}
-
-// ctfeBenchmarksPlatformsJSON tests that the response contains valid JSON with the keys
-// expected by ct/templates/chromium_perf.html and ct/templates/chromium_analysis.html.
-func ctfeBenchmarksPlatformsJSON(r io.Reader, headers http.Header) bool {
-	return hasKeys(decodeJSONObject(r), []string{"benchmarks", "platforms"})
-}
-
-// ctfeCLDataJSON tests that the response contains valid JSON with the keys expected by
-// ct/res/imp/chromium-perf-sk.html.
-func ctfeCLDataJSON(r io.Reader, headers http.Header) bool {
-	return hasKeys(decodeJSONObject(r), []string{"subject", "modified", "catapult_patch", "chromium_patch", "skia_patch", "url"})
-}
-
-// ctfeGetTasksJSONObject tests that obj has the attributes expected by
-// ct/res/imp/pending-tasks-sk.html and ct/res/imp/*-runs-sk.html. Returns false if obj is nil.
-func ctfeGetTasksJSONObject(obj map[string]interface{}, headers http.Header) bool {
-	if !hasKeys(obj, []string{"data", "permissions", "pagination"}) {
-		return false
-	}
-	data, dataOk := obj["data"].([]interface{})
-	permissions, permissionsOk := obj["permissions"].([]interface{})
-	if !dataOk || !permissionsOk || len(data) != len(permissions) {
-		return false
-	}
-	// TODO(benjaminwagner): Other checks required?
-	return true
-}
-
-// ctfeGetTasksJSON tests that the response contains valid JSON and satisfies
-// ctfeGetTasksJSONObject.
-func ctfeGetTasksJSON(r io.Reader, headers http.Header) bool {
-	return ctfeGetTasksJSONObject(decodeJSONObject(r), headers)
-}
-
-// ctfeGetTasksNonEmptyJSON tests the same as ctfeGetTasksJSON and also checks that there is at
-// least one task present.
-func ctfeGetTasksNonEmptyJSON(r io.Reader, headers http.Header) bool {
-	obj := decodeJSONObject(r)
-	if !ctfeGetTasksJSONObject(obj, headers) {
-		return false
-	}
-	data := obj["data"].([]interface{})
-	if len(data) < 1 {
-		return false
-	}
-	return true
-}
-
-// ctfeRevDataJSON tests that the response contains valid JSON with the keys expected by
-// ct/res/imp/chromium-builds-sk.html.
-func ctfeRevDataJSON(r io.Reader, headers http.Header) bool {
-	return hasKeys(decodeJSONObject(r), []string{"commit", "author", "committer"})
-}
-
 // monitorIssueTracker reads the counts for all the types of issues in the Skia
 // issue tracker (bugs.chromium.org/p/skia) and stuffs the counts into Graphite.
 func monitorIssueTracker(c *http.Client) {
@@ -298,56 +245,24 @@ func monitorIssueTracker(c *http.Client) {
 }
 
 func probeOneRound(cfg Probes, c *http.Client) {
-	var resp *http.Response
-	var begin time.Time
 	for name, probe := range cfg {
 		glog.Infof("Probe: %s Starting fail value: %d", name, probe.failure.Get())
-		begin = time.Now()
-		var err error
-		if probe.Method == "GET" {
-			resp, err = c.Get(probe.URL)
-		} else if probe.Method == "HEAD" {
-			resp, err = c.Head(probe.URL)
-		} else if probe.Method == "POST" {
-			resp, err = c.Post(probe.URL, probe.MimeType, strings.NewReader(probe.Body))
-		} else {
-			glog.Errorf("Error: unknown method: %s", probe.Method)
-			continue
-		}
+		begin := time.Now()
+		err := probe.run(c)
 		d := time.Since(begin)
 		probe.latency.Update(d.Nanoseconds() / int64(time.Millisecond))
 		if err != nil {
-			glog.Warningf("Failed to make request: Name: %s URL: %s Error: %s", name, probe.URL, err)
+			glog.Warningf("Probe failed: Name: %s Error: %s", name, err)
 			probe.failure.Update(1)
 			continue
 		}
-		responseTestResults := true
-		if probe.responseTest != nil && resp.Body != nil {
-			responseTestResults = probe.responseTest(resp.Body, resp.Header)
-		}
-		if resp.Body != nil {
-			util.Close(resp.Body)
-		}
-		// TODO(jcgregorio) Save the last N responses and present them in a web UI.
-
-		if !In(resp.StatusCode, probe.Expected) {
-			glog.Warningf("Got wrong status code: Name %s Got %d Want %v", name, resp.StatusCode, probe.Expected)
-			probe.failure.Update(1)
-			continue
-		}
-		if !responseTestResults {
-			glog.Warningf("Response test failed: Name: %s %#v", name, probe)
-			probe.failure.Update(1)
-			continue
-		}
-
 		probe.failure.Update(0)
 	}
 }
 
 func main() {
 	defer common.LogPanic()
-	common.InitWithMetrics2("probeserver", influxHost, influxUser, influxPassword, influxDatabase, testing)
+	common.InitWithMetrics2("probeserver", influxHost, influxUser, influxPassword, influxDatabase, isTesting)
 
 	client, err := auth.NewJWTServiceAccountClient("", "", &http.Transport{Dial: httputils.DialTimeout}, "https://www.googleapis.com/auth/userinfo.email")
 	if err != nil {
@@ -367,6 +282,9 @@ func main() {
 	for name, probe := range cfg {
 		probe.failure = metrics2.GetInt64Metric("prober", map[string]string{"type": "failure", "probename": name})
 		probe.latency = metrics2.GetInt64Metric("prober", map[string]string{"type": "latency", "probename": name})
+		if probe.Type == PROBE_TLS {
+			probe.certExpiry = metrics2.GetInt64Metric("prober", map[string]string{"type": "cert-expiry", "probename": name})
+		}
 	}
 
 	// Create a client that uses our dialer with a timeout.
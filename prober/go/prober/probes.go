@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"time"
+
+	"go.skia.org/infra/go/util"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// Values for Probe.Type.
+	PROBE_HTTP  = "http"
+	PROBE_STEPS = "steps"
+	PROBE_TLS   = "tls"
+	PROBE_TCP   = "tcp"
+	PROBE_DNS   = "dns"
+	PROBE_GRPC  = "grpc"
+
+	// DEFAULT_CERT_EXPIRY_WARNING is how long before a certificate expires
+	// that a tls probe starts failing, if not given in the config.
+	DEFAULT_CERT_EXPIRY_WARNING = 14 * 24 * time.Hour
+
+	// MAX_BODY_SIZE is the most of a response body that is read and tested.
+	MAX_BODY_SIZE = 10 * 1024 * 1024
+)
+
+// Step is a single HTTP request and the tests of its response.
+type Step struct {
+	// URL is the HTTP URL to probe.
+	URL string `json:"url"`
+
+	// Method is the HTTP method to use when probing, GET if empty.
+	Method string `json:"method"`
+
+	// Expected is the list of expected HTTP status code, i.e. [200, 201]
+	Expected []int `json:"expected"`
+
+	// Body is the body of the request to send if the method is POST.
+	Body string `json:"body"`
+
+	// The mimetype of the Body.
+	MimeType string `json:"mimetype"`
+
+	// Headers are added to the request.
+	Headers map[string]string `json:"headers"`
+
+	// Assertions are the tests of the response, which must all pass.
+	Assertions []*Assertion `json:"assertions"`
+}
+
+// init validates the Step.
+func (s *Step) init() error {
+	if s.URL == "" {
+		return fmt.Errorf("Missing url.")
+	}
+	if s.Method == "" {
+		s.Method = "GET"
+	}
+	if !util.In(s.Method, []string{"GET", "HEAD", "POST", "PUT", "DELETE"}) {
+		return fmt.Errorf("Unknown method: %s", s.Method)
+	}
+	if len(s.Expected) == 0 {
+		return fmt.Errorf("Missing expected status codes for %s", s.URL)
+	}
+	for _, a := range s.Assertions {
+		if err := a.init(); err != nil {
+			return fmt.Errorf("Invalid assertion for %s: %s", s.URL, err)
+		}
+	}
+	return nil
+}
+
+// testsBody returns true if any of the Assertions test the response body.
+func (s *Step) testsBody() bool {
+	for _, a := range s.Assertions {
+		if a.Header == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// run makes the request and tests the response.
+func (s *Step) run(c *http.Client) error {
+	req, err := http.NewRequest(s.Method, s.URL, strings.NewReader(s.Body))
+	if err != nil {
+		return fmt.Errorf("Failed to create request for %s: %s", s.URL, err)
+	}
+	if s.MimeType != "" {
+		req.Header.Set("Content-Type", s.MimeType)
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to make request to %s: %s", s.URL, err)
+	}
+	defer util.Close(resp.Body)
+	// TODO(jcgregorio) Save the last N responses and present them in a web UI.
+	if !In(resp.StatusCode, s.Expected) {
+		return fmt.Errorf("Got wrong status code from %s: Got %d Want %v", s.URL, resp.StatusCode, s.Expected)
+	}
+	if len(s.Assertions) == 0 {
+		return nil
+	}
+	// Only read the body if it's tested, since it may be large.
+	var body []byte
+	if s.testsBody() {
+		body, err = ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: MAX_BODY_SIZE})
+		if err != nil {
+			return fmt.Errorf("Failed to read response from %s: %s", s.URL, err)
+		}
+	}
+	if err := testAll(s.Assertions, body, resp.Header); err != nil {
+		return fmt.Errorf("Response test failed for %s: %s", s.URL, err)
+	}
+	return nil
+}
+
+// runSteps runs the steps in order, sharing cookies between them, and stops
+// at the first which fails.
+func runSteps(steps []*Step, c *http.Client) error {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return fmt.Errorf("Failed to create cookie jar: %s", err)
+	}
+	withCookies := *c
+	withCookies.Jar = jar
+	for i, s := range steps {
+		if err := s.run(&withCookies); err != nil {
+			return fmt.Errorf("Step %d: %s", i+1, err)
+		}
+	}
+	return nil
+}
+
+// probeTLS connects to addr and returns the time until the first of its
+// certificates expires. Returns an error if the connection fails or the
+// certificates aren't valid.
+func probeTLS(addr string) (time.Duration, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: DIAL_TIMEOUT}, "tcp", addr, &tls.Config{})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to connect to %s: %s", addr, err)
+	}
+	defer util.Close(conn)
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return 0, fmt.Errorf("No certificates from %s", addr)
+	}
+	expiry := certs[0].NotAfter
+	for _, cert := range certs[1:] {
+		if cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+	return expiry.Sub(time.Now()), nil
+}
+
+// probeTCP returns an error if a TCP connection to addr can't be made.
+func probeTCP(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, DIAL_TIMEOUT)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to %s: %s", addr, err)
+	}
+	util.Close(conn)
+	return nil
+}
+
+// probeDNS returns an error if host doesn't resolve to all of the expected
+// addresses, or to any address if none are expected.
+func probeDNS(host string, expected []string) error {
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve %s: %s", host, err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("%s resolved to no addresses.", host)
+	}
+	for _, e := range expected {
+		if !util.In(e, addrs) {
+			return fmt.Errorf("%s resolved to %v, missing %s", host, addrs, e)
+		}
+	}
+	return nil
+}
+
+// probeGRPC returns an error if the gRPC health service at addr doesn't
+// report that the given service is serving. An empty service checks the
+// server as a whole.
+func probeGRPC(addr, service string, useTLS bool) error {
+	opts := []grpc.DialOption{grpc.WithBlock(), grpc.WithTimeout(DIAL_TIMEOUT)}
+	if useTLS {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, "")))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to %s: %s", addr, err)
+	}
+	defer util.Close(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), REQUEST_TIMEOUT)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return fmt.Errorf("Health check of %q at %s failed: %s", service, addr, err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("Health check of %q at %s returned %s", service, addr, resp.Status)
+	}
+	return nil
+}
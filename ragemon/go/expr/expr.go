@@ -0,0 +1,383 @@
+// Package expr evaluates expressions over ragemon timeseries, such as:
+//
+//	sum(rate(filter("metric=requests")), "host")
+//
+// Expressions are parsed and evaluated by go/calc, so all of its functions
+// are available. The timeseries are aligned onto a grid of evenly spaced
+// timestamps, and intervals without any points are vec32.MISSING_DATA_SENTINEL.
+//
+// The aggregation functions sum(), avg(), max() and min() are replaced with
+// versions that take optional parameter names to group by, and rate() and
+// moving_avg() are added. Those functions keep the structured keys of the rows
+// they transform, so that their results may be grouped.
+package expr
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.skia.org/infra/go/calc"
+	"go.skia.org/infra/go/query"
+	"go.skia.org/infra/go/vec32"
+	"go.skia.org/infra/ragemon/go/store"
+	"go.skia.org/infra/ragemon/go/ts"
+)
+
+const (
+	// MAX_POINTS is the largest number of timestamps in a Result.
+	MAX_POINTS = 10000
+)
+
+// missing is the value of intervals without any points.
+const missing = vec32.MISSING_DATA_SENTINEL
+
+// Result is the result of evaluating an expression.
+type Result struct {
+	// Timestamps are the start of each interval, in seconds since the Unix
+	// epoch.
+	Timestamps []int64 `json:"timestamps"`
+
+	// Series maps keys to the value of each interval, or nil for intervals
+	// without any points.
+	Series map[string][]*float32 `json:"series"`
+}
+
+// grid describes the timestamps that timeseries are aligned onto.
+type grid struct {
+	begin int64 // Aligned to a multiple of step.
+	step  int64
+	n     int
+}
+
+// newGrid returns the grid covering [begin, end) with the given step.
+func newGrid(begin, end time.Time, step time.Duration) (*grid, error) {
+	s := int64(step / time.Second)
+	if s <= 0 {
+		return nil, fmt.Errorf("Step must be at least one second, got %s", step)
+	}
+	if !begin.Before(end) {
+		return nil, fmt.Errorf("Begin %s must be before end %s", begin, end)
+	}
+	b := ts.Downsample([]ts.Point{{Timestamp: begin.Unix()}}, s)[0].Timestamp
+	n := (end.Unix() - b + s - 1) / s
+	if n > MAX_POINTS {
+		return nil, fmt.Errorf("Too many points: %d, the maximum is %d. Increase the step.", n, MAX_POINTS)
+	}
+	return &grid{
+		begin: b,
+		step:  s,
+		n:     int(n),
+	}, nil
+}
+
+// row aligns the points, which must be sorted by timestamp, onto the grid.
+func (g *grid) row(points []ts.Point) []float32 {
+	ret := make([]float32, g.n)
+	for i := range ret {
+		ret[i] = missing
+	}
+	for _, p := range ts.Downsample(points, g.step) {
+		i := (p.Timestamp - g.begin) / g.step
+		if i >= 0 && i < int64(g.n) {
+			ret[i] = float32(p.Value)
+		}
+	}
+	return ret
+}
+
+// rowsFromQuery returns a calc.RowsFromQuery which finds the matching
+// timeseries in the store and aligns them onto the grid.
+func (g *grid) rowsFromQuery(st store.Store) calc.RowsFromQuery {
+	return func(s string) (calc.Rows, error) {
+		values, err := url.ParseQuery(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid query %q: %s", s, err)
+		}
+		q, err := query.New(values)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid query %q: %s", s, err)
+		}
+		begin := time.Unix(g.begin, 0)
+		end := time.Unix(g.begin+int64(g.n)*g.step, 0)
+		ret := calc.Rows{}
+		for key, series := range st.Match(begin, end, q, time.Duration(g.step)*time.Second) {
+			ret[key] = g.row(series.Points())
+		}
+		return ret, nil
+	}
+}
+
+// Eval evaluates the expression over the timeseries in the store between
+// [begin, end), with one value for each interval of the given step.
+func Eval(st store.Store, exp string, begin, end time.Time, step time.Duration) (*Result, error) {
+	g, err := newGrid(begin, end, step)
+	if err != nil {
+		return nil, err
+	}
+	ctx := newContext(g.rowsFromQuery(st), exp, g.step)
+	rows, err := ctx.Eval(exp)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Result{
+		Timestamps: make([]int64, g.n),
+		Series:     make(map[string][]*float32, len(rows)),
+	}
+	for i := range ret.Timestamps {
+		ret.Timestamps[i] = g.begin + int64(i)*g.step
+	}
+	for key, row := range rows {
+		values := make([]*float32, len(row))
+		for i, v := range row {
+			if v != missing && !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0) {
+				// Take a copy, since v is reused.
+				v := v
+				values[i] = &v
+			}
+		}
+		ret.Series[key] = values
+	}
+	return ret, nil
+}
+
+// newContext returns a calc.Context with the ragemon functions. The formula is
+// used as the key of aggregates which aren't grouped, as in go/calc.
+func newContext(rowsFromQuery calc.RowsFromQuery, formula string, step int64) *calc.Context {
+	ctx := calc.NewContext(rowsFromQuery)
+	for name, f := range map[string]calc.Func{
+		"sum":        &aggregateFunc{name: "sum", formula: formula, agg: aggSum},
+		"avg":        &aggregateFunc{name: "avg", formula: formula, agg: aggAvg},
+		"ave":        &aggregateFunc{name: "ave", formula: formula, agg: aggAvg},
+		"max":        &aggregateFunc{name: "max", formula: formula, agg: aggMax},
+		"min":        &aggregateFunc{name: "min", formula: formula, agg: aggMin},
+		"rate":       &rateFunc{step: step},
+		"moving_avg": movingAvgFunc{},
+	} {
+		ctx.Funcs[name] = f
+	}
+	return ctx
+}
+
+// evalRowsArg evaluates the first argument of the node, which must be a
+// function.
+func evalRowsArg(ctx *calc.Context, name string, node *calc.Node) (calc.Rows, error) {
+	if len(node.Args) == 0 || node.Args[0].Typ != calc.NodeFunc {
+		return nil, fmt.Errorf("%s() takes a function as its first argument.", name)
+	}
+	rows, err := node.Args[0].Eval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s() argument failed to evaluate: %s", name, err)
+	}
+	return rows, nil
+}
+
+// aggSum, aggAvg, aggMax and aggMin aggregate the non-missing values at one index.
+func aggSum(vals []float32) float32 {
+	ret := float32(0.0)
+	for _, v := range vals {
+		ret += v
+	}
+	return ret
+}
+
+func aggAvg(vals []float32) float32 {
+	return aggSum(vals) / float32(len(vals))
+}
+
+func aggMax(vals []float32) float32 {
+	ret := vals[0]
+	for _, v := range vals[1:] {
+		if v > ret {
+			ret = v
+		}
+	}
+	return ret
+}
+
+func aggMin(vals []float32) float32 {
+	ret := vals[0]
+	for _, v := range vals[1:] {
+		if v < ret {
+			ret = v
+		}
+	}
+	return ret
+}
+
+// aggregateFunc implements calc.Func and aggregates rows, optionally grouped
+// by the values of the given params.
+type aggregateFunc struct {
+	name    string
+	formula string
+	agg     func([]float32) float32
+}
+
+func (f *aggregateFunc) Eval(ctx *calc.Context, node *calc.Node) (calc.Rows, error) {
+	rows, err := evalRowsArg(ctx, f.name, node)
+	if err != nil {
+		return nil, err
+	}
+	params := []string{}
+	for _, arg := range node.Args[1:] {
+		if arg.Typ != calc.NodeString {
+			return nil, fmt.Errorf("%s() takes param names as strings after its first argument.", f.name)
+		}
+		params = append(params, arg.Val)
+	}
+
+	// Group the rows.
+	groups := map[string][][]float32{}
+	for key, row := range rows {
+		groupKey := f.formula
+		if len(params) > 0 {
+			parsed, err := query.ParseKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("%s() can't group %q: %s", f.name, key, err)
+			}
+			groupParams := map[string]string{}
+			for _, p := range params {
+				if v, ok := parsed[p]; ok {
+					groupParams[p] = v
+				}
+			}
+			// Rows without any of the params end up in their own group.
+			groupKey = ","
+			if len(groupParams) > 0 {
+				if groupKey, err = query.MakeKey(groupParams); err != nil {
+					return nil, fmt.Errorf("%s() can't group %q: %s", f.name, key, err)
+				}
+			}
+		}
+		groups[groupKey] = append(groups[groupKey], row)
+	}
+
+	ret := calc.Rows{}
+	for groupKey, groupRows := range groups {
+		out := make([]float32, len(groupRows[0]))
+		vals := make([]float32, 0, len(groupRows))
+		for i := range out {
+			vals = vals[:0]
+			for _, r := range groupRows {
+				if v := r[i]; v != missing {
+					vals = append(vals, v)
+				}
+			}
+			if len(vals) > 0 {
+				out[i] = f.agg(vals)
+			} else {
+				out[i] = missing
+			}
+		}
+		ret[groupKey] = out
+	}
+	return ret, nil
+}
+
+func (f *aggregateFunc) Describe() string {
+	return fmt.Sprintf(`%s() aggregates the values of all argument rows.
+
+  Any further arguments are the names of params to group by, in which case
+  there is one row for each combination of their values, for example:
+
+     %s(filter("metric=cpu"), "host")`, f.name, f.name)
+}
+
+// rateFunc implements calc.Func and returns the per-second rate of increase of
+// counters.
+type rateFunc struct {
+	step int64
+}
+
+func (f *rateFunc) Eval(ctx *calc.Context, node *calc.Node) (calc.Rows, error) {
+	if len(node.Args) != 1 {
+		return nil, fmt.Errorf("rate() takes a single argument.")
+	}
+	rows, err := evalRowsArg(ctx, "rate", node)
+	if err != nil {
+		return nil, err
+	}
+	ret := calc.Rows{}
+	for key, row := range rows {
+		out := make([]float32, len(row))
+		prev := -1
+		for i, v := range row {
+			out[i] = missing
+			if v == missing {
+				continue
+			}
+			if prev >= 0 {
+				delta := v - row[prev]
+				if delta < 0 {
+					// The counter was reset.
+					delta = v
+				}
+				out[i] = delta / float32(int64(i-prev)*f.step)
+			}
+			prev = i
+		}
+		ret[key] = out
+	}
+	return ret, nil
+}
+
+func (f *rateFunc) Describe() string {
+	return `rate() returns the per-second rate of increase of counters.
+
+  A decrease is treated as the counter being reset to zero.`
+}
+
+// movingAvgFunc implements calc.Func and returns the trailing moving average
+// of rows over the given number of points.
+type movingAvgFunc struct{}
+
+func (movingAvgFunc) Eval(ctx *calc.Context, node *calc.Node) (calc.Rows, error) {
+	if len(node.Args) != 2 || node.Args[1].Typ != calc.NodeNum {
+		return nil, fmt.Errorf("moving_avg() takes a function and a number of points.")
+	}
+	n, err := strconv.Atoi(node.Args[1].Val)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("moving_avg() number of points must be a positive integer, got %s", node.Args[1].Val)
+	}
+	rows, err := evalRowsArg(ctx, "moving_avg", node)
+	if err != nil {
+		return nil, err
+	}
+	ret := calc.Rows{}
+	for key, row := range rows {
+		out := make([]float32, len(row))
+		for i := range row {
+			start := i - n + 1
+			if start < 0 {
+				start = 0
+			}
+			out[i] = missing
+			if vals := nonMissing(row[start : i+1]); len(vals) > 0 {
+				out[i] = aggAvg(vals)
+			}
+		}
+		ret[key] = out
+	}
+	return ret, nil
+}
+
+func (movingAvgFunc) Describe() string {
+	return `moving_avg() returns the average of each value and the values before it.
+
+  The second argument is the number of values to average, for example:
+
+     moving_avg(filter("metric=cpu"), 5)`
+}
+
+// nonMissing returns the values which aren't vec32.MISSING_DATA_SENTINEL.
+func nonMissing(row []float32) []float32 {
+	ret := []float32{}
+	for _, v := range row {
+		if v != missing {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
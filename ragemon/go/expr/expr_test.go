@@ -0,0 +1,165 @@
+package expr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/go/paramtools"
+	"go.skia.org/infra/go/query"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/ragemon/go/store"
+	"go.skia.org/infra/ragemon/go/ts"
+)
+
+// memStore is a store.Store which holds timeseries in memory.
+type memStore struct {
+	set ts.TimeSeriesSet
+}
+
+func (m *memStore) Add([]store.Measurement) error {
+	return nil
+}
+
+func (m *memStore) Match(begin, end time.Time, q *query.Query, step time.Duration) ts.TimeSeriesSet {
+	ret := ts.TimeSeriesSet{}
+	for key, series := range m.set {
+		if !q.Matches(key) {
+			continue
+		}
+		points := series.PointsInRange(begin.Unix(), end.Unix())
+		if len(points) == 0 {
+			continue
+		}
+		ret[key] = ts.New(points[0])
+		for _, p := range points[1:] {
+			ret[key].Add(p)
+		}
+	}
+	return ret
+}
+
+func (m *memStore) ParamSet() paramtools.ParamSet {
+	return paramtools.ParamSet{}
+}
+
+func newSeries(points ...ts.Point) *ts.TimeSeries {
+	ret := ts.New(points[0])
+	for _, p := range points[1:] {
+		ret.Add(p)
+	}
+	return ret
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		set: ts.TimeSeriesSet{
+			",host=a,metric=req,": newSeries(ts.Point{Timestamp: 0, Value: 10}, ts.Point{Timestamp: 60, Value: 20}, ts.Point{Timestamp: 120, Value: 30}, ts.Point{Timestamp: 240, Value: 5}),
+			",host=b,metric=req,": newSeries(ts.Point{Timestamp: 0, Value: 1}, ts.Point{Timestamp: 60, Value: 2}, ts.Point{Timestamp: 180, Value: 4}, ts.Point{Timestamp: 190, Value: 6}),
+			",host=a,metric=cpu,": newSeries(ts.Point{Timestamp: 0, Value: 50}),
+		},
+	}
+}
+
+// values returns the values of the series, with missing values as
+// the missing sentinel.
+func values(t *testing.T, r *Result, key string) []float32 {
+	series, ok := r.Series[key]
+	assert.True(t, ok, "Missing series %q", key)
+	ret := make([]float32, len(series))
+	for i, v := range series {
+		ret[i] = missing
+		if v != nil {
+			ret[i] = *v
+		}
+	}
+	return ret
+}
+
+func eval(t *testing.T, exp string) *Result {
+	r, err := Eval(newMemStore(), exp, time.Unix(0, 0), time.Unix(300, 0), time.Minute)
+	assert.NoError(t, err)
+	return r
+}
+
+func TestEvalFilter(t *testing.T) {
+	testutils.SmallTest(t)
+	r := eval(t, `filter("metric=req")`)
+	assert.Equal(t, []int64{0, 60, 120, 180, 240}, r.Timestamps)
+	assert.Equal(t, 2, len(r.Series))
+	assert.Equal(t, []float32{10, 20, 30, missing, 5}, values(t, r, ",host=a,metric=req,"))
+	// Points in the same interval are averaged.
+	assert.Equal(t, []float32{1, 2, missing, 5, missing}, values(t, r, ",host=b,metric=req,"))
+	assert.Nil(t, r.Series[",host=b,metric=req,"][2])
+}
+
+func TestEvalAggregates(t *testing.T) {
+	testutils.SmallTest(t)
+	r := eval(t, `sum(filter("metric=req"))`)
+	assert.Equal(t, 1, len(r.Series))
+	assert.Equal(t, []float32{11, 22, 30, 5, 5}, values(t, r, `sum(filter("metric=req"))`))
+
+	r = eval(t, `sum(filter(""), "host")`)
+	assert.Equal(t, 2, len(r.Series))
+	assert.Equal(t, []float32{60, 20, 30, missing, 5}, values(t, r, ",host=a,"))
+	assert.Equal(t, []float32{1, 2, missing, 5, missing}, values(t, r, ",host=b,"))
+
+	r = eval(t, `max(filter(""), "metric")`)
+	assert.Equal(t, []float32{50, missing, missing, missing, missing}, values(t, r, ",metric=cpu,"))
+	assert.Equal(t, []float32{10, 20, 30, 5, 5}, values(t, r, ",metric=req,"))
+
+	r = eval(t, `min(filter("metric=req"), "metric", "host")`)
+	assert.Equal(t, 2, len(r.Series))
+	assert.Equal(t, []float32{1, 2, missing, 5, missing}, values(t, r, ",host=b,metric=req,"))
+
+	r = eval(t, `avg(filter(""), "nosuchparam")`)
+	assert.Equal(t, []float32{(10 + 1 + 50) / 3.0, 11, 30, 5, 5}, values(t, r, ","))
+}
+
+func TestEvalRate(t *testing.T) {
+	testutils.SmallTest(t)
+	r := eval(t, `rate(filter("host=a&metric=req"))`)
+	v := values(t, r, ",host=a,metric=req,")
+	assert.Equal(t, missing, v[0])
+	assert.InDelta(t, 10.0/60, v[1], 0.0001)
+	assert.InDelta(t, 10.0/60, v[2], 0.0001)
+	assert.Equal(t, missing, v[3])
+	// The counter was reset, and the previous value was two intervals ago.
+	assert.InDelta(t, 5.0/120, v[4], 0.0001)
+
+	// Rates can be grouped.
+	r = eval(t, `sum(rate(filter("metric=req")), "metric")`)
+	assert.InDelta(t, 10.0/60+1.0/60, values(t, r, ",metric=req,")[1], 0.0001)
+}
+
+func TestEvalMovingAvg(t *testing.T) {
+	testutils.SmallTest(t)
+	r := eval(t, `moving_avg(filter("host=b"), 2)`)
+	assert.Equal(t, []float32{1, 1.5, 2, 5, 5}, values(t, r, ",host=b,metric=req,"))
+}
+
+func TestEvalErrors(t *testing.T) {
+	testutils.SmallTest(t)
+	st := newMemStore()
+	begin := time.Unix(0, 0)
+	end := time.Unix(300, 0)
+	for _, exp := range []string{
+		`moving_avg(filter(""), 0)`,
+		`moving_avg(filter(""))`,
+		`sum(filter(""), 3)`,
+		`sum("metric=req")`,
+		`rate(filter(""), 2)`,
+		`nosuchfunc(filter(""))`,
+		`sum(norm(filter("")), "host")`,
+		`filter("%zz")`,
+	} {
+		_, err := Eval(st, exp, begin, end, time.Minute)
+		assert.Error(t, err, exp)
+	}
+	_, err := Eval(st, `filter("")`, begin, end, 0)
+	assert.Error(t, err)
+	_, err = Eval(st, `filter("")`, end, begin, time.Minute)
+	assert.Error(t, err)
+	_, err = Eval(st, `filter("")`, begin, begin.Add(24*time.Hour), time.Second)
+	assert.Error(t, err)
+}
@@ -1,23 +1,36 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/skia-dev/glog"
 	"go.skia.org/infra/go/common"
 	"go.skia.org/infra/go/httputils"
 	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/ragemon/go/expr"
 	"go.skia.org/infra/ragemon/go/parser"
 	"go.skia.org/infra/ragemon/go/store"
 )
 
 var (
-	port     = flag.String("port", ":8000", "HTTP service address (e.g., ':8000')")
-	storeDir = flag.String("store_dir", "/tmp/store", "The directory to store data in.")
+	port            = flag.String("port", ":8000", "HTTP service address (e.g., ':8000')")
+	storeDir        = flag.String("store_dir", "/tmp/store", "The directory to store data in.")
+	retentionConfig = flag.String("retention_config", "", "JSON file containing a list of retention rules, each with a \"query\" selecting keys and a \"keep\" map from resolution (raw, 1m, 1h) to duration. Keys which match no rule are kept forever, as are all keys if this is not set.")
+)
+
+const (
+	// DEFAULT_QUERY_RANGE is the range queried if no begin time is given.
+	DEFAULT_QUERY_RANGE = time.Hour
+
+	// DEFAULT_QUERY_STEP is the step used if none is given.
+	DEFAULT_QUERY_STEP = time.Minute
 )
 
 var (
@@ -45,16 +58,88 @@ func postHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// parseTime parses the given form value as seconds since the Unix epoch,
+// returning 'def' if the value is empty.
+func parseTime(r *http.Request, name string, def time.Time) (time.Time, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid value for %s: %q", name, v)
+	}
+	return time.Unix(i, 0), nil
+}
+
+// queryHandler evaluates the expression in the "expr" parameter and returns
+// the resulting timeseries as JSON. The optional "begin" and "end" parameters
+// are in seconds since the Unix epoch, and "step" is a duration, e.g. "5m".
+func queryHandler(w http.ResponseWriter, r *http.Request) {
+	exp := r.FormValue("expr")
+	if exp == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Missing expr."), "The expr parameter is required.")
+		return
+	}
+	end, err := parseTime(r, "end", time.Now())
+	if err != nil {
+		httputils.ReportError(w, r, err, err.Error())
+		return
+	}
+	begin, err := parseTime(r, "begin", end.Add(-DEFAULT_QUERY_RANGE))
+	if err != nil {
+		httputils.ReportError(w, r, err, err.Error())
+		return
+	}
+	step := DEFAULT_QUERY_STEP
+	if v := r.FormValue("step"); v != "" {
+		if step, err = time.ParseDuration(v); err != nil {
+			httputils.ReportError(w, r, err, fmt.Sprintf("Invalid value for step: %q", v))
+			return
+		}
+	}
+	res, err := expr.Eval(st, exp, begin, end, step)
+	if err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to evaluate %q: %s", exp, err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		httputils.ReportError(w, r, err, "Failed to encode response.")
+	}
+}
+
+// readRetention reads the retention rules from the given JSON file.
+func readRetention(filename string) ([]*store.Retention, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read retention config: %s", err)
+	}
+	rv := []*store.Retention{}
+	if err := json.Unmarshal(b, &rv); err != nil {
+		return nil, fmt.Errorf("Failed to parse retention config: %s", err)
+	}
+	return rv, nil
+}
+
 func main() {
 	defer common.LogPanic()
 	common.Init()
 	var err error
-	st, err = store.New(*storeDir)
+	retention := []*store.Retention{}
+	if *retentionConfig != "" {
+		retention, err = readRetention(*retentionConfig)
+		if err != nil {
+			glog.Fatal(err)
+		}
+	}
+	st, err = store.New(*storeDir, retention)
 	if err != nil {
 		glog.Fatalf("Failed to create Store: %s", err)
 	}
 	r := mux.NewRouter()
 	r.HandleFunc("/new", postHandler)
+	r.HandleFunc("/query", queryHandler)
 	http.Handle("/", httputils.LoggingGzipRequestResponse(r))
 	glog.Infoln("Ready to serve.")
 	glog.Fatal(http.ListenAndServe(*port, nil))
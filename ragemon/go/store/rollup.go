@@ -0,0 +1,461 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/query"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/ragemon/go/ts"
+)
+
+const (
+	// COMPACTION_PERIOD controls how frequently rollups are computed and
+	// retention is applied.
+	COMPACTION_PERIOD = time.Hour
+
+	// ROLLUP_BUCKET_NAME is the name of the BoltDB bucket that records that a
+	// raw tile has been rolled up.
+	ROLLUP_BUCKET_NAME = "rollups"
+
+	// ROLLUP_DONE is the key in ROLLUP_BUCKET_NAME which is set once a raw tile
+	// has been rolled up.
+	ROLLUP_DONE = "done"
+)
+
+// Resolution describes a set of tiles which hold points at a given spacing.
+type Resolution struct {
+	// Name is used in tile filenames and in Retention.Keep.
+	Name string
+
+	// Step is the spacing of the points in seconds, or 0 for raw points.
+	Step int64
+
+	// TileSize is the duration that each tile covers, in seconds. Must be a
+	// multiple of TILE_SIZE_IN_SECONDS.
+	TileSize int64
+}
+
+var (
+	// RAW holds the points as they were added.
+	RAW = &Resolution{Name: "raw", Step: 0, TileSize: TILE_SIZE_IN_SECONDS}
+
+	// ROLLUPS are the resolutions computed from the raw tiles in the
+	// background, from finest to coarsest. Each point is the mean of the raw
+	// points in its interval.
+	ROLLUPS = []*Resolution{
+		&Resolution{Name: "1m", Step: 60, TileSize: 24 * 60 * 60},
+		&Resolution{Name: "1h", Step: 60 * 60, TileSize: 30 * 24 * 60 * 60},
+	}
+
+	// tileNameRe matches tile filenames, which are the tile index prefixed by
+	// the Resolution name for rollups.
+	tileNameRe = regexp.MustCompile(`^(?:([0-9a-z]+)-)?([0-9]+)\.db$`)
+)
+
+// resolutionByName returns the Resolution with the given name, or nil.
+func resolutionByName(name string) *Resolution {
+	if name == RAW.Name {
+		return RAW
+	}
+	for _, r := range ROLLUPS {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// tileName returns the BoltDB filename for the given tile index.
+func (r *Resolution) tileName(index int64) string {
+	if r == RAW {
+		return boltNameFromIndex(index)
+	}
+	return fmt.Sprintf("%s-%06d.db", r.Name, index)
+}
+
+// Retention controls how long the points of matching keys are kept at each
+// Resolution.
+type Retention struct {
+	// Query selects the keys the Retention applies to, in URL query format,
+	// e.g. "metric=cpu". An empty Query matches all keys.
+	Query string `json:"query"`
+
+	// Keep maps Resolution names to how long points at that resolution are
+	// kept, e.g. "168h". Resolutions which aren't listed are kept forever.
+	Keep map[string]string `json:"keep"`
+
+	q    *query.Query
+	keep map[string]time.Duration
+}
+
+// init parses and validates the Retention.
+func (r *Retention) init() error {
+	values, err := url.ParseQuery(r.Query)
+	if err != nil {
+		return fmt.Errorf("Invalid retention query %q: %s", r.Query, err)
+	}
+	r.q, err = query.New(values)
+	if err != nil {
+		return fmt.Errorf("Invalid retention query %q: %s", r.Query, err)
+	}
+	r.keep = map[string]time.Duration{}
+	for name, s := range r.Keep {
+		if resolutionByName(name) == nil {
+			return fmt.Errorf("Unknown resolution %q in retention for %q", name, r.Query)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("Invalid retention %q for %q: %s", s, r.Query, err)
+		}
+		r.keep[name] = d
+	}
+	return nil
+}
+
+// retentionFor returns the first of the Retentions which matches the key, or
+// nil if none match.
+func (s *StoreImpl) retentionFor(key string) *Retention {
+	for _, r := range s.retention {
+		if r.q.Matches(key) {
+			return r
+		}
+	}
+	return nil
+}
+
+// expired returns true if the points of 'key' in the tile of Resolution 'r'
+// which ends at 'tileEnd' are past their retention. Keys without a Retention
+// are kept forever.
+func (s *StoreImpl) expired(r *Resolution, key string, tileEnd int64, now time.Time) bool {
+	ret := s.retentionFor(key)
+	if ret == nil {
+		return false
+	}
+	keep, ok := ret.keep[r.Name]
+	return ok && tileEnd < now.Add(-keep).Unix()
+}
+
+// resolutionForStep returns the coarsest Resolution whose points are no more
+// widely spaced than the given step.
+func resolutionForStep(step time.Duration) *Resolution {
+	ret := RAW
+	for _, r := range ROLLUPS {
+		if r.Step <= int64(step/time.Second) {
+			ret = r
+		}
+	}
+	return ret
+}
+
+// tileFile is a tile found on disk.
+type tileFile struct {
+	res   *Resolution
+	index int64
+}
+
+// tileFiles returns the tiles in the store directory, sorted by Resolution
+// and then index.
+func (s *StoreImpl) tileFiles() ([]tileFile, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list tiles: %s", err)
+	}
+	ret := []tileFile{}
+	for _, info := range infos {
+		m := tileNameRe.FindStringSubmatch(info.Name())
+		if m == nil {
+			continue
+		}
+		res := RAW
+		if m[1] != "" {
+			if res = resolutionByName(m[1]); res == nil {
+				continue
+			}
+		}
+		index, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, tileFile{res: res, index: index})
+	}
+	sort.Sort(tileFileSlice(ret))
+	return ret, nil
+}
+
+// tileFileSlice is a helper for sorting tileFiles.
+type tileFileSlice []tileFile
+
+func (p tileFileSlice) Len() int { return len(p) }
+func (p tileFileSlice) Less(i, j int) bool {
+	if p[i].res.TileSize != p[j].res.TileSize {
+		return p[i].res.TileSize < p[j].res.TileSize
+	}
+	return p[i].index < p[j].index
+}
+func (p tileFileSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// isRolledUp returns true if the raw tile has been rolled up.
+func isRolledUp(db *bolt.DB) (bool, error) {
+	ret := false
+	err := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(ROLLUP_BUCKET_NAME)); b != nil {
+			ret = b.Get([]byte(ROLLUP_DONE)) != nil
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// rollup computes the rollups of the closed raw tile at 'index' into the
+// rollup tiles, then marks the raw tile as rolled up. Rolling up a tile again
+// is harmless, since points in the rollup tiles are replaced.
+//
+// s.mutex must be held.
+func (s *StoreImpl) rollup(index int64) error {
+	db, err := s.getBoltDB(index, false)
+	if err != nil {
+		return err
+	}
+	raw := tileInfoFromBolt(db).set
+	for _, r := range ROLLUPS {
+		// Raw tiles never span rollup tiles.
+		rdb, err := s.getTileDB(r.tileName(index*TILE_SIZE_IN_SECONDS/r.TileSize), false)
+		if err != nil {
+			return err
+		}
+		add := func(tx *bolt.Tx) error {
+			m, err := tx.CreateBucketIfNotExists([]byte(BUCKET_NAME))
+			if m == nil {
+				return fmt.Errorf("Failed to get bucket %q: %s", BUCKET_NAME, err)
+			}
+			for key, series := range raw {
+				var existing *ts.TimeSeries
+				if b := m.Get([]byte(key)); b != nil {
+					if existing, err = ts.NewFromData(b); err != nil {
+						glog.Errorf("Replacing corrupt rollup %s for key=%q: %s", r.Name, key, err)
+						existing = nil
+					}
+				}
+				merged := ts.Merge(existing, ts.Downsample(series.Points(), r.Step))
+				if merged == nil {
+					continue
+				}
+				b, err := merged.Bytes()
+				if err != nil {
+					return fmt.Errorf("Failed to convert rollup %s to bytes for key=%q: %s", r.Name, key, err)
+				}
+				if err := m.Put([]byte(key), b); err != nil {
+					return fmt.Errorf("Failed writing rollup %s for key=%q: %s", r.Name, key, err)
+				}
+			}
+			return nil
+		}
+		if err := rdb.Update(add); err != nil {
+			return fmt.Errorf("Failed to roll up tile %d into %s: %s", index, r.Name, err)
+		}
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(ROLLUP_BUCKET_NAME))
+		if b == nil {
+			return fmt.Errorf("Failed to get bucket %q: %s", ROLLUP_BUCKET_NAME, err)
+		}
+		return b.Put([]byte(ROLLUP_DONE), []byte{1})
+	})
+}
+
+// compactTile drops the keys of the tile which are past their retention. If
+// any keys were dropped the tile is rewritten, since BoltDB never shrinks its
+// files, and if no keys remain the tile is removed.
+//
+// s.mutex must be held.
+func (s *StoreImpl) compactTile(f tileFile, now time.Time) error {
+	name := f.res.tileName(f.index)
+	tileEnd := (f.index + 1) * f.res.TileSize
+	db, err := s.getTileDB(name, false)
+	if err != nil {
+		return err
+	}
+	drop := map[string]bool{}
+	total := 0
+	if err := db.View(func(tx *bolt.Tx) error {
+		m := tx.Bucket([]byte(BUCKET_NAME))
+		if m == nil {
+			return nil
+		}
+		return m.ForEach(func(k, v []byte) error {
+			total++
+			if s.expired(f.res, string(k), tileEnd, now) {
+				drop[string(k)] = true
+			}
+			return nil
+		})
+	}); err != nil {
+		return fmt.Errorf("Failed to read tile %s: %s", name, err)
+	}
+	if len(drop) == 0 {
+		return nil
+	}
+
+	// Close the tile before replacing it. The lru cache closes it on removal.
+	s.cache.Remove(name)
+	filename := filepath.Join(s.dir, name)
+	if len(drop) == total {
+		glog.Infof("Removing expired tile %s", name)
+		return os.Remove(filename)
+	}
+	glog.Infof("Compacting tile %s, dropping %d of %d keys.", name, len(drop), total)
+	src, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return fmt.Errorf("Unable to open tile %s: %s", name, err)
+	}
+	defer util.Close(src)
+	tmpName := filename + ".tmp"
+	dst, err := bolt.Open(tmpName, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return fmt.Errorf("Unable to create compacted tile %s: %s", tmpName, err)
+	}
+	copyKeys := func(tx *bolt.Tx) error {
+		return src.View(func(srcTx *bolt.Tx) error {
+			return srcTx.ForEach(func(bucket []byte, b *bolt.Bucket) error {
+				out, err := tx.CreateBucketIfNotExists(bucket)
+				if out == nil {
+					return fmt.Errorf("Failed to create bucket %q: %s", string(bucket), err)
+				}
+				return b.ForEach(func(k, v []byte) error {
+					if string(bucket) == BUCKET_NAME && drop[string(k)] {
+						return nil
+					}
+					return out.Put(dup(k), dup(v))
+				})
+			})
+		})
+	}
+	if err := dst.Update(copyKeys); err != nil {
+		util.Close(dst)
+		util.RemoveAll(tmpName)
+		return fmt.Errorf("Failed to compact tile %s: %s", name, err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("Failed to close compacted tile %s: %s", tmpName, err)
+	}
+	return os.Rename(tmpName, filename)
+}
+
+// compact does a single compaction pass. Closed raw tiles, i.e. those which
+// have been dropped from s.tiles and so no longer accept points, are rolled up
+// if they haven't been already, and then points past their retention are
+// dropped from all closed tiles.
+func (s *StoreImpl) compact(now time.Time) []error {
+	ret := []error{}
+	files, err := s.tileFiles()
+	if err != nil {
+		return append(ret, err)
+	}
+
+	for _, f := range files {
+		if f.res != RAW {
+			continue
+		}
+		if err := s.rollupIfNeeded(f.index); err != nil {
+			ret = append(ret, err)
+			glog.Errorf("Failed to roll up tile %d: %s", f.index, err)
+		}
+	}
+
+	for _, f := range files {
+		if err := s.compactTileWithLock(f, now); err != nil {
+			ret = append(ret, err)
+			glog.Errorf("Failed to compact tile %s: %s", f.res.tileName(f.index), err)
+		}
+	}
+
+	if err := s.updateRolledUp(now); err != nil {
+		ret = append(ret, err)
+	}
+	return ret
+}
+
+// rollupIfNeeded rolls up the raw tile at 'index' if it's closed and hasn't
+// been rolled up already.
+func (s *StoreImpl) rollupIfNeeded(index int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.tiles[index]; ok {
+		return nil
+	}
+	db, err := s.getBoltDB(index, false)
+	if err != nil {
+		return err
+	}
+	done, err := isRolledUp(db)
+	if err != nil || done {
+		return err
+	}
+	return s.rollup(index)
+}
+
+// compactTileWithLock calls compactTile while holding s.mutex, unless the tile
+// is a raw tile which is still open.
+func (s *StoreImpl) compactTileWithLock(f tileFile, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.tiles[f.index]; ok && f.res == RAW {
+		return nil
+	}
+	return s.compactTile(f, now)
+}
+
+// updateRolledUp finds the newest closed raw tile for which it and all older
+// raw tiles are rolled up, and stores it in s.rolledUpThrough.
+func (s *StoreImpl) updateRolledUp(now time.Time) error {
+	files, err := s.tileFiles()
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	through := timeToIndex(now) - 2
+	for i, _ := range s.tiles {
+		if i-1 < through {
+			through = i - 1
+		}
+	}
+	for _, f := range files {
+		if f.res != RAW || f.index > through {
+			continue
+		}
+		db, err := s.getBoltDB(f.index, false)
+		if err != nil {
+			return err
+		}
+		done, err := isRolledUp(db)
+		if err != nil {
+			return fmt.Errorf("Failed to read rollup state of tile %d: %s", f.index, err)
+		}
+		if !done {
+			through = f.index - 1
+			break
+		}
+	}
+	s.rolledUpThrough = through
+	return nil
+}
+
+// compactionLoop runs in the background and periodically calls compact.
+func (s *StoreImpl) compactionLoop() {
+	for _ = range time.Tick(COMPACTION_PERIOD) {
+		if errors := s.compact(time.Now()); len(errors) != 0 {
+			glog.Errorf("Errors occured while compacting: %v", errors)
+		}
+	}
+}
@@ -60,8 +60,10 @@ type Store interface {
 	Add([]Measurement) error
 
 	// Returns the timeseries values between [begin, end) that match the given
-	// query.
-	Match(begin, end time.Time, q *query.Query) ts.TimeSeriesSet
+	// query. If step is non-zero then the points may be downsampled to the
+	// coarsest available resolution which is no coarser than step, in which
+	// case each point is the mean of the points in its interval.
+	Match(begin, end time.Time, q *query.Query, step time.Duration) ts.TimeSeriesSet
 
 	// Returns the calculated ParamSet for the tiles in memory.
 	ParamSet() paramtools.ParamSet
@@ -91,6 +93,10 @@ func newTileInfo() *TileInfo {
 // is broken up into 2 hour long tiles, with each BoltDB filename, and
 // each key in 'tiles', being the index number of one of those tiles.
 //
+// Tiles which have been dropped from 'tiles', and so are complete on disk, are
+// rolled up in the background into coarser tiles for each of
+// the ROLLUPS, and points are dropped once they are past their Retention.
+//
 type StoreImpl struct {
 	// mutex protects access to tiles, paramSet, and cache.
 	mutex sync.Mutex
@@ -106,6 +112,13 @@ type StoreImpl struct {
 
 	// The dir that tiles are written into.
 	dir string
+
+	// retention is searched in order for the first Retention matching a key.
+	retention []*Retention
+
+	// rolledUpThrough is the index of the newest raw tile for which it and
+	// all older raw tiles have been rolled up.
+	rolledUpThrough int64
 }
 
 // boltNameFromIndex returns the BoltDB filename for the given tile index.
@@ -120,19 +133,24 @@ func getBoltDBNoCache(dir string, index int64) (*bolt.DB, error) {
 
 // getBoltDB returns a new/existing bolt.DB. Already opened db's are cached.
 func (s *StoreImpl) getBoltDB(index int64, getLock bool) (*bolt.DB, error) {
+	return s.getTileDB(boltNameFromIndex(index), getLock)
+}
+
+// getTileDB returns a new/existing bolt.DB for the tile with the given
+// filename. Already opened db's are cached.
+func (s *StoreImpl) getTileDB(name string, getLock bool) (*bolt.DB, error) {
 	if getLock {
 		s.mutex.Lock()
 		defer s.mutex.Unlock()
 	}
-	name := boltNameFromIndex(index)
 	if idb, ok := s.cache.Get(name); ok {
 		if db, ok := idb.(*bolt.DB); ok {
 			return db, nil
 		}
 	}
-	db, err := getBoltDBNoCache(s.dir, index)
+	db, err := bolt.Open(filepath.Join(s.dir, name), 0600, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
-		return nil, fmt.Errorf("Unable to open boltdb %d: %s", index, err)
+		return nil, fmt.Errorf("Unable to open boltdb %s: %s", name, err)
 	}
 	s.cache.Add(name, db)
 	return db, nil
@@ -205,17 +223,7 @@ func (s *StoreImpl) oneStep(now time.Time) []error {
 				s.mutex.Lock()
 				defer s.mutex.Unlock()
 
-				t := s.tiles[i]
-				for _, key := range batch {
-					b, err := t.set[key].Bytes()
-					if err != nil {
-						return fmt.Errorf("Failed to convert to bytes while writing in the background for key=%q: %s", key, err)
-					}
-					if err := m.Put([]byte(key), b); err != nil {
-						return fmt.Errorf("Failed writing in the background for key=%q: %s", key, err)
-					}
-				}
-				return nil
+				return putKeys(m, s.tiles[i], batch)
 			}
 
 			if err := db.Update(add); err != nil {
@@ -234,8 +242,15 @@ func (s *StoreImpl) oneStep(now time.Time) []error {
 			s.tiles[i] = newTileInfo()
 		}
 	}
-	for k, _ := range s.tiles {
+	for k, t := range s.tiles {
 		if k < currentTileIndex-1 || k > currentTileIndex+1 {
+			// Points may have arrived since the tile was written above, and
+			// once it's dropped the tile may be rolled up, so write them now.
+			if err := s.flushTile(k, t); err != nil {
+				ret = append(ret, err)
+				glog.Errorf("Failed to save tile %d, keeping it in memory: %s", k, err)
+				continue
+			}
 			delete(s.tiles, k)
 		}
 	}
@@ -244,6 +259,49 @@ func (s *StoreImpl) oneStep(now time.Time) []error {
 	return ret
 }
 
+// putKeys writes the timeseries of the given keys in the tile into the bucket.
+func putKeys(m *bolt.Bucket, t *TileInfo, keys []string) error {
+	for _, key := range keys {
+		b, err := t.set[key].Bytes()
+		if err != nil {
+			return fmt.Errorf("Failed to convert to bytes while writing in the background for key=%q: %s", key, err)
+		}
+		if err := m.Put([]byte(key), b); err != nil {
+			return fmt.Errorf("Failed writing in the background for key=%q: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// flushTile writes all the updated keys of the tile at 'index' to disk.
+//
+// s.mutex must be held.
+func (s *StoreImpl) flushTile(index int64, t *TileInfo) error {
+	if len(t.updatedKeys) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(t.updatedKeys))
+	for key, _ := range t.updatedKeys {
+		keys = append(keys, key)
+	}
+	db, err := s.getBoltDB(index, false)
+	if err != nil {
+		return err
+	}
+	add := func(tx *bolt.Tx) error {
+		m, err := tx.CreateBucketIfNotExists([]byte(BUCKET_NAME))
+		if m == nil {
+			return fmt.Errorf("Failed to get bucket %q: %s", BUCKET_NAME, err)
+		}
+		return putKeys(m, t, keys)
+	}
+	if err := db.Update(add); err != nil {
+		return fmt.Errorf("Failed to save tile %d: %s", index, err)
+	}
+	t.updatedKeys = map[string]bool{}
+	return nil
+}
+
 // background runs in the background and periodically flushes tiles to disk.
 func (s *StoreImpl) background() {
 	for _ = range time.Tick(15 * time.Minute) {
@@ -283,8 +341,18 @@ func tileInfoFromBolt(db *bolt.DB) *TileInfo {
 }
 
 // New returns a *StoreImpl that reads/writes BoltBD files stored
-// in the given directory 'dir'.
-func New(dir string) (*StoreImpl, error) {
+// in the given directory 'dir'. Points are kept according to the first of the
+// given Retentions which matches their key, and are never dropped if none
+// match.
+//
+// The store must not be opened by more than one process at a time.
+func New(dir string, retention []*Retention) (*StoreImpl, error) {
+	for _, r := range retention {
+		if err := r.init(); err != nil {
+			return nil, err
+		}
+	}
+
 	cache, err := lru.NewWithEvict(MAX_CACHED_TILES, closer)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't create boltdb cache: %s", err)
@@ -309,13 +377,18 @@ func New(dir string) (*StoreImpl, error) {
 	}
 
 	impl := &StoreImpl{
-		dir:      dir,
-		cache:    cache,
-		tiles:    tiles,
-		paramSet: paramSet,
+		dir:       dir,
+		cache:     cache,
+		tiles:     tiles,
+		paramSet:  paramSet,
+		retention: retention,
+	}
+	if err := impl.updateRolledUp(time.Now()); err != nil {
+		return nil, fmt.Errorf("Failed to find rolled up tiles: %s", err)
 	}
 
 	go impl.background()
+	go impl.compactionLoop()
 
 	return impl, nil
 }
@@ -393,24 +466,75 @@ func addMatchesToTimeSeriesSet(tss ts.TimeSeriesSet, key string, matches []ts.Po
 
 // Match
 //
+// Points are read from the coarsest Resolution whose Step is no larger than
+// 'step'. Raw tiles which haven't been rolled up yet are downsampled as they
+// are read.
+//
 // TODO(jcgregorio) Keep a cache of recent queries and the keys that matched them
 //  to avoid doing full scans for each call to Match.
-func (s *StoreImpl) Match(begin, end time.Time, q *query.Query) ts.TimeSeriesSet {
+func (s *StoreImpl) Match(begin, end time.Time, q *query.Query, step time.Duration) ts.TimeSeriesSet {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	ret := ts.TimeSeriesSet{}
+	res := resolutionForStep(step)
+	if res == RAW {
+		s.matchTiles(ret, RAW, begin.Unix(), end.Unix(), q)
+		return ret
+	}
+
+	rolledUpEnd := (s.rolledUpThrough + 1) * TILE_SIZE_IN_SECONDS
+	if begin.Unix() < rolledUpEnd {
+		s.matchTiles(ret, res, begin.Unix(), min64(end.Unix(), rolledUpEnd), q)
+	}
+	if end.Unix() > rolledUpEnd {
+		raw := ts.TimeSeriesSet{}
+		s.matchTiles(raw, RAW, max64(begin.Unix(), rolledUpEnd), end.Unix(), q)
+		for key, series := range raw {
+			matches := []ts.Point{}
+			for _, p := range ts.Downsample(series.Points(), res.Step) {
+				// Drop the partial interval before 'begin', as the rollup
+				// tiles would.
+				if p.Timestamp >= begin.Unix() {
+					matches = append(matches, p)
+				}
+			}
+			addMatchesToTimeSeriesSet(ret, key, matches)
+		}
+	}
+	return ret
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// matchTiles adds the points between [begin, end) of the timeseries that match
+// the given query in the tiles of the given Resolution to 'ret'.
+//
+// s.mutex must be held.
+func (s *StoreImpl) matchTiles(ret ts.TimeSeriesSet, res *Resolution, begin, end int64, q *query.Query) {
 	// Need to search through both BoltDBs and TimeseriesSets.
-	for i := timeToIndex(begin); i <= timeToIndex(end); i++ {
-		if tileInfo, ok := s.tiles[i]; ok {
+	for i := begin / res.TileSize; i <= end/res.TileSize; i++ {
+		if tileInfo, ok := s.tiles[i]; ok && res == RAW {
 			for key, value := range tileInfo.set {
 				if q.Matches(key) {
-					matches := value.PointsInRange(begin.Unix(), end.Unix())
+					matches := value.PointsInRange(begin, end)
 					addMatchesToTimeSeriesSet(ret, key, matches)
 				}
 			}
 		} else {
-			db, err := s.getBoltDB(i, false)
+			db, err := s.getTileDB(res.tileName(i), false)
 			if err != nil {
 				glog.Errorf("Failed to open BoltDB: %s", err)
 				continue
@@ -429,7 +553,7 @@ func (s *StoreImpl) Match(begin, end time.Time, q *query.Query) ts.TimeSeriesSet
 					}
 					// Don't make the copy until we know we are going to need it.
 					key := string(dup(bkey))
-					matches, err := ts.PointsInRange(begin.Unix(), end.Unix(), rawValue)
+					matches, err := ts.PointsInRange(begin, end, rawValue)
 					if err != nil {
 						glog.Errorf("Failed to load matched points %q: %s", key, err)
 					} else {
@@ -444,7 +568,6 @@ func (s *StoreImpl) Match(begin, end time.Time, q *query.Query) ts.TimeSeriesSet
 			}
 		}
 	}
-	return ret
 }
 
 func (s *StoreImpl) ParamSet() paramtools.ParamSet {
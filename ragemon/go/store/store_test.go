@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -35,14 +36,14 @@ func cleanup() {
 // queries runs a series of queries against the given store.
 func queries(t *testing.T, now time.Time, m []Measurement, st *StoreImpl) {
 	// Search in a narrow window that only gets 2 of the 3 points.
-	matches := st.Match(now.Add(-time.Second), now.Add(time.Second), &query.Query{})
+	matches := st.Match(now.Add(-time.Second), now.Add(time.Second), &query.Query{}, 0)
 	assert.Equal(t, 2, len(matches))
 	assert.Equal(t, []ts.Point{m[1].Point}, matches[",host=foo,metric=cpu,"].Points())
 	// m is sorted when added so value=102 is actually in position 2.
 	assert.Equal(t, []ts.Point{m[2].Point}, matches[",host=bar,metric=cpu,"].Points())
 
 	// Widen the window to get all 3 points.
-	matches = st.Match(now.Add(-time.Second), now.Add(2*time.Minute), &query.Query{})
+	matches = st.Match(now.Add(-time.Second), now.Add(2*time.Minute), &query.Query{}, 0)
 	assert.Equal(t, 2, len(matches))
 	assert.Equal(t, []ts.Point{m[1].Point}, matches[",host=foo,metric=cpu,"].Points())
 	assert.Equal(t, []ts.Point{m[2].Point, m[3].Point}, matches[",host=bar,metric=cpu,"].Points())
@@ -50,17 +51,17 @@ func queries(t *testing.T, now time.Time, m []Measurement, st *StoreImpl) {
 	// Narrow the query to get just 1 timeseries.
 	q, err := query.New(url.Values{"host": []string{"bar"}})
 	assert.NoError(t, err)
-	matches = st.Match(now.Add(-time.Second), now.Add(2*time.Minute), q)
+	matches = st.Match(now.Add(-time.Second), now.Add(2*time.Minute), q, 0)
 	assert.Equal(t, 1, len(matches))
 	assert.Equal(t, []ts.Point{m[2].Point, m[3].Point}, matches[",host=bar,metric=cpu,"].Points())
 
 	// Narrow the query to get 0 timeseries.
 	q, err = query.New(url.Values{"host": []string{"quux"}})
-	matches = st.Match(now.Add(-time.Second), now.Add(2*time.Minute), q)
+	matches = st.Match(now.Add(-time.Second), now.Add(2*time.Minute), q, 0)
 	assert.Equal(t, 0, len(matches))
 
 	// Narrow the time window to get 0 points.
-	matches = st.Match(now.Add(2*time.Minute), now.Add(3*time.Minute), &query.Query{})
+	matches = st.Match(now.Add(2*time.Minute), now.Add(3*time.Minute), &query.Query{}, 0)
 	assert.Equal(t, 0, len(matches))
 }
 
@@ -69,7 +70,7 @@ func TestNew(t *testing.T) {
 	setupStoreDir(t)
 	defer cleanup()
 
-	st, err := New(tmpDir)
+	st, err := New(tmpDir, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, 3, st.cache.Len())
 
@@ -139,7 +140,7 @@ func TestNew(t *testing.T) {
 
 	// Now purge the lru cache and create a new StoreImpl and re-run the queries.
 	st.cache.Purge()
-	st, err = New(tmpDir)
+	st, err = New(tmpDir, nil)
 	assert.NoError(t, err)
 	queries(t, now, m, st)
 
@@ -157,3 +158,145 @@ func TestNew(t *testing.T) {
 	assert.Error(t, err)
 
 }
+
+func TestRollupAndCompact(t *testing.T) {
+	testutils.MediumTest(t)
+	setupStoreDir(t)
+	defer cleanup()
+
+	st, err := New(tmpDir, []*Retention{
+		&Retention{
+			Query: "host=bar",
+			Keep:  map[string]string{"raw": "1h"},
+		},
+		&Retention{
+			Query: "",
+			Keep:  map[string]string{"raw": "168h"},
+		},
+	})
+	assert.NoError(t, err)
+
+	// Add points to the current tile, starting at its beginning.
+	index := timeToIndex(time.Now())
+	base := index * TILE_SIZE_IN_SECONDS
+	m := []Measurement{}
+	for _, key := range []string{",host=foo,metric=cpu,", ",host=bar,metric=cpu,"} {
+		for i, offset := range []int64{0, 30, 60, 3600} {
+			m = append(m, Measurement{
+				Key: key,
+				Point: ts.Point{
+					Timestamp: base + offset,
+					Value:     int64(2*i + 1),
+				},
+			})
+		}
+	}
+	assert.NoError(t, st.Add(m))
+
+	// Before the tile is rolled up, points are downsampled as they are read.
+	begin := time.Unix(base, 0)
+	end := begin.Add(2 * time.Hour)
+	minutes := []ts.Point{{Timestamp: base, Value: 2}, {Timestamp: base + 60, Value: 5}, {Timestamp: base + 3600, Value: 7}}
+	hours := []ts.Point{{Timestamp: base, Value: 3}, {Timestamp: base + 3600, Value: 7}}
+	matches := st.Match(begin, end, &query.Query{}, time.Minute)
+	assert.Equal(t, minutes, matches[",host=foo,metric=cpu,"].Points())
+	matches = st.Match(begin, end, &query.Query{}, 2*time.Hour)
+	assert.Equal(t, hours, matches[",host=foo,metric=cpu,"].Points())
+
+	// Jump ahead so the tile is closed, then roll it up.
+	later := begin.Add(6 * time.Hour)
+	assert.Equal(t, 0, len(st.oneStep(begin)))
+	assert.Equal(t, 0, len(st.oneStep(later)))
+	assert.Equal(t, 0, len(st.compact(later)))
+	assert.True(t, st.rolledUpThrough >= index)
+	_, err = os.Stat(filepath.Join(tmpDir, ROLLUPS[0].tileName(base/ROLLUPS[0].TileSize)))
+	assert.NoError(t, err)
+
+	// Raw points for bar are past their retention, but the rollups remain.
+	matches = st.Match(begin, end, &query.Query{}, 0)
+	assert.Equal(t, 1, len(matches))
+	assert.Equal(t, 4, len(matches[",host=foo,metric=cpu,"].Points()))
+	for _, key := range []string{",host=foo,metric=cpu,", ",host=bar,metric=cpu,"} {
+		matches = st.Match(begin, end, &query.Query{}, time.Minute)
+		assert.Equal(t, minutes, matches[key].Points())
+		matches = st.Match(begin, end, &query.Query{}, time.Hour)
+		assert.Equal(t, hours, matches[key].Points())
+	}
+
+	// Rolling up again doesn't change the rollups.
+	st.mutex.Lock()
+	assert.NoError(t, st.rollup(index))
+	st.mutex.Unlock()
+	matches = st.Match(begin, end, &query.Query{}, time.Minute)
+	assert.Equal(t, minutes, matches[",host=foo,metric=cpu,"].Points())
+
+	// Once all of its raw points are past their retention the tile is
+	// removed.
+	assert.Equal(t, 0, len(st.compact(begin.Add(200*time.Hour))))
+	_, err = os.Stat(filepath.Join(tmpDir, boltNameFromIndex(index)))
+	assert.True(t, os.IsNotExist(err))
+	matches = st.Match(begin, end, &query.Query{}, time.Hour)
+	assert.Equal(t, hours, matches[",host=bar,metric=cpu,"].Points())
+}
+
+func TestRollupWaitsForFlush(t *testing.T) {
+	testutils.MediumTest(t)
+	setupStoreDir(t)
+	defer cleanup()
+
+	st, err := New(tmpDir, nil)
+	assert.NoError(t, err)
+
+	index := timeToIndex(time.Now())
+	base := index * TILE_SIZE_IN_SECONDS
+	key := ",host=foo,metric=cpu,"
+	assert.NoError(t, st.Add([]Measurement{{Key: key, Point: ts.Point{Timestamp: base, Value: 1}}}))
+	assert.Equal(t, 0, len(st.oneStep(time.Unix(base+60, 0))))
+
+	// Past the end of the tile, but before the next flush, a late point
+	// arrives for it.
+	later := time.Unix(base+2*TILE_SIZE_IN_SECONDS+60, 0)
+	assert.NoError(t, st.Add([]Measurement{{Key: key, Point: ts.Point{Timestamp: base + 120, Value: 3}}}))
+
+	// The tile is still open, so it isn't rolled up yet.
+	assert.Equal(t, 0, len(st.compact(later)))
+	assert.True(t, st.rolledUpThrough < index)
+	begin := time.Unix(base, 0)
+	end := begin.Add(time.Hour)
+	minutes := []ts.Point{{Timestamp: base, Value: 1}, {Timestamp: base + 120, Value: 3}}
+	matches := st.Match(begin, end, &query.Query{}, time.Minute)
+	assert.Equal(t, minutes, matches[key].Points())
+
+	// The flush closes the tile, and then the rollup has both points.
+	assert.Equal(t, 0, len(st.oneStep(later)))
+	assert.Equal(t, 0, len(st.compact(later)))
+	assert.True(t, st.rolledUpThrough >= index)
+	matches = st.Match(begin, end, &query.Query{}, time.Minute)
+	assert.Equal(t, minutes, matches[key].Points())
+	matches = st.Match(begin, end, &query.Query{}, 0)
+	assert.Equal(t, 2, len(matches[key].Points()))
+
+	// Without any Retentions nothing is ever dropped.
+	assert.Equal(t, 0, len(st.compact(later.Add(100000*time.Hour))))
+	_, err = os.Stat(filepath.Join(tmpDir, boltNameFromIndex(index)))
+	assert.NoError(t, err)
+	matches = st.Match(begin, end, &query.Query{}, 0)
+	assert.Equal(t, 2, len(matches[key].Points()))
+}
+
+func TestResolutionForStep(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.Equal(t, RAW, resolutionForStep(0))
+	assert.Equal(t, RAW, resolutionForStep(30*time.Second))
+	assert.Equal(t, ROLLUPS[0], resolutionForStep(time.Minute))
+	assert.Equal(t, ROLLUPS[0], resolutionForStep(59*time.Minute))
+	assert.Equal(t, ROLLUPS[1], resolutionForStep(24*time.Hour))
+}
+
+func TestRetentionInvalid(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.Error(t, (&Retention{Keep: map[string]string{"1d": "1h"}}).init())
+	assert.Error(t, (&Retention{Keep: map[string]string{"raw": "forever"}}).init())
+	assert.Error(t, (&Retention{Query: "%zz"}).init())
+	assert.NoError(t, (&Retention{Query: "host=foo", Keep: map[string]string{"1h": "8760h"}}).init())
+}
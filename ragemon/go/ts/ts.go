@@ -212,3 +212,73 @@ func (t *TimeSeries) Bytes() ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// Downsample returns one Point for each interval of 'step' seconds which
+// contains any of the given points, which must be sorted by Timestamp. Each
+// interval starts at a multiple of 'step' since the Unix epoch, and the Point
+// for an interval has the interval start as its Timestamp and the mean of the
+// values in the interval as its Value.
+func Downsample(points []Point, step int64) []Point {
+	ret := []Point{}
+	if len(points) == 0 || step <= 0 {
+		return append(ret, points...)
+	}
+	bucket := floor(points[0].Timestamp, step)
+	sum := int64(0)
+	n := int64(0)
+	for _, p := range points {
+		if b := floor(p.Timestamp, step); b != bucket {
+			ret = append(ret, Point{Timestamp: bucket, Value: sum / n})
+			bucket = b
+			sum = 0
+			n = 0
+		}
+		sum += p.Value
+		n++
+	}
+	return append(ret, Point{Timestamp: bucket, Value: sum / n})
+}
+
+// floor returns the largest multiple of step which is <= t.
+func floor(t, step int64) int64 {
+	ret := t - t%step
+	if ret > t {
+		ret -= step
+	}
+	return ret
+}
+
+// Merge returns a TimeSeries with the points of 't' and 'points', sorted by
+// Timestamp. Where both have a point with the same Timestamp, the point from
+// 'points' is kept. Returns nil if there are no points at all.
+func Merge(t *TimeSeries, points []Point) *TimeSeries {
+	byTimestamp := map[int64]Point{}
+	if t != nil {
+		t.lock.Lock()
+		for _, p := range t.data {
+			byTimestamp[p.Timestamp] = p
+		}
+		t.lock.Unlock()
+	}
+	for _, p := range points {
+		byTimestamp[p.Timestamp] = p
+	}
+	if len(byTimestamp) == 0 {
+		return nil
+	}
+	data := make([]Point, 0, len(byTimestamp))
+	for _, p := range byTimestamp {
+		data = append(data, p)
+	}
+	sort.Sort(pointSlice(data))
+	return &TimeSeries{
+		data: data,
+	}
+}
+
+// pointSlice is a helper for sorting Points by Timestamp.
+type pointSlice []Point
+
+func (p pointSlice) Len() int           { return len(p) }
+func (p pointSlice) Less(i, j int) bool { return p[i].Timestamp < p[j].Timestamp }
+func (p pointSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -290,3 +290,35 @@ func TestInRange(t *testing.T) {
 		}
 	}
 }
+
+func TestDownsample(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.Equal(t, []Point{}, Downsample([]Point{}, 60))
+	pts := []Point{
+		{Timestamp: 120, Value: 1},
+		{Timestamp: 150, Value: 3},
+		{Timestamp: 179, Value: 8},
+		{Timestamp: 240, Value: -4},
+		{Timestamp: 299, Value: -2},
+	}
+	assert.Equal(t, []Point{
+		{Timestamp: 120, Value: 4},
+		{Timestamp: 240, Value: -3},
+	}, Downsample(pts, 60))
+	assert.Equal(t, []Point{{Timestamp: 0, Value: 1}}, Downsample(pts, 3600))
+	// A step of zero leaves the points as they are.
+	assert.Equal(t, pts, Downsample(pts, 0))
+
+	// Intervals before the epoch still start at multiples of the step.
+	assert.Equal(t, []Point{{Timestamp: -60, Value: 5}}, Downsample([]Point{{Timestamp: -30, Value: 5}}, 60))
+}
+
+func TestMerge(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.Nil(t, Merge(nil, []Point{}))
+	ts := New(Point{Timestamp: 10, Value: 1})
+	ts.Add(Point{Timestamp: 30, Value: 3})
+	merged := Merge(ts, []Point{{Timestamp: 20, Value: 2}, {Timestamp: 30, Value: 4}})
+	assert.Equal(t, []Point{{10, 1}, {20, 2}, {30, 4}}, merged.Points())
+	assert.Equal(t, []Point{{5, 0}}, Merge(nil, []Point{{5, 0}}).Points())
+}
@@ -9,8 +9,10 @@ import (
 	"github.com/skia-dev/glog"
 
 	"go.skia.org/infra/go/buildbot"
+	"go.skia.org/infra/go/eventbus"
 	"go.skia.org/infra/go/timer"
 	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/status/go/events"
 )
 
 /*
@@ -46,10 +48,14 @@ type BuildCache struct {
 	mutex           sync.RWMutex
 	db              buildbot.DB
 	dbId            string
+	// evt is set after the initial load, so that only changes are
+	// published.
+	evt *eventbus.EventBus
 }
 
-// NewBuildCache creates a new BuildCache instance.
-func NewBuildCache(db buildbot.DB) (*BuildCache, error) {
+// NewBuildCache creates a new BuildCache instance. Changes to builds and
+// builder comments are published on evt, if not nil.
+func NewBuildCache(db buildbot.DB, evt *eventbus.EventBus) (*BuildCache, error) {
 	// Start tracking build changes in the DB.
 	dbId, err := db.StartTrackingModifiedBuilds()
 	if err != nil {
@@ -80,6 +86,7 @@ func NewBuildCache(db buildbot.DB) (*BuildCache, error) {
 		to = from
 		from = to.Add(-BUILD_LOADING_CHUNK)
 	}
+	bc.evt = evt
 	if err := bc.update(); err != nil {
 		return nil, err
 	}
@@ -139,10 +146,44 @@ func (c *BuildCache) updateBuilderComments() error {
 	if err != nil {
 		return err
 	}
+	c.publishBuilderCommentChanges(builderComments)
 	c.builderComments = builderComments
 	return nil
 }
 
+// publishBuilderCommentChanges publishes the differences between the cached
+// builder comments and the given comments, for the builders in the given
+// map. Assumes the caller holds a lock.
+func (c *BuildCache) publishBuilderCommentChanges(comments map[string][]*buildbot.BuilderComment) {
+	if c.evt == nil {
+		return
+	}
+	changes := []*events.Comment{}
+	diff := func(builder string, a, b []*buildbot.BuilderComment, action string) {
+		ids := map[int64]bool{}
+		for _, comment := range b {
+			ids[comment.Id] = true
+		}
+		for _, comment := range a {
+			if !ids[comment.Id] {
+				changes = append(changes, &events.Comment{
+					Action:  action,
+					Kind:    events.COMMENT_KIND_BUILDER,
+					Target:  builder,
+					Comment: comment,
+				})
+			}
+		}
+	}
+	for builder, newComments := range comments {
+		diff(builder, newComments, c.builderComments[builder], events.COMMENT_ADDED)
+		diff(builder, c.builderComments[builder], newComments, events.COMMENT_DELETED)
+	}
+	if len(changes) > 0 {
+		events.Publish(c.evt, events.EV_COMMENTS, changes)
+	}
+}
+
 // insert adds the given build to the cache. Assumes the caller holds a lock.
 func (c *BuildCache) insert(b *buildbot.Build) {
 	idStr := string(b.Id())
@@ -196,16 +237,31 @@ func (c *BuildCache) updateWithBuilds(builds []*buildbot.Build) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 	glog.Infof("Inserting %d builds.", len(builds))
+	changed := []*buildbot.BuildSummary{}
 	for _, b := range builds {
 		idStr := string(b.Id())
-		if c.get(idStr) != nil {
+		if old := c.get(idStr); old != nil {
+			if buildChanged(old, b) {
+				changed = append(changed, b.GetSummary())
+			}
 			c.delete(idStr)
+		} else {
+			changed = append(changed, b.GetSummary())
 		}
 		c.insert(b)
 	}
+	if len(changed) > 0 {
+		events.Publish(c.evt, events.EV_BUILDS, changed)
+	}
 	return nil
 }
 
+// buildChanged returns true if the build's results or comments differ from
+// the old version.
+func buildChanged(old, b *buildbot.Build) bool {
+	return old.IsFinished() != b.IsFinished() || old.Results != b.Results || len(old.Comments) != len(b.Comments)
+}
+
 // GetBuildsForCommits returns the build data for the given commits.
 func (c *BuildCache) GetBuildsForCommits(commits []string) (map[string]map[string]*buildbot.BuildSummary, error) {
 	defer timer.New("BuildCache.GetBuildsForCommits").Stop()
@@ -262,6 +318,7 @@ func (c *BuildCache) AddBuilderComment(builder string, comment *buildbot.Builder
 	if err != nil {
 		return err
 	}
+	c.publishBuilderCommentChanges(map[string][]*buildbot.BuilderComment{builder: newComments})
 	c.builderComments[builder] = newComments
 	return nil
 }
@@ -277,6 +334,7 @@ func (c *BuildCache) DeleteBuilderComment(builder string, commentId int64) error
 	if err != nil {
 		return err
 	}
+	c.publishBuilderCommentChanges(map[string][]*buildbot.BuilderComment{builder: newComments})
 	c.builderComments[builder] = newComments
 	return nil
 }
@@ -287,11 +345,7 @@ func (c *BuildCache) refreshBuild(id buildbot.BuildID) error {
 	if err != nil {
 		return err
 	}
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	c.delete(string(b.Id()))
-	c.insert(b)
-	return nil
+	return c.updateWithBuilds([]*buildbot.Build{b})
 }
 
 // AddBuildComment adds the given comment to the given build.
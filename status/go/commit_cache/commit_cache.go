@@ -11,10 +11,12 @@ import (
 	"github.com/skia-dev/glog"
 
 	"go.skia.org/infra/go/buildbot"
+	"go.skia.org/infra/go/eventbus"
 	"go.skia.org/infra/go/git/gitinfo"
 	"go.skia.org/infra/go/timer"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/go/vcsinfo"
+	"go.skia.org/infra/status/go/events"
 )
 
 /*
@@ -34,7 +36,9 @@ type CommitCache struct {
 	Commits     []*vcsinfo.LongCommit
 	Comments    map[string][]*buildbot.CommitComment
 	db          buildbot.DB
+	evt         *eventbus.EventBus
 	mutex       sync.RWMutex
+	repoUrl     string
 	repo        *gitinfo.GitInfo
 	requestSize int
 }
@@ -73,8 +77,9 @@ func (c *CommitCache) toFile() error {
 
 // New creates and returns a new CommitCache which watches the given repo.
 // The initial update will load ALL commits from the repository, so expect
-// this to be slow.
-func New(repo *gitinfo.GitInfo, cacheFile string, requestSize int, db buildbot.DB) (*CommitCache, error) {
+// this to be slow. New commits and comment changes are published on evt, if
+// not nil, with the given repo URL.
+func New(repo *gitinfo.GitInfo, cacheFile string, requestSize int, db buildbot.DB, repoUrl string, evt *eventbus.EventBus) (*CommitCache, error) {
 	defer timer.New("commit_cache.New()").Stop()
 	c, err := fromFile(cacheFile)
 	if err != nil {
@@ -83,6 +88,8 @@ func New(repo *gitinfo.GitInfo, cacheFile string, requestSize int, db buildbot.D
 	}
 	c.cacheFile = cacheFile
 	c.db = db
+	c.evt = evt
+	c.repoUrl = repoUrl
 	c.repo = repo
 	c.requestSize = requestSize
 
@@ -210,11 +217,53 @@ func (c *CommitCache) update() (rv error) {
 	defer timer.New("  CommitCache locked").Stop()
 	c.BranchHeads = branchHeads
 	c.Commits = allCommits
+	c.publishCommentChanges(comments)
 	c.Comments = comments
+	// Don't push the entire history when the cache is first created.
+	if n > 0 && len(newCommits) > 0 {
+		events.Publish(c.evt, events.EV_NEW_COMMITS, &events.NewCommits{
+			Repo:    c.repoUrl,
+			Commits: newCommits,
+		})
+	}
 	glog.Infof("Finished updating the cache.")
 	return nil
 }
 
+// publishCommentChanges publishes the differences between the cached comments
+// and the given comments. Assumes the caller holds a lock.
+func (c *CommitCache) publishCommentChanges(comments map[string][]*buildbot.CommitComment) {
+	// Don't push all of the comments when the cache is first created.
+	if c.evt == nil || c.Comments == nil {
+		return
+	}
+	changes := []*events.Comment{}
+	diff := func(a, b map[string][]*buildbot.CommitComment, action string) {
+		for hash, commentsA := range a {
+			ids := map[int64]bool{}
+			for _, comment := range b[hash] {
+				ids[comment.Id] = true
+			}
+			for _, comment := range commentsA {
+				if !ids[comment.Id] {
+					changes = append(changes, &events.Comment{
+						Action:  action,
+						Kind:    events.COMMENT_KIND_COMMIT,
+						Repo:    c.repoUrl,
+						Target:  hash,
+						Comment: comment,
+					})
+				}
+			}
+		}
+	}
+	diff(comments, c.Comments, events.COMMENT_ADDED)
+	diff(c.Comments, comments, events.COMMENT_DELETED)
+	if len(changes) > 0 {
+		events.Publish(c.evt, events.EV_COMMENTS, changes)
+	}
+}
+
 type CommitData struct {
 	Comments    map[string][]*buildbot.CommitComment `json:"comments"`
 	Commits     []*vcsinfo.LongCommit                `json:"commits"`
@@ -275,6 +324,7 @@ func (c *CommitCache) AddCommitComment(comment *buildbot.CommitComment) error {
 	if err != nil {
 		return fmt.Errorf("Failed to retrieve commit comments: %s", err)
 	}
+	c.publishCommentChanges(comments)
 	c.Comments = comments
 	return nil
 }
@@ -297,6 +347,7 @@ func (c *CommitCache) DeleteCommitComment(id int64) error {
 	if err != nil {
 		return fmt.Errorf("Failed to retrieve commit comments: %s", err)
 	}
+	c.publishCommentChanges(comments)
 	c.Comments = comments
 	return nil
 }
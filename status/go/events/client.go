@@ -0,0 +1,107 @@
+package events
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.skia.org/infra/go/util"
+)
+
+// ErrReset is returned by Client.Next when the Server could not resume the
+// stream after the Client's LastId. The stream continues, but events were
+// missed, so the caller must reload its whole state before calling Next again.
+var ErrReset = errors.New("Events were missed; the state must be reloaded.")
+
+// Client reads events from a Server.
+type Client struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	// LastId is the ID of the last event read, which may be passed to
+	// NewClient to resume the stream after reconnecting.
+	LastId int64
+}
+
+// NewClient connects to the events stream at the given URL. If lastId is
+// non-zero, the recent events after lastId are sent first.
+func NewClient(c *http.Client, url string, lastId int64) (*Client, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastId > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatInt(lastId, 10))
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to events stream: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		util.Close(resp.Body)
+		return nil, fmt.Errorf("Failed to connect to events stream: %s", resp.Status)
+	}
+	return &Client{
+		body:   resp.Body,
+		reader: bufio.NewReader(resp.Body),
+		LastId: lastId,
+	}, nil
+}
+
+// Next blocks until the next event is received. Returns io.EOF if the server
+// closed the stream, or ErrReset if the server sent an EV_RESET event.
+func (c *Client) Next() (*Event, error) {
+	e := &Event{}
+	data := []string{}
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(data) == 0 {
+				// Keepalive or empty event.
+				continue
+			}
+			e.Data = []byte(strings.Join(data, "\n"))
+			if e.Id != 0 {
+				c.LastId = e.Id
+			}
+			if e.Type == EV_RESET {
+				return nil, ErrReset
+			}
+			return e, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field := line
+		value := ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field = line[:i]
+			value = strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "id":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid event ID %q", value)
+			}
+			e.Id = id
+		case "event":
+			e.Type = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+}
+
+// Close disconnects from the stream.
+func (c *Client) Close() error {
+	return c.body.Close()
+}
@@ -0,0 +1,263 @@
+// Package events streams incremental updates from the status caches to
+// clients using server-sent events, so that clients don't have to reload
+// whole windows of commits and builds.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/eventbus"
+	"go.skia.org/infra/go/vcsinfo"
+)
+
+const (
+	// Event types, which are also the eventbus topics on which the caches
+	// publish updates. Each update loop publishes at most one event of each
+	// type, since the eventbus does not preserve ordering.
+
+	// EV_NEW_COMMITS is published by a CommitCache with a *NewCommits.
+	EV_NEW_COMMITS = "status-new-commits"
+	// EV_BUILDS is published by a BuildCache with a []*buildbot.BuildSummary
+	// of builds which are new or whose results or comments changed.
+	EV_BUILDS = "status-builds"
+	// EV_TASKS is published by a SchedulerCache with a []*db.Task of Tasks
+	// which are new or whose status changed.
+	EV_TASKS = "status-tasks"
+	// EV_COMMENTS is published by any of the caches with a []*Comment.
+	EV_COMMENTS = "status-comments"
+	// EV_RESET is sent by a Server to clients which reconnect with an event
+	// ID whose following events are no longer available, eg. because the
+	// server restarted. Clients must reload their whole state.
+	EV_RESET = "status-reset"
+
+	// Values for Comment.Action.
+	COMMENT_ADDED   = "added"
+	COMMENT_DELETED = "deleted"
+
+	// Values for Comment.Kind. Build comments are pushed as part of EV_BUILDS.
+	COMMENT_KIND_COMMIT    = "commit"
+	COMMENT_KIND_BUILDER   = "builder"
+	COMMENT_KIND_TASK      = "task"
+	COMMENT_KIND_TASK_SPEC = "taskSpec"
+
+	// MAX_RECENT_EVENTS is the number of events kept for clients which
+	// reconnect with the Last-Event-ID header.
+	MAX_RECENT_EVENTS = 1000
+	// SUBSCRIBER_BUFFER is the number of events which may be queued for a
+	// client. Clients which fall further behind are disconnected and must
+	// reconnect.
+	SUBSCRIBER_BUFFER = 100
+	// KEEPALIVE_PERIOD is how often a comment is sent to idle clients to keep
+	// the connection open.
+	KEEPALIVE_PERIOD = 30 * time.Second
+)
+
+// TYPES are all of the event types.
+var TYPES = []string{EV_NEW_COMMITS, EV_BUILDS, EV_TASKS, EV_COMMENTS}
+
+// NewCommits describes commits which were added to a repo. As elsewhere in
+// the events, Repo is the repo URL.
+type NewCommits struct {
+	Repo    string                `json:"repo"`
+	Commits []*vcsinfo.LongCommit `json:"commits"`
+}
+
+// Comment describes a comment which was added or deleted.
+type Comment struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Repo   string `json:"repo,omitempty"`
+	// Target is the commit hash, builder name, or TaskSpec name which the
+	// comment is about. For Task comments, it is the TaskSpec name; the
+	// commit is in Comment.
+	Target  string      `json:"target"`
+	Comment interface{} `json:"comment"`
+}
+
+// Publish publishes data on the given topic, if evt is not nil.
+func Publish(evt *eventbus.EventBus, topic string, data interface{}) {
+	if evt != nil {
+		evt.Publish(topic, data)
+	}
+}
+
+// Event is a single update sent to clients.
+type Event struct {
+	// Id increases with each event sent by a Server. IDs start at the time
+	// the Server was created in microseconds, so that they keep increasing
+	// across restarts.
+	Id   int64
+	Type string
+	// Data is the JSON encoding of the data published on the topic.
+	Data json.RawMessage
+}
+
+// Server pushes the events published on an eventbus.EventBus to clients.
+type Server struct {
+	mutex       sync.Mutex
+	nextId      int64
+	recent      []*Event
+	subscribers map[chan *Event]bool
+}
+
+// NewServer returns a Server which sends the events published on the given
+// EventBus.
+func NewServer(evt *eventbus.EventBus) *Server {
+	s := &Server{
+		nextId:      time.Now().UnixNano() / int64(time.Microsecond),
+		recent:      []*Event{},
+		subscribers: map[chan *Event]bool{},
+	}
+	for _, topic := range TYPES {
+		// Capture the loop variable.
+		topic := topic
+		evt.SubscribeAsync(topic, func(data interface{}) {
+			s.send(topic, data)
+		})
+	}
+	return s
+}
+
+// send assigns an ID to the event and queues it for all clients.
+func (s *Server) send(eventType string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		glog.Errorf("Failed to encode %s event: %s", eventType, err)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nextId++
+	e := &Event{
+		Id:   s.nextId,
+		Type: eventType,
+		Data: b,
+	}
+	s.recent = append(s.recent, e)
+	if len(s.recent) > MAX_RECENT_EVENTS {
+		s.recent = s.recent[len(s.recent)-MAX_RECENT_EVENTS:]
+	}
+	for ch, _ := range s.subscribers {
+		select {
+		case ch <- e:
+		default:
+			glog.Warningf("Disconnecting slow events client.")
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns a channel of new events, and the recent events after
+// lastId, if lastId is non-zero. If the events after lastId are not all
+// available, or lastId was not sent by this Server, the backlog is a single
+// EV_RESET event instead.
+func (s *Server) subscribe(lastId int64) (chan *Event, []*Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	backlog := []*Event{}
+	if lastId > 0 {
+		if lastId > s.nextId || (lastId < s.nextId && (len(s.recent) == 0 || s.recent[0].Id > lastId+1)) {
+			backlog = append(backlog, &Event{
+				Id:   s.nextId,
+				Type: EV_RESET,
+				Data: json.RawMessage("{}"),
+			})
+		} else {
+			for _, e := range s.recent {
+				if e.Id > lastId {
+					backlog = append(backlog, e)
+				}
+			}
+		}
+	}
+	ch := make(chan *Event, SUBSCRIBER_BUFFER)
+	s.subscribers[ch] = true
+	return ch, backlog
+}
+
+// unsubscribe stops sending events on the given channel.
+func (s *Server) unsubscribe(ch chan *Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.subscribers[ch] {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// writeEvent writes the event in the text/event-stream format.
+func writeEvent(w http.ResponseWriter, e *Event) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, e.Type, e.Data)
+	return err
+}
+
+// ServeHTTP streams events to the client until it disconnects. Clients which
+// reconnect with the Last-Event-ID header, or the "lastEventId" parameter,
+// first receive the recent events which they missed, or an EV_RESET event if
+// those are no longer available. The response must not be
+// buffered, eg. by compression.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+	lastIdStr := r.Header.Get("Last-Event-ID")
+	if lastIdStr == "" {
+		lastIdStr = r.FormValue("lastEventId")
+	}
+	lastId := int64(0)
+	if lastIdStr != "" {
+		var err error
+		lastId, err = strconv.ParseInt(lastIdStr, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid event ID %q", lastIdStr), http.StatusBadRequest)
+			return
+		}
+	}
+	var closed <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closed = cn.CloseNotify()
+	}
+
+	ch, backlog := s.subscribe(lastId)
+	defer s.unsubscribe(ch)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range backlog {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(KEEPALIVE_PERIOD)
+	defer keepalive.Stop()
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				// The client fell behind.
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+		flusher.Flush()
+	}
+}
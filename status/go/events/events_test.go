@@ -0,0 +1,103 @@
+package events
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/eventbus"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/go/vcsinfo"
+)
+
+func TestEventsStream(t *testing.T) {
+	testutils.SmallTest(t)
+	evt := eventbus.New(nil)
+	server := httptest.NewServer(NewServer(evt))
+	defer server.Close()
+
+	c, err := NewClient(http.DefaultClient, server.URL, 0)
+	assert.NoError(t, err)
+	defer util.Close(c)
+
+	// New commits.
+	Publish(evt, EV_NEW_COMMITS, &NewCommits{
+		Repo: "skia",
+		Commits: []*vcsinfo.LongCommit{
+			&vcsinfo.LongCommit{
+				ShortCommit: &vcsinfo.ShortCommit{
+					Hash: "abc123",
+				},
+			},
+		},
+	})
+	e, err := c.Next()
+	assert.NoError(t, err)
+	firstId := e.Id
+	assert.True(t, firstId > 0)
+	assert.Equal(t, EV_NEW_COMMITS, e.Type)
+	var commits NewCommits
+	assert.NoError(t, json.Unmarshal(e.Data, &commits))
+	assert.Equal(t, "skia", commits.Repo)
+	assert.Equal(t, 1, len(commits.Commits))
+	assert.Equal(t, "abc123", commits.Commits[0].Hash)
+
+	// Comments.
+	Publish(evt, EV_COMMENTS, []*Comment{
+		&Comment{
+			Action:  COMMENT_ADDED,
+			Kind:    COMMENT_KIND_COMMIT,
+			Repo:    "skia",
+			Target:  "abc123",
+			Comment: "Broke the build.",
+		},
+	})
+	e, err = c.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, firstId+1, e.Id)
+	assert.Equal(t, EV_COMMENTS, e.Type)
+	assert.Equal(t, firstId+1, c.LastId)
+	var comments []*Comment
+	assert.NoError(t, json.Unmarshal(e.Data, &comments))
+	assert.Equal(t, 1, len(comments))
+	assert.Equal(t, COMMENT_ADDED, comments[0].Action)
+	assert.Equal(t, "Broke the build.", comments[0].Comment)
+
+	// A client which reconnects receives the events it missed.
+	c2, err := NewClient(http.DefaultClient, server.URL, firstId)
+	assert.NoError(t, err)
+	defer util.Close(c2)
+	e, err = c2.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, firstId+1, e.Id)
+	assert.Equal(t, EV_COMMENTS, e.Type)
+
+	// Clients which reconnect with an ID from before the recent events, eg.
+	// from before a restart, or with an ID which was never sent, are told to
+	// reload their state. The stream continues after the reset.
+	for _, lastId := range []int64{firstId - 2, firstId + 100} {
+		c3, err := NewClient(http.DefaultClient, server.URL, lastId)
+		assert.NoError(t, err)
+		_, err = c3.Next()
+		assert.Equal(t, ErrReset, err)
+		resetId := c3.LastId
+		assert.True(t, resetId > firstId && resetId < firstId+100)
+		Publish(evt, EV_TASKS, []string{})
+		e, err = c3.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, EV_TASKS, e.Type)
+		assert.Equal(t, resetId+1, e.Id)
+		assert.NoError(t, c3.Close())
+	}
+}
+
+func TestEventsInvalidLastId(t *testing.T) {
+	testutils.SmallTest(t)
+	server := httptest.NewServer(NewServer(eventbus.New(nil)))
+	defer server.Close()
+	_, err := NewClient(http.DefaultClient, server.URL+"?lastEventId=abc", 0)
+	assert.Error(t, err)
+}
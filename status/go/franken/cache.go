@@ -1,4 +1,9 @@
 // Defines BTCache, a Frankenstein cache containing both Builds and Tasks.
+//
+// BTCache is a compatibility adapter which presents Tasks from a
+// scheduler_cache.SchedulerCache as buildbot.Builds, so that clients of the
+// buildbot-shaped API continue to work. New code should use SchedulerCache
+// directly.
 package franken
 
 import (
@@ -13,11 +18,12 @@ import (
 
 	"github.com/skia-dev/glog"
 	"go.skia.org/infra/go/buildbot"
+	"go.skia.org/infra/go/eventbus"
 	"go.skia.org/infra/go/git/gitinfo"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/status/go/build_cache"
+	"go.skia.org/infra/status/go/scheduler_cache"
 	"go.skia.org/infra/task_scheduler/go/db"
-	"go.skia.org/infra/task_scheduler/go/db/local_db"
 )
 
 const (
@@ -37,77 +43,42 @@ const (
 	// BuilderComment.Id via lookup in an LRUCache. If a comment falls out of this
 	// cache, we can no longer delete that comment.
 	MAX_COMMENTS = 1000
-
-	// TASK_URL_FMT is a format string for the Swarming task URL. Parameter is
-	// task ID.
-	TASK_URL_FMT = "https://chromium-swarm.appspot.com/task?id=%s"
-	// TASK_TRIGGER_URL_FMT is a format string for triggering a Task with task
-	// scheduler. Parameters are task spec name and commit hash.
-	TASK_TRIGGER_URL_FMT = "https://task-scheduler.skia.org/trigger?submit=true&task_spec=%s&commit=%s"
-	// TASKLIST_URL_FMT is a format string for the Swarming tasklist URL.
-	// Parameters are a single tag key and value.
-	TASKLIST_URL_FMT = "https://chromium-swarm.appspot.com/tasklist?c=name&c=state&c=created_ts&c=duration&c=completed_ts&c=source_revision&f=%s%%3A%s&l=50&s=created_ts%%3Adesc"
-	// BOT_DETAIL_URL_FMT is a format string for the Swarming bot detail URL.
-	// Parameter is bot name.
-	BOT_DETAIL_URL_FMT = "https://chromium-swarm.appspot.com/bot?id=%s"
 )
 
 // BTCache is API-compatible with BuildCache, but also includes Tasks.
 type BTCache struct {
-	// repos, tasks, commentDb, builds, taskNumberCache, and commentIdCache are
-	// safe for concurrent use.
-	repos     *gitinfo.RepoMap
-	tasks     db.TaskCache
-	commentDb db.CommentDB
-	builds    *build_cache.BuildCache
+	// repos, tasks, builds, taskNumberCache, and commentIdCache are safe for
+	// concurrent use.
+	repos  *gitinfo.RepoMap
+	tasks  *scheduler_cache.SchedulerCache
+	builds *build_cache.BuildCache
 	// taskNumberCache maps Build.Number for a Build generated from a Task to
 	// Task.Id.
 	taskNumberCache util.LRUCache
+	// taskNumberMutex makes checking for and assigning a Build.Number in
+	// taskNumberCache atomic.
+	taskNumberMutex sync.Mutex
 	// commentIdCache maps BuildComment.Id or BuilderComment.Id for a generated
 	// comment to the *TaskComment or *TaskSpecComment from which it was
 	// generated.
 	commentIdCache util.LRUCache
-	// mutex protects cachedTaskComments and cachedTaskSpecComments.
-	mutex sync.RWMutex
-	// cachedTaskComments contains BuildComments generated from the latest
-	// TaskComments.
-	// map[TaskComment.Repo][TaskComment.Revision][TaskComment.Name][]BuildComment
-	cachedTaskComments map[string]map[string]map[string][]*buildbot.BuildComment
-	// cachedTaskSpecComments contains BuilderComments generated from the latest
-	// TaskSpecComments. map[BuilderName][]BuilderComment
-	cachedTaskSpecComments map[string][]*buildbot.BuilderComment
 }
 
 // NewBTCache creates a combined Build and Task cache for the given repos,
-// pulling data from the given buildDb and taskDb.
-func NewBTCache(repos *gitinfo.RepoMap, buildDb buildbot.DB, taskDb db.RemoteDB) (*BTCache, error) {
-	tasks, err := db.NewTaskCache(taskDb, build_cache.BUILD_LOADING_PERIOD)
-	if err != nil {
-		return nil, err
-	}
-	builds, err := build_cache.NewBuildCache(buildDb)
+// pulling data from the given buildDb and SchedulerCache. Changes to builds are
+// published on evt, if not nil.
+func NewBTCache(repos *gitinfo.RepoMap, buildDb buildbot.DB, tasks *scheduler_cache.SchedulerCache, evt *eventbus.EventBus) (*BTCache, error) {
+	builds, err := build_cache.NewBuildCache(buildDb, evt)
 	if err != nil {
 		return nil, err
 	}
-	c := &BTCache{
+	return &BTCache{
 		repos:           repos,
 		tasks:           tasks,
-		commentDb:       taskDb,
 		builds:          builds,
 		taskNumberCache: util.NewMemLRUCache(MAX_TASKS),
 		commentIdCache:  util.NewMemLRUCache(MAX_COMMENTS),
-	}
-	if err := c.update(); err != nil {
-		return nil, err
-	}
-	go func() {
-		for _ = range time.Tick(time.Minute) {
-			if err := c.update(); err != nil {
-				glog.Error(err)
-			}
-		}
-	}()
-	return c, nil
+	}, nil
 }
 
 // taskNameToBuilderName generates a Builder name from a TaskSpec name.
@@ -163,90 +134,23 @@ func (c *BTCache) taskSpecCommentForId(id int64) (*db.TaskSpecComment, error) {
 	return v.(*db.TaskSpecComment), nil
 }
 
-// update reads updated tasks and comments from the task scheduler DB. (Builds
-// are updated automatically by BuildCache.)
-func (c *BTCache) update() error {
-	if err := c.repos.Update(); err != nil {
-		return err
-	}
-	if err := c.tasks.Update(); err != nil {
-		return err
-	}
-	return c.updateComments()
-}
-
-// updateComments reads updated comments from the task scheduler DB. This method
-// is separate from update to avoid calling c.repos.Update when adding/deleting
-// comments.
-func (c *BTCache) updateComments() error {
-	repos := c.repos.Repos()
-	comments, err := c.commentDb.GetCommentsForRepos(repos, time.Now().Add(-build_cache.BUILD_LOADING_PERIOD))
-	if err != nil {
-		return err
-	}
-	taskComments := make(map[string]map[string]map[string][]*buildbot.BuildComment, len(repos))
-	taskSpecComments := map[string][]*buildbot.BuilderComment{}
-	for _, rc := range comments {
-		for _, m := range rc.TaskComments {
-			for _, comments := range m {
-				comment := comments[0]
-				commitMap, ok := taskComments[comment.Repo]
-				if !ok {
-					commitMap = map[string]map[string][]*buildbot.BuildComment{}
-					taskComments[comment.Repo] = commitMap
-				}
-				nameMap, ok := commitMap[comment.Revision]
-				if !ok {
-					nameMap = map[string][]*buildbot.BuildComment{}
-					commitMap[comment.Revision] = nameMap
-				}
-				buildComments := nameMap[comment.Name]
-				for _, tc := range comments {
-					id := c.commentId(tc)
-					buildComments = append(buildComments, &buildbot.BuildComment{
-						Id:        id,
-						User:      tc.User,
-						Timestamp: tc.Timestamp,
-						Message:   tc.Message,
-					})
-				}
-				nameMap[comment.Name] = buildComments
-			}
-		}
-		for _, comments := range rc.TaskSpecComments {
-			builderName := taskNameToBuilderName(comments[0].Name)
-			builderComments := taskSpecComments[builderName]
-			for _, tsc := range comments {
-				id := c.commentId(tsc)
-				builderComments = append(builderComments, &buildbot.BuilderComment{
-					Id:            id,
-					Builder:       builderName,
-					User:          tsc.User,
-					Timestamp:     tsc.Timestamp,
-					Flaky:         tsc.Flaky,
-					IgnoreFailure: tsc.IgnoreFailure,
-					Message:       tsc.Message,
-				})
-			}
-			taskSpecComments[builderName] = builderComments
-		}
-	}
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	c.cachedTaskComments = taskComments
-	c.cachedTaskSpecComments = taskSpecComments
-	return nil
-}
-
-// taskIdToBuildNumber returns a unique number to use as Build.Number.
+// taskIdToBuildNumber returns a number to use as Build.Number, which will then
+// be retrievable via buildNumberToTaskId. The number does not depend on the
+// format of the Task ID. Distinct Task IDs may hash to the same number, in
+// which case the number refers to the most recent Task and the collision is
+// logged.
 func (c *BTCache) taskIdToBuildNumber(id string) int {
-	// Very hacky...
-	_, seq, err := local_db.ParseId(id)
-	if err != nil {
-		glog.Error(err)
-		return -1
+	// Use int32 to avoid issues in Javascript.
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(id))
+	// Shift down by one to ensure non-negative.
+	num := int(int32(hash.Sum32() >> 1))
+
+	c.taskNumberMutex.Lock()
+	defer c.taskNumberMutex.Unlock()
+	if v, ok := c.taskNumberCache.Get(num); ok && v.(string) != id {
+		glog.Errorf("Tasks %s and %s have the same build number %d; build number now refers to %s.", v.(string), id, num, id)
 	}
-	num := int(seq)
 	c.taskNumberCache.Add(num, id)
 	return num
 }
@@ -262,7 +166,7 @@ func (c *BTCache) buildNumberToTaskId(num int) (string, error) {
 }
 
 // taskToBuild generates a Build representing a Task.
-func (c *BTCache) taskToBuild(task *db.Task) *buildbot.Build {
+func (c *BTCache) taskToBuild(task *scheduler_cache.Task) *buildbot.Build {
 	results := buildbot.BUILDBOT_EXCEPTION
 	switch task.Status {
 	case db.TASK_STATUS_PENDING, db.TASK_STATUS_RUNNING, db.TASK_STATUS_SUCCESS:
@@ -276,15 +180,15 @@ func (c *BTCache) taskToBuild(task *db.Task) *buildbot.Build {
 	buildSlave := DEFAULT_BUILD_SLAVE
 
 	properties := [][]interface{}{
-		{"taskURL", fmt.Sprintf(TASK_URL_FMT, task.SwarmingTaskId), PROPERTY_SOURCE},
-		{"taskRetryURL", fmt.Sprintf(TASK_TRIGGER_URL_FMT, task.Name, task.Revision), PROPERTY_SOURCE},
-		{"taskSpecTasklistURL", fmt.Sprintf(TASKLIST_URL_FMT, db.SWARMING_TAG_NAME, task.Name), PROPERTY_SOURCE},
+		{"taskURL", task.URL, PROPERTY_SOURCE},
+		{"taskRetryURL", task.RetryURL, PROPERTY_SOURCE},
+		{"taskSpecTasklistURL", fmt.Sprintf(scheduler_cache.TASKLIST_URL_FMT, db.SWARMING_TAG_NAME, task.Name), PROPERTY_SOURCE},
 	}
 	if task.SwarmingBotId != "" {
 		buildSlave = task.SwarmingBotId
 		properties = append(properties, [][]interface{}{
-			{"botTasklistURL", fmt.Sprintf(TASKLIST_URL_FMT, "slavename", task.SwarmingBotId), PROPERTY_SOURCE},
-			{"botDetailURL", fmt.Sprintf(BOT_DETAIL_URL_FMT, task.SwarmingBotId), PROPERTY_SOURCE},
+			{"botTasklistURL", fmt.Sprintf(scheduler_cache.TASKLIST_URL_FMT, "slavename", task.SwarmingBotId), PROPERTY_SOURCE},
+			{"botDetailURL", fmt.Sprintf(scheduler_cache.BOT_DETAIL_URL_FMT, task.SwarmingBotId), PROPERTY_SOURCE},
 		}...)
 	}
 	propertiesStr := ""
@@ -299,13 +203,16 @@ func (c *BTCache) taskToBuild(task *db.Task) *buildbot.Build {
 		finished = task.Finished
 	}
 
-	comments := c.cachedTaskComments[task.Repo][task.Revision][task.Name]
-	if comments == nil {
-		comments = []*buildbot.BuildComment{}
+	comments := make([]*buildbot.BuildComment, 0, len(task.Comments))
+	for _, tc := range task.Comments {
+		comments = append(comments, &buildbot.BuildComment{
+			Id:        c.commentId(tc),
+			User:      tc.User,
+			Timestamp: tc.Timestamp,
+			Message:   tc.Message,
+		})
 	}
 
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
 	return &buildbot.Build{
 		Builder:       taskNameToBuilderName(task.Name),
 		Master:        FAKE_MASTER,
@@ -403,10 +310,23 @@ func (c *BTCache) GetBuildsFromDateRange(from, to time.Time) ([]*buildbot.Build,
 // BuilderComment). See also BuildCache.GetBuildersComments.
 func (c *BTCache) GetBuildersComments() map[string][]*buildbot.BuilderComment {
 	buildResult := c.builds.GetBuildersComments()
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	for name, comments := range c.cachedTaskSpecComments {
-		buildResult[name] = comments
+	for _, repo := range c.repos.Repos() {
+		for name, comments := range c.tasks.GetTaskSpecComments(repo) {
+			builderName := taskNameToBuilderName(name)
+			builderComments := buildResult[builderName]
+			for _, tsc := range comments {
+				builderComments = append(builderComments, &buildbot.BuilderComment{
+					Id:            c.commentId(tsc),
+					Builder:       builderName,
+					User:          tsc.User,
+					Timestamp:     tsc.Timestamp,
+					Flaky:         tsc.Flaky,
+					IgnoreFailure: tsc.IgnoreFailure,
+					Message:       tsc.Message,
+				})
+			}
+			buildResult[builderName] = builderComments
+		}
 	}
 	return buildResult
 }
@@ -426,7 +346,7 @@ func (c *BTCache) AddBuilderComment(builder string, comment *buildbot.BuilderCom
 		if repo == "" {
 			return fmt.Errorf("Unknown TaskSpec %q (derived from %q)", name, builder)
 		}
-		taskSpecComment := &db.TaskSpecComment{
+		return c.tasks.AddTaskSpecComment(&db.TaskSpecComment{
 			Repo:          repo,
 			Name:          name,
 			Timestamp:     comment.Timestamp,
@@ -434,11 +354,7 @@ func (c *BTCache) AddBuilderComment(builder string, comment *buildbot.BuilderCom
 			Flaky:         comment.Flaky,
 			IgnoreFailure: comment.IgnoreFailure,
 			Message:       comment.Message,
-		}
-		if err := c.commentDb.PutTaskSpecComment(taskSpecComment); err != nil {
-			return err
-		}
-		return c.updateComments()
+		})
 	} else {
 		return c.builds.AddBuilderComment(builder, comment)
 	}
@@ -454,10 +370,7 @@ func (c *BTCache) DeleteBuilderComment(builder string, commentId int64) error {
 		if err != nil {
 			return err
 		}
-		if err := c.commentDb.DeleteTaskSpecComment(taskSpecComment); err != nil {
-			return err
-		}
-		return c.updateComments()
+		return c.tasks.DeleteTaskSpecComment(taskSpecComment)
 	} else {
 		return c.builds.DeleteBuilderComment(builder, commentId)
 	}
@@ -479,18 +392,7 @@ func (c *BTCache) AddBuildComment(master, builder string, number int, comment *b
 		if name != task.Name {
 			return fmt.Errorf("Inconsistent Task name; expected %q, got %q", task.Name, name)
 		}
-		taskComment := &db.TaskComment{
-			Repo:      task.Repo,
-			Revision:  task.Revision,
-			Name:      task.Name,
-			Timestamp: comment.Timestamp,
-			User:      comment.User,
-			Message:   comment.Message,
-		}
-		if err := c.commentDb.PutTaskComment(taskComment); err != nil {
-			return err
-		}
-		return c.updateComments()
+		return c.tasks.AddTaskComment(task.Id, comment.User, comment.Message, comment.Timestamp)
 	} else {
 		return c.builds.AddBuildComment(master, builder, number, comment)
 	}
@@ -505,10 +407,7 @@ func (c *BTCache) DeleteBuildComment(master, builder string, number int, comment
 		if err != nil {
 			return err
 		}
-		if err := c.commentDb.DeleteTaskComment(taskComment); err != nil {
-			return err
-		}
-		return c.updateComments()
+		return c.tasks.DeleteTaskComment(taskComment)
 	} else {
 		return c.builds.DeleteBuildComment(master, builder, number, commentId)
 	}
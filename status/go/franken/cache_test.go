@@ -0,0 +1,43 @@
+package franken
+
+import (
+	"testing"
+
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/util"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestTaskIdToBuildNumber(t *testing.T) {
+	testutils.SmallTest(t)
+	c := &BTCache{
+		taskNumberCache: util.NewMemLRUCache(MAX_TASKS),
+	}
+
+	a := c.taskIdToBuildNumber("task1")
+	b := c.taskIdToBuildNumber("task2")
+	expect.NotEqual(t, a, b)
+
+	// Numbers are stable.
+	expect.Equal(t, a, c.taskIdToBuildNumber("task1"))
+	expect.Equal(t, b, c.taskIdToBuildNumber("task2"))
+
+	id, err := c.buildNumberToTaskId(a)
+	assert.NoError(t, err)
+	expect.Equal(t, "task1", id)
+	id, err = c.buildNumberToTaskId(b)
+	assert.NoError(t, err)
+	expect.Equal(t, "task2", id)
+
+	// These IDs have the same hash. The number refers to the most recent
+	// Task.
+	expect.Equal(t, c.taskIdToBuildNumber("task22788"), c.taskIdToBuildNumber("task153898"))
+	id, err = c.buildNumberToTaskId(c.taskIdToBuildNumber("task153898"))
+	assert.NoError(t, err)
+	expect.Equal(t, "task153898", id)
+
+	_, err = c.buildNumberToTaskId(-1)
+	expect.Error(t, err)
+}
@@ -0,0 +1,501 @@
+// Defines SchedulerCache, which provides the status page's view of Jobs,
+// Tasks, and comments from the task scheduler.
+package scheduler_cache
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/eventbus"
+	"go.skia.org/infra/go/git/gitinfo"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/status/go/build_cache"
+	"go.skia.org/infra/status/go/events"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+const (
+	// TASK_URL_FMT is a format string for the Swarming task URL. Parameter is
+	// task ID.
+	TASK_URL_FMT = "https://chromium-swarm.appspot.com/task?id=%s"
+	// TASK_TRIGGER_URL_FMT is a format string for triggering a Task with task
+	// scheduler. Parameters are task spec name and commit hash.
+	TASK_TRIGGER_URL_FMT = "https://task-scheduler.skia.org/trigger?submit=true&task_spec=%s&commit=%s"
+	// TASKLIST_URL_FMT is a format string for the Swarming tasklist URL.
+	// Parameters are a single tag key and value.
+	TASKLIST_URL_FMT = "https://chromium-swarm.appspot.com/tasklist?c=name&c=state&c=created_ts&c=duration&c=completed_ts&c=source_revision&f=%s%%3A%s&l=50&s=created_ts%%3Adesc"
+	// BOT_DETAIL_URL_FMT is a format string for the Swarming bot detail URL.
+	// Parameter is bot name.
+	BOT_DETAIL_URL_FMT = "https://chromium-swarm.appspot.com/bot?id=%s"
+)
+
+// Task is a db.Task with the additional information displayed by the status
+// page. The blamelist of the Task is Task.Commits.
+type Task struct {
+	*db.Task
+
+	// Attempts summarizes every attempt of the Task's TaskKey, including this
+	// one, sorted by creation time.
+	Attempts []*db.TaskSummary
+
+	// Comments are the TaskComments for the Task's TaskSpec at the Task's
+	// revision, sorted by timestamp.
+	Comments []*db.TaskComment
+
+	// URL is the Swarming task URL.
+	URL string
+
+	// RetryURL triggers another attempt of the Task.
+	RetryURL string
+}
+
+// Job is a db.Job with the additional information displayed by the status
+// page. The DAG of the Job is Job.Dependencies and the attempts of each of its
+// Tasks are Job.Tasks.
+type Job struct {
+	*db.Job
+
+	// Blamelist is the union of the blamelists of the latest attempts of the
+	// Job's Tasks, sorted. Tasks which have expired from the cache are not
+	// included.
+	Blamelist []string
+
+	// Comments maps TaskSpec name to the TaskComments for the Job's Tasks.
+	Comments map[string][]*db.TaskComment
+
+	// URL is the task scheduler's page for the Job.
+	URL string
+}
+
+// SchedulerCache caches Tasks, Jobs, and comments from the task scheduler
+// for the last build_cache.BUILD_LOADING_PERIOD.
+type SchedulerCache struct {
+	// repos, tasks, jobs, and commentDb are safe for concurrent use.
+	repos     *gitinfo.RepoMap
+	tasks     db.TaskCache
+	jobs      db.JobCache
+	commentDb db.CommentDB
+	// mutex protects comments.
+	mutex sync.RWMutex
+	// comments maps repo URL to the latest comments for that repo.
+	comments map[string]*db.RepoComments
+
+	// evt, if not nil, receives changes to Tasks and comments. The fields
+	// below are used to find Task status changes and are only accessed by
+	// Update.
+	evt        *eventbus.EventBus
+	taskDb     db.TaskReader
+	taskDbId   string
+	taskStatus map[string]db.TaskStatus
+}
+
+// New creates a SchedulerCache for the given repos, pulling data from the
+// given taskDb and updating it periodically. New and updated Tasks and
+// comments are published on evt, if not nil.
+func New(repos *gitinfo.RepoMap, taskDb db.RemoteDB, evt *eventbus.EventBus) (*SchedulerCache, error) {
+	tasks, err := db.NewTaskCache(taskDb, build_cache.BUILD_LOADING_PERIOD)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := db.NewJobCache(taskDb, build_cache.BUILD_LOADING_PERIOD, getRevisionTimestamp(repos))
+	if err != nil {
+		return nil, err
+	}
+	c := &SchedulerCache{
+		repos:      repos,
+		tasks:      tasks,
+		jobs:       jobs,
+		commentDb:  taskDb,
+		taskDb:     taskDb,
+		taskStatus: map[string]db.TaskStatus{},
+	}
+	if err := c.Update(); err != nil {
+		return nil, err
+	}
+	if evt != nil {
+		c.taskDbId, err = taskDb.StartTrackingModifiedTasks()
+		if err != nil {
+			return nil, err
+		}
+		c.evt = evt
+	}
+	go func() {
+		for _ = range time.Tick(time.Minute) {
+			if err := c.Update(); err != nil {
+				glog.Error(err)
+			}
+		}
+	}()
+	return c, nil
+}
+
+// getRevisionTimestamp returns a db.GetRevisionTimestamp which finds commits
+// in the given repos. Unlike RepoMap.Repo, it never checks out new repos.
+func getRevisionTimestamp(repos *gitinfo.RepoMap) db.GetRevisionTimestamp {
+	return func(repo, revision string) (time.Time, error) {
+		if !util.In(repo, repos.Repos()) {
+			return time.Time{}, fmt.Errorf("Unknown repo %s", repo)
+		}
+		r, err := repos.Repo(repo)
+		if err != nil {
+			return time.Time{}, err
+		}
+		ts := r.Timestamp(revision)
+		if ts.IsZero() {
+			return time.Time{}, fmt.Errorf("Unknown commit %s@%s", repo, revision)
+		}
+		return ts, nil
+	}
+}
+
+// Update reads updated Tasks, Jobs, and comments from the task scheduler DB.
+func (c *SchedulerCache) Update() error {
+	if err := c.repos.Update(); err != nil {
+		return err
+	}
+	if err := c.tasks.Update(); err != nil {
+		return err
+	}
+	if err := c.jobs.Update(); err != nil {
+		return err
+	}
+	if err := c.publishTaskChanges(); err != nil {
+		return err
+	}
+	return c.updateComments()
+}
+
+// publishTaskChanges publishes the Tasks which are new or whose status has
+// changed since the last call.
+func (c *SchedulerCache) publishTaskChanges() error {
+	if c.evt == nil {
+		return nil
+	}
+	tasks, err := c.taskDb.GetModifiedTasks(c.taskDbId)
+	if db.IsUnknownId(err) {
+		glog.Warningf("Connection to db lost; some Task changes may not be published.")
+		c.taskDbId, err = c.taskDb.StartTrackingModifiedTasks()
+		return err
+	} else if err != nil {
+		return err
+	}
+	changed := []*db.Task{}
+	for _, task := range tasks {
+		if status, ok := c.taskStatus[task.Id]; !ok || status != task.Status {
+			changed = append(changed, task)
+		}
+		// Finished Tasks are unlikely to change again.
+		if task.Done() {
+			delete(c.taskStatus, task.Id)
+		} else {
+			c.taskStatus[task.Id] = task.Status
+		}
+	}
+	if len(changed) > 0 {
+		events.Publish(c.evt, events.EV_TASKS, changed)
+	}
+	return nil
+}
+
+// updateComments reads updated comments from the task scheduler DB. This method
+// is separate from Update to avoid calling c.repos.Update when adding/deleting
+// comments.
+func (c *SchedulerCache) updateComments() error {
+	comments, err := c.commentDb.GetCommentsForRepos(c.repos.Repos(), time.Now().Add(-build_cache.BUILD_LOADING_PERIOD))
+	if err != nil {
+		return err
+	}
+	byRepo := make(map[string]*db.RepoComments, len(comments))
+	for _, rc := range comments {
+		byRepo[rc.Repo] = rc
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.evt != nil {
+		changes := commentChanges(byRepo, c.comments, events.COMMENT_ADDED)
+		changes = append(changes, commentChanges(c.comments, byRepo, events.COMMENT_DELETED)...)
+		if len(changes) > 0 {
+			events.Publish(c.evt, events.EV_COMMENTS, changes)
+		}
+	}
+	c.comments = byRepo
+	return nil
+}
+
+// commentChanges returns the TaskComments and TaskSpecComments in a which are
+// not in b, as events with the given action. Comments are identified by their
+// repo, TaskSpec name, revision, and timestamp.
+func commentChanges(a, b map[string]*db.RepoComments, action string) []*events.Comment {
+	rv := []*events.Comment{}
+	for repo, rcA := range a {
+		rcB, ok := b[repo]
+		if !ok {
+			rcB = &db.RepoComments{}
+		}
+		for revision, byName := range rcA.TaskComments {
+			for name, comments := range byName {
+				existing := map[int64]bool{}
+				for _, tc := range rcB.TaskComments[revision][name] {
+					existing[tc.Timestamp.UnixNano()] = true
+				}
+				for _, tc := range comments {
+					if !existing[tc.Timestamp.UnixNano()] {
+						rv = append(rv, &events.Comment{
+							Action:  action,
+							Kind:    events.COMMENT_KIND_TASK,
+							Repo:    repo,
+							Target:  name,
+							Comment: tc,
+						})
+					}
+				}
+			}
+		}
+		for name, comments := range rcA.TaskSpecComments {
+			existing := map[int64]bool{}
+			for _, tsc := range rcB.TaskSpecComments[name] {
+				existing[tsc.Timestamp.UnixNano()] = true
+			}
+			for _, tsc := range comments {
+				if !existing[tsc.Timestamp.UnixNano()] {
+					rv = append(rv, &events.Comment{
+						Action:  action,
+						Kind:    events.COMMENT_KIND_TASK_SPEC,
+						Repo:    repo,
+						Target:  name,
+						Comment: tsc,
+					})
+				}
+			}
+		}
+	}
+	return rv
+}
+
+// GetTaskComments returns the TaskComments for the given TaskSpec at the
+// given revision, sorted by timestamp.
+func (c *SchedulerCache) GetTaskComments(repo, revision, name string) []*db.TaskComment {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	rv := []*db.TaskComment{}
+	if rc, ok := c.comments[repo]; ok {
+		for _, tc := range rc.TaskComments[revision][name] {
+			rv = append(rv, tc.Copy())
+		}
+	}
+	return rv
+}
+
+// GetTaskSpecComments returns the TaskSpecComments for the given repo. Keys
+// are TaskSpec names and values are sorted by timestamp.
+func (c *SchedulerCache) GetTaskSpecComments(repo string) map[string][]*db.TaskSpecComment {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	rv := map[string][]*db.TaskSpecComment{}
+	if rc, ok := c.comments[repo]; ok {
+		for name, comments := range rc.TaskSpecComments {
+			cpy := make([]*db.TaskSpecComment, 0, len(comments))
+			for _, tsc := range comments {
+				cpy = append(cpy, tsc.Copy())
+			}
+			rv[name] = cpy
+		}
+	}
+	return rv
+}
+
+// makeTask adds the information displayed by the status page to a db.Task.
+func (c *SchedulerCache) makeTask(task *db.Task) (*Task, error) {
+	attempts, err := c.tasks.GetTasksByKey(&task.TaskKey)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]*db.TaskSummary, 0, len(attempts))
+	for _, a := range attempts {
+		summaries = append(summaries, a.MakeTaskSummary())
+	}
+	return &Task{
+		Task:     task,
+		Attempts: summaries,
+		Comments: c.GetTaskComments(task.Repo, task.Revision, task.Name),
+		URL:      fmt.Sprintf(TASK_URL_FMT, task.SwarmingTaskId),
+		RetryURL: fmt.Sprintf(TASK_TRIGGER_URL_FMT, task.Name, task.Revision),
+	}, nil
+}
+
+// GetTask returns the Task with the given ID, or db.ErrNotFound if it is not
+// in the cache.
+func (c *SchedulerCache) GetTask(id string) (*Task, error) {
+	task, err := c.tasks.GetTask(id)
+	if err != nil {
+		return nil, err
+	}
+	return c.makeTask(task)
+}
+
+// GetTasksForCommits returns the Tasks which included each of the given
+// commits in their blamelists. Returns a map whose keys are commit hashes and
+// values are sub-maps whose keys are TaskSpec names. See also
+// db.TaskCache.GetTasksForCommits.
+func (c *SchedulerCache) GetTasksForCommits(repo string, commits []string) (map[string]map[string]*Task, error) {
+	tasks, err := c.tasks.GetTasksForCommits(repo, commits)
+	if err != nil {
+		return nil, err
+	}
+	rv := make(map[string]map[string]*Task, len(tasks))
+	for hash, byName := range tasks {
+		rv[hash] = make(map[string]*Task, len(byName))
+		for name, task := range byName {
+			t, err := c.makeTask(task)
+			if err != nil {
+				return nil, err
+			}
+			rv[hash][name] = t
+		}
+	}
+	return rv, nil
+}
+
+// GetTasksFromDateRange returns the Tasks which were created in the given
+// date range, sorted by creation time.
+func (c *SchedulerCache) GetTasksFromDateRange(from, to time.Time) ([]*Task, error) {
+	tasks, err := c.tasks.GetTasksFromDateRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]*Task, 0, len(tasks))
+	for _, task := range tasks {
+		t, err := c.makeTask(task)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, t)
+	}
+	return rv, nil
+}
+
+// KnownTaskName returns true iff the given TaskSpec has been seen in the given
+// repo.
+func (c *SchedulerCache) KnownTaskName(repo, name string) bool {
+	return c.tasks.KnownTaskName(repo, name)
+}
+
+// makeJob adds the information displayed by the status page to a db.Job.
+func (c *SchedulerCache) makeJob(job *db.Job) *Job {
+	blamelist := util.StringSet{}
+	comments := make(map[string][]*db.TaskComment, len(job.Tasks))
+	for name, summaries := range job.Tasks {
+		comments[name] = c.GetTaskComments(job.Repo, job.Revision, name)
+		if len(summaries) == 0 {
+			continue
+		}
+		task, err := c.tasks.GetTask(summaries[len(summaries)-1].Id)
+		if err != nil {
+			// The Task may have expired from the cache.
+			continue
+		}
+		blamelist.AddLists(task.Commits)
+	}
+	commits := blamelist.Keys()
+	sort.Strings(commits)
+	return &Job{
+		Job:       job,
+		Blamelist: commits,
+		Comments:  comments,
+		URL:       fmt.Sprintf(db.JOB_URL_TMPL, job.Id),
+	}
+}
+
+// GetJob returns the Job with the given ID, reading it from the DB if it has
+// expired from the cache.
+func (c *SchedulerCache) GetJob(id string) (*Job, error) {
+	job, err := c.jobs.GetJobMaybeExpired(id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, db.ErrNotFound
+	}
+	return c.makeJob(job), nil
+}
+
+// GetJobsFromDateRange returns the Jobs which were created in the given date
+// range, sorted by creation time.
+func (c *SchedulerCache) GetJobsFromDateRange(from, to time.Time) ([]*Job, error) {
+	jobs, err := c.jobs.GetJobsFromDateRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		rv = append(rv, c.makeJob(job))
+	}
+	return rv, nil
+}
+
+// GetJobsForCommit returns the cached Jobs which ran at the given commit,
+// sorted by creation time.
+func (c *SchedulerCache) GetJobsForCommit(repo, commit string) ([]*Job, error) {
+	jobs, err := c.jobs.GetJobsFromDateRange(time.Time{}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	rv := []*Job{}
+	for _, job := range jobs {
+		if job.Repo == repo && job.Revision == commit {
+			rv = append(rv, c.makeJob(job))
+		}
+	}
+	return rv, nil
+}
+
+// AddTaskComment adds a TaskComment for the given Task.
+func (c *SchedulerCache) AddTaskComment(taskId, user, message string, ts time.Time) error {
+	task, err := c.tasks.GetTask(taskId)
+	if err != nil {
+		return err
+	}
+	if err := c.commentDb.PutTaskComment(&db.TaskComment{
+		Repo:      task.Repo,
+		Revision:  task.Revision,
+		Name:      task.Name,
+		Timestamp: ts,
+		TaskId:    task.Id,
+		User:      user,
+		Message:   message,
+	}); err != nil {
+		return err
+	}
+	return c.updateComments()
+}
+
+// DeleteTaskComment deletes the given TaskComment.
+func (c *SchedulerCache) DeleteTaskComment(comment *db.TaskComment) error {
+	if err := c.commentDb.DeleteTaskComment(comment); err != nil {
+		return err
+	}
+	return c.updateComments()
+}
+
+// AddTaskSpecComment adds the given TaskSpecComment. Returns an error if the
+// TaskSpec is unknown.
+func (c *SchedulerCache) AddTaskSpecComment(comment *db.TaskSpecComment) error {
+	if !c.tasks.KnownTaskName(comment.Repo, comment.Name) {
+		return fmt.Errorf("Unknown TaskSpec %q in %s", comment.Name, comment.Repo)
+	}
+	if err := c.commentDb.PutTaskSpecComment(comment); err != nil {
+		return err
+	}
+	return c.updateComments()
+}
+
+// DeleteTaskSpecComment deletes the given TaskSpecComment.
+func (c *SchedulerCache) DeleteTaskSpecComment(comment *db.TaskSpecComment) error {
+	if err := c.commentDb.DeleteTaskSpecComment(comment); err != nil {
+		return err
+	}
+	return c.updateComments()
+}
@@ -0,0 +1,243 @@
+package scheduler_cache
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"go.skia.org/infra/go/git/gitinfo"
+	git_testutils "go.skia.org/infra/go/git/testutils"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/status/go/events"
+	"go.skia.org/infra/task_scheduler/go/db"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+)
+
+// setup returns a SchedulerCache backed by an in-memory DB and a test repo,
+// the repo URL, its commits, and a cleanup func.
+func setup(t *testing.T) (*SchedulerCache, db.DB, string, []string, func()) {
+	testutils.MediumTest(t)
+	testutils.SkipIfShort(t)
+
+	g := git_testutils.GitInit(t)
+	commits := git_testutils.GitSetup(g)
+	workdir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	repos := gitinfo.NewRepoMap(workdir)
+	_, err = repos.Repo(g.Dir())
+	assert.NoError(t, err)
+
+	d := db.NewInMemoryDB()
+	c, err := New(repos, d, nil)
+	assert.NoError(t, err)
+	return c, d, g.Dir(), commits, func() {
+		testutils.RemoveAll(t, workdir)
+		g.Cleanup()
+	}
+}
+
+func makeTask(repo, name string, commits []string) *db.Task {
+	return &db.Task{
+		Created: time.Now(),
+		TaskKey: db.TaskKey{
+			RepoState: db.RepoState{
+				Repo:     repo,
+				Revision: commits[0],
+			},
+			Name: name,
+		},
+		Commits:        commits,
+		SwarmingTaskId: "swarm-" + name,
+	}
+}
+
+func TestTasksAndJobs(t *testing.T) {
+	c, d, repo, commits, cleanup := setup(t)
+	defer cleanup()
+
+	// Two attempts of Build, the second of which has a larger blamelist, and
+	// one attempt of Test.
+	build1 := makeTask(repo, "Build", []string{commits[4], commits[3]})
+	build2 := makeTask(repo, "Build", []string{commits[4], commits[3], commits[2]})
+	build2.Created = build1.Created.Add(time.Minute)
+	test := makeTask(repo, "Test", []string{commits[4], commits[1]})
+	assert.NoError(t, d.PutTasks([]*db.Task{build1, build2, test}))
+
+	job := &db.Job{
+		Created: time.Now(),
+		RepoState: db.RepoState{
+			Repo:     repo,
+			Revision: commits[4],
+		},
+		Name: "Build-And-Test",
+		Dependencies: map[string][]string{
+			"Build": {},
+			"Test":  {"Build"},
+		},
+		Tasks: map[string][]*db.TaskSummary{
+			"Build": {build1.MakeTaskSummary(), build2.MakeTaskSummary()},
+			"Test":  {test.MakeTaskSummary()},
+			// Tasks which aren't in the cache are ignored.
+			"Perf": {{Id: "missing"}},
+		},
+	}
+	other := &db.Job{
+		Created: time.Now(),
+		RepoState: db.RepoState{
+			Repo:     repo,
+			Revision: commits[3],
+		},
+		Name:  "Other",
+		Tasks: map[string][]*db.TaskSummary{},
+	}
+	assert.NoError(t, d.PutJobs([]*db.Job{job, other}))
+	assert.NoError(t, c.Update())
+
+	// Tasks.
+	task, err := c.GetTask(build2.Id)
+	assert.NoError(t, err)
+	expect.Equal(t, build2.Id, task.Id)
+	expect.Equal(t, []*db.TaskSummary{build1.MakeTaskSummary(), build2.MakeTaskSummary()}, task.Attempts)
+	expect.Equal(t, []*db.TaskComment{}, task.Comments)
+	expect.Equal(t, "https://chromium-swarm.appspot.com/task?id=swarm-Build", task.URL)
+	_, err = c.GetTask("missing")
+	expect.Equal(t, db.ErrNotFound, err)
+
+	byCommit, err := c.GetTasksForCommits(repo, []string{commits[1], commits[2]})
+	assert.NoError(t, err)
+	expect.Equal(t, test.Id, byCommit[commits[1]]["Test"].Id)
+	expect.Equal(t, build2.Id, byCommit[commits[2]]["Build"].Id)
+
+	expect.True(t, c.KnownTaskName(repo, "Build"))
+	expect.False(t, c.KnownTaskName(repo, "Perf"))
+
+	// Jobs.
+	j, err := c.GetJob(job.Id)
+	assert.NoError(t, err)
+	expect.Equal(t, job.Id, j.Id)
+	// The Blamelist is the union of the blamelists of the latest attempts.
+	expected := []string{commits[1], commits[2], commits[3], commits[4]}
+	expect.Equal(t, expected, j.Blamelist)
+	expect.Equal(t, 3, len(j.Comments))
+	expect.Equal(t, "https://task-scheduler.skia.org/job/"+job.Id, j.URL)
+	_, err = c.GetJob("missing")
+	expect.Equal(t, db.ErrNotFound, err)
+
+	jobs, err := c.GetJobsForCommit(repo, commits[4])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(jobs))
+	expect.Equal(t, job.Id, jobs[0].Id)
+	jobs, err = c.GetJobsForCommit(repo, commits[3])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(jobs))
+	expect.Equal(t, other.Id, jobs[0].Id)
+	expect.Equal(t, []string{}, jobs[0].Blamelist)
+	jobs, err = c.GetJobsForCommit(repo, commits[0])
+	assert.NoError(t, err)
+	expect.Equal(t, 0, len(jobs))
+
+	jobs, err = c.GetJobsFromDateRange(time.Time{}, time.Now())
+	assert.NoError(t, err)
+	expect.Equal(t, 2, len(jobs))
+}
+
+func TestComments(t *testing.T) {
+	c, d, repo, commits, cleanup := setup(t)
+	defer cleanup()
+
+	task := makeTask(repo, "Build", []string{commits[4]})
+	assert.NoError(t, d.PutTask(task))
+	assert.NoError(t, c.Update())
+
+	// TaskComments.
+	ts := time.Unix(0, 1480683331123456789).UTC()
+	assert.NoError(t, c.AddTaskComment(task.Id, "me@google.com", "flaky", ts))
+	expect.Error(t, c.AddTaskComment("missing", "me@google.com", "flaky", ts))
+	comments := c.GetTaskComments(repo, commits[4], "Build")
+	assert.Equal(t, 1, len(comments))
+	expect.Equal(t, task.Id, comments[0].TaskId)
+	expect.Equal(t, "flaky", comments[0].Message)
+	t2, err := c.GetTask(task.Id)
+	assert.NoError(t, err)
+	expect.Equal(t, comments, t2.Comments)
+
+	// Comments are deleted by timestamp, to the nanosecond.
+	assert.NoError(t, c.DeleteTaskComment(&db.TaskComment{
+		Repo:      repo,
+		Revision:  commits[4],
+		Name:      "Build",
+		Timestamp: time.Unix(0, ts.UnixNano()),
+	}))
+	expect.Equal(t, 0, len(c.GetTaskComments(repo, commits[4], "Build")))
+
+	// TaskSpecComments may only be added for known TaskSpecs.
+	tsc := &db.TaskSpecComment{
+		Repo:      repo,
+		Name:      "Build",
+		Timestamp: ts,
+		User:      "me@google.com",
+		Flaky:     true,
+		Message:   "flaky",
+	}
+	assert.NoError(t, c.AddTaskSpecComment(tsc))
+	unknown := tsc.Copy()
+	unknown.Name = "Perf"
+	expect.Error(t, c.AddTaskSpecComment(unknown))
+	expect.Equal(t, map[string][]*db.TaskSpecComment{"Build": {tsc}}, c.GetTaskSpecComments(repo))
+
+	assert.NoError(t, c.DeleteTaskSpecComment(&db.TaskSpecComment{
+		Repo:      repo,
+		Name:      "Build",
+		Timestamp: time.Unix(0, ts.UnixNano()),
+	}))
+	expect.Equal(t, map[string][]*db.TaskSpecComment{}, c.GetTaskSpecComments(repo))
+}
+
+func TestCommentChanges(t *testing.T) {
+	testutils.SmallTest(t)
+
+	ts := time.Unix(0, 1480683331123456789)
+	tc := &db.TaskComment{Repo: "r", Revision: "a", Name: "Build", Timestamp: ts}
+	tsc := &db.TaskSpecComment{Repo: "r", Name: "Build", Timestamp: ts}
+	a := map[string]*db.RepoComments{
+		"r": {
+			Repo:             "r",
+			TaskComments:     map[string]map[string][]*db.TaskComment{"a": {"Build": {tc}}},
+			TaskSpecComments: map[string][]*db.TaskSpecComment{"Build": {tsc}},
+		},
+	}
+
+	changes := commentChanges(a, map[string]*db.RepoComments{}, events.COMMENT_ADDED)
+	assert.Equal(t, 2, len(changes))
+	expect.Equal(t, &events.Comment{
+		Action:  events.COMMENT_ADDED,
+		Kind:    events.COMMENT_KIND_TASK,
+		Repo:    "r",
+		Target:  "Build",
+		Comment: tc,
+	}, changes[0])
+	expect.Equal(t, &events.Comment{
+		Action:  events.COMMENT_ADDED,
+		Kind:    events.COMMENT_KIND_TASK_SPEC,
+		Repo:    "r",
+		Target:  "Build",
+		Comment: tsc,
+	}, changes[1])
+
+	// Comments are identified by timestamp, ignoring timezone.
+	b := map[string]*db.RepoComments{
+		"r": {
+			Repo: "r",
+			TaskComments: map[string]map[string][]*db.TaskComment{"a": {"Build": {
+				&db.TaskComment{Repo: "r", Revision: "a", Name: "Build", Timestamp: ts.UTC()},
+			}}},
+			TaskSpecComments: map[string][]*db.TaskSpecComment{"Build": {
+				&db.TaskSpecComment{Repo: "r", Name: "Build", Timestamp: ts.UTC()},
+			}},
+		},
+	}
+	expect.Equal(t, 0, len(commentChanges(a, b, events.COMMENT_DELETED)))
+	expect.Equal(t, 0, len(commentChanges(b, a, events.COMMENT_DELETED)))
+}
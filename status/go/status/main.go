@@ -22,6 +22,7 @@ import (
 	"github.com/skia-dev/glog"
 	"go.skia.org/infra/go/buildbot"
 	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/eventbus"
 	"go.skia.org/infra/go/git/gitinfo"
 	"go.skia.org/infra/go/httputils"
 	"go.skia.org/infra/go/influxdb"
@@ -35,7 +36,10 @@ import (
 	"go.skia.org/infra/go/vcsinfo"
 	"go.skia.org/infra/status/go/commit_cache"
 	"go.skia.org/infra/status/go/device_cfg"
+	"go.skia.org/infra/status/go/events"
 	"go.skia.org/infra/status/go/franken"
+	"go.skia.org/infra/status/go/scheduler_cache"
+	"go.skia.org/infra/task_scheduler/go/db"
 	"go.skia.org/infra/task_scheduler/go/db/remote_db"
 )
 
@@ -55,13 +59,15 @@ const (
 
 var (
 	buildCache           *franken.BTCache                     = nil
+	schedulerCache       *scheduler_cache.SchedulerCache      = nil
 	commitCaches         map[string]*commit_cache.CommitCache = nil
 	buildbotDashTemplate *template.Template                   = nil
 	commitsTemplate      *template.Template                   = nil
-	db                   buildbot.DB                          = nil
+	buildDb              buildbot.DB                          = nil
 	hostsTemplate        *template.Template                   = nil
 	infraTemplate        *template.Template                   = nil
 	dbClient             *influxdb.Client                     = nil
+	eventServer          *events.Server                       = nil
 	goldGMStatus         *polling_status.PollingStatus        = nil
 	goldSKPStatus        *polling_status.PollingStatus        = nil
 	goldImageStatus      *polling_status.PollingStatus        = nil
@@ -71,12 +77,18 @@ var (
 	sshDevices           *polling_status.PollingStatus        = nil
 )
 
+// repoUrls maps the repo names used in URL paths to repo URLs.
+var repoUrls = map[string]string{
+	SKIA_REPO:  common.REPO_SKIA,
+	INFRA_REPO: common.REPO_SKIA_INFRA,
+}
+
 // flags
 var (
 	host               = flag.String("host", "localhost", "HTTP service host")
 	port               = flag.String("port", ":8002", "HTTP service port (e.g., ':8002')")
 	useMetadata        = flag.Bool("use_metadata", true, "Load sensitive values from metadata not from flags.")
-	testing            = flag.Bool("testing", false, "Set to true for locally testing rules. No email will be sent.")
+	isTesting          = flag.Bool("testing", false, "Set to true for locally testing rules. No email will be sent.")
 	workdir            = flag.String("workdir", ".", "Directory to use for scratch work.")
 	resourcesDir       = flag.String("resources_dir", "", "The directory to find templates, JS, and CSS files. If blank the current directory will be used.")
 	buildbotDbHost     = flag.String("buildbot_db_host", "skia-datahopper2:8000", "Where the Skia buildbot database is hosted.")
@@ -157,6 +169,29 @@ func getCommitCache(w http.ResponseWriter, r *http.Request) (*commit_cache.Commi
 	return cache, nil
 }
 
+// getRepoUrl returns the URL of the repo named in the request path.
+func getRepoUrl(w http.ResponseWriter, r *http.Request) (string, error) {
+	repo, _ := mux.Vars(r)["repo"]
+	url, ok := repoUrls[repo]
+	if !ok {
+		e := fmt.Sprintf("Unknown repo: %s", repo)
+		err := fmt.Errorf(e)
+		httputils.ReportError(w, r, err, e)
+		return "", err
+	}
+	return url, nil
+}
+
+// getTimestampParam returns the time given by a path variable in nanoseconds
+// since the epoch, as used to identify comments.
+func getTimestampParam(name string, r *http.Request) (time.Time, error) {
+	ns, err := strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid timestamp for parameter %q", name)
+	}
+	return time.Unix(0, ns).UTC(), nil
+}
+
 type commitsData struct {
 	Comments    map[string][]*buildbot.CommitComment         `json:"comments"`
 	Commits     []*vcsinfo.LongCommit                        `json:"commits"`
@@ -410,7 +445,7 @@ func commitsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
 	// Don't use cached templates in testing mode.
-	if *testing {
+	if *isTesting {
 		reloadTemplates()
 	}
 
@@ -424,7 +459,7 @@ func infraHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
 	// Don't use cached templates in testing mode.
-	if *testing {
+	if *isTesting {
 		reloadTemplates()
 	}
 
@@ -554,7 +589,7 @@ func buildbotDashHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
 	// Don't use cached templates in testing mode.
-	if *testing {
+	if *isTesting {
 		reloadTemplates()
 	}
 
@@ -602,7 +637,7 @@ func hostsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
 	// Don't use cached templates in testing mode.
-	if *testing {
+	if *isTesting {
 		reloadTemplates()
 	}
 
@@ -675,6 +710,265 @@ func buildProgressHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// reportSchedulerError reports an error from the SchedulerCache, using a 404
+// for unknown Tasks and Jobs.
+func reportSchedulerError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if err == db.ErrNotFound {
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+	httputils.ReportError(w, r, err, msg)
+}
+
+// taskJsonHandler writes the Task with the given ID, including its blamelist,
+// attempts and comments.
+func taskJsonHandler(w http.ResponseWriter, r *http.Request) {
+	defer timer.New("taskJsonHandler").Stop()
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	task, err := schedulerCache.GetTask(id)
+	if err != nil {
+		reportSchedulerError(w, r, err, fmt.Sprintf("Failed to retrieve Task %q", id))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(task); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to encode response: %s", err))
+		return
+	}
+}
+
+// tasksJsonHandler writes the Tasks for each of the commits given by the
+// "commit" parameter, along with the comments on each TaskSpec.
+func tasksJsonHandler(w http.ResponseWriter, r *http.Request) {
+	defer timer.New("tasksJsonHandler").Stop()
+	w.Header().Set("Content-Type", "application/json")
+	repo, err := getRepoUrl(w, r)
+	if err != nil {
+		return
+	}
+	commits := r.URL.Query()["commit"]
+	if len(commits) > MAX_COMMITS_TO_LOAD {
+		commits = commits[:MAX_COMMITS_TO_LOAD]
+	}
+	tasks, err := schedulerCache.GetTasksForCommits(repo, commits)
+	if err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to obtain tasks: %s", err))
+		return
+	}
+	rv := struct {
+		Tasks            map[string]map[string]*scheduler_cache.Task `json:"tasks"`
+		TaskSpecComments map[string][]*db.TaskSpecComment            `json:"taskSpecComments"`
+	}{
+		Tasks:            tasks,
+		TaskSpecComments: schedulerCache.GetTaskSpecComments(repo),
+	}
+	if err := json.NewEncoder(w).Encode(rv); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to encode response: %s", err))
+		return
+	}
+}
+
+// jobJsonHandler writes the Job with the given ID, including its DAG, the
+// attempts of each of its Tasks, its blamelist and comments.
+func jobJsonHandler(w http.ResponseWriter, r *http.Request) {
+	defer timer.New("jobJsonHandler").Stop()
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	job, err := schedulerCache.GetJob(id)
+	if err != nil {
+		reportSchedulerError(w, r, err, fmt.Sprintf("Failed to retrieve Job %q", id))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to encode response: %s", err))
+		return
+	}
+}
+
+// jobsJsonHandler writes the Jobs which ran at the commit given by the
+// "commit" parameter or, if no commit is given, the Jobs created between the
+// "start" and "end" parameters, which default to the last day.
+func jobsJsonHandler(w http.ResponseWriter, r *http.Request) {
+	defer timer.New("jobsJsonHandler").Stop()
+	w.Header().Set("Content-Type", "application/json")
+	repo, err := getRepoUrl(w, r)
+	if err != nil {
+		return
+	}
+	var jobs []*scheduler_cache.Job
+	if commit := r.FormValue("commit"); commit != "" {
+		jobs, err = schedulerCache.GetJobsForCommit(repo, commit)
+		if err != nil {
+			httputils.ReportError(w, r, err, fmt.Sprintf("Failed to obtain jobs: %s", err))
+			return
+		}
+	} else {
+		start, err := getIntParam("start", r)
+		if err != nil {
+			httputils.ReportError(w, r, err, fmt.Sprintf("Invalid value for parameter \"start\": %v", err))
+			return
+		}
+		end, err := getIntParam("end", r)
+		if err != nil {
+			httputils.ReportError(w, r, err, fmt.Sprintf("Invalid value for parameter \"end\": %v", err))
+			return
+		}
+		endTime := time.Now()
+		if end != nil {
+			endTime = time.Unix(int64(*end), 0)
+		}
+		startTime := endTime.AddDate(0, 0, -1)
+		if start != nil {
+			startTime = time.Unix(int64(*start), 0)
+		}
+		all, err := schedulerCache.GetJobsFromDateRange(startTime, endTime)
+		if err != nil {
+			httputils.ReportError(w, r, err, fmt.Sprintf("Failed to obtain jobs: %s", err))
+			return
+		}
+		jobs = make([]*scheduler_cache.Job, 0, len(all))
+		for _, job := range all {
+			if job.Repo == repo {
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	if err := json.NewEncoder(w).Encode(jobs); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to encode response: %s", err))
+		return
+	}
+}
+
+func addTaskCommentHandler(w http.ResponseWriter, r *http.Request) {
+	defer timer.New("addTaskCommentHandler").Stop()
+	if !userHasEditRights(r) {
+		httputils.ReportError(w, r, fmt.Errorf("User does not have edit rights."), "User does not have edit rights.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	comment := struct {
+		Comment string `json:"comment"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to add comment: %v", err))
+		return
+	}
+	defer util.Close(r.Body)
+	if err := schedulerCache.AddTaskComment(id, login.LoggedInAs(r), comment.Comment, time.Now().UTC()); err != nil {
+		reportSchedulerError(w, r, err, fmt.Sprintf("Failed to add comment: %v", err))
+		return
+	}
+}
+
+func deleteTaskCommentHandler(w http.ResponseWriter, r *http.Request) {
+	defer timer.New("deleteTaskCommentHandler").Stop()
+	if !userHasEditRights(r) {
+		httputils.ReportError(w, r, fmt.Errorf("User does not have edit rights."), "User does not have edit rights.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	ts, err := getTimestampParam("timestamp", r)
+	if err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Invalid comment timestamp: %v", err))
+		return
+	}
+	task, err := schedulerCache.GetTask(id)
+	if err != nil {
+		reportSchedulerError(w, r, err, fmt.Sprintf("Failed to retrieve Task %q", id))
+		return
+	}
+	c := &db.TaskComment{
+		Repo:      task.Repo,
+		Revision:  task.Revision,
+		Name:      task.Name,
+		Timestamp: ts,
+	}
+	if err := schedulerCache.DeleteTaskComment(c); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to delete comment: %v", err))
+		return
+	}
+}
+
+func addTaskSpecCommentHandler(w http.ResponseWriter, r *http.Request) {
+	defer timer.New("addTaskSpecCommentHandler").Stop()
+	if !userHasEditRights(r) {
+		httputils.ReportError(w, r, fmt.Errorf("User does not have edit rights."), "User does not have edit rights.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	repo, err := getRepoUrl(w, r)
+	if err != nil {
+		return
+	}
+	comment := struct {
+		Comment       string `json:"comment"`
+		Flaky         bool   `json:"flaky"`
+		IgnoreFailure bool   `json:"ignoreFailure"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to add comment: %v", err))
+		return
+	}
+	defer util.Close(r.Body)
+	c := &db.TaskSpecComment{
+		Repo:          repo,
+		Name:          mux.Vars(r)["name"],
+		Timestamp:     time.Now().UTC(),
+		User:          login.LoggedInAs(r),
+		Flaky:         comment.Flaky,
+		IgnoreFailure: comment.IgnoreFailure,
+		Message:       comment.Comment,
+	}
+	if err := schedulerCache.AddTaskSpecComment(c); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to add comment: %v", err))
+		return
+	}
+}
+
+func deleteTaskSpecCommentHandler(w http.ResponseWriter, r *http.Request) {
+	defer timer.New("deleteTaskSpecCommentHandler").Stop()
+	if !userHasEditRights(r) {
+		httputils.ReportError(w, r, fmt.Errorf("User does not have edit rights."), "User does not have edit rights.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	repo, err := getRepoUrl(w, r)
+	if err != nil {
+		return
+	}
+	ts, err := getTimestampParam("timestamp", r)
+	if err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Invalid comment timestamp: %v", err))
+		return
+	}
+	c := &db.TaskSpecComment{
+		Repo:      repo,
+		Name:      mux.Vars(r)["name"],
+		Timestamp: ts,
+	}
+	if err := schedulerCache.DeleteTaskSpecComment(c); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to delete comment: %v", err))
+		return
+	}
+}
+
+// addSchedulerHandlers adds the handlers for Jobs, Tasks, and their comments
+// to the given router.
+func addSchedulerHandlers(r *mux.Router) {
+	r.HandleFunc("/json/job/{id}", jobJsonHandler)
+	task := r.PathPrefix("/json/task/{id}").Subrouter()
+	task.HandleFunc("", taskJsonHandler)
+	task.HandleFunc("/comments", addTaskCommentHandler).Methods("POST")
+	task.HandleFunc("/comments/{timestamp:[0-9]+}", deleteTaskCommentHandler).Methods("DELETE")
+	r.HandleFunc("/json/{repo}/jobs", jobsJsonHandler)
+	r.HandleFunc("/json/{repo}/tasks", tasksJsonHandler)
+	taskSpecs := r.PathPrefix("/json/{repo}/taskSpecs/{name}").Subrouter()
+	taskSpecs.HandleFunc("/comments", addTaskSpecCommentHandler).Methods("POST")
+	taskSpecs.HandleFunc("/comments/{timestamp:[0-9]+}", deleteTaskSpecCommentHandler).Methods("DELETE")
+}
+
 func runServer(serverURL string) {
 	r := mux.NewRouter()
 	r.HandleFunc("/", commitsHandler)
@@ -686,6 +980,7 @@ func runServer(serverURL string) {
 	r.HandleFunc("/json/perfAlerts", perfJsonHandler)
 	r.HandleFunc("/json/slaveHosts", slaveHostsJsonHandler)
 	r.HandleFunc("/json/version", skiaversion.JsonHandler)
+	addSchedulerHandlers(r)
 	r.HandleFunc("/json/{repo}/buildProgress", buildProgressHandler)
 	r.HandleFunc("/logout/", login.LogoutHandler)
 	r.HandleFunc("/loginstatus/", login.StatusHandler)
@@ -701,6 +996,8 @@ func runServer(serverURL string) {
 	commits.HandleFunc("/", commitsJsonHandler)
 	commits.HandleFunc("/{commit:[a-f0-9]+}/comments", addCommitCommentHandler).Methods("POST")
 	commits.HandleFunc("/{commit:[a-f0-9]+}/comments/{commentId:[0-9]+}", deleteCommitCommentHandler).Methods("DELETE")
+	// The events stream must not be compressed or buffered.
+	http.Handle("/json/events", eventServer)
 	http.Handle("/", httputils.LoggingGzipRequestResponse(r))
 	glog.Infof("Ready to serve on %s", serverURL)
 	glog.Fatal(http.ListenAndServe(*port, nil))
@@ -710,7 +1007,7 @@ func main() {
 	defer common.LogPanic()
 	// Setup flags.
 
-	common.InitWithMetrics2("status", influxHost, influxUser, influxPassword, influxDatabase, testing)
+	common.InitWithMetrics2("status", influxHost, influxUser, influxPassword, influxDatabase, isTesting)
 	v, err := skiaversion.GetVersion()
 	if err != nil {
 		glog.Fatal(err)
@@ -718,16 +1015,16 @@ func main() {
 	glog.Infof("Version %s, built at %s", v.Commit, v.Date)
 
 	Init()
-	if *testing {
+	if *isTesting {
 		*useMetadata = false
 	}
 	serverURL := "https://" + *host
-	if *testing {
+	if *isTesting {
 		serverURL = "http://" + *host + *port
 	}
 
 	// Create buildbot remote DB.
-	db, err = buildbot.NewRemoteDB(*buildbotDbHost)
+	buildDb, err = buildbot.NewRemoteDB(*buildbotDbHost)
 	if err != nil {
 		glog.Fatal(err)
 	}
@@ -738,7 +1035,7 @@ func main() {
 	}
 
 	// Setup InfluxDB client.
-	dbClient, err = influxdb_init.NewClientFromParamsAndMetadata(*influxHost, *influxUser, *influxPassword, *influxDatabase, *testing)
+	dbClient, err = influxdb_init.NewClientFromParamsAndMetadata(*influxHost, *influxUser, *influxPassword, *influxDatabase, *isTesting)
 	if err != nil {
 		glog.Fatal(err)
 	}
@@ -768,8 +1065,18 @@ func main() {
 
 	glog.Info("Checkout complete")
 
+	// Changes to the caches below are pushed to clients of /json/events.
+	evt := eventbus.New(nil)
+	eventServer = events.NewServer(evt)
+
+	// Create the Task and Job cache.
+	schedulerCache, err = scheduler_cache.New(repos, taskDb, evt)
+	if err != nil {
+		glog.Fatalf("Failed to create scheduler cache: %s", err)
+	}
+
 	// Create the build cache.
-	bc, err := franken.NewBTCache(repos, db, taskDb)
+	bc, err := franken.NewBTCache(repos, buildDb, schedulerCache, evt)
 	if err != nil {
 		glog.Fatalf("Failed to create build cache: %s", err)
 	}
@@ -777,13 +1084,13 @@ func main() {
 
 	// Create the commit caches.
 	commitCaches = map[string]*commit_cache.CommitCache{}
-	skiaCache, err := commit_cache.New(skiaRepo, path.Join(*workdir, "commit_cache.gob"), DEFAULT_COMMITS_TO_LOAD, db)
+	skiaCache, err := commit_cache.New(skiaRepo, path.Join(*workdir, "commit_cache.gob"), DEFAULT_COMMITS_TO_LOAD, buildDb, common.REPO_SKIA, evt)
 	if err != nil {
 		glog.Fatalf("Failed to create commit cache: %v", err)
 	}
 	commitCaches[SKIA_REPO] = skiaCache
 
-	infraCache, err := commit_cache.New(infraRepo, path.Join(*workdir, "commit_cache_infra.gob"), DEFAULT_COMMITS_TO_LOAD, db)
+	infraCache, err := commit_cache.New(infraRepo, path.Join(*workdir, "commit_cache_infra.gob"), DEFAULT_COMMITS_TO_LOAD, buildDb, common.REPO_SKIA_INFRA, evt)
 	if err != nil {
 		glog.Fatalf("Failed to create commit cache: %v", err)
 	}
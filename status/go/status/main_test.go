@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.skia.org/infra/go/git/gitinfo"
+	git_testutils "go.skia.org/infra/go/git/testutils"
+	"go.skia.org/infra/go/login"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/status/go/scheduler_cache"
+	"go.skia.org/infra/task_scheduler/go/db"
+
+	expect "github.com/stretchr/testify/assert"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestSchedulerHandlers(t *testing.T) {
+	testutils.MediumTest(t)
+	testutils.SkipIfShort(t)
+
+	g := git_testutils.GitInit(t)
+	defer g.Cleanup()
+	commits := git_testutils.GitSetup(g)
+	workdir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, workdir)
+	repos := gitinfo.NewRepoMap(workdir)
+	_, err = repos.Repo(g.Dir())
+	assert.NoError(t, err)
+
+	d := db.NewInMemoryDB()
+	build := &db.Task{
+		Created: time.Now(),
+		TaskKey: db.TaskKey{
+			RepoState: db.RepoState{
+				Repo:     g.Dir(),
+				Revision: commits[4],
+			},
+			Name: "Build",
+		},
+		Commits: []string{commits[4], commits[3]},
+	}
+	assert.NoError(t, d.PutTask(build))
+	job := &db.Job{
+		Created: time.Now(),
+		RepoState: db.RepoState{
+			Repo:     g.Dir(),
+			Revision: commits[4],
+		},
+		Name:  "Build",
+		Tasks: map[string][]*db.TaskSummary{"Build": {build.MakeTaskSummary()}},
+	}
+	assert.NoError(t, d.PutJob(job))
+
+	schedulerCache, err = scheduler_cache.New(repos, d, nil)
+	assert.NoError(t, err)
+	repoUrls = map[string]string{SKIA_REPO: g.Dir()}
+
+	login.Init("id", "secret", "http://localhost", "salt", login.DEFAULT_SCOPE, login.DEFAULT_DOMAIN_WHITELIST, false)
+	cookie, err := login.CookieFor(&login.Session{
+		Email:     "me@google.com",
+		AuthScope: login.DEFAULT_SCOPE[0],
+	})
+	assert.NoError(t, err)
+
+	r := mux.NewRouter()
+	addSchedulerHandlers(r)
+	do := func(method, path, body string, loggedIn bool) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		assert.NoError(t, err)
+		if loggedIn {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Tasks.
+	w := do("GET", "/json/task/"+build.Id, "", false)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var task scheduler_cache.Task
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&task))
+	expect.Equal(t, build.Id, task.Id)
+	expect.Equal(t, 1, len(task.Attempts))
+	expect.Equal(t, http.StatusNotFound, do("GET", "/json/task/missing", "", false).Code)
+
+	w = do("GET", "/json/skia/tasks?commit="+commits[3], "", false)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tasks struct {
+		Tasks map[string]map[string]*scheduler_cache.Task `json:"tasks"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&tasks))
+	expect.Equal(t, build.Id, tasks.Tasks[commits[3]]["Build"].Id)
+	expect.Equal(t, http.StatusInternalServerError, do("GET", "/json/bogus/tasks", "", false).Code)
+
+	// Jobs.
+	w = do("GET", "/json/job/"+job.Id, "", false)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var j scheduler_cache.Job
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&j))
+	expect.Equal(t, job.Id, j.Id)
+	blamelist := []string{commits[3], commits[4]}
+	sort.Strings(blamelist)
+	expect.Equal(t, blamelist, j.Blamelist)
+	expect.Equal(t, http.StatusNotFound, do("GET", "/json/job/missing", "", false).Code)
+
+	for _, path := range []string{"/json/skia/jobs?commit=" + commits[4], "/json/skia/jobs"} {
+		w = do("GET", path, "", false)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var jobs []*scheduler_cache.Job
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&jobs))
+		assert.Equal(t, 1, len(jobs), path)
+		expect.Equal(t, job.Id, jobs[0].Id)
+	}
+	w = do("GET", "/json/skia/jobs?commit="+commits[0], "", false)
+	expect.Equal(t, "[]\n", w.Body.String())
+
+	// Task comments require edit rights.
+	taskComments := fmt.Sprintf("/json/task/%s/comments", build.Id)
+	expect.Equal(t, http.StatusInternalServerError, do("POST", taskComments, `{"comment": "flaky"}`, false).Code)
+	expect.Equal(t, 0, len(schedulerCache.GetTaskComments(g.Dir(), commits[4], "Build")))
+	expect.Equal(t, http.StatusNotFound, do("POST", "/json/task/missing/comments", `{"comment": "flaky"}`, true).Code)
+	expect.Equal(t, http.StatusOK, do("POST", taskComments, `{"comment": "flaky"}`, true).Code)
+	comments := schedulerCache.GetTaskComments(g.Dir(), commits[4], "Build")
+	assert.Equal(t, 1, len(comments))
+	expect.Equal(t, "me@google.com", comments[0].User)
+	expect.Equal(t, "flaky", comments[0].Message)
+
+	// Comments are deleted by their timestamp in nanoseconds.
+	deleteComment := fmt.Sprintf("%s/%d", taskComments, comments[0].Timestamp.UnixNano())
+	expect.Equal(t, http.StatusInternalServerError, do("DELETE", deleteComment, "", false).Code)
+	expect.Equal(t, 1, len(schedulerCache.GetTaskComments(g.Dir(), commits[4], "Build")))
+	expect.Equal(t, http.StatusOK, do("DELETE", deleteComment, "", true).Code)
+	expect.Equal(t, 0, len(schedulerCache.GetTaskComments(g.Dir(), commits[4], "Build")))
+
+	// TaskSpec comments may only be added for known TaskSpecs.
+	body := `{"comment": "flaky", "flaky": true}`
+	expect.Equal(t, http.StatusInternalServerError, do("POST", "/json/skia/taskSpecs/Perf/comments", body, true).Code)
+	expect.Equal(t, http.StatusInternalServerError, do("POST", "/json/skia/taskSpecs/Build/comments", body, false).Code)
+	expect.Equal(t, http.StatusOK, do("POST", "/json/skia/taskSpecs/Build/comments", body, true).Code)
+	specComments := schedulerCache.GetTaskSpecComments(g.Dir())
+	assert.Equal(t, 1, len(specComments["Build"]))
+	expect.True(t, specComments["Build"][0].Flaky)
+	expect.Equal(t, "flaky", specComments["Build"][0].Message)
+
+	deleteSpecComment := fmt.Sprintf("/json/skia/taskSpecs/Build/comments/%d", specComments["Build"][0].Timestamp.UnixNano())
+	expect.Equal(t, http.StatusOK, do("DELETE", deleteSpecComment, "", true).Code)
+	expect.Equal(t, 0, len(schedulerCache.GetTaskSpecComments(g.Dir())))
+}
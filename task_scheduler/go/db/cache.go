@@ -427,6 +427,10 @@ type JobCache interface {
 	// window.
 	GetJobMaybeExpired(string) (*Job, error)
 
+	// GetJobsFromDateRange retrieves all jobs in the cache which were
+	// created in the given date range, sorted by creation time.
+	GetJobsFromDateRange(time.Time, time.Time) ([]*Job, error)
+
 	// ScheduledJobsForCommit indicates whether or not we triggered any jobs
 	// for the given repo/commit.
 	ScheduledJobsForCommit(string, string) (bool, error)
@@ -445,7 +449,7 @@ type JobCache interface {
 type GetRevisionTimestamp func(repo, revision string) (time.Time, error)
 
 type jobCache struct {
-	db                   JobDB
+	db                   JobReader
 	getRevisionTimestamp GetRevisionTimestamp
 	mtx                  sync.RWMutex
 	queryId              string
@@ -489,6 +493,20 @@ func (c *jobCache) GetJobMaybeExpired(id string) (*Job, error) {
 	return c.db.GetJobById(id)
 }
 
+// See documentation for JobCache interface.
+func (c *jobCache) GetJobsFromDateRange(from time.Time, to time.Time) ([]*Job, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	rv := []*Job{}
+	for _, job := range c.jobs {
+		if !job.Created.Before(from) && job.Created.Before(to) {
+			rv = append(rv, job.Copy())
+		}
+	}
+	sort.Sort(JobSlice(rv))
+	return rv, nil
+}
+
 // See documentation for JobCache interface.
 func (c *jobCache) ScheduledJobsForCommit(repo, rev string) (bool, error) {
 	c.mtx.RLock()
@@ -626,7 +644,7 @@ func (c *jobCache) Update() error {
 
 // NewJobCache returns a local cache which provides more convenient views of
 // job data than the database can provide.
-func NewJobCache(db JobDB, timePeriod time.Duration, getRevisionTimestamp GetRevisionTimestamp) (JobCache, error) {
+func NewJobCache(db JobReader, timePeriod time.Duration, getRevisionTimestamp GetRevisionTimestamp) (JobCache, error) {
 	tc := &jobCache{
 		db:                   db,
 		getRevisionTimestamp: getRevisionTimestamp,
@@ -528,6 +528,38 @@ func TestJobCache(t *testing.T) {
 	testutils.AssertDeepEqual(t, j2, test)
 }
 
+func TestJobCacheGetJobsFromDateRange(t *testing.T) {
+	testutils.SmallTest(t)
+	db := NewInMemoryJobDB()
+
+	// Pre-load jobs into the DB.
+	startTime := time.Now().Add(-30 * time.Minute) // Arbitrary starting point.
+	j1 := makeJob(startTime)
+	j2 := makeJob(startTime.Add(time.Minute))
+	j3 := makeJob(startTime.Add(2 * time.Minute))
+	assert.NoError(t, db.PutJobs([]*Job{j3, j1, j2}))
+
+	c, err := NewJobCache(db, time.Hour, DummyGetRevisionTimestamp(j1.Created.Add(-1*time.Minute)))
+	assert.NoError(t, err)
+
+	timeStart := time.Time{}
+	timeEnd := startTime.Add(time.Hour)
+	test := func(from, to time.Time, expect ...*Job) {
+		jobs, err := c.GetJobsFromDateRange(from, to)
+		assert.NoError(t, err)
+		if len(expect) == 0 {
+			assert.Equal(t, 0, len(jobs))
+		} else {
+			testutils.AssertDeepEqual(t, expect, jobs)
+		}
+	}
+	test(timeStart, timeEnd, j1, j2, j3)
+	test(timeStart, j1.Created)
+	test(j1.Created, j2.Created, j1)
+	test(j2.Created, timeEnd, j2, j3)
+	test(j3.Created.Add(time.Nanosecond), timeEnd)
+}
+
 func TestJobCacheTriggeredForCommit(t *testing.T) {
 	testutils.SmallTest(t)
 	db := NewInMemoryJobDB()